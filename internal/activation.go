@@ -0,0 +1,353 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ActivationStateActive is the activation state of a zone served by Edge DNS
+	ActivationStateActive = "ACTIVE"
+	// Activation state policies
+	ActivationPolicyIgnore   = "ignore"   // the zone is left alone
+	ActivationPolicyReport   = "report"   // the zone is reported as stuck each interval
+	ActivationPolicyWait     = "wait"     // the zone is reported as stuck once in the state longer than the timeout
+	ActivationPolicyRecreate = "recreate" // the zone is deleted once in the state longer than the timeout and created again
+	DefaultActivationTimeout = time.Hour
+	// activation state ages file in the state directory
+	activationStateFile = "activation-states.json"
+	// file of zones deleted for recreation in the state directory
+	recreationStateFile = "zone-recreations.json"
+)
+
+var (
+	// ActivationPolicies are the valid activation state policy names
+	ActivationPolicies = []string{ActivationPolicyIgnore, ActivationPolicyReport, ActivationPolicyWait, ActivationPolicyRecreate}
+	// DefaultActivationPolicies apply to states without a configured policy
+	DefaultActivationPolicies = []string{"LOCKED=report", "PENDING=wait", "NEW=wait", "ERROR=report"}
+)
+
+// ActivationPolicy is the handling of managed zones in a non active activation state
+type ActivationPolicy struct {
+	State   string
+	Action  string
+	Timeout time.Duration
+}
+
+// ParseActivationPolicy parses a policy spec STATE=ACTION[:TIMEOUT], e.g. PENDING=recreate:2h. The timeout defaults to
+// defaultTimeout.
+func ParseActivationPolicy(spec string, defaultTimeout time.Duration) (ActivationPolicy, error) {
+
+	policy := ActivationPolicy{Timeout: defaultTimeout}
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return policy, fmt.Errorf("Invalid activation state policy %q. Expected STATE=ACTION[:TIMEOUT]", spec)
+	}
+	policy.State = strings.ToUpper(strings.TrimSpace(parts[0]))
+	if policy.State == ActivationStateActive {
+		return policy, fmt.Errorf("Invalid activation state policy %q. %s zones need no policy", spec, ActivationStateActive)
+	}
+	action := strings.TrimSpace(parts[1])
+	if i := strings.Index(action, ":"); i >= 0 {
+		timeout, err := time.ParseDuration(action[i+1:])
+		if err != nil || timeout <= 0 {
+			return policy, fmt.Errorf("Invalid activation state policy %q timeout", spec)
+		}
+		policy.Timeout = timeout
+		action = action[:i]
+	}
+	policy.Action = strings.ToLower(action)
+	valid := false
+	for _, a := range ActivationPolicies {
+		if a == policy.Action {
+			valid = true
+		}
+	}
+	if !valid {
+		return policy, fmt.Errorf("Invalid activation state policy %q. Valid actions: %s", spec, strings.Join(ActivationPolicies, ", "))
+	}
+
+	return policy, nil
+}
+
+// ParseActivationPolicies parses the default policies followed by specs. Later policies of a state replace earlier ones.
+func ParseActivationPolicies(specs []string, defaultTimeout time.Duration) (map[string]ActivationPolicy, error) {
+
+	if defaultTimeout <= 0 {
+		defaultTimeout = DefaultActivationTimeout
+	}
+	policies := map[string]ActivationPolicy{}
+	for _, spec := range append(append([]string{}, DefaultActivationPolicies...), specs...) {
+		policy, err := ParseActivationPolicy(spec, defaultTimeout)
+		if err != nil {
+			return nil, err
+		}
+		policies[policy.State] = policy
+	}
+
+	return policies, nil
+}
+
+// activationPolicy returns the policy of a state. States without a policy are reported.
+func activationPolicy(policies map[string]ActivationPolicy, state string) ActivationPolicy {
+
+	if policy, ok := policies[state]; ok {
+		return policy
+	}
+
+	return ActivationPolicy{State: state, Action: ActivationPolicyReport}
+}
+
+// ZoneActivation is the time a zone was first seen in its current non active state
+type ZoneActivation struct {
+	Account string    `json:"account"`
+	Zone    string    `json:"zone"`
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+}
+
+// StuckZone is a managed zone in a non active state
+type StuckZone struct {
+	ZoneActivation
+	Policy ActivationPolicy
+	Age    time.Duration
+}
+
+func (za *ZoneActivation) stateKey() string {
+
+	return activationKey(za.Account, za.Zone)
+}
+
+func (za *ZoneActivation) stateZone() string {
+
+	return za.Zone
+}
+
+// activationTracker tracks how long zones have been in non active states
+type activationTracker struct {
+	store *keyedStateStore
+}
+
+func newActivationTracker(stateDir string) (*activationTracker, error) {
+
+	store, err := newKeyedStateStore(stateDir, activationStateFile, &[]*ZoneActivation{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &activationTracker{store: store}, nil
+}
+
+func activationKey(account, zone string) string {
+
+	return account + "/" + zone
+}
+
+// update records the activation states of all zones of an account at now. Zones that are active or no longer listed
+// are dropped.
+func (t *activationTracker) update(account string, states map[string]string, now time.Time) error {
+
+	return t.store.change(func(entries map[string]stateEntry) bool {
+		changed := false
+		for key, entry := range entries {
+			za := entry.(*ZoneActivation)
+			if za.Account != account {
+				continue
+			}
+			if state, ok := states[za.Zone]; !ok || state != za.State {
+				delete(entries, key)
+				changed = true
+			}
+		}
+		for zone, state := range states {
+			// zones without a state are not tracked
+			if state == ActivationStateActive || state == "" {
+				continue
+			}
+			key := activationKey(account, zone)
+			if _, ok := entries[key]; !ok {
+				entries[key] = &ZoneActivation{Account: account, Zone: zone, State: state, Since: now}
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// forget drops zones of an account, e.g. after deleting them
+func (t *activationTracker) forget(account string, zones []string) error {
+
+	return t.store.remove(zoneKeys(account, zones)...)
+}
+
+// stuck returns the tracked zones of an account in managed, sorted by zone
+func (t *activationTracker) stuck(account string, managed []string, policies map[string]ActivationPolicy, now time.Time) []*StuckZone {
+
+	stuck := []*StuckZone{}
+	for _, zone := range managed {
+		entry, ok := t.store.get(activationKey(account, zone))
+		if !ok {
+			continue
+		}
+		za := entry.(*ZoneActivation)
+		stuck = append(stuck, &StuckZone{ZoneActivation: *za, Policy: activationPolicy(policies, za.State), Age: now.Sub(za.Since)})
+	}
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Zone < stuck[j].Zone })
+
+	return stuck
+}
+
+// ZoneRecreation is a zone deleted for recreation in the account it is created again in
+type ZoneRecreation struct {
+	Account string    `json:"account"`
+	Zone    string    `json:"zone"`
+	Deleted time.Time `json:"deleted"`
+}
+
+func (zr *ZoneRecreation) stateKey() string {
+
+	return zr.Zone
+}
+
+func (zr *ZoneRecreation) stateZone() string {
+
+	return zr.Zone
+}
+
+// zoneRecreations tracks zones deleted for recreation until they are created again
+type zoneRecreations struct {
+	store *keyedStateStore
+}
+
+func newZoneRecreations(stateDir string) (*zoneRecreations, error) {
+
+	store, err := newKeyedStateStore(stateDir, recreationStateFile, &[]*ZoneRecreation{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &zoneRecreations{store: store}, nil
+}
+
+// deleted records zones of an account deleted for recreation
+func (r *zoneRecreations) deleted(account string, zones []string, now time.Time) error {
+
+	return r.store.change(func(entries map[string]stateEntry) bool {
+		for _, zone := range zones {
+			entries[zone] = &ZoneRecreation{Account: account, Zone: zone, Deleted: now}
+		}
+		return len(zones) > 0
+	})
+}
+
+// account returns the account a zone deleted for recreation belongs to, if any
+func (r *zoneRecreations) account(zone string) string {
+
+	entry, ok := r.store.get(zone)
+	if !ok {
+		return ""
+	}
+
+	return entry.(*ZoneRecreation).Account
+}
+
+// created drops a zone once created again
+func (r *zoneRecreations) created(zone string) error {
+
+	return r.store.remove(zone)
+}
+
+// retain drops the zones not in zones, e.g. of domains no longer in the registrar
+func (r *zoneRecreations) retain(zones []string) error {
+
+	return r.store.retainZones(zones)
+}
+
+// zoneStates lists the secondary zones of an account with their activation states
+func zoneStates(ctx context.Context, account *EdgeDNSAccount, pageSize int) (map[string]string, error) {
+
+	queryArgs := dns.ZoneListQueryArgs{
+		ContractIds: account.Contract,
+		PageSize:    pageSize,
+		SortBy:      "zone",
+		Types:       "SECONDARY",
+	}
+
+	return account.client.GetZoneStates(ctx, queryArgs)
+}
+
+// remediateStuckZones applies the activation state policies to the managed zones of an account. Stuck zones are
+// logged and exported as metrics. Zones due for recreation are deleted; the next interval creates them again in the
+// account.
+func remediateStuckZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, managed []string, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	now := time.Now()
+	stuck := edge.activations.stuck(account.Name(), managed, edge.ActivationPolicies, now)
+	metrics.ResetGauge("stuck_zones", map[string]string{"account": account.Name()})
+	metrics.ResetGauge("stuck_zone_age_seconds", map[string]string{"account": account.Name()})
+	counts := map[string]int{}
+	recreate := []string{}
+	for _, sz := range stuck {
+		overdue := sz.Age >= sz.Policy.Timeout
+		switch sz.Policy.Action {
+		case ActivationPolicyIgnore:
+			continue
+		case ActivationPolicyWait:
+			if !overdue {
+				log.Debugf("Zone %s in state %s for %s. Waiting", sz.Zone, sz.State, sz.Age.Round(time.Second))
+				continue
+			}
+		case ActivationPolicyRecreate:
+			if overdue {
+				recreate = append(recreate, sz.Zone)
+			}
+		}
+		log.Warnf("Zone %s stuck in state %s for %s. Account: %s. Policy: %s", sz.Zone, sz.State, sz.Age.Round(time.Second), account.Name(), sz.Policy.Action)
+		counts[sz.State]++
+		metrics.SetGauge("stuck_zone_age_seconds", "Seconds a managed zone has been in a non active activation state", map[string]string{"account": account.Name(), "zone": sz.Zone, "state": sz.State}, sz.Age.Seconds())
+	}
+	for state, count := range counts {
+		metrics.SetGauge("stuck_zones", "Managed zones reported in a non active activation state", map[string]string{"account": account.Name(), "state": state}, float64(count))
+	}
+	if len(recreate) < 1 {
+		return nil
+	}
+	if allowed, reason := edge.changeAllowed(ChangeOperationDelete, now); !allowed {
+		log.Infof("Recreation of stuck zones %v deferred. Account: %s. %s", recreate, account.Name(), reason)
+		return nil
+	}
+	log.Warnf("Recreating stuck zones: %v. Account: %s", recreate, account.Name())
+	deleted, err := removeSecondaryZones(ctx, edge, account, recreate, dryrun)
+	if err != nil {
+		return err
+	}
+	if dryrun {
+		return nil
+	}
+	if err := edge.recreations.deleted(account.Name(), deleted, now); err != nil {
+		return err
+	}
+
+	return edge.activations.forget(account.Name(), deleted)
+}
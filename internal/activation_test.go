@@ -0,0 +1,151 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseActivationPolicies(t *testing.T) {
+
+	policies, err := ParseActivationPolicies([]string{"pending=recreate:2h", "LOCKED=ignore"}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, ActivationPolicy{State: "PENDING", Action: ActivationPolicyRecreate, Timeout: 2 * time.Hour}, policies["PENDING"])
+	assert.Equal(t, ActivationPolicyIgnore, policies["LOCKED"].Action)
+	assert.Equal(t, ActivationPolicy{State: "ERROR", Action: ActivationPolicyReport, Timeout: DefaultActivationTimeout}, policies["ERROR"])
+	assert.Equal(t, ActivationPolicyReport, activationPolicy(policies, "UNKNOWN").Action)
+
+	for _, spec := range []string{"PENDING", "PENDING=delete", "PENDING=wait:soon", "ACTIVE=report", "=wait"} {
+		_, err := ParseActivationPolicy(spec, time.Hour)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestActivationTrackerPersistence(t *testing.T) {
+
+	dir := t.TempDir()
+	tracker, err := newActivationTracker(dir)
+	assert.Nil(t, err)
+	start := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, tracker.update("1-A", map[string]string{"a.com": "PENDING", "b.com": "ACTIVE", "c.com": "ERROR"}, start))
+	// state ages survive a restart
+	tracker, err = newActivationTracker(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, tracker.update("1-A", map[string]string{"a.com": "PENDING", "b.com": "LOCKED", "c.com": "ACTIVE"}, time.Now()))
+	stuck := tracker.stuck("1-A", []string{"a.com", "b.com", "c.com"}, map[string]ActivationPolicy{}, time.Now())
+	assert.Equal(t, 2, len(stuck))
+	assert.Equal(t, "a.com", stuck[0].Zone)
+	assert.True(t, stuck[0].Age >= 2*time.Hour)
+	assert.Equal(t, "LOCKED", stuck[1].State)
+	assert.True(t, stuck[1].Age < time.Minute)
+	// other accounts are separate
+	assert.Equal(t, 0, len(tracker.stuck("1-B", []string{"a.com"}, nil, time.Now())))
+
+	assert.Nil(t, tracker.forget("1-A", []string{"a.com"}))
+	tracker, err = newActivationTracker(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tracker.stuck("1-A", []string{"a.com", "b.com"}, nil, time.Now())))
+
+	// unchanged states are not saved again
+	path := filepath.Join(dir, activationStateFile)
+	assert.Nil(t, os.Remove(path))
+	assert.Nil(t, tracker.update("1-A", map[string]string{"b.com": "LOCKED"}, time.Now()))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, tracker.update("1-A", map[string]string{"b.com": "ERROR"}, time.Now()))
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+}
+
+func TestRemediateStuckZones(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestRemediateStuckZones"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.ActivationPolicies = []string{"PENDING=recreate:1h", "NEW=wait:1h", "LOCKED=ignore"}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+
+	stubEdgeDNS.FuncOutput["GetZoneStates"] = map[string]string{"pending.zone": "PENDING", "new.zone": "NEW", "error.zone": "ERROR", "locked.zone": "LOCKED", "unmanaged.zone": "ERROR"}
+	_, err = handler.accountZoneNames(ctx)
+	assert.Nil(t, err)
+	managed := []string{"error.zone", "locked.zone", "new.zone", "pending.zone"}
+	assert.Nil(t, remediateStuckZones(ctx, handler, account, managed, false))
+	errorLabels := map[string]string{"account": account.Name(), "state": "ERROR"}
+	count, ok := metrics.Gauge("stuck_zones", errorLabels)
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), count)
+	_, ok = metrics.Gauge("stuck_zones", map[string]string{"account": account.Name(), "state": "NEW"})
+	assert.False(t, ok)
+
+	// past the timeout, waiting zones are reported and recreated zones deleted
+	stubEdgeDNS.FuncOutput["DeleteBulkZones"] = &dns.BulkZonesResponse{}
+	for _, entry := range handler.activations.store.list(nil) {
+		za := entry.(*ZoneActivation)
+		za.Since = za.Since.Add(-2 * time.Hour)
+	}
+	assert.Nil(t, remediateStuckZones(ctx, handler, account, managed, false))
+	count, ok = metrics.Gauge("stuck_zones", map[string]string{"account": account.Name(), "state": "NEW"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), count)
+	_, ok = handler.activations.store.get(activationKey(account.Name(), "pending.zone"))
+	assert.False(t, ok)
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	assert.Contains(t, buf.String(), `edgedns_coordinator_stuck_zone_age_seconds{account="123456",state="ERROR",zone="error.zone"}`)
+	assert.NotContains(t, buf.String(), "unmanaged.zone")
+	assert.NotContains(t, buf.String(), "locked.zone")
+}
+
+func TestRecreateStuckZonesInAccount(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestRecreateStuckZonesInAccount"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.ActivationPolicies = []string{"PENDING=recreate:1h"}
+	config.EdgeDNSAccountSpecs = []string{"contract=1-OTHER,group=42"}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	other := handler.Accounts[1]
+
+	stubEdgeDNS.FuncOutput["GetZoneStates"] = map[string]string{"pending.zone": "PENDING"}
+	_, err = handler.accountZoneNames(ctx)
+	assert.Nil(t, err)
+	for _, entry := range handler.activations.store.list(nil) {
+		za := entry.(*ZoneActivation)
+		za.Since = za.Since.Add(-2 * time.Hour)
+	}
+	assert.Nil(t, remediateStuckZones(ctx, handler, other, []string{"pending.zone"}, false))
+
+	// the deleted zone is created again in its account, also after a restart
+	handler, err = InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	assert.Equal(t, other.Name(), handler.createAccount("pending.zone").Name())
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"pending.zone", "new.zone"}, false))
+	queries := stubEdgeDNS.FuncOutput["CreatedZoneQueries"].(map[string]dns.ZoneQueryString)
+	assert.Equal(t, dns.ZoneQueryString{Contract: "1-OTHER", Group: "42"}, queries["pending.zone"])
+	assert.Equal(t, dns.ZoneQueryString{Contract: "123456", Group: "123456789"}, queries["new.zone"])
+	assert.Equal(t, handler.Accounts[0], handler.createAccount("pending.zone"))
+}
@@ -0,0 +1,601 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Operation classes of zone changes
+	ChangeOperationCreate      = "create" // secondary zones created for new registrar domains
+	ChangeOperationUpdate      = "update" // comment, TSIG key, DS record and delegation updates of managed zones
+	ChangeOperationDelete      = "delete" // secondary zones deleted for removed registrar domains or recreated
+	DefaultMaintenanceTimezone = "UTC"
+	// Longest maintenance window
+	MaxMaintenanceWindow = 31 * 24 * time.Hour
+	// Deferred changes file in the state directory
+	deferredChangeStateFile = "deferred-changes.json"
+	// Maintenance window schedules are searched this far ahead
+	cronSearchLimit = 5 * 366 * 24 * time.Hour
+)
+
+var (
+	// ChangeOperations are the operation classes of zone changes
+	ChangeOperations = []string{ChangeOperationCreate, ChangeOperationUpdate, ChangeOperationDelete}
+	cronMonthNames   = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDayNames     = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSchedule is a five field cron schedule, MINUTE HOUR DAY-OF-MONTH MONTH DAY-OF-WEEK. Each field is a bit set of
+// the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Unrestricted day fields. As in cron, a day matches either restricted day field if both are restricted.
+	domStar, dowStar bool
+}
+
+// parseCronSchedule parses a schedule of five fields. Fields are *, values, ranges, lists and steps, e.g. 0,30 2-4
+// */2 jan-jun mon-fri. Months and days of week may be given by name. Sunday is 0 or 7.
+func parseCronSchedule(fields []string) (*cronSchedule, error) {
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 schedule fields, got %d", len(fields))
+	}
+	var err error
+	s := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+
+	value := func(v string) (int, error) {
+		if n, ok := names[strings.ToLower(v)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("Invalid schedule field %q. Values range from %d to %d", field, min, max)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("Invalid schedule field %q step", field)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("Invalid schedule field %q range", field)
+			}
+		default:
+			var err error
+			if lo, err = value(part); err != nil {
+				return 0, err
+			}
+			// a/n steps from a to the end of the range
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *cronSchedule) dayMatch(t time.Time) bool {
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// next returns the first minute at or after t the schedule matches, in the time zone of loc
+func (s *cronSchedule) next(t time.Time, loc *time.Location) (time.Time, bool) {
+
+	limit := t.Add(cronSearchLimit)
+	t = t.In(loc)
+	if m := t.Truncate(time.Minute); !m.Equal(t) {
+		t = m.Add(time.Minute)
+	}
+	for t.Before(limit) {
+		y, mo, d := t.Date()
+		switch {
+		case s.month&(1<<uint(mo)) == 0:
+			t = time.Date(y, mo+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatch(t):
+			t = time.Date(y, mo, d+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(y, mo, d, t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// MaintenanceWindow is a recurring period operations of a class may be carried out in. Windows open at the times
+// matching the schedule and stay open for the duration.
+type MaintenanceWindow struct {
+	Operation string
+	Schedule  string
+	Duration  time.Duration
+	Location  *time.Location
+	cron      *cronSchedule
+}
+
+// ParseMaintenanceWindow parses a window spec OPERATION=MINUTE HOUR DAY MONTH WEEKDAY DURATION [TIMEZONE], e.g.
+// delete=0 2 * * sat 4h Europe/Berlin. Windows without a time zone use loc.
+func ParseMaintenanceWindow(spec string, loc *time.Location) (*MaintenanceWindow, error) {
+
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid maintenance window %q. Expected OPERATION=MINUTE HOUR DAY MONTH WEEKDAY DURATION [TIMEZONE]", spec)
+	}
+	w := &MaintenanceWindow{Operation: strings.ToLower(strings.TrimSpace(parts[0])), Location: loc}
+	if !validChangeOperation(w.Operation) {
+		return nil, fmt.Errorf("Invalid maintenance window %q. Valid operations: %s", spec, strings.Join(ChangeOperations, ", "))
+	}
+	fields := strings.Fields(parts[1])
+	if len(fields) != 6 && len(fields) != 7 {
+		return nil, fmt.Errorf("Invalid maintenance window %q. Expected OPERATION=MINUTE HOUR DAY MONTH WEEKDAY DURATION [TIMEZONE]", spec)
+	}
+	cron, err := parseCronSchedule(fields[:5])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid maintenance window %q. %s", spec, err.Error())
+	}
+	w.cron = cron
+	w.Schedule = strings.Join(fields[:5], " ")
+	if w.Duration, err = time.ParseDuration(fields[5]); err != nil || w.Duration < time.Minute || w.Duration > MaxMaintenanceWindow {
+		return nil, fmt.Errorf("Invalid maintenance window %q duration. Expected 1m to %s", spec, MaxMaintenanceWindow)
+	}
+	if len(fields) == 7 {
+		if w.Location, err = time.LoadLocation(fields[6]); err != nil {
+			return nil, fmt.Errorf("Invalid maintenance window %q time zone. %s", spec, err.Error())
+		}
+	}
+	if _, ok := w.cron.next(time.Now(), w.Location); !ok {
+		return nil, fmt.Errorf("Invalid maintenance window %q. Schedule never matches", spec)
+	}
+
+	return w, nil
+}
+
+// open returns true and the closing time if the window is open at now
+func (w *MaintenanceWindow) open(now time.Time) (bool, time.Time) {
+
+	// the last opening must be after now - duration
+	start, ok := w.cron.next(now.Add(-w.Duration).Add(time.Nanosecond), w.Location)
+	if !ok || start.After(now) {
+		return false, time.Time{}
+	}
+
+	return true, start.Add(w.Duration)
+}
+
+func (w *MaintenanceWindow) String() string {
+
+	return fmt.Sprintf("%s=%s %s %s", w.Operation, w.Schedule, w.Duration, w.Location)
+}
+
+// ChangeFreeze is a period no operations of the freeze operation classes are carried out in
+type ChangeFreeze struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ParseChangeFreeze parses a freeze spec START/END. Times are RFC 3339 times, or dates in loc. An end date includes
+// the whole day, e.g. 2021-12-24/2022-01-02 ends at midnight on January 3.
+func ParseChangeFreeze(spec string, loc *time.Location) (ChangeFreeze, error) {
+
+	freeze := ChangeFreeze{}
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return freeze, fmt.Errorf("Invalid change freeze %q. Expected START/END", spec)
+	}
+	parse := func(v string, end bool) (time.Time, error) {
+		v = strings.TrimSpace(v)
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			return t, fmt.Errorf("Invalid change freeze %q time %q. Expected an RFC 3339 time or a date", spec, v)
+		}
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	var err error
+	if freeze.Start, err = parse(parts[0], false); err != nil {
+		return freeze, err
+	}
+	if freeze.End, err = parse(parts[1], true); err != nil {
+		return freeze, err
+	}
+	if !freeze.End.After(freeze.Start) {
+		return freeze, fmt.Errorf("Invalid change freeze %q. End must be after start", spec)
+	}
+
+	return freeze, nil
+}
+
+// ChangePolicy decides when operations of each class may be carried out. Operations of a class with maintenance
+// windows are allowed while one of its windows is open. Freezes and the freeze file block the freeze operation
+// classes regardless of windows.
+type ChangePolicy struct {
+	windows          map[string][]*MaintenanceWindow
+	freezes          []ChangeFreeze
+	freezeFile       string
+	freezeOperations map[string]bool
+}
+
+// NewChangePolicy parses the maintenance window and freeze specs. Freezes apply to all operation classes if
+// freezeOperations is empty.
+func NewChangePolicy(windowSpecs, freezeSpecs []string, freezeFile string, freezeOperations []string, timezone string) (*ChangePolicy, error) {
+
+	if timezone == "" {
+		timezone = DefaultMaintenanceTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid maintenance time zone %q. %s", timezone, err.Error())
+	}
+	p := &ChangePolicy{windows: map[string][]*MaintenanceWindow{}, freezeFile: freezeFile, freezeOperations: map[string]bool{}}
+	for _, spec := range windowSpecs {
+		w, err := ParseMaintenanceWindow(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		p.windows[w.Operation] = append(p.windows[w.Operation], w)
+	}
+	for _, spec := range freezeSpecs {
+		freeze, err := ParseChangeFreeze(spec, loc)
+		if err != nil {
+			return nil, err
+		}
+		p.freezes = append(p.freezes, freeze)
+	}
+	if len(freezeOperations) == 0 {
+		freezeOperations = ChangeOperations
+	}
+	for _, op := range freezeOperations {
+		op = strings.ToLower(op)
+		if !validChangeOperation(op) {
+			return nil, fmt.Errorf("Invalid freeze operation %q. Valid operations: %s", op, strings.Join(ChangeOperations, ", "))
+		}
+		p.freezeOperations[op] = true
+	}
+
+	return p, nil
+}
+
+// empty returns true if the policy restricts no operations
+func (p *ChangePolicy) empty() bool {
+
+	return len(p.windows) == 0 && len(p.freezes) == 0 && p.freezeFile == ""
+}
+
+// allowed returns true if operations of class op may be carried out at now. Otherwise, the reason is returned.
+func (p *ChangePolicy) allowed(op string, now time.Time) (bool, string) {
+
+	if p.freezeOperations[op] {
+		if p.freezeFile != "" {
+			if _, err := os.Stat(p.freezeFile); err == nil {
+				return false, fmt.Sprintf("Freeze file %s present", p.freezeFile)
+			}
+		}
+		for _, freeze := range p.freezes {
+			if !now.Before(freeze.Start) && now.Before(freeze.End) {
+				return false, fmt.Sprintf("Change freeze until %s", freeze.End.Format(time.RFC3339))
+			}
+		}
+	}
+	windows := p.windows[op]
+	if len(windows) == 0 {
+		return true, ""
+	}
+	var next time.Time
+	for _, w := range windows {
+		if open, _ := w.open(now); open {
+			return true, ""
+		}
+		if start, ok := w.cron.next(now, w.Location); ok && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	return false, fmt.Sprintf("Outside maintenance windows. Next window opens %s", next.Format(time.RFC3339))
+}
+
+func validChangeOperation(op string) bool {
+
+	for _, o := range ChangeOperations {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeferredChange is a zone change held back by the change policy until its operation class is allowed
+type DeferredChange struct {
+	Operation string    `json:"operation"`
+	Account   string    `json:"account,omitempty"`
+	Zone      string    `json:"zone"`
+	Queued    time.Time `json:"queued"`
+}
+
+func (c *DeferredChange) stateKey() string {
+
+	return deferredChangeKey(c.Operation, c.Account, c.Zone)
+}
+
+func (c *DeferredChange) stateZone() string {
+
+	return c.Zone
+}
+
+// deferredChanges is the queue of deferred changes
+type deferredChanges struct {
+	store *keyedStateStore
+}
+
+func newDeferredChanges(stateDir string) (*deferredChanges, error) {
+
+	store, err := newKeyedStateStore(stateDir, deferredChangeStateFile, &[]*DeferredChange{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deferredChanges{store: store}, nil
+}
+
+func deferredChangeKey(op, account, zone string) string {
+
+	return op + "/" + account + "/" + zone
+}
+
+// add queues changes of zones. Zones already queued keep their queued time.
+func (d *deferredChanges) add(op, account string, zones []string, now time.Time) error {
+
+	if len(zones) < 1 {
+		return nil
+	}
+
+	return d.store.change(func(entries map[string]stateEntry) bool {
+		for _, zone := range zones {
+			key := deferredChangeKey(op, account, zone)
+			if _, ok := entries[key]; !ok {
+				entries[key] = &DeferredChange{Operation: op, Account: account, Zone: zone, Queued: now}
+			}
+		}
+		return true
+	})
+}
+
+// remove drops queued changes of zones. All queued changes of op and account are dropped if zones is nil.
+func (d *deferredChanges) remove(op, account string, zones []string) error {
+
+	drop := map[string]bool{}
+	for _, zone := range zones {
+		drop[zone] = true
+	}
+
+	return d.store.removeIf(func(entry stateEntry) bool {
+		c := entry.(*DeferredChange)
+		return c.Operation == op && c.Account == account && (zones == nil || drop[c.Zone])
+	})
+}
+
+// zones returns the queued zones of op and account, sorted
+func (d *deferredChanges) zones(op, account string) []string {
+
+	zones := []string{}
+	for _, c := range d.list() {
+		if c.Operation == op && c.Account == account {
+			zones = append(zones, c.Zone)
+		}
+	}
+
+	return zones
+}
+
+// list returns the queued changes sorted by operation, account and zone
+func (d *deferredChanges) list() []*DeferredChange {
+
+	changes := []*DeferredChange{}
+	for _, entry := range d.store.list(nil) {
+		changes = append(changes, entry.(*DeferredChange))
+	}
+
+	return changes
+}
+
+// changeAllowed returns true if operations of class op may be carried out at now. Otherwise, the reason is returned.
+func (e *EdgeDNSHandler) changeAllowed(op string, now time.Time) (bool, string) {
+
+	if e.changePolicy == nil {
+		return true, ""
+	}
+
+	return e.changePolicy.allowed(op, now)
+}
+
+// changeWindows returns whether each operation class may be carried out this interval. Classes held back are logged
+// and the state of each class exported as a metric.
+func changeWindows(ctx context.Context, edge *EdgeDNSHandler, now time.Time) map[string]bool {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	windows := map[string]bool{}
+	for _, op := range ChangeOperations {
+		allowed, reason := edge.changeAllowed(op, now)
+		windows[op] = allowed
+		open := 0.0
+		if allowed {
+			open = 1
+		} else {
+			log.Infof("Zone %s operations deferred. %s", op, reason)
+		}
+		metrics.SetGauge("change_window_open", "1 if zone operations of the class may be carried out, else 0", map[string]string{"operation": op}, open)
+	}
+
+	return windows
+}
+
+// deferZoneChanges queues changes of zones held back by the change policy. Dry runs only log the changes.
+func deferZoneChanges(ctx context.Context, edge *EdgeDNSHandler, op, account string, zones []string, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if len(zones) < 1 {
+		return
+	}
+	if dryrun {
+		log.Infof("Zone %s of %v deferred. Account: %s. dry run. Not queued", op, zones, account)
+		return
+	}
+	log.Infof("Zone %s of %v deferred. Account: %s", op, zones, account)
+	if err := edge.deferred.add(op, account, zones, time.Now()); err != nil {
+		log.Errorf("Unable to save deferred changes. Error: %s", err.Error())
+	}
+}
+
+// deferZoneCreates queues the creation of new zones held back by the change policy. New zones are found again each
+// interval, so queued creations of domains no longer new are dropped.
+func deferZoneCreates(ctx context.Context, edge *EdgeDNSHandler, newZones []string, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if !dryrun {
+		current := map[string]bool{}
+		for _, zone := range newZones {
+			current[zone] = true
+		}
+		stale := []string{}
+		for _, zone := range edge.deferred.zones(ChangeOperationCreate, "") {
+			if !current[zone] {
+				stale = append(stale, zone)
+			}
+		}
+		if len(stale) > 0 {
+			if err := edge.deferred.remove(ChangeOperationCreate, "", stale); err != nil {
+				log.Errorf("Unable to save deferred changes. Error: %s", err.Error())
+			}
+		}
+	}
+	deferZoneChanges(ctx, edge, ChangeOperationCreate, "", newZones, dryrun)
+}
+
+// pendingZoneDeletions returns the zones of an account removed from the registrar this interval together with the
+// deletions deferred earlier. Deferred deletions of zones listed by the registrar again, or no longer in the account,
+// are cancelled.
+func pendingZoneDeletions(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, removed, accountZones, registrarDomains []string) []string {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	listed := map[string]bool{}
+	for _, zone := range registrarDomains {
+		listed[zone] = true
+	}
+	exists := map[string]bool{}
+	for _, zone := range accountZones {
+		exists[zone] = true
+	}
+	pending := append([]string{}, removed...)
+	cancelled := []string{}
+	for _, zone := range edge.deferred.zones(ChangeOperationDelete, account.Name()) {
+		if listed[zone] || !exists[zone] {
+			cancelled = append(cancelled, zone)
+			continue
+		}
+		pending = append(pending, zone)
+	}
+	if len(cancelled) > 0 {
+		log.Infof("Deferred zone deletions cancelled: %v. Zones listed by the registrar again or no longer in account %s", cancelled, account.Name())
+		if err := edge.deferred.remove(ChangeOperationDelete, account.Name(), cancelled); err != nil {
+			log.Errorf("Unable to save deferred changes. Error: %s", err.Error())
+		}
+	}
+
+	return sortedZoneList(pending)
+}
+
+// reportDeferredChanges exports the number of queued changes of each operation class
+func reportDeferredChanges(edge *EdgeDNSHandler) {
+
+	counts := map[string]int{}
+	for _, c := range edge.deferred.list() {
+		counts[c.Operation]++
+	}
+	for _, op := range ChangeOperations {
+		metrics.SetGauge("deferred_changes", "Zone changes queued until their operation class is allowed", map[string]string{"operation": op}, float64(counts[op]))
+	}
+}
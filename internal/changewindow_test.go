@@ -0,0 +1,188 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+
+	for _, spec := range []string{
+		"purge=0 2 * * * 1h",
+		"delete=0 2 * *",
+		"delete=61 2 * * * 1h",
+		"delete=0 2 * * funday 1h",
+		"delete=0 2 * * * 0s",
+		"delete=0 2 * * * 32d",
+		"delete=0 2 30 feb * 1h",
+		"delete=0 2 * * * 1h Mars/Olympus_Mons",
+	} {
+		_, err := ParseMaintenanceWindow(spec, time.UTC)
+		assert.NotNil(t, err, spec)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.Nil(t, err)
+	w, err := ParseMaintenanceWindow("DELETE=0 2 * * sat 4h Europe/Berlin", time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, ChangeOperationDelete, w.Operation)
+	open, end := w.open(time.Date(2021, 3, 6, 2, 30, 0, 0, berlin))
+	assert.True(t, open)
+	assert.Equal(t, time.Date(2021, 3, 6, 6, 0, 0, 0, berlin), end.In(berlin))
+	open, _ = w.open(time.Date(2021, 3, 6, 6, 0, 0, 0, berlin))
+	assert.False(t, open)
+	open, _ = w.open(time.Date(2021, 3, 6, 0, 30, 0, 0, time.UTC))
+	assert.False(t, open)
+	open, _ = w.open(time.Date(2021, 3, 5, 2, 30, 0, 0, berlin))
+	assert.False(t, open)
+
+	// windows may span midnight
+	w, err = ParseMaintenanceWindow("update=30 23 * * * 2h", time.UTC)
+	assert.Nil(t, err)
+	open, _ = w.open(time.Date(2021, 3, 7, 1, 15, 0, 0, time.UTC))
+	assert.True(t, open)
+	open, _ = w.open(time.Date(2021, 3, 7, 23, 29, 0, 0, time.UTC))
+	assert.False(t, open)
+
+	// restricted day of month and day of week match either
+	w, err = ParseMaintenanceWindow("create=0 0 1 * mon 1h", time.UTC)
+	assert.Nil(t, err)
+	open, _ = w.open(time.Date(2021, 3, 8, 0, 30, 0, 0, time.UTC))
+	assert.True(t, open)
+	open, _ = w.open(time.Date(2021, 4, 1, 0, 30, 0, 0, time.UTC))
+	assert.True(t, open)
+	open, _ = w.open(time.Date(2021, 3, 9, 0, 30, 0, 0, time.UTC))
+	assert.False(t, open)
+
+	// lists and steps
+	w, err = ParseMaintenanceWindow("delete=*/20 9-17/4 * jan,jul 7 10m", time.UTC)
+	assert.Nil(t, err)
+	open, _ = w.open(time.Date(2021, 7, 4, 13, 45, 0, 0, time.UTC))
+	assert.True(t, open)
+	open, _ = w.open(time.Date(2021, 7, 4, 11, 5, 0, 0, time.UTC))
+	assert.False(t, open)
+	open, _ = w.open(time.Date(2021, 6, 6, 13, 45, 0, 0, time.UTC))
+	assert.False(t, open)
+}
+
+func TestParseChangeFreeze(t *testing.T) {
+
+	for _, spec := range []string{"2021-12-20", "2021-12-20/tomorrow", "2022-01-03/2021-12-20"} {
+		_, err := ParseChangeFreeze(spec, time.UTC)
+		assert.NotNil(t, err, spec)
+	}
+
+	freeze, err := ParseChangeFreeze("2021-12-20/2022-01-02", time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 12, 20, 0, 0, 0, 0, time.UTC), freeze.Start)
+	assert.Equal(t, time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC), freeze.End)
+
+	freeze, err = ParseChangeFreeze("2021-12-20T18:00:00+01:00/2021-12-21T06:00:00Z", time.UTC)
+	assert.Nil(t, err)
+	assert.True(t, freeze.Start.Equal(time.Date(2021, 12, 20, 17, 0, 0, 0, time.UTC)))
+}
+
+func TestChangePolicy(t *testing.T) {
+
+	freezeFile := filepath.Join(t.TempDir(), "freeze")
+	_, err := NewChangePolicy(nil, nil, "", []string{"purge"}, "")
+	assert.NotNil(t, err)
+	_, err = NewChangePolicy(nil, nil, "", nil, "Nowhere/Else")
+	assert.NotNil(t, err)
+
+	policy, err := NewChangePolicy([]string{"delete=0 2 * * sat 4h", "delete=0 22 * * wed 1h"}, []string{"2021-12-20/2022-01-02"}, freezeFile, []string{"delete", "update"}, "")
+	assert.Nil(t, err)
+	assert.False(t, policy.empty())
+
+	saturday := time.Date(2021, 3, 6, 3, 0, 0, 0, time.UTC)
+	allowed, _ := policy.allowed(ChangeOperationDelete, saturday)
+	assert.True(t, allowed)
+	allowed, reason := policy.allowed(ChangeOperationDelete, saturday.Add(-48*time.Hour))
+	assert.False(t, allowed)
+	assert.Contains(t, reason, "2021-03-06T02:00:00Z")
+	allowed, _ = policy.allowed(ChangeOperationCreate, saturday.Add(-48*time.Hour))
+	assert.True(t, allowed)
+
+	// freezes take precedence over windows and only apply to the freeze operations
+	christmas := time.Date(2021, 12, 25, 2, 30, 0, 0, time.UTC)
+	allowed, reason = policy.allowed(ChangeOperationDelete, christmas)
+	assert.False(t, allowed)
+	assert.Contains(t, reason, "2022-01-03T00:00:00Z")
+	allowed, _ = policy.allowed(ChangeOperationUpdate, christmas)
+	assert.False(t, allowed)
+	allowed, _ = policy.allowed(ChangeOperationCreate, christmas)
+	assert.True(t, allowed)
+
+	assert.Nil(t, ioutil.WriteFile(freezeFile, []byte{}, 0600))
+	allowed, reason = policy.allowed(ChangeOperationDelete, saturday)
+	assert.False(t, allowed)
+	assert.Contains(t, reason, freezeFile)
+	assert.Nil(t, os.Remove(freezeFile))
+	allowed, _ = policy.allowed(ChangeOperationDelete, saturday)
+	assert.True(t, allowed)
+}
+
+func TestMonitorChangeWindows(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestMonitorChangeWindows"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.FreezeFile = filepath.Join(t.TempDir(), "freeze")
+	config.FreezeOperations = []string{ChangeOperationDelete}
+	assert.Nil(t, ioutil.WriteFile(config.FreezeFile, []byte{}, 0600))
+	stubEdgeDNS.FuncOutput["GetZoneNames"] = []string{"old.zone", "back.zone"}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+
+	// deletions are queued during the freeze. Creations go ahead
+	lastRegistrarTally["windowtest"] = []string{"back.zone", "old.zone", "regtest.zone", "regtest2.zone"}
+	monitorProc(ctx, "windowtest", stubRegistrar, handler, time.Millisecond, false, true)
+	_, ok := stubEdgeDNS.FuncOutput["DeletedZones"]
+	assert.False(t, ok)
+	assert.Len(t, stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate), 2)
+	assert.Equal(t, []string{"back.zone", "old.zone"}, handler.deferred.zones(ChangeOperationDelete, handler.Accounts[0].Name()))
+
+	// queued deletions survive a restart
+	deferred, err := newDeferredChanges(config.StateDir)
+	assert.Nil(t, err)
+	assert.Len(t, deferred.list(), 2)
+
+	// deletions of domains listed again are cancelled; the rest are carried out once the freeze ends
+	stubRegistrar.FuncOutput["GetDomains"] = []string{"back.zone", "regtest.zone", "regtest2.zone"}
+	assert.Nil(t, os.Remove(config.FreezeFile))
+	monitorProc(ctx, "windowtest", stubRegistrar, handler, time.Millisecond, false, true)
+	assert.Equal(t, []string{"old.zone"}, stubEdgeDNS.FuncOutput["DeletedZones"])
+	assert.Len(t, handler.deferred.list(), 0)
+
+	// creations are queued outside their windows
+	handler.changePolicy, err = NewChangePolicy([]string{"create=0 0 1 1 * 1m"}, nil, "", nil, "")
+	assert.Nil(t, err)
+	stubRegistrar.FuncOutput["GetDomains"] = []string{"back.zone", "new.zone", "regtest.zone", "regtest2.zone"}
+	stubEdgeDNS.FuncOutput["GetZoneNames"] = []string{"back.zone", "regtest.zone", "regtest2.zone"}
+	monitorProc(ctx, "windowtest", stubRegistrar, handler, time.Millisecond, false, true)
+	_, ok = stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)["new.zone"]
+	assert.False(t, ok)
+	assert.Equal(t, []string{"new.zone"}, handler.deferred.zones(ChangeOperationCreate, ""))
+}
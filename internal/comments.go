@@ -0,0 +1,299 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Maximum length of Edge DNS zone comments
+	DefaultZoneCommentMaxLength = 2048
+	// Comment sources of zones in the state directory
+	zoneCommentStateFile = "zone-comments.json"
+)
+
+// ZoneCommentData is the data of the zone comment template
+type ZoneCommentData struct {
+	Registrar string
+	Zone      string
+	Account   string
+	// Registrar values of created zones, Edge DNS values of existing zones. The TSIG key secret is redacted
+	Domain registrar.Domain
+	Time   time.Time
+	// ID of the monitor interval
+	CycleID string
+	Host    string
+}
+
+// ZoneCommentTemplate renders zone comments within a length limit
+type ZoneCommentTemplate struct {
+	text      string
+	tmpl      *template.Template
+	maxLength int
+}
+
+// ParseZoneCommentTemplate parses a Go text/template zone comment. The template is rendered with sample data to catch
+// references to unknown fields.
+func ParseZoneCommentTemplate(text string, maxLength int) (*ZoneCommentTemplate, error) {
+
+	if maxLength <= 0 {
+		maxLength = DefaultZoneCommentMaxLength
+	}
+	tmpl, err := template.New("zone-comment").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid zone comment template. %s", err.Error())
+	}
+	t := &ZoneCommentTemplate{text: text, tmpl: tmpl, maxLength: maxLength}
+	sample := ZoneCommentData{
+		Registrar: "registrar",
+		Zone:      "example.com",
+		Account:   "1-ABCDE",
+		Domain:    registrar.Domain{Name: "example.com", Type: "SECONDARY", Masters: []string{"192.0.2.1"}, TsigKey: &dns.TSIGKey{Name: "key", Algorithm: "hmac-sha256"}},
+		Time:      time.Now(),
+		CycleID:   "0",
+		Host:      "host",
+	}
+	if _, _, err := t.render(sample); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// render executes the template. Comments longer than the maximum length are truncated.
+func (t *ZoneCommentTemplate) render(data ZoneCommentData) (comment string, truncated bool, err error) {
+
+	buf := &bytes.Buffer{}
+	if err := t.tmpl.Execute(buf, data); err != nil {
+		return "", false, fmt.Errorf("Zone comment template failed. %s", err.Error())
+	}
+	comment, truncated = truncateComment(buf.String(), t.maxLength)
+
+	return comment, truncated, nil
+}
+
+// truncateComment truncates comment to at most maxLength characters
+func truncateComment(comment string, maxLength int) (string, bool) {
+
+	if utf8.RuneCountInString(comment) <= maxLength {
+		return comment, false
+	}
+
+	return string([]rune(comment)[:maxLength]), true
+}
+
+// commentSource identifies the template or override a comment is rendered from
+func commentSource(text string) string {
+
+	sum := sha256.Sum256([]byte(text))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// newCycleID returns a random ID of a monitor interval
+func newCycleID() string {
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// zoneComment returns the comment of a zone and the source it is rendered from. A comment override replaces the
+// template. Template failures fall back to the default comment.
+func zoneComment(ctx context.Context, edge *EdgeDNSHandler, account string, domain *registrar.Domain, override *string) (string, string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	maxLength := DefaultZoneCommentMaxLength
+	if edge.commentTemplate != nil {
+		maxLength = edge.commentTemplate.maxLength
+	}
+	if override != nil {
+		comment, truncated := truncateComment(*override, maxLength)
+		if truncated {
+			log.Warnf("Zone %s comment override truncated to %d characters", domain.Name, maxLength)
+		}
+		return comment, commentSource(*override)
+	}
+	if edge.commentTemplate == nil {
+		return DefaultZoneComment, commentSource(DefaultZoneComment)
+	}
+	data := ZoneCommentData{
+		Registrar: edge.Registrar,
+		Zone:      domain.Name,
+		Account:   account,
+		Domain:    *domain,
+		Time:      time.Now(),
+		CycleID:   edge.cycleID,
+		Host:      edge.hostname,
+	}
+	if domain.TsigKey != nil {
+		data.Domain.TsigKey = registrar.RedactTsigKey(domain.TsigKey)
+	}
+	comment, truncated, err := edge.commentTemplate.render(data)
+	if err != nil {
+		log.Errorf("Zone %s comment not rendered. Using default comment. Error: %s", domain.Name, err.Error())
+		return DefaultZoneComment, commentSource(DefaultZoneComment)
+	}
+	if truncated {
+		log.Warnf("Zone %s comment truncated to %d characters", domain.Name, maxLength)
+	}
+
+	return comment, commentSource(edge.commentTemplate.text)
+}
+
+// ZoneCommentSource is the source of the comment last set on a zone
+type ZoneCommentSource struct {
+	Zone   string `json:"zone"`
+	Source string `json:"source"`
+}
+
+func (c *ZoneCommentSource) stateKey() string {
+
+	return c.Zone
+}
+
+func (c *ZoneCommentSource) stateZone() string {
+
+	return c.Zone
+}
+
+// zoneCommentTracker records the comment source of each zone so that comments are only updated when their template
+// or override changes
+type zoneCommentTracker struct {
+	store *keyedStateStore
+}
+
+func newZoneCommentTracker(stateDir string) (*zoneCommentTracker, error) {
+
+	store, err := newKeyedStateStore(stateDir, zoneCommentStateFile, &[]*ZoneCommentSource{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &zoneCommentTracker{store: store}, nil
+}
+
+// source returns the comment source of a zone. Zones without a recorded source, e.g. zones created before comment
+// templates or before the first drift pass, carry the default comment.
+func (c *zoneCommentTracker) source(zone string) string {
+
+	entry, ok := c.store.get(zone)
+	if !ok {
+		return commentSource(DefaultZoneComment)
+	}
+
+	return entry.(*ZoneCommentSource).Source
+}
+
+func (c *zoneCommentTracker) set(zone, source string) error {
+
+	return c.store.put(&ZoneCommentSource{Zone: zone, Source: source})
+}
+
+// retain drops the sources of zones not in zones
+func (c *zoneCommentTracker) retain(zones []string) error {
+
+	return c.store.retainZones(zones)
+}
+
+// zoneCommentOverride returns the comment override of a zone, if any, and the source its comment is rendered from
+func zoneCommentOverride(edge *EdgeDNSHandler, zone string) (*string, string) {
+
+	var override *string
+	if edge.overrides != nil {
+		_, override = edge.overrides.apply(&registrar.Domain{Name: zone})
+	}
+	switch {
+	case override != nil:
+		return override, commentSource(*override)
+	case edge.commentTemplate != nil:
+		return nil, commentSource(edge.commentTemplate.text)
+	}
+
+	return nil, commentSource(DefaultZoneComment)
+}
+
+// updateZoneComments is the comment drift pass. Managed zones whose comment template or override changed since their
+// comment was last set are read from Edge DNS and updated if their comment differs from the rendered comment.
+func updateZoneComments(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	updated := 0
+	for _, zone := range sortedZoneList(zones) {
+		override, source := zoneCommentOverride(edge, zone)
+		if edge.zoneComments.source(zone) == source {
+			continue
+		}
+		resp, err := account.client.GetZone(ctx, zone)
+		if err != nil {
+			log.Errorf("Zone %s comment not checked. Error: %s", zone, err.Error())
+			continue
+		}
+		domain := &registrar.Domain{
+			Name:                  zone,
+			Type:                  resp.Type,
+			SignAndServe:          resp.SignAndServe,
+			SignAndServeAlgorithm: resp.SignAndServeAlgorithm,
+			Masters:               resp.Masters,
+			TsigKey:               resp.TsigKey,
+		}
+		comment, source := zoneComment(ctx, edge, account.Name(), domain, override)
+		if resp.Comment != comment {
+			if dryrun {
+				log.Infof("Update zone %s comment to %q. dry run. No changes made", zone, comment)
+				continue
+			}
+			update := &dns.ZoneCreate{
+				Zone:                  zone,
+				Type:                  resp.Type,
+				Masters:               resp.Masters,
+				Comment:               comment,
+				SignAndServe:          resp.SignAndServe,
+				SignAndServeAlgorithm: resp.SignAndServeAlgorithm,
+				TsigKey:               resp.TsigKey,
+				Target:                resp.Target,
+				EndCustomerId:         resp.EndCustomerId,
+				ContractId:            resp.ContractId,
+			}
+			if err := account.client.UpdateZone(ctx, update); err != nil {
+				log.Errorf("Failed to update zone %s comment. Error: %s", zone, err.Error())
+				continue
+			}
+			log.Infof("Updated zone %s comment to %q", zone, comment)
+			updated++
+		}
+		if err := edge.zoneComments.set(zone, source); err != nil {
+			log.Errorf("Unable to save zone %s comment source. Error: %s", zone, err.Error())
+		}
+	}
+	log.Debugf("Zone comment drift pass complete. Account: %s. %d zones updated", account.Name(), updated)
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseZoneCommentTemplate(t *testing.T) {
+
+	_, err := ParseZoneCommentTemplate("Created by {{.Registrar", 0)
+	assert.NotNil(t, err)
+	_, err = ParseZoneCommentTemplate("Created by {{.Unknown}}", 0)
+	assert.NotNil(t, err)
+
+	tmpl, err := ParseZoneCommentTemplate("{{.Zone}} via {{.Registrar}} {{.Domain.Masters}} {{.Time.Format \"2006\"}}", 0)
+	assert.Nil(t, err)
+	comment, truncated, err := tmpl.render(ZoneCommentData{Zone: "example.com", Registrar: "akamai", Domain: registrar.Domain{Masters: []string{"1.2.3.4"}}, Time: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, "example.com via akamai [1.2.3.4] 2021", comment)
+
+	// long comments are truncated by character
+	tmpl, err = ParseZoneCommentTemplate("zoné {{.Zone}}", 4)
+	assert.Nil(t, err)
+	comment, truncated, err = tmpl.render(ZoneCommentData{Zone: "example.com"})
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, "zoné", comment)
+}
+
+func TestZoneCommentCreate(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestZoneCommentCreate"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.Registrar = "test"
+	config.TSig = true
+	config.ZoneCommentTemplate = "{{.Zone}} by {{.Registrar}} on {{.Host}} cycle {{.CycleID}} key {{.Domain.TsigKey.Secret}}"
+	config.ZoneCommentMaxLength = DefaultZoneCommentMaxLength
+	stubRegistrar.FuncOutput["GetTsigKey"] = &dns.TSIGKey{Name: "key", Algorithm: "hmac-sha256", Secret: "c2VjcmV0LWNvbW1lbnQ="}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	handler.cycleID = "cycle1"
+
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"comment.zone"}, false))
+	comment := stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)["comment.zone"].Comment
+	assert.True(t, strings.HasPrefix(comment, "comment.zone by test on "+handler.hostname+" cycle cycle1"))
+	// TSIG key secrets are not available to templates
+	assert.NotContains(t, comment, "c2VjcmV0LWNvbW1lbnQ=")
+}
+
+func TestUpdateZoneComments(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestUpdateZoneComments"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.UpdateZoneComments = true
+	config.ZoneCommentTemplate = DefaultZoneComment
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+
+	// zones without a recorded source carry the default comment and are not read with the default template
+	stubEdgeDNS.FuncErrors["GetZone"] = "zone not read"
+	updateZoneComments(ctx, handler, account, []string{"managed.zone", "other.zone"}, false)
+	_, ok := stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+	assert.Equal(t, commentSource(DefaultZoneComment), handler.zoneComments.source("other.zone"))
+	delete(stubEdgeDNS.FuncErrors, "GetZone")
+
+	// comments matching the template are not updated
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "managed.zone", Type: "SECONDARY", Masters: []string{"1.2.3.4"}, Comment: DefaultZoneComment}
+	updateZoneComments(ctx, handler, account, []string{"managed.zone"}, false)
+	_, ok = stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+
+	// a changed template updates comments once
+	handler.commentTemplate, err = ParseZoneCommentTemplate("{{.Zone}} managed in {{.Account}}", DefaultZoneCommentMaxLength)
+	assert.Nil(t, err)
+	updateZoneComments(ctx, handler, account, []string{"managed.zone"}, true)
+	_, ok = stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+	updateZoneComments(ctx, handler, account, []string{"managed.zone"}, false)
+	updated := stubEdgeDNS.FuncOutput["UpdateZone"].(map[string]*dns.ZoneCreate)["managed.zone"]
+	assert.Equal(t, "managed.zone managed in "+account.Name(), updated.Comment)
+	assert.Equal(t, []string{"1.2.3.4"}, updated.Masters)
+
+	delete(stubEdgeDNS.FuncOutput, "GetZone")
+	delete(stubEdgeDNS.FuncOutput, "UpdateZone")
+	updateZoneComments(ctx, handler, account, []string{"managed.zone"}, false)
+	_, ok = stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+
+	// comment sources survive a restart
+	tracker, err := newZoneCommentTracker(config.StateDir)
+	assert.Nil(t, err)
+	assert.Equal(t, commentSource("{{.Zone}} managed in {{.Account}}"), tracker.source("managed.zone"))
+}
+
+func TestUpdateZoneCommentsNewTemplate(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestUpdateZoneCommentsNewTemplate"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.UpdateZoneComments = true
+	config.ZoneCommentTemplate = "{{.Zone}} managed in {{.Account}}"
+
+	// enabling the pass together with a new template updates existing zones, with or without a state directory
+	for _, stateDir := range []string{config.StateDir, ""} {
+		config.StateDir = stateDir
+		delete(stubEdgeDNS.FuncOutput, "UpdateZone")
+		handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+		assert.Nil(t, err)
+		account := handler.Accounts[0]
+		stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "existing.zone", Type: "SECONDARY", Masters: []string{"1.2.3.4"}, Comment: DefaultZoneComment}
+		updateZoneComments(ctx, handler, account, []string{"existing.zone"}, false)
+		updated := stubEdgeDNS.FuncOutput["UpdateZone"].(map[string]*dns.ZoneCreate)["existing.zone"]
+		assert.Equal(t, "existing.zone managed in "+account.Name(), updated.Comment)
+	}
+}
@@ -14,9 +14,12 @@
 package internal
 
 import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -27,26 +30,63 @@ const (
 
 var (
 	DefaultConfig = Config{
-		Registrar:             "",
-		RegistrarConfigPath:   "",
-		Interval:              DefaultInterval,
-		EdgeDNSContract:       "",
-		EdgeDNSGroup:          0,
-		EdgegridHost:          "",
-		EdgegridClientToken:   "",
-		EdgegridClientSecret:  "",
-		EdgegridAccessToken:   "",
-		EdgegridEdgercPath:    "",
-		EdgegridEdgercSection: "",
-		LogFilePath:           "",
-		LogHandler:            "text",
-		LogLevel:              "info",
-		PluginLibPath:         "",
+		Registrar:               "",
+		RegistrarConfigPath:     "",
+		Interval:                DefaultInterval,
+		EdgeDNSContract:         "",
+		EdgeDNSGroup:            0,
+		EdgeDNSPageSize:         registrar.DefaultZonePageSize,
+		EdgeDNSContractName:     "",
+		EdgeDNSGroupName:        "",
+		EdgegridHost:            "",
+		EdgegridClientToken:     "",
+		EdgegridClientSecret:    "",
+		EdgegridAccessToken:     "",
+		EdgegridEdgercPath:      "",
+		EdgegridEdgercSection:   "",
+		EdgegridTimeout:         registrar.DefaultEdgeDNSTimeout,
+		EdgegridKeepAlive:       registrar.DefaultEdgeDNSKeepAlive,
+		EdgegridProxy:           "",
+		EdgegridAccountKey:      "",
+		LogFilePath:             "",
+		LogHandler:              "text",
+		LogLevel:                "info",
+		PluginLibPath:           "",
+		VerifyResolver:          "",
+		VerifyTimeout:           DefaultDNSQueryTimeout,
+		VerifyThreshold:         DefaultVerifyThreshold,
+		ProbeTransferType:       ProbeTransferAXFR,
+		MasterAddressFamily:     registrar.AddressFamilyBoth,
+		SecretRefresh:           registrar.DefaultSecretRefresh,
+		ActivationTimeout:       DefaultActivationTimeout,
+		ConfirmTimeout:          DefaultConfirmTimeout,
+		ConfirmPollInterval:     DefaultConfirmPollInterval,
+		ConfirmRetries:          DefaultConfirmRetries,
+		TransferStatusBatchSize: DefaultTransferStatusBatchSize,
+		TransferAlertThreshold:  DefaultTransferAlertThreshold,
+		DelegationFormat:        DelegationFormatTable,
+		ZoneCommentTemplate:     DefaultZoneComment,
+		ZoneCommentMaxLength:    DefaultZoneCommentMaxLength,
+		MaintenanceTimezone:     DefaultMaintenanceTimezone,
+		NotifyTimeout:           DefaultNotifyTimeout,
+		RemovalAction:           RemovalActionDelete,
+		ConvertTransferSource:   ConvertSourceMasters,
+		TSigAlgorithm:           TsigAlgorithmHmacSHA256,
+		TSigRotationGrace:       DefaultTsigRotationGrace,
+		StateDir:                "",
+		MetricsAddress:          "",
+		LogSyslogNetwork:        DefaultSyslogNetwork,
+		LogSyslogAddress:        "",
+		LogSyslogFacility:       DefaultSyslogFacility,
+		LogSyslogAppName:        DefaultSyslogAppName,
+		LogJournaldSocket:       DefaultJournaldSocket,
+		LogFileMode:             fmt.Sprintf("%#o", DefaultLogFileMode),
 	}
 )
 
 // Internal master config. Reflects all accepted command line directives
 type Config struct {
+	ConfigPath          string // Coordinator config file path
 	Registrar           string
 	RegistrarConfigPath string        // Registrar conffg file path. Parsed by Registrar Provider
 	Interval            time.Duration // Default: 15 minutes
@@ -54,8 +94,13 @@ type Config struct {
 	TSig                bool
 	FailOnError         bool
 	// Edge DNS Credentials
-	EdgeDNSContract      string
-	EdgeDNSGroup         int
+	EdgeDNSContract     string
+	EdgeDNSGroup        int
+	EdgeDNSPageSize     int
+	EdgeDNSAccountSpecs []string
+	// Contract and group resolved by name
+	EdgeDNSContractName  string
+	EdgeDNSGroupName     string
 	EdgegridHost         string
 	EdgegridClientToken  string
 	EdgegridClientSecret string
@@ -63,14 +108,105 @@ type Config struct {
 	// --OR--
 	EdgegridEdgercPath    string
 	EdgegridEdgercSection string
+	// Edge DNS API HTTP client
+	EdgegridTimeout   time.Duration
+	EdgegridKeepAlive time.Duration
+	EdgegridProxy     string
+	// Default account switch key
+	EdgegridAccountKey string
 	// Optional
 	LogFilePath string
 	LogHandler  string
 	LogLevel    string
 	DryRun      bool
 	Once        bool
+	// Syslog and journald log handlers
+	LogSyslogNetwork  string
+	LogSyslogAddress  string
+	LogSyslogFacility string
+	LogSyslogAppName  string
+	LogJournaldSocket string
+	// Log file rotation and additional log sinks
+	LogFileMaxSize    int // megabytes
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+	LogFileRetention  time.Duration
+	LogFileCompress   bool
+	LogFileMode       string
+	LogSinks          []string
 	// Plugin Registrar
 	PluginLibPath string
+	// Serial verification
+	Verify          bool
+	VerifyResolver  string
+	VerifyTimeout   time.Duration
+	VerifyThreshold time.Duration
+	// Master transfer probe
+	ProbeMasters      bool
+	ProbeTransferType string
+	// Master address family policy
+	MasterAddressFamily string
+	// Re-read interval of secret references
+	SecretRefresh time.Duration
+	// Activation state policies
+	ActivationPolicies []string
+	ActivationTimeout  time.Duration
+	// Confirmation of created zones
+	ConfirmZones        bool
+	ConfirmTimeout      time.Duration
+	ConfirmPollInterval time.Duration
+	ConfirmRetries      int
+	// Zone transfer status monitoring
+	TransferStatusInterval  time.Duration
+	TransferStatusBatchSize int
+	TransferAlertThreshold  time.Duration
+	// DS record publication at the registrar
+	PublishDS bool
+	// Parent delegation checks
+	DelegationResolver      string
+	DelegationCheckInterval time.Duration
+	DelegationFormat        string
+	// Name server delegation updates at the registrar
+	UpdateDelegation    bool
+	DelegationAllowList []string
+	// Per zone overrides of registrar values
+	ZoneOverridesPath string
+	// Zone comments
+	ZoneCommentTemplate  string
+	ZoneCommentMaxLength int
+	UpdateZoneComments   bool
+	// Maintenance windows and change freezes
+	MaintenanceWindows  []string
+	MaintenanceTimezone string
+	ChangeFreezes       []string
+	FreezeFile          string
+	FreezeOperations    []string
+	// Zones never deleted
+	ProtectedZones     []string
+	ProtectedZonesPath string
+	// Notification webhooks
+	NotifyWebhooks []string
+	NotifyTimeout  time.Duration
+	// Action on zones whose domains were removed from the registrar
+	RemovalAction         string
+	ConvertTransferSource string
+	// Local TSIG keyring files and zone to key rules
+	TSigKeyrings     []string
+	TSigKeyringZones []string
+	// Coordinator managed TSIG keys
+	TSigGenerate         bool
+	TSigKeystoreSecret   string
+	TSigAlgorithm        string
+	TSigKeyGroups        []string
+	TSigRotationInterval time.Duration
+	TSigRotationGrace    time.Duration
+	TSigExportFile       string
+	// Directory of persistent coordinator state
+	StateDir string
+	// Metrics listen address
+	MetricsAddress string
+	// Registrar config sections embedded in the coordinator config file. Keyed by registrar name
+	RegistrarSections map[string][]byte
 	// Add MarkMonitor ….
 }
 
@@ -90,7 +226,8 @@ func NewApp() *kingpin.Application {
 func (cfg *Config) ParseFlags(app *kingpin.Application, args []string) (string, error) {
 
 	app.DefaultEnvars() // ParseFlags adds and parses flags from command line
-	app.Flag("registrar", "registrar").Required().StringVar(&cfg.Registrar)
+	app.Flag(ConfigFlag, "coordinator YAML configuration filepath. Flags and env vars take precedence over file values").StringVar(&cfg.ConfigPath)
+	app.Flag("registrar", "registrar").StringVar(&cfg.Registrar)
 	app.Flag("registrar-config-path", "registrar configuration filepath").StringVar(&cfg.RegistrarConfigPath)
 	app.Flag("interval", "registrar coordination interval in duration format (default: 15m)").Default(DefaultConfig.Interval.String()).DurationVar(&cfg.Interval)
 	app.Flag("fail-on-error", "Fail and exit on error during sub command processing").BoolVar(&cfg.FailOnError)
@@ -99,22 +236,119 @@ func (cfg *Config) ParseFlags(app *kingpin.Application, args []string) (string,
 	app.Flag("once", "When enabled, exits the synchronization loop after the first iteration (default: disabled)").BoolVar(&cfg.Once)
 	app.Flag("dry-run", "When enabled, prints DNS record changes rather than actually performing them (default: disabled)").BoolVar(&cfg.DryRun)
 	app.Flag("log-file-path", "The log file path. Default destination is stderr").Default(DefaultConfig.LogFilePath).StringVar(&cfg.LogFilePath)
-	app.Flag("log-handler", "The handler used to log messages (default: text. options: text, json, cli, discard)").Default(DefaultConfig.LogHandler).EnumVar(&cfg.LogHandler, "text", "json", "cli", "discard", "syslog")
+	app.Flag("log-handler", "The handler used to log messages (default: text. options: text, json, cli, discard, syslog, journald)").Default(DefaultConfig.LogHandler).EnumVar(&cfg.LogHandler, "text", "json", "cli", "discard", "syslog", "journald")
+	app.Flag("log-syslog-network", "Syslog transport (default: unix, options: unix, udp, tcp)").Default(DefaultConfig.LogSyslogNetwork).EnumVar(&cfg.LogSyslogNetwork, SyslogNetworkUnix, SyslogNetworkUDP, SyslogNetworkTCP)
+	app.Flag("log-syslog-address", "Syslog server address. host:port for udp and tcp, socket path for unix (default: /dev/log)").Default(DefaultConfig.LogSyslogAddress).StringVar(&cfg.LogSyslogAddress)
+	app.Flag("log-syslog-facility", "Syslog facility (default: daemon)").Default(DefaultConfig.LogSyslogFacility).EnumVar(&cfg.LogSyslogFacility, SyslogFacilities()...)
+	app.Flag("log-syslog-app-name", "Syslog app name and journald identifier (default: edgedns-registrar-coordinator)").Default(DefaultConfig.LogSyslogAppName).StringVar(&cfg.LogSyslogAppName)
+	app.Flag("log-journald-socket", "Journald native protocol socket path (default: /run/systemd/journal/socket)").Default(DefaultConfig.LogJournaldSocket).StringVar(&cfg.LogJournaldSocket)
+	app.Flag("log-file-max-size", "Rotate the log file once it exceeds size megabytes. 0 disables size rotation").Default("0").IntVar(&cfg.LogFileMaxSize)
+	app.Flag("log-file-max-age", "Rotate the log file once it is older than duration. 0 disables age rotation").Default("0s").DurationVar(&cfg.LogFileMaxAge)
+	app.Flag("log-file-max-backups", "Number of rotated log files to keep. 0 keeps all").Default("0").IntVar(&cfg.LogFileMaxBackups)
+	app.Flag("log-file-retention", "Remove rotated log files older than duration. 0 keeps all").Default("0s").DurationVar(&cfg.LogFileRetention)
+	app.Flag("log-file-compress", "Gzip rotated log files").BoolVar(&cfg.LogFileCompress)
+	app.Flag("log-file-mode", "Octal permission mode of log files (default: 0600)").Default(DefaultConfig.LogFileMode).StringVar(&cfg.LogFileMode)
+	app.Flag("log-sink", "Additional log sink, e.g. handler=json,level=debug,file=/var/log/coordinator.json. Repeatable").StringsVar(&cfg.LogSinks)
 	app.Flag("log-level", "Set the level of logging. (default: info, options: debug, info, warning, error, fatal)").Default(DefaultConfig.LogLevel).EnumVar(&cfg.LogLevel, "debug", "info", "warning", "error", "fatal")
 
 	// Edge DNS
 	app.Flag("edgedns-contract", "Contract to use creating a domain.").Default(DefaultConfig.EdgeDNSContract).StringVar(&cfg.EdgeDNSContract)
 	app.Flag("edgedns-group", "group id to use creating a domain.").IntVar(&cfg.EdgeDNSGroup)
+	app.Flag("edgedns-contract-name", "Contract name or id resolved through the Edge DNS data services. Replaces --edgedns-contract").Default(DefaultConfig.EdgeDNSContractName).StringVar(&cfg.EdgeDNSContractName)
+	app.Flag("edgedns-group-name", "Group name resolved through the Edge DNS data services. Replaces --edgedns-group").Default(DefaultConfig.EdgeDNSGroupName).StringVar(&cfg.EdgeDNSGroupName)
+	app.Flag("edgedns-account", "Additional Edge DNS contract to manage secondary zones in, e.g. contract=1-ABCDE,group=12345,account-key=B-C-1ED34DY:1-2RBL. Group and account-key are optional. Repeatable").StringsVar(&cfg.EdgeDNSAccountSpecs)
+	app.Flag("edgedns-page-size", "Number of zones requested per Edge DNS zone list page (default: 500)").Default(strconv.Itoa(DefaultConfig.EdgeDNSPageSize)).IntVar(&cfg.EdgeDNSPageSize)
 	app.Flag("edgegrid-host", "EdgeDNS API Server URL.").Default(DefaultConfig.EdgegridHost).StringVar(&cfg.EdgegridHost)
-	app.Flag("edgegrid-client-token", "EdgeDNS API Client Token.").Default(DefaultConfig.EdgegridClientToken).StringVar(&cfg.EdgegridClientToken)
+	app.Flag("edgegrid-client-token", "EdgeDNS API Client Token. Edgegrid tokens and secret may be secret references, e.g. file:///run/secrets/token or env://NAME").Default(DefaultConfig.EdgegridClientToken).StringVar(&cfg.EdgegridClientToken)
 	app.Flag("edgegrid-client-secret", "EdgeDNS API Client Secret.").Default(DefaultConfig.EdgegridClientSecret).StringVar(&cfg.EdgegridClientSecret)
 	app.Flag("edgegrid-access-token", "EdgeDNS API Access Token.").Default(DefaultConfig.EdgegridAccessToken).StringVar(&cfg.EdgegridAccessToken)
 	app.Flag("edgegrid-edgerc-path", "optionally specify the .edgerc file path instead of individual Edgegrid keys").Default(DefaultConfig.EdgegridEdgercPath).StringVar(&cfg.EdgegridEdgercPath)
 	app.Flag("edgegrid-edgerc-section", "specify the section when specifying an .edgerc file path").Default(DefaultConfig.EdgegridEdgercSection).StringVar(&cfg.EdgegridEdgercSection)
+	app.Flag("edgegrid-account-key", "Account switch key of Edge DNS accounts without their own. Overrides AKAMAI_ACCOUNT_KEY and the .edgerc account_key").Default(DefaultConfig.EdgegridAccountKey).StringVar(&cfg.EdgegridAccountKey)
+	app.Flag("edgegrid-timeout", "EdgeDNS API request timeout (default: 1m)").Default(DefaultConfig.EdgegridTimeout.String()).DurationVar(&cfg.EdgegridTimeout)
+	app.Flag("edgegrid-keepalive", "EdgeDNS API connection keep-alive period. Negative disables keep-alive (default: 30s)").Default(DefaultConfig.EdgegridKeepAlive.String()).DurationVar(&cfg.EdgegridKeepAlive)
+	app.Flag("edgegrid-proxy", "EdgeDNS API proxy URL. Default uses the HTTPS_PROXY and NO_PROXY env vars").Default(DefaultConfig.EdgegridProxy).StringVar(&cfg.EdgegridProxy)
+
+	app.Flag("secret-refresh", "Interval to re-read secret references in duration format. 0 resolves references once (default: 5m)").Default(DefaultConfig.SecretRefresh.String()).DurationVar(&cfg.SecretRefresh)
 
 	// Plugin Registrat Orpovider
 	app.Flag("plugin-filepath", "plugin provider library location path.").Default(DefaultConfig.PluginLibPath).StringVar(&cfg.PluginLibPath)
 
+	app.Flag("master-address-family", "Address family of registrar masters used for secondary zones (default: both, options: v4, v6, both)").Default(DefaultConfig.MasterAddressFamily).EnumVar(&cfg.MasterAddressFamily, registrar.AddressFamilyIPv4, registrar.AddressFamilyIPv6, registrar.AddressFamilyBoth)
+
+	// Serial verification
+	app.Flag("verify", "Verify SOA serials of managed zones each monitor interval (default: disabled)").BoolVar(&cfg.Verify)
+	app.Flag("verify-resolver", "Resolver address used to look up name server addresses during verification. Default is the system resolver").Default(DefaultConfig.VerifyResolver).StringVar(&cfg.VerifyResolver)
+	app.Flag("verify-timeout", "DNS query timeout in duration format (default: 5s)").Default(DefaultConfig.VerifyTimeout.String()).DurationVar(&cfg.VerifyTimeout)
+	app.Flag("verify-threshold", "Report a serial mismatch as stale once older than threshold in duration format (default: 1h)").Default(DefaultConfig.VerifyThreshold.String()).DurationVar(&cfg.VerifyThreshold)
+
+	// Master transfer probe
+	app.Flag("probe-masters", "Probe masters with an SOA query and transfer request before creating a secondary zone. Zones whose masters refuse transfer are deferred (default: disabled)").BoolVar(&cfg.ProbeMasters)
+	app.Flag("probe-transfer-type", "Transfer type used to probe masters (default: axfr, options: axfr, ixfr)").Default(DefaultConfig.ProbeTransferType).EnumVar(&cfg.ProbeTransferType, ProbeTransferAXFR, ProbeTransferIXFR)
+
+	// Activation state policies
+	app.Flag("activation-state-policy", "Handling of managed zones in a non active activation state, STATE=ACTION[:TIMEOUT], e.g. PENDING=recreate:2h. Actions: ignore, report, wait, recreate (default: LOCKED=report, PENDING=wait, NEW=wait, ERROR=report). Repeatable").StringsVar(&cfg.ActivationPolicies)
+	app.Flag("activation-state-timeout", "Time a zone may spend in a wait or recreate state before it is reported or recreated (default: 1h)").Default(DefaultConfig.ActivationTimeout.String()).DurationVar(&cfg.ActivationTimeout)
+	// Confirmation of created zones
+	app.Flag("confirm-zones", "Confirm created zones become active and complete a first zone transfer. Zones that miss the confirm timeout are reported and recreated (default: disabled)").BoolVar(&cfg.ConfirmZones)
+	app.Flag("confirm-timeout", "Time a created zone may take to become active and complete a first zone transfer (default: 30m)").Default(DefaultConfig.ConfirmTimeout.String()).DurationVar(&cfg.ConfirmTimeout)
+	app.Flag("confirm-poll-interval", "Interval of zone activation and transfer status checks of created zones with --once (default: 30s)").Default(DefaultConfig.ConfirmPollInterval.String()).DurationVar(&cfg.ConfirmPollInterval)
+	app.Flag("confirm-retries", "Times a zone failing confirmation is deleted and created again before it is only reported (default: 1)").Default(strconv.Itoa(DefaultConfig.ConfirmRetries)).IntVar(&cfg.ConfirmRetries)
+	// Zone transfer status monitoring
+	app.Flag("transfer-status-interval", "Check the zone transfer status of managed zones at most this often in duration format. 0 disables checks (default: 0)").Default("0s").DurationVar(&cfg.TransferStatusInterval)
+	app.Flag("transfer-status-batch-size", "Number of zones per Edge DNS zone transfer status request (default: 100)").Default(strconv.Itoa(DefaultConfig.TransferStatusBatchSize)).IntVar(&cfg.TransferStatusBatchSize)
+	app.Flag("transfer-alert-threshold", "Alert when a managed zone has not transferred successfully within threshold in duration format (default: 24h)").Default(DefaultConfig.TransferAlertThreshold.String()).DurationVar(&cfg.TransferAlertThreshold)
+	// DS record publication at the registrar
+	app.Flag("publish-ds", "Publish the DS records of zones created with --dnssec through the registrar once the zones are active. Requires a registrar supporting SetDSRecords (default: disabled)").BoolVar(&cfg.PublishDS)
+	// Parent delegation checks
+	app.Flag("delegation-resolver", "Resolver address used to look up parent zone name servers and name server addresses during delegation checks. Default is the system resolver").Default(DefaultConfig.DelegationResolver).StringVar(&cfg.DelegationResolver)
+	app.Flag("delegation-check-interval", "Check the parent delegation of managed zones at most this often in duration format. 0 disables checks (default: 0)").Default("0s").DurationVar(&cfg.DelegationCheckInterval)
+	app.Flag("delegation-format", "Report format of the check-delegation sub command (default: table, options: table, json)").Default(DefaultConfig.DelegationFormat).EnumVar(&cfg.DelegationFormat, DelegationFormatTable, DelegationFormatJSON)
+	// Name server delegation updates at the registrar
+	app.Flag("update-delegation", "Delegate created zones to the Akamai name servers through the registrar once the zones are active. Requires --state-dir and a registrar supporting SetNameServers (default: disabled)").BoolVar(&cfg.UpdateDelegation)
+	app.Flag("delegation-allow", "Zone whose delegation may be updated, e.g. example.com, or wildcard, e.g. *.example.com. Repeatable. Default allows all created zones").StringsVar(&cfg.DelegationAllowList)
+	// Per zone overrides
+	app.Flag("zone-overrides-file", "YAML file of masters, TSIG, DNSSEC and comment overrides of created zones keyed by zone name or pattern. Read again when changed. Optional").Default(DefaultConfig.ZoneOverridesPath).StringVar(&cfg.ZoneOverridesPath)
+	// Zone comments
+	app.Flag("zone-comment-template", "Go text/template of the comment of created zones. Fields: .Registrar, .Zone, .Account, .Domain, .Time, .CycleID, .Host (default: Created by EdgeDNS Registrar Coordinator)").Default(DefaultConfig.ZoneCommentTemplate).StringVar(&cfg.ZoneCommentTemplate)
+	app.Flag("zone-comment-max-length", "Zone comments are truncated to length characters (default: 2048)").Default(strconv.Itoa(DefaultConfig.ZoneCommentMaxLength)).IntVar(&cfg.ZoneCommentMaxLength)
+	app.Flag("update-zone-comments", "Update the comments of existing managed zones when the comment template or a comment override changes (default: disabled)").BoolVar(&cfg.UpdateZoneComments)
+	// Maintenance windows and change freezes
+	app.Flag("maintenance-window", "Recurring window zone operations of a class are allowed in, OPERATION=MINUTE HOUR DAY MONTH WEEKDAY DURATION [TIMEZONE], e.g. delete=0 2 * * sat 4h Europe/Berlin. Operations: create, update, delete. Classes without windows are always allowed. Repeatable").StringsVar(&cfg.MaintenanceWindows)
+	app.Flag("maintenance-timezone", "Time zone of maintenance windows and change freeze dates without a time zone (default: UTC)").Default(DefaultConfig.MaintenanceTimezone).StringVar(&cfg.MaintenanceTimezone)
+	app.Flag("change-freeze", "Period no zone operations of the freeze operation classes are carried out in, START/END, e.g. 2021-12-20/2022-01-02 or 2021-12-20T00:00:00Z/2022-01-03T00:00:00Z. Repeatable").StringsVar(&cfg.ChangeFreezes)
+	app.Flag("freeze-file", "Freeze zone operations of the freeze operation classes while this file exists. Optional").Default(DefaultConfig.FreezeFile).StringVar(&cfg.FreezeFile)
+	app.Flag("freeze-operation", "Operation class held back by change freezes and the freeze file. Repeatable (default: create, update, delete)").EnumsVar(&cfg.FreezeOperations, ChangeOperations...)
+	// Protected zones
+	app.Flag("protected-zone", "Zone never deleted, e.g. example.com, or wildcard, e.g. *.example.com. Repeatable").StringsVar(&cfg.ProtectedZones)
+	app.Flag("protected-zones-file", "File of zones never deleted, one zone name or wildcard per line. Read again when changed. Optional").Default(DefaultConfig.ProtectedZonesPath).StringVar(&cfg.ProtectedZonesPath)
+	// Notifications
+	app.Flag("notify-webhook", "URL notifications, e.g. of blocked protected zone deletions, are posted to as JSON. May be a secret reference. Repeatable").StringsVar(&cfg.NotifyWebhooks)
+	app.Flag("notify-timeout", "Timeout of notification webhook requests in duration format (default: 10s)").Default(DefaultConfig.NotifyTimeout.String()).DurationVar(&cfg.NotifyTimeout)
+	// Removed zones
+	app.Flag("removal-action", "Action on zones whose domains were removed from the registrar (default: delete. options: delete, convert to a primary zone, report only)").Default(DefaultConfig.RemovalAction).EnumVar(&cfg.RemovalAction, RemovalActionDelete, RemovalActionConvert, RemovalActionReport)
+	app.Flag("convert-transfer-source", "Name servers the records of converted zones are transferred from (default: masters. options: masters, akamai, or a name server address)").Default(DefaultConfig.ConvertTransferSource).StringVar(&cfg.ConvertTransferSource)
+	// Local TSIG keyring
+	app.Flag("tsig-keyring", "TSIG key file used for zones the registrar returns no key for with --tsig. named.conf key statements, or a YAML keyring if the file ends in .yaml or .yml. Repeatable").StringsVar(&cfg.TSigKeyrings)
+	app.Flag("tsig-keyring-zone", "Map zones to a keyring key, PATTERN=KEY, e.g. *.example.com=example-key. Patterns are zone names, wildcards or *. Zones without a rule use the key named after the zone. Repeatable").StringsVar(&cfg.TSigKeyringZones)
+	// Coordinator managed TSIG keys
+	app.Flag("tsig-generate", "Generate the TSIG keys of created zones instead of retrieving them from the registrar. Keys are stored encrypted in the state directory. Requires --state-dir and --tsig-keystore-secret (default: disabled)").BoolVar(&cfg.TSigGenerate)
+	app.Flag("tsig-keystore-secret", "Passphrase encrypting the TSIG key store. May be a secret reference, e.g. file:///run/secrets/tsig").Default(DefaultConfig.TSigKeystoreSecret).StringVar(&cfg.TSigKeystoreSecret)
+	app.Flag("tsig-algorithm", "Algorithm of generated TSIG keys (default: hmac-sha256, options: hmac-sha256, hmac-sha512)").Default(DefaultConfig.TSigAlgorithm).EnumVar(&cfg.TSigAlgorithm, TsigAlgorithmHmacSHA256, TsigAlgorithmHmacSHA512)
+	app.Flag("tsig-key-group", "Share a generated TSIG key between zones, GROUP=PATTERN, e.g. corp=*.example.com. Patterns are zone names, wildcards or *. Zones without a group get their own key. Repeatable").StringsVar(&cfg.TSigKeyGroups)
+	app.Flag("tsig-rotation-interval", "Rotate generated TSIG keys once older than interval in duration format. 0 disables rotation (default: 0)").Default("0s").DurationVar(&cfg.TSigRotationInterval)
+	app.Flag("tsig-rotation-grace", "Time a rotated TSIG key is exported before zones are updated to it (default: 24h)").Default(DefaultConfig.TSigRotationGrace.String()).DurationVar(&cfg.TSigRotationGrace)
+	app.Flag("tsig-export-file", "File rewritten with named.conf key statements of the generated TSIG keys on key changes. Optional").Default(DefaultConfig.TSigExportFile).StringVar(&cfg.TSigExportFile)
+	app.Flag("state-dir", "Directory of persistent coordinator state, e.g. zone activation state ages. Default keeps state in memory").Default(DefaultConfig.StateDir).StringVar(&cfg.StateDir)
+	app.Flag("metrics-address", "Listen address of the Prometheus metrics endpoint, e.g. :9100. Default disables metrics").Default(DefaultConfig.MetricsAddress).StringVar(&cfg.MetricsAddress)
+
+	// Config file values become flag defaults
+	if path := configPathFromArgs(app, args); path != "" {
+		if err := cfg.applyConfigFile(app, path); err != nil {
+			return "", err
+		}
+	}
+
 	cmd, err := app.Parse(args)
 	if err != nil {
 		return cmd, err
@@ -123,25 +357,44 @@ func (cfg *Config) ParseFlags(app *kingpin.Application, args []string) (string,
 	return cmd, nil
 }
 
-// Validate config
-func (cfg *Config) Validate() error {
+// RegistrarConfigData returns the config file section of the configured registrar, if any
+func (cfg *Config) RegistrarConfigData() []byte {
 
-	if cfg.Interval <= 0 {
-		return fmt.Errorf("Interval must be greter than zero")
-	}
+	return cfg.RegistrarSections[cfg.Registrar]
+}
 
-	if cfg.Registrar == "" {
-		return fmt.Errorf("no registrar specified")
+// LogFileOptions returns the rotation options of log files
+func (cfg *Config) LogFileOptions() LogFileOptions {
+
+	mode, err := parseFileMode(cfg.LogFileMode)
+	if err != nil {
+		mode = DefaultLogFileMode
 	}
 
-	if cfg.EdgeDNSContract == "" {
-		return fmt.Errorf("edgedns contract is required")
+	return LogFileOptions{
+		MaxSize:    int64(cfg.LogFileMaxSize) * 1024 * 1024,
+		MaxAge:     cfg.LogFileMaxAge,
+		MaxBackups: cfg.LogFileMaxBackups,
+		Retention:  cfg.LogFileRetention,
+		Compress:   cfg.LogFileCompress,
+		Mode:       mode,
 	}
+}
 
-	if cfg.EdgeDNSGroup < 1 {
-		return fmt.Errorf("edgedns group is required")
+// parseFileMode parses an octal permission mode, e.g. 0640
+func parseFileMode(mode string) (os.FileMode, error) {
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("Invalid file mode %q. Expected octal permissions, e.g. 0600", mode)
 	}
 
+	return os.FileMode(m), nil
+}
+
+// ValidateEdgegrid validates the Edgegrid credentials, e.g. for the list-groups sub command
+func (cfg *Config) ValidateEdgegrid() error {
+
 	if cfg.EdgegridHost == "" && cfg.EdgegridEdgercPath == "" {
 		return fmt.Errorf("no Edgegrid Host specified")
 	}
@@ -155,6 +408,152 @@ func (cfg *Config) Validate() error {
 		return fmt.Errorf("no Edgegrid access token specified")
 	}
 
+	return nil
+}
+
+// Validate config
+func (cfg *Config) Validate() error {
+
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("Interval must be greter than zero")
+	}
+
+	if cfg.Registrar == "" {
+		return fmt.Errorf("no registrar specified")
+	}
+
+	if cfg.EdgeDNSContract != "" && cfg.EdgeDNSContractName != "" {
+		return fmt.Errorf("edgedns contract and contract name are mutually exclusive")
+	}
+	if cfg.EdgeDNSGroup != 0 && cfg.EdgeDNSGroupName != "" {
+		return fmt.Errorf("edgedns group and group name are mutually exclusive")
+	}
+	accounts, err := cfg.EdgeDNSAccounts()
+	if err != nil {
+		return err
+	}
+	if len(accounts) < 1 {
+		return fmt.Errorf("edgedns contract is required")
+	}
+	// new zones are created in the first account
+	if accounts[0].Group < 1 && accounts[0].GroupName == "" {
+		return fmt.Errorf("edgedns group is required")
+	}
+
+	if err := cfg.ValidateEdgegrid(); err != nil {
+		return err
+	}
+
+	if cfg.EdgeDNSPageSize < 1 {
+		return fmt.Errorf("edgedns page size must be greater than zero")
+	}
+
+	if cfg.EdgegridTimeout < 0 {
+		return fmt.Errorf("edgegrid timeout must not be negative")
+	}
+
+	if cfg.LogHandler == "syslog" && cfg.LogSyslogNetwork != SyslogNetworkUnix && cfg.LogSyslogAddress == "" {
+		return fmt.Errorf("syslog address is required for %s", cfg.LogSyslogNetwork)
+	}
+
+	if cfg.LogFileMaxSize < 0 || cfg.LogFileMaxAge < 0 || cfg.LogFileMaxBackups < 0 || cfg.LogFileRetention < 0 {
+		return fmt.Errorf("log file rotation settings must not be negative")
+	}
+	if _, err := parseFileMode(cfg.LogFileMode); err != nil {
+		return err
+	}
+	for _, spec := range cfg.LogSinks {
+		if _, err := ParseLogSink(spec); err != nil {
+			return err
+		}
+	}
+
+	if cfg.SecretRefresh < 0 {
+		return fmt.Errorf("secret refresh must not be negative")
+	}
+
+	if cfg.VerifyTimeout < 0 || cfg.VerifyThreshold < 0 {
+		return fmt.Errorf("verify timeout and threshold must not be negative")
+	}
+
+	if cfg.ActivationTimeout < 0 {
+		return fmt.Errorf("activation state timeout must not be negative")
+	}
+	if _, err := ParseActivationPolicies(cfg.ActivationPolicies, cfg.ActivationTimeout); err != nil {
+		return err
+	}
+
+	if cfg.ConfirmTimeout < 0 || cfg.ConfirmPollInterval < 0 || cfg.ConfirmRetries < 0 {
+		return fmt.Errorf("confirm timeout, poll interval and retries must not be negative")
+	}
+
+	if cfg.TransferStatusInterval < 0 || cfg.TransferStatusBatchSize < 0 || cfg.TransferAlertThreshold < 0 {
+		return fmt.Errorf("transfer status interval, batch size and alert threshold must not be negative")
+	}
+
+	if cfg.DelegationCheckInterval < 0 {
+		return fmt.Errorf("delegation check interval must not be negative")
+	}
+
+	// the audit trail of delegation updates must survive restarts
+	if cfg.UpdateDelegation && cfg.StateDir == "" {
+		return fmt.Errorf("state directory must be specified to update delegations")
+	}
+
+	if cfg.ZoneCommentMaxLength < 1 {
+		return fmt.Errorf("zone comment max length must be greater than zero")
+	}
+	if _, err := ParseZoneCommentTemplate(cfg.ZoneCommentTemplate, cfg.ZoneCommentMaxLength); err != nil {
+		return err
+	}
+
+	if _, err := NewChangePolicy(cfg.MaintenanceWindows, cfg.ChangeFreezes, cfg.FreezeFile, cfg.FreezeOperations, cfg.MaintenanceTimezone); err != nil {
+		return err
+	}
+
+	if _, err := newProtectedZones(cfg.ProtectedZones, ""); err != nil {
+		return err
+	}
+	if cfg.NotifyTimeout < 0 {
+		return fmt.Errorf("notify timeout must not be negative")
+	}
+
+	switch cfg.RemovalAction {
+	case RemovalActionDelete, RemovalActionConvert, RemovalActionReport:
+	default:
+		return fmt.Errorf("unknown removal action %s", cfg.RemovalAction)
+	}
+	if err := validateConvertSource(cfg.ConvertTransferSource); err != nil {
+		return err
+	}
+
+	if len(cfg.TSigKeyrings) > 0 && !cfg.TSig {
+		return fmt.Errorf("tsig keyring requires tsig")
+	}
+	for _, spec := range cfg.TSigKeyringZones {
+		if _, err := ParseTsigKeyringRule(spec); err != nil {
+			return err
+		}
+	}
+	if cfg.TSigGenerate {
+		if cfg.TSig {
+			return fmt.Errorf("tsig and tsig generate are mutually exclusive")
+		}
+		// generated keys must survive restarts
+		if cfg.StateDir == "" {
+			return fmt.Errorf("state directory must be specified to generate TSIG keys")
+		}
+		if cfg.TSigKeystoreSecret == "" {
+			return fmt.Errorf("TSIG key store secret must be specified to generate TSIG keys")
+		}
+	}
+	if cfg.TSigRotationInterval < 0 || cfg.TSigRotationGrace < 0 {
+		return fmt.Errorf("TSIG rotation interval and grace must not be negative")
+	}
+	if _, err := ParseTsigKeyGroups(cfg.TSigKeyGroups); err != nil {
+		return err
+	}
+
 	// Plugin Registrar
 	if cfg.Registrar == "plugin" && cfg.PluginLibPath == "" {
 		return fmt.Errorf("plugin library filepath must be specified for plugin registrar")
@@ -0,0 +1,297 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v2"
+
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	ConfigFlag = "config"
+	// Config file key holding the per registrar configuration sections
+	RegistrarsConfigKey = "registrars"
+)
+
+var (
+	// configuration file keys of credentials, including the keys of the registrar sections
+	secretConfigKeys = map[string]bool{
+		"edgegrid_client_token":    true,
+		"edgegrid_client_secret":   true,
+		"edgegrid_access_token":    true,
+		"notify_webhook":           true,
+		"tsig_keystore_secret":     true,
+		"akamai_client_token":      true,
+		"akamai_client_secret":     true,
+		"akamai_access_token":      true,
+		"markmonitor_ssh_password": true,
+	}
+)
+
+// configFileKey converts a flag name to its configuration file key
+func configFileKey(flagName string) string {
+
+	return strings.Replace(flagName, "-", "_", -1)
+}
+
+// configFlagName converts a configuration file key to its flag name
+func configFlagName(key string) string {
+
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// configSpec converts a configuration file map to a comma separated key=value spec, e.g. contract=1-ABCDE,group=12345
+func configSpec(m map[interface{}]interface{}) string {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, configFlagName(k)+"="+fmt.Sprint(m[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// configEnvar returns the environment variable kingpin associates with a flag
+func configEnvar(app *kingpin.Application, flagName string) string {
+
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(app.Name + "_" + flagName))
+}
+
+// configPathFromArgs finds the configuration file path in args or the environment before flags are parsed
+func configPathFromArgs(app *kingpin.Application, args []string) string {
+
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--"+ConfigFlag+"=") {
+			return strings.TrimPrefix(arg, "--"+ConfigFlag+"=")
+		}
+		if arg == "--"+ConfigFlag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return os.Getenv(configEnvar(app, ConfigFlag))
+}
+
+// isSecretKey returns true if the key or flag name identifies a credential
+func isSecretKey(key string) bool {
+
+	return secretConfigKeys[configFileKey(strings.ToLower(key))]
+}
+
+// applyConfigFile loads the configuration file at path. Each entry becomes the default of the matching flag so that
+// command line flags and environment variables take precedence over the file. Registrar sections are saved for the registrar.
+func (cfg *Config) applyConfigFile(app *kingpin.Application, path string) error {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read configuration file %s. %s", path, err.Error())
+	}
+	entries := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid configuration file %s. %s", path, err.Error())
+	}
+
+	cfg.RegistrarSections = map[string][]byte{}
+	for key, val := range entries {
+		if key == RegistrarsConfigKey {
+			sections, ok := val.(map[interface{}]interface{})
+			if !ok {
+				return fmt.Errorf("configuration file %s. %s must be a map of registrar sections", path, RegistrarsConfigKey)
+			}
+			for name, section := range sections {
+				sectionData, err := yaml.Marshal(section)
+				if err != nil {
+					return fmt.Errorf("configuration file %s. Invalid %v registrar section. %s", path, name, err.Error())
+				}
+				cfg.RegistrarSections[fmt.Sprint(name)] = sectionData
+			}
+			continue
+		}
+		flagName := configFlagName(key)
+		flag := app.GetFlag(flagName)
+		if flag == nil || flagName == ConfigFlag {
+			return fmt.Errorf("configuration file %s. Unknown key %q", path, key)
+		}
+		switch v := val.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				if m, ok := item.(map[interface{}]interface{}); ok {
+					// map items, e.g. edgedns accounts, become key=value specs
+					values = append(values, configSpec(m))
+					continue
+				}
+				values = append(values, fmt.Sprint(item))
+			}
+			flag.Default(values...)
+		case map[interface{}]interface{}:
+			return fmt.Errorf("configuration file %s. Key %q must not be a map", path, key)
+		default:
+			flag.Default(fmt.Sprint(v))
+		}
+	}
+	cfg.ConfigPath = path
+
+	return nil
+}
+
+// maskSecrets returns a copy of a parsed YAML value with secret entries masked
+func maskSecrets(val interface{}) interface{} {
+
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		masked := yaml.MapSlice{}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			item := v[k]
+			if isSecretKey(k) && item != nil && fmt.Sprint(item) != "" && !registrar.IsSecretRef(fmt.Sprint(item)) {
+				item = registrar.MaskedSecret
+			} else {
+				item = maskSecrets(item)
+			}
+			masked = append(masked, yaml.MapItem{Key: k, Value: item})
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			masked = append(masked, maskSecrets(item))
+		}
+		return masked
+	}
+
+	return val
+}
+
+// configValue returns the typed YAML scalar of a flag value so that booleans and numbers are not quoted
+func configValue(str string) interface{} {
+
+	var val interface{}
+	if str == "" || yaml.Unmarshal([]byte(str), &val) != nil {
+		return str
+	}
+	switch val.(type) {
+	case bool, int, float64:
+		// values YAML reads differently, e.g. the octal 0600, stay strings
+		if fmt.Sprint(val) == str {
+			return val
+		}
+	}
+
+	return str
+}
+
+// configListValue returns the items of a repeatable flag value
+func configListValue(flag *kingpin.FlagModel) []string {
+
+	items := []string{}
+	getter, ok := flag.Value.(kingpin.Getter)
+	if !ok {
+		return items
+	}
+	list := reflect.Indirect(reflect.ValueOf(getter.Get()))
+	if list.Kind() != reflect.Slice {
+		return items
+	}
+	for i := 0; i < list.Len(); i++ {
+		items = append(items, fmt.Sprint(list.Index(i).Interface()))
+	}
+
+	return items
+}
+
+// maskSecretValue masks a flag value unless it is empty or a secret reference
+func maskSecretValue(val string) string {
+
+	if val == "" || registrar.IsSecretRef(val) {
+		return val
+	}
+
+	return registrar.MaskedSecret
+}
+
+// Dump writes the effective configuration as YAML to w with secrets masked. Secret references are not masked.
+func (cfg *Config) Dump(app *kingpin.Application, w io.Writer) error {
+
+	out := yaml.MapSlice{}
+	for _, flag := range app.Model().Flags {
+		if flag.Name == "help" || flag.Name == "version" || flag.Name == ConfigFlag || flag.Hidden {
+			continue
+		}
+		var val interface{}
+		if cumulative, ok := flag.Value.(interface{ IsCumulative() bool }); ok && cumulative.IsCumulative() {
+			items := configListValue(flag)
+			if isSecretKey(flag.Name) {
+				for i, item := range items {
+					items[i] = maskSecretValue(item)
+				}
+			}
+			val = items
+		} else if isSecretKey(flag.Name) {
+			val = maskSecretValue(flag.Value.String())
+		} else {
+			val = configValue(flag.Value.String())
+		}
+		out = append(out, yaml.MapItem{Key: configFileKey(flag.Name), Value: val})
+	}
+	if len(cfg.RegistrarSections) > 0 {
+		names := make([]string, 0, len(cfg.RegistrarSections))
+		for name := range cfg.RegistrarSections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		sections := yaml.MapSlice{}
+		for _, name := range names {
+			section := map[interface{}]interface{}{}
+			if err := yaml.Unmarshal(cfg.RegistrarSections[name], &section); err != nil {
+				return err
+			}
+			sections = append(sections, yaml.MapItem{Key: name, Value: maskSecrets(section)})
+		}
+		out = append(out, yaml.MapItem{Key: RegistrarsConfigKey, Value: sections})
+	}
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if cfg.ConfigPath != "" {
+		fmt.Fprintf(w, "# configuration file: %s\n", cfg.ConfigPath)
+	}
+	_, err = w.Write(data)
+
+	return err
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testConfigFile = `
+registrar: akamai
+interval: 5m
+log_level: debug
+edgedns_contract: 1-FILE
+edgedns_group: 1234
+edgegrid_client_secret: file-secret
+dnssec: true
+registrars:
+  akamai:
+    akamai_contracts: 1-REG
+    akamai_client_secret: reg-secret
+`
+
+func writeTestConfigFile(t *testing.T, content string) string {
+
+	path := filepath.Join(t.TempDir(), "coordinator.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %s", err.Error())
+	}
+
+	return path
+}
+
+func parseTestConfig(t *testing.T, args ...string) (*Config, error) {
+
+	cfg := NewConfig()
+	app := NewApp()
+	app.Command("monitor", "")
+	_, err := cfg.ParseFlags(app, append([]string{"monitor"}, args...))
+
+	return cfg, err
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+
+	path := writeTestConfigFile(t, testConfigFile)
+
+	// file overrides defaults
+	cfg, err := parseTestConfig(t, "--config", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "akamai", cfg.Registrar)
+	assert.Equal(t, 5*time.Minute, cfg.Interval)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, "1-FILE", cfg.EdgeDNSContract)
+	assert.Equal(t, 1234, cfg.EdgeDNSGroup)
+	assert.True(t, cfg.DNSSEC)
+	assert.Equal(t, DefaultConfig.LogHandler, cfg.LogHandler)
+	assert.Contains(t, string(cfg.RegistrarConfigData()), "akamai_contracts: 1-REG")
+
+	// env overrides file
+	os.Setenv("EDGEDNS_REGISTRAR_COORDINATOR_LOG_LEVEL", "warning")
+	defer os.Unsetenv("EDGEDNS_REGISTRAR_COORDINATOR_LOG_LEVEL")
+	cfg, err = parseTestConfig(t, "--config="+path)
+	assert.Nil(t, err)
+	assert.Equal(t, "warning", cfg.LogLevel)
+
+	// flag overrides env and file
+	cfg, err = parseTestConfig(t, "--config", path, "--log-level", "error", "--no-dnssec", "--edgedns-group", "99")
+	assert.Nil(t, err)
+	assert.Equal(t, "error", cfg.LogLevel)
+	assert.False(t, cfg.DNSSEC)
+	assert.Equal(t, 99, cfg.EdgeDNSGroup)
+}
+
+func TestConfigFileEnvPath(t *testing.T) {
+
+	path := writeTestConfigFile(t, testConfigFile)
+	os.Setenv("EDGEDNS_REGISTRAR_COORDINATOR_CONFIG", path)
+	defer os.Unsetenv("EDGEDNS_REGISTRAR_COORDINATOR_CONFIG")
+
+	cfg, err := parseTestConfig(t)
+	assert.Nil(t, err)
+	assert.Equal(t, path, cfg.ConfigPath)
+	assert.Equal(t, "1-FILE", cfg.EdgeDNSContract)
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+
+	_, err := parseTestConfig(t, "--config", writeTestConfigFile(t, "no_such_flag: 1\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no_such_flag")
+
+	_, err = parseTestConfig(t, "--config", writeTestConfigFile(t, "log_level: verbose\n"))
+	assert.NotNil(t, err)
+
+	_, err = parseTestConfig(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestConfigDumpMasksSecrets(t *testing.T) {
+
+	path := writeTestConfigFile(t, testConfigFile)
+	cfg := NewConfig()
+	app := NewApp()
+	app.Command("monitor", "")
+	_, err := cfg.ParseFlags(app, []string{"monitor", "--config", path, "--edgegrid-access-token", "flag-token", "--secret-refresh", "10m"})
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, cfg.Dump(app, &buf))
+	out := buf.String()
+	assert.Contains(t, out, "edgedns_contract: 1-FILE")
+	assert.Contains(t, out, "akamai_contracts: 1-REG")
+	assert.Contains(t, out, "edgegrid_access_token: '"+registrar.MaskedSecret+"'")
+	assert.NotContains(t, out, "file-secret")
+	assert.NotContains(t, out, "reg-secret")
+	assert.NotContains(t, out, "flag-token")
+	// settings other than credentials are not masked
+	assert.Contains(t, out, "secret_refresh: 10m0s")
+}
+
+func TestConfigDumpReload(t *testing.T) {
+
+	path := writeTestConfigFile(t, testConfigFile)
+	cfg := NewConfig()
+	app := NewApp()
+	app.Command("monitor", "")
+	_, err := cfg.ParseFlags(app, []string{"monitor", "--config", path, "--protected-zone", "a.com", "--protected-zone", "b.com",
+		"--maintenance-window", "delete=0 2 * * sat 4h"})
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, cfg.Dump(app, &buf))
+
+	// the dump loads back into the same configuration
+	reloaded, err := parseTestConfig(t, "--config", writeTestConfigFile(t, buf.String()))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.com", "b.com"}, reloaded.ProtectedZones)
+	assert.Equal(t, []string{"delete=0 2 * * sat 4h"}, reloaded.MaintenanceWindows)
+	assert.Equal(t, DefaultConfig.LogFileMode, reloaded.LogFileMode)
+	assert.Equal(t, "1-FILE", reloaded.EdgeDNSContract)
+	assert.Equal(t, 1234, reloaded.EdgeDNSGroup)
+	assert.True(t, reloaded.DNSSEC)
+	assert.Contains(t, string(reloaded.RegistrarConfigData()), "akamai_contracts: 1-REG")
+}
+
+func TestConfigFileEdgeDNSAccounts(t *testing.T) {
+
+	path := writeTestConfigFile(t, testConfigFile+`
+edgegrid_account_key: KEY-DEFAULT
+edgedns_account:
+  - contract: 1-SUB
+    group: 5678
+    account_key: KEY-SUB
+  - contract=1-OTHER
+`)
+	cfg, err := parseTestConfig(t, "--config", path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"account-key=KEY-SUB,contract=1-SUB,group=5678", "contract=1-OTHER"}, cfg.EdgeDNSAccountSpecs)
+
+	accounts, err := cfg.EdgeDNSAccounts()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(accounts))
+	assert.Equal(t, "KEY-DEFAULT:1-FILE", accounts[0].Name())
+	assert.Equal(t, 1234, accounts[0].Group)
+	assert.Equal(t, "KEY-SUB:1-SUB", accounts[1].Name())
+	assert.Equal(t, 5678, accounts[1].Group)
+	assert.Equal(t, "KEY-DEFAULT:1-OTHER", accounts[2].Name())
+	assert.Equal(t, 1234, accounts[2].Group)
+
+	// the same contract under another account switch key is a separate account
+	cfg.EdgeDNSAccountSpecs = append(cfg.EdgeDNSAccountSpecs, "contract=1-SUB")
+	_, err = cfg.EdgeDNSAccounts()
+	assert.Nil(t, err)
+	cfg.EdgeDNSAccountSpecs = append(cfg.EdgeDNSAccountSpecs, "contract=1-FILE")
+	_, err = cfg.EdgeDNSAccounts()
+	assert.NotNil(t, err)
+
+	_, err = ParseEdgeDNSAccount("group=12")
+	assert.NotNil(t, err)
+	_, err = ParseEdgeDNSAccount("contract=1-A,group=abc")
+	assert.NotNil(t, err)
+	_, err = ParseEdgeDNSAccount("contract=1-A,switch=KEY")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,352 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"context"
+	"time"
+)
+
+const (
+	DefaultConfirmTimeout      = 30 * time.Minute
+	DefaultConfirmPollInterval = 30 * time.Second
+	DefaultConfirmRetries      = 1
+	// Confirmation stages of a created zone
+	ConfirmStageActivation = "activation"
+	ConfirmStageTransfer   = "transfer"
+	// zone confirmation queue file in the state directory
+	confirmationStateFile = "zone-confirmations.json"
+)
+
+// ZoneConfirmation is a created zone awaiting activation and its first successful zone transfer
+type ZoneConfirmation struct {
+	Account  string    `json:"account"`
+	Zone     string    `json:"zone"`
+	Created  time.Time `json:"created"`
+	Deadline time.Time `json:"deadline"`
+	// Times the zone has been created
+	Attempts int `json:"attempts"`
+	// Last seen activation state and transfer error
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Failed zones missed their deadline and are in the remediation queue. Deleted zones await recreation
+	Failed  bool `json:"failed,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Stage returns the confirmation stage the zone is in
+func (zc *ZoneConfirmation) Stage() string {
+
+	if zc.State != ActivationStateActive {
+		return ConfirmStageActivation
+	}
+
+	return ConfirmStageTransfer
+}
+
+func (zc *ZoneConfirmation) stateKey() string {
+
+	return activationKey(zc.Account, zc.Zone)
+}
+
+func (zc *ZoneConfirmation) stateZone() string {
+
+	return zc.Zone
+}
+
+// confirmationQueue tracks created zones until they are confirmed. Zones that miss their deadline stay queued for
+// remediation.
+type confirmationQueue struct {
+	store *keyedStateStore
+}
+
+func newConfirmationQueue(stateDir string) (*confirmationQueue, error) {
+
+	store, err := newKeyedStateStore(stateDir, confirmationStateFile, &[]*ZoneConfirmation{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &confirmationQueue{store: store}, nil
+}
+
+// created queues a zone created at now. Attempts carry over from a zone recreated after failing confirmation.
+func (q *confirmationQueue) created(account, zone string, now time.Time, timeout time.Duration) error {
+
+	return q.store.change(func(entries map[string]stateEntry) bool {
+		key := activationKey(account, zone)
+		attempts := 1
+		if entry, ok := entries[key]; ok {
+			attempts = entry.(*ZoneConfirmation).Attempts + 1
+		}
+		entries[key] = &ZoneConfirmation{Account: account, Zone: zone, Created: now, Deadline: now.Add(timeout), Attempts: attempts}
+		return true
+	})
+}
+
+// checked returns copies of the queued zones still to be checked, i.e. not deleted for recreation, sorted by account
+// and zone. Pending zones only excludes failed zones.
+func (q *confirmationQueue) checked(pendingOnly bool) []*ZoneConfirmation {
+
+	return confirmationCopies(q.store.list(func(entry stateEntry) bool {
+		zc := entry.(*ZoneConfirmation)
+		return !zc.Deleted && !(pendingOnly && zc.Failed)
+	}))
+}
+
+// pending returns true if a zone of an account awaits confirmation
+func (q *confirmationQueue) pending(account, zone string) bool {
+
+	entry, ok := q.store.get(activationKey(account, zone))
+	if !ok {
+		return false
+	}
+	zc := entry.(*ZoneConfirmation)
+
+	return !zc.Failed && !zc.Deleted
+}
+
+// failed returns copies of the failed zones of an account sorted by zone
+func (q *confirmationQueue) failed(account string) []*ZoneConfirmation {
+
+	return confirmationCopies(q.store.list(func(entry stateEntry) bool {
+		zc := entry.(*ZoneConfirmation)
+		return zc.Account == account && zc.Failed
+	}))
+}
+
+// update replaces a queued zone. Zones no longer queued are not added back.
+func (q *confirmationQueue) update(zc *ZoneConfirmation) error {
+
+	c := *zc
+
+	return q.store.replace(&c)
+}
+
+// forget drops zones of an account, e.g. once confirmed or removed from the registrar
+func (q *confirmationQueue) forget(account string, zones []string) error {
+
+	return q.store.remove(zoneKeys(account, zones)...)
+}
+
+// retain drops zones of domains no longer in the registrar
+func (q *confirmationQueue) retain(registrarDomains []string) error {
+
+	return q.store.retainZones(registrarDomains)
+}
+
+func confirmationCopies(entries []stateEntry) []*ZoneConfirmation {
+
+	zones := make([]*ZoneConfirmation, 0, len(entries))
+	for _, entry := range entries {
+		c := *entry.(*ZoneConfirmation)
+		zones = append(zones, &c)
+	}
+
+	return zones
+}
+
+// confirmCreatedZone queues a zone created in account for confirmation
+func confirmCreatedZone(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zone string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if !edge.ConfirmZones {
+		return
+	}
+	if err := edge.confirmations.created(account.Name(), zone, time.Now(), edge.ConfirmTimeout); err != nil {
+		log.Errorf("Unable to queue zone %s for confirmation. Error: %s", zone, err.Error())
+	}
+}
+
+// confirmZones polls the queued zones every poll interval until none are pending or until passes. A zero until checks
+// the zones once. Zones are confirmed once active with a successful zone transfer. Pending zones past their deadline
+// fail and are remediated.
+func confirmZones(ctx context.Context, edge *EdgeDNSHandler, until time.Time, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	// failed zones are checked once per call in case they load late
+	checkFailed := true
+	for {
+		zones := edge.confirmations.checked(!checkFailed)
+		checkFailed = false
+		if len(zones) > 0 {
+			checkZoneConfirmations(ctx, edge, zones, time.Now())
+		}
+		if len(edge.confirmations.checked(true)) < 1 {
+			break
+		}
+		if until.IsZero() || time.Now().Add(edge.ConfirmPollInterval).After(until) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(edge.ConfirmPollInterval):
+		}
+	}
+	for _, account := range edge.Accounts {
+		if err := remediateUnconfirmedZones(ctx, edge, account, dryrun); err != nil {
+			log.Errorf("Failed to remediate unconfirmed zones. Account: %s. Error: %s", account.Name(), err.Error())
+		}
+	}
+}
+
+// checkZoneConfirmations checks the activation state of each zone and, once active, its transfer status. Transfer
+// status is requested once per account for all active zones.
+func checkZoneConfirmations(ctx context.Context, edge *EdgeDNSHandler, zones []*ZoneConfirmation, now time.Time) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	active := map[string][]*ZoneConfirmation{}
+	for _, zc := range zones {
+		account := edge.account(zc.Account)
+		if account == nil {
+			log.Warnf("Zone %s account %s is no longer managed. Dropping confirmation", zc.Zone, zc.Account)
+			edge.confirmations.forget(zc.Account, []string{zc.Zone})
+			continue
+		}
+		if zc.State != ActivationStateActive {
+			zone, err := account.client.GetZone(ctx, zc.Zone)
+			if err != nil {
+				log.Debugf("Unable to retrieve zone %s. Error: %s", zc.Zone, err.Error())
+			} else {
+				zc.State = zone.ActivationState
+			}
+		}
+		if zc.State == ActivationStateActive {
+			active[zc.Account] = append(active[zc.Account], zc)
+			continue
+		}
+		failZoneConfirmation(ctx, edge, zc, now)
+	}
+	for name, azones := range active {
+		names := make([]string, 0, len(azones))
+		for _, zc := range azones {
+			names = append(names, zc.Zone)
+		}
+		statuses, err := edge.account(name).client.GetZoneTransferStatus(ctx, names)
+		if err != nil {
+			log.Errorf("Unable to retrieve zone transfer status. Account: %s. Error: %s", name, err.Error())
+		}
+		transferred := map[string]bool{}
+		for _, status := range statuses {
+			for _, zc := range azones {
+				if zc.Zone != status.Zone {
+					continue
+				}
+				zc.Error = status.LastTransferError
+				if status.Transferred() {
+					transferred[zc.Zone] = true
+					log.Infof("Zone %s confirmed. Active and transferred serial %d from %s after %s", zc.Zone, status.SerialNumber, status.MasterServer, now.Sub(zc.Created).Round(time.Second))
+				}
+			}
+		}
+		confirmed := []string{}
+		for _, zc := range azones {
+			if transferred[zc.Zone] {
+				confirmed = append(confirmed, zc.Zone)
+				continue
+			}
+			failZoneConfirmation(ctx, edge, zc, now)
+		}
+		edge.confirmations.forget(name, confirmed)
+	}
+}
+
+// failZoneConfirmation records the progress of an unconfirmed zone and fails it once past its deadline
+func failZoneConfirmation(ctx context.Context, edge *EdgeDNSHandler, zc *ZoneConfirmation, now time.Time) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if !zc.Failed && now.After(zc.Deadline) {
+		zc.Failed = true
+		log.Errorf("Zone %s failed confirmation. Not confirmed %s after creation. Stage: %s. State: %s. Transfer error: %s. Account: %s", zc.Zone, now.Sub(zc.Created).Round(time.Second), zc.Stage(), zc.State, zc.Error, zc.Account)
+	} else if !zc.Failed {
+		log.Debugf("Zone %s awaiting confirmation. Stage: %s. State: %s", zc.Zone, zc.Stage(), zc.State)
+	}
+	if err := edge.confirmations.update(zc); err != nil {
+		log.Errorf("Unable to save zone %s confirmation. Error: %s", zc.Zone, err.Error())
+	}
+}
+
+// remediateUnconfirmedZones reports the failed zones of an account and exports them as metrics. Zones with retries
+// left are deleted; the next interval creates them again.
+func remediateUnconfirmedZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	now := time.Now()
+	metrics.ResetGauge("unconfirmed_zone_age_seconds", map[string]string{"account": account.Name()})
+	pending := 0
+	for _, zc := range edge.confirmations.checked(true) {
+		if zc.Account == account.Name() {
+			pending++
+		}
+	}
+	metrics.SetGauge("pending_zone_confirmations", "Created zones awaiting activation and a first zone transfer", map[string]string{"account": account.Name()}, float64(pending))
+	retry := []*ZoneConfirmation{}
+	for _, zc := range edge.confirmations.failed(account.Name()) {
+		if zc.Deleted {
+			log.Debugf("Unconfirmed zone %s deleted. Awaiting recreation", zc.Zone)
+			continue
+		}
+		metrics.SetGauge("unconfirmed_zone_age_seconds", "Seconds since creation of a zone that failed confirmation", map[string]string{"account": account.Name(), "zone": zc.Zone, "stage": zc.Stage()}, now.Sub(zc.Created).Seconds())
+		if zc.Attempts <= edge.ConfirmRetries {
+			retry = append(retry, zc)
+			continue
+		}
+		log.Errorf("Zone %s unconfirmed after %d attempts. Stage: %s. State: %s. Transfer error: %s. Account: %s. Remediation required", zc.Zone, zc.Attempts, zc.Stage(), zc.State, zc.Error, account.Name())
+	}
+	if len(retry) < 1 {
+		return nil
+	}
+	zones := make([]string, 0, len(retry))
+	for _, zc := range retry {
+		zones = append(zones, zc.Zone)
+	}
+	if allowed, reason := edge.changeAllowed(ChangeOperationDelete, now); !allowed {
+		log.Infof("Recreation of unconfirmed zones %v deferred. Account: %s. %s", zones, account.Name(), reason)
+		return nil
+	}
+	log.Warnf("Recreating unconfirmed zones: %v. Account: %s", zones, account.Name())
+	deleted, err := removeSecondaryZones(ctx, edge, account, zones, dryrun)
+	if err != nil {
+		return err
+	}
+	if dryrun {
+		return nil
+	}
+	isDeleted := map[string]bool{}
+	for _, zone := range deleted {
+		isDeleted[zone] = true
+	}
+	for _, zc := range retry {
+		if !isDeleted[zc.Zone] {
+			continue
+		}
+		zc.Deleted = true
+		if err := edge.confirmations.update(zc); err != nil {
+			return err
+		}
+	}
+	if err := edge.recreations.deleted(account.Name(), deleted, now); err != nil {
+		return err
+	}
+
+	return edge.activations.forget(account.Name(), deleted)
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfirmationQueuePersistence(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := newConfirmationQueue(dir)
+	assert.Nil(t, err)
+	now := time.Now()
+	assert.Nil(t, q.created("1-A", "a.com", now, time.Hour))
+	assert.Nil(t, q.created("1-A", "b.com", now, time.Hour))
+	zc := q.checked(true)[0]
+	zc.Failed = true
+	zc.Deleted = true
+	assert.Nil(t, q.update(zc))
+
+	// the queue survives a restart
+	q, err = newConfirmationQueue(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(q.checked(false)))
+	assert.Equal(t, "a.com", q.failed("1-A")[0].Zone)
+	// attempts carry over to the recreated zone
+	assert.Nil(t, q.created("1-A", "a.com", now, time.Hour))
+	assert.Equal(t, 2, q.checked(true)[0].Attempts)
+	assert.Equal(t, 0, len(q.failed("1-A")))
+
+	assert.Nil(t, q.retain([]string{"b.com"}))
+	q, err = newConfirmationQueue(dir)
+	assert.Nil(t, err)
+	zones := q.checked(false)
+	assert.Equal(t, 1, len(zones))
+	assert.Equal(t, "b.com", zones[0].Zone)
+}
+
+func TestConfirmZones(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestConfirmZones"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.ConfirmZones = true
+	config.ConfirmTimeout = 20 * time.Millisecond
+	config.ConfirmPollInterval = 5 * time.Millisecond
+	config.ConfirmRetries = 1
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+
+	// created zones are queued
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"never.zone"}, false))
+	assert.Equal(t, 1, len(handler.confirmations.checked(true)))
+
+	// without until, pending zones are checked once
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "never.zone", ActivationState: "PENDING"}
+	handler.ConfirmPollInterval = time.Minute
+	start := time.Now()
+	confirmZones(ctx, handler, time.Time{}, false)
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.Equal(t, 1, len(handler.confirmations.checked(true)))
+	handler.ConfirmPollInterval = config.ConfirmPollInterval
+
+	// zones that never activate fail and are deleted for recreation
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "never.zone", ActivationState: "PENDING"}
+	confirmZones(ctx, handler, time.Now().Add(time.Second), false)
+	failed := handler.confirmations.failed(account.Name())
+	assert.Equal(t, 1, len(failed))
+	assert.True(t, failed[0].Deleted)
+	assert.Equal(t, ConfirmStageActivation, failed[0].Stage())
+
+	// the recreated zone activates but never transfers and is reported once out of retries
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"never.zone"}, false))
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "never.zone", ActivationState: ActivationStateActive}
+	stubEdgeDNS.FuncOutput["GetZoneTransferStatus"] = []*registrar.EdgeDNSZoneTransferStatus{{Zone: "never.zone", LastTransferError: "REFUSED"}}
+	confirmZones(ctx, handler, time.Now().Add(time.Second), false)
+	failed = handler.confirmations.failed(account.Name())
+	assert.Equal(t, 1, len(failed))
+	assert.False(t, failed[0].Deleted)
+	assert.Equal(t, 2, failed[0].Attempts)
+	assert.Equal(t, "REFUSED", failed[0].Error)
+	_, ok := metrics.Gauge("unconfirmed_zone_age_seconds", map[string]string{"account": account.Name(), "zone": "never.zone", "stage": ConfirmStageTransfer})
+	assert.True(t, ok)
+
+	// a failed zone that loads late is confirmed
+	stubEdgeDNS.FuncOutput["GetZoneTransferStatus"] = []*registrar.EdgeDNSZoneTransferStatus{{Zone: "never.zone", LastTransferDate: "2021-03-01T10:00:00Z"}}
+	confirmZones(ctx, handler, time.Now().Add(time.Second), false)
+	assert.Equal(t, 0, len(handler.confirmations.checked(false)))
+	_, ok = metrics.Gauge("unconfirmed_zone_age_seconds", map[string]string{"account": account.Name(), "zone": "never.zone", "stage": ConfirmStageTransfer})
+	assert.False(t, ok)
+	pending, ok := metrics.Gauge("pending_zone_confirmations", map[string]string{"account": account.Name()})
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), pending)
+}
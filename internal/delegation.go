@@ -0,0 +1,289 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Delegation report formats
+	DelegationFormatTable = "table"
+	DelegationFormatJSON  = "json"
+	// Zone delegation states
+	DelegationStateOK          = "OK"
+	DelegationStateUndelegated = "UNDELEGATED" // the parent delegates to none of the Akamai name servers
+	DelegationStatePartial     = "PARTIAL"     // Akamai name servers are missing or other name servers are delegated
+	DelegationStateLame        = "LAME"        // a delegated name server does not answer authoritatively
+	DelegationStateUnknown     = "UNKNOWN"     // the parent delegation could not be retrieved
+)
+
+// ZoneDelegation is the parent delegation of a managed zone compared with the Akamai name servers of its contract
+type ZoneDelegation struct {
+	Account     string   `json:"account"`
+	Zone        string   `json:"zone"`
+	State       string   `json:"state"`
+	NameServers []string `json:"nameservers"`
+	// Akamai name servers the parent does not delegate to
+	Missing []string `json:"missing,omitempty"`
+	// Delegated name servers not assigned to the contract
+	Foreign []string `json:"foreign,omitempty"`
+	// Delegated name servers not answering authoritatively
+	Lame  []string `json:"lame,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Problem returns true if the zone is not fully delegated to the Akamai name servers
+func (d *ZoneDelegation) Problem() bool {
+
+	return d.State != DelegationStateOK
+}
+
+// CheckDelegation implements the check-delegation sub command. Reports the parent delegation of all managed zones once.
+func CheckDelegation(ctx context.Context, err chan string, regname string, reg registrar.RegistrarProvider, edge *EdgeDNSHandler, format string, w io.Writer) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering CheckDelegation")
+
+	var errmsg string
+
+	edge.refreshCredentials(ctx)
+	accountZones, edgeErr := edge.accountZoneNames(ctx)
+	if edgeErr != nil {
+		log.Errorf("CheckDelegation. Failed to read EdgeDNS Secondary zones. Error: %s", edgeErr.Error())
+		err <- "CheckDelegation. Failed to read EdgeDNS Secondary zones."
+		return
+	}
+	registrarDomains, regErr := reg.GetDomains(ctx)
+	if regErr != nil {
+		log.Errorf("CheckDelegation. Failed to read registrar primary zones. Error: %s", regErr.Error())
+		err <- "CheckDelegation. Failed to read registrar primary zones."
+		return
+	}
+
+	results := []*ZoneDelegation{}
+	for _, account := range edge.Accounts {
+		accountResults, derr := Delegations(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains))
+		if derr != nil {
+			log.Errorf("CheckDelegation. Failed to check delegations. Account: %s. Error: %s", account.Name(), derr.Error())
+			err <- "CheckDelegation. Failed to check delegations."
+			return
+		}
+		results = append(results, accountResults...)
+	}
+	if werr := WriteDelegationReport(w, format, results); werr != nil {
+		log.Errorf("CheckDelegation. Failed to write report. Error: %s", werr.Error())
+	}
+	if problems := reportDelegations(ctx, results); problems > 0 {
+		errmsg = fmt.Sprintf("CheckDelegation. %d of %d zones are not delegated to the Akamai name servers.", problems, len(results))
+	}
+
+	err <- errmsg
+	return
+}
+
+// Delegations compares the parent delegation of zones of an account with the account's Akamai name servers. Results
+// are sorted by zone.
+func Delegations(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string) ([]*ZoneDelegation, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("Checking delegations: %v. Account: %s", zones, account.Name())
+
+	results := []*ZoneDelegation{}
+	if len(zones) < 1 {
+		return results, nil
+	}
+	nameservers, err := account.client.GetNameServers(ctx, account.Contract)
+	if err != nil {
+		log.Errorf("Unable to retrieve Edge DNS name servers. Error: %s", err.Error())
+		return results, err
+	}
+	if len(nameservers) < 1 {
+		return results, fmt.Errorf("No Edge DNS name servers found for contract %s", account.Contract)
+	}
+	for _, zone := range sortedZoneList(zones) {
+		results = append(results, checkDelegation(ctx, edge, account, zone, nameservers))
+	}
+
+	return results, nil
+}
+
+func checkDelegation(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zone string, nameservers []string) *ZoneDelegation {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	d := &ZoneDelegation{Account: account.Name(), Zone: zone, NameServers: []string{}}
+	delegated, err := edge.delegationclient.GetDelegation(ctx, zone)
+	if err != nil {
+		log.Debugf("Zone %s delegation lookup failed. %s", zone, err.Error())
+		d.State = DelegationStateUnknown
+		d.Error = err.Error()
+		return d
+	}
+	d.NameServers = delegated
+
+	akamai := map[string]bool{}
+	for _, ns := range nameservers {
+		akamai[nameServerName(ns)] = true
+	}
+	isDelegated := map[string]bool{}
+	for _, ns := range delegated {
+		isDelegated[nameServerName(ns)] = true
+		if !akamai[nameServerName(ns)] {
+			d.Foreign = append(d.Foreign, ns)
+		}
+	}
+	for _, ns := range nameservers {
+		if !isDelegated[nameServerName(ns)] {
+			d.Missing = append(d.Missing, ns)
+		}
+	}
+	if len(d.Missing) == len(nameservers) {
+		d.State = DelegationStateUndelegated
+		return d
+	}
+	for _, ns := range delegated {
+		if _, err := querySerial(ctx, edge.delegationclient, zone, ns); err != nil {
+			log.Debugf("Zone %s name server %s SOA query failed. %s", zone, ns, err.Error())
+			d.Lame = append(d.Lame, ns)
+		}
+	}
+	switch {
+	case len(d.Lame) > 0:
+		d.State = DelegationStateLame
+	case len(d.Missing) > 0 || len(d.Foreign) > 0:
+		d.State = DelegationStatePartial
+	default:
+		d.State = DelegationStateOK
+	}
+
+	return d
+}
+
+// monitorDelegations reports the parent delegation of the managed zones of an account
+func monitorDelegations(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	results, err := Delegations(ctx, edge, account, zones)
+	if err != nil {
+		log.Errorf("Monitor. Failed to check delegations. Account: %s. Error: %s", account.Name(), err.Error())
+		return
+	}
+	reportDelegations(ctx, results)
+}
+
+// delegationCheckDue returns true if the delegation check cadence has elapsed since the last check. The check time is
+// recorded.
+func (e *EdgeDNSHandler) delegationCheckDue(now time.Time) bool {
+
+	if e.DelegationCheckInterval <= 0 || now.Before(e.lastDelegationCheck.Add(e.DelegationCheckInterval)) {
+		return false
+	}
+	e.lastDelegationCheck = now
+
+	return true
+}
+
+// reportDelegations logs zone delegations, exports them as metrics and returns the number of zones with problems
+func reportDelegations(ctx context.Context, results []*ZoneDelegation) int {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	counts := map[string]map[string]int{}
+	for _, r := range results {
+		if _, ok := counts[r.Account]; !ok {
+			metrics.ResetGauge("zone_delegation_state", map[string]string{"account": r.Account})
+			counts[r.Account] = map[string]int{}
+			for _, state := range []string{DelegationStateOK, DelegationStateUndelegated, DelegationStatePartial, DelegationStateLame, DelegationStateUnknown} {
+				counts[r.Account][state] = 0
+			}
+		}
+	}
+	problems := 0
+	for _, r := range results {
+		counts[r.Account][r.State]++
+		metrics.SetGauge("zone_delegation_state", "Parent delegation state of a managed zone", map[string]string{"account": r.Account, "zone": r.Zone, "state": r.State}, 1)
+		entry := log.WithFields(delegationFields(r))
+		if r.Problem() {
+			problems++
+			entry.Warnf("Zone %s not delegated to the Akamai name servers: %s", r.Zone, r.State)
+		} else {
+			entry.Debugf("Zone %s delegation verified", r.Zone)
+		}
+	}
+	for account, states := range counts {
+		for state, count := range states {
+			metrics.SetGauge("zone_delegations", "Managed zones by parent delegation state", map[string]string{"account": account, "state": state}, float64(count))
+		}
+	}
+	log.Infof("Delegation check complete. %d zones checked, %d not delegated", len(results), problems)
+
+	return problems
+}
+
+func delegationFields(r *ZoneDelegation) log.Fields {
+
+	fields := log.Fields{
+		"account":     r.Account,
+		"zone":        r.Zone,
+		"state":       r.State,
+		"nameservers": r.NameServers,
+	}
+	if len(r.Missing) > 0 {
+		fields["missing"] = r.Missing
+	}
+	if len(r.Foreign) > 0 {
+		fields["foreign"] = r.Foreign
+	}
+	if len(r.Lame) > 0 {
+		fields["lame"] = r.Lame
+	}
+	if r.Error != "" {
+		fields["error"] = r.Error
+	}
+
+	return fields
+}
+
+// WriteDelegationReport writes zone delegations as a table or as a JSON array
+func WriteDelegationReport(w io.Writer, format string, results []*ZoneDelegation) error {
+
+	if format == DelegationFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tZONE\tSTATE\tNAME SERVERS\tMISSING\tFOREIGN\tLAME\tERROR")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Account, r.Zone, r.State, reportList(r.NameServers), reportList(r.Missing), reportList(r.Foreign), reportList(r.Lame), reportValue(r.Error))
+	}
+
+	return tw.Flush()
+}
+
+func reportList(l []string) string {
+
+	return reportValue(strings.Join(l, ","))
+}
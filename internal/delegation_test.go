@@ -0,0 +1,91 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDelegations(t *testing.T) {
+
+	ctx, _, handler, dnsStub := initVerifyTest(t, "TestDelegations")
+	handler.delegationclient = dnsStub
+	account := handler.Accounts[0]
+	dnsStub.Serials["a1-1.akam.net"] = 1
+	dnsStub.Serials["a2-2.akam.net"] = 1
+	dnsStub.Serials["ns1.other.net"] = 1
+	dnsStub.Delegations["ok.zone"] = []string{"a1-1.akam.net", "a2-2.akam.net"}
+	dnsStub.Delegations["partial.zone"] = []string{"a1-1.akam.net", "ns1.other.net"}
+	dnsStub.Delegations["undelegated.zone"] = []string{"ns1.other.net"}
+	dnsStub.Delegations["lame.zone"] = []string{"a1-1.akam.net", "a2-2.akam.net", "ns2.other.net"}
+
+	results, err := Delegations(ctx, handler, account, []string{"unknown.zone", "ok.zone", "partial.zone", "undelegated.zone", "lame.zone"})
+	assert.Nil(t, err)
+	states := map[string]*ZoneDelegation{}
+	for _, r := range results {
+		states[r.Zone] = r
+	}
+	assert.Equal(t, "lame.zone", results[0].Zone)
+	assert.Equal(t, DelegationStateOK, states["ok.zone"].State)
+	assert.Equal(t, DelegationStatePartial, states["partial.zone"].State)
+	assert.Equal(t, []string{"a2-2.akam.net"}, states["partial.zone"].Missing)
+	assert.Equal(t, []string{"ns1.other.net"}, states["partial.zone"].Foreign)
+	assert.Equal(t, DelegationStateUndelegated, states["undelegated.zone"].State)
+	assert.Equal(t, DelegationStateLame, states["lame.zone"].State)
+	assert.Equal(t, []string{"ns2.other.net"}, states["lame.zone"].Lame)
+	assert.Equal(t, DelegationStateUnknown, states["unknown.zone"].State)
+	assert.NotEqual(t, "", states["unknown.zone"].Error)
+
+	assert.Equal(t, 4, reportDelegations(ctx, results))
+	count, ok := metrics.Gauge("zone_delegations", map[string]string{"account": account.Name(), "state": DelegationStatePartial})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), count)
+	_, ok = metrics.Gauge("zone_delegation_state", map[string]string{"account": account.Name(), "zone": "ok.zone", "state": DelegationStateOK})
+	assert.True(t, ok)
+}
+
+func TestDelegationCheckDue(t *testing.T) {
+
+	_, _, handler, _ := initVerifyTest(t, "TestDelegationCheckDue")
+	now := time.Now()
+	assert.False(t, handler.delegationCheckDue(now))
+	handler.DelegationCheckInterval = time.Hour
+	assert.True(t, handler.delegationCheckDue(now))
+	assert.False(t, handler.delegationCheckDue(now.Add(time.Minute)))
+	assert.True(t, handler.delegationCheckDue(now.Add(time.Hour)))
+}
+
+func TestWriteDelegationReport(t *testing.T) {
+
+	results := []*ZoneDelegation{
+		{Account: "1-A", Zone: "partial.zone", State: DelegationStatePartial, NameServers: []string{"a1-1.akam.net", "ns1.other.net"}, Missing: []string{"a2-2.akam.net"}, Foreign: []string{"ns1.other.net"}},
+	}
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteDelegationReport(buf, DelegationFormatTable, results))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[1], "a1-1.akam.net,ns1.other.net")
+
+	buf.Reset()
+	assert.Nil(t, WriteDelegationReport(buf, DelegationFormatJSON, results))
+	decoded := []*ZoneDelegation{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, results, decoded)
+}
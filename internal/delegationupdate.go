@@ -0,0 +1,318 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Delegation update queue and audit trail files in the state directory
+	delegationUpdateStateFile = "delegation-updates.json"
+	delegationAuditStateFile  = "delegation-audit.json"
+)
+
+// DelegationChange is an audit trail entry of a name server delegation change made through the registrar
+type DelegationChange struct {
+	Time    time.Time `json:"time"`
+	Account string    `json:"account"`
+	Zone    string    `json:"zone"`
+	// Name servers replaced by the change
+	Prior       []string `json:"prior"`
+	NameServers []string `json:"nameservers"`
+	// True if the change restored the prior name servers of an earlier change
+	Rollback bool `json:"rollback,omitempty"`
+	// True until the registrar change is made. Prior then holds the delegation looked up before the change.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// delegationAuditTrail records delegation changes in the state directory so they can be rolled back
+type delegationAuditTrail struct {
+	lock     sync.Mutex
+	stateDir string
+	changes  []*DelegationChange
+}
+
+func newDelegationAuditTrail(stateDir string) (*delegationAuditTrail, error) {
+
+	a := &delegationAuditTrail{stateDir: stateDir, changes: []*DelegationChange{}}
+	if err := loadState(stateDir, delegationAuditStateFile, &a.changes); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// record appends a change to the audit trail. The change is not kept if the audit trail cannot be saved.
+func (a *delegationAuditTrail) record(change *DelegationChange) error {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.changes = append(a.changes, change)
+	if err := saveState(a.stateDir, delegationAuditStateFile, a.changes); err != nil {
+		a.changes = a.changes[:len(a.changes)-1]
+		return err
+	}
+
+	return nil
+}
+
+// complete marks a recorded pending change as made. Prior name servers returned by the registrar replace those
+// looked up before the change.
+func (a *delegationAuditTrail) complete(change *DelegationChange, prior []string) error {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	change.Pending = false
+	if len(prior) > 0 {
+		change.Prior = prior
+	}
+
+	return saveState(a.stateDir, delegationAuditStateFile, a.changes)
+}
+
+// discard drops a recorded pending change that was not made
+func (a *delegationAuditTrail) discard(change *DelegationChange) error {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i, c := range a.changes {
+		if c == change {
+			a.changes = append(a.changes[:i], a.changes[i+1:]...)
+			return saveState(a.stateDir, delegationAuditStateFile, a.changes)
+		}
+	}
+
+	return nil
+}
+
+// lastChange returns the latest change of zone made by the coordinator, not counting rollbacks. Pending changes are
+// included as the coordinator may have stopped after making them.
+func (a *delegationAuditTrail) lastChange(zone string) *DelegationChange {
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for i := len(a.changes) - 1; i >= 0; i-- {
+		if a.changes[i].Zone == zone && !a.changes[i].Rollback {
+			c := *a.changes[i]
+			return &c
+		}
+	}
+
+	return nil
+}
+
+// delegationAllowed returns true if the delegation of zone may be updated. An empty allow list allows all zones.
+// Entries are zone names or wildcards, e.g. *.example.com, matching names below the wildcard domain.
+func (e *EdgeDNSHandler) delegationAllowed(zone string) bool {
+
+	if len(e.DelegationAllowList) < 1 {
+		return true
+	}
+	for _, allowed := range e.DelegationAllowList {
+		if zonePatternMatch(allowed, zone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queueDelegationUpdate queues a zone created in account for a delegation update
+func queueDelegationUpdate(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zone string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if !edge.UpdateDelegation {
+		return
+	}
+	if !edge.delegationAllowed(zone) {
+		log.Debugf("Zone %s not in delegation allow list", zone)
+		return
+	}
+	if err := edge.delegationUpdates.queue(account.Name(), zone, time.Now()); err != nil {
+		log.Errorf("Unable to queue zone %s for delegation update. Error: %s", zone, err.Error())
+	}
+}
+
+// updateDelegations delegates queued zones of an account to the account's Akamai name servers through the registrar.
+// Zones are updated once active and, if create confirmation is enabled, confirmed. Each change is recorded in the
+// audit trail, with the current delegation, before the registrar is changed. Zones stay queued until updated.
+func updateDelegations(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, reg registrar.RegistrarProvider, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	queued := edge.delegationUpdates.queued(account.Name())
+	metrics.SetGauge("pending_delegation_updates", "Zones whose delegation is not yet updated to the Akamai name servers", map[string]string{"account": account.Name()}, float64(len(queued)))
+	if len(queued) < 1 {
+		return nil
+	}
+	writer, ok := reg.(registrar.RegistrarWriter)
+	if !ok {
+		log.Warnf("Registrar does not support delegation updates. Zones not updated: %d. Account: %s", len(queued), account.Name())
+		return nil
+	}
+	done := []string{}
+	active := []*RegistrarWrite{}
+	for _, p := range queued {
+		// the allow list may have changed since the zone was queued
+		if !edge.delegationAllowed(p.Zone) {
+			log.Infof("Zone %s no longer in delegation allow list. Delegation update dropped", p.Zone)
+			done = append(done, p.Zone)
+			continue
+		}
+		active = append(active, p)
+	}
+	active = activeWrites(ctx, edge, account, active, "Delegation update")
+	var nameservers []string
+	if len(active) > 0 {
+		var err error
+		if nameservers, err = account.client.GetNameServers(ctx, account.Contract); err != nil {
+			return err
+		}
+		if len(nameservers) < 1 {
+			return fmt.Errorf("No Edge DNS name servers found for contract %s", account.Contract)
+		}
+	}
+	for _, p := range active {
+		if dryrun {
+			log.Infof("Update delegation of zone %s to %v. dry run. No changes made", p.Zone, nameservers)
+			continue
+		}
+		// the prior delegation is saved first so the change can be rolled back even if it is not recorded afterwards
+		change := &DelegationChange{Time: time.Now(), Account: account.Name(), Zone: p.Zone, Prior: currentDelegation(ctx, edge, p.Zone), NameServers: nameservers, Pending: true}
+		if aerr := edge.delegationAudit.record(change); aerr != nil {
+			log.Errorf("Unable to record zone %s delegation change. Delegation not updated. Error: %s", p.Zone, aerr.Error())
+			continue
+		}
+		prior, err := writer.SetNameServers(ctx, p.Zone, nameservers)
+		if err != nil {
+			if derr := edge.delegationAudit.discard(change); derr != nil {
+				log.Errorf("Unable to drop zone %s pending delegation change. Error: %s", p.Zone, derr.Error())
+			}
+		}
+		if err == registrar.ErrNotSupported {
+			log.Warnf("Registrar does not support delegation updates. Zones not updated: %d. Account: %s", len(queued), account.Name())
+			break
+		}
+		if err != nil {
+			p.Attempts++
+			p.Error = err.Error()
+			log.Errorf("Failed to update delegation of zone %s. Attempts: %d. Error: %s", p.Zone, p.Attempts, err.Error())
+			if uerr := edge.delegationUpdates.update(p); uerr != nil {
+				log.Errorf("Unable to save zone %s delegation update. Error: %s", p.Zone, uerr.Error())
+			}
+			continue
+		}
+		if aerr := edge.delegationAudit.complete(change, prior); aerr != nil {
+			// the pending change saved before the update keeps the delegation looked up then
+			log.Errorf("Unable to record zone %s delegation change. Prior name servers: %v. Error: %s", p.Zone, prior, aerr.Error())
+		}
+		log.WithFields(delegationChangeFields(change)).Infof("Updated delegation of zone %s", p.Zone)
+		done = append(done, p.Zone)
+	}
+	metrics.SetGauge("pending_delegation_updates", "Zones whose delegation is not yet updated to the Akamai name servers", map[string]string{"account": account.Name()}, float64(len(queued)-len(done)))
+
+	return edge.delegationUpdates.forget(account.Name(), done)
+}
+
+// currentDelegation returns the name servers zone is delegated to, or none if the lookup fails
+func currentDelegation(ctx context.Context, edge *EdgeDNSHandler, zone string) []string {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	delegated, err := edge.delegationclient.GetDelegation(ctx, zone)
+	if err != nil {
+		log.Warnf("Zone %s delegation lookup failed. Prior name servers recorded once returned by the registrar. Error: %s", zone, err.Error())
+		return []string{}
+	}
+
+	return delegated
+}
+
+// RollbackDelegation implements the rollback-delegation sub command. Restores the name servers replaced by the latest
+// delegation update of each zone, as recorded in the audit trail.
+func RollbackDelegation(ctx context.Context, err chan string, reg registrar.RegistrarProvider, edge *EdgeDNSHandler, zones []string, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering RollbackDelegation")
+
+	writer, ok := reg.(registrar.RegistrarWriter)
+	if !ok {
+		err <- "RollbackDelegation. Registrar does not support delegation updates."
+		return
+	}
+	failed := 0
+	for _, zone := range zones {
+		if rerr := rollbackZoneDelegation(ctx, edge, writer, zone, dryrun); rerr != nil {
+			log.Errorf("RollbackDelegation. Failed to roll back delegation of zone %s. Error: %s", zone, rerr.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		err <- fmt.Sprintf("RollbackDelegation. %d of %d zones not rolled back.", failed, len(zones))
+		return
+	}
+
+	err <- ""
+	return
+}
+
+func rollbackZoneDelegation(ctx context.Context, edge *EdgeDNSHandler, writer registrar.RegistrarWriter, zone string, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	last := edge.delegationAudit.lastChange(zone)
+	if last == nil {
+		return fmt.Errorf("No delegation change of zone %s recorded", zone)
+	}
+	if len(last.Prior) < 1 {
+		return fmt.Errorf("No prior name servers of zone %s recorded", zone)
+	}
+	if dryrun {
+		log.Infof("Roll back delegation of zone %s to %v. dry run. No changes made", zone, last.Prior)
+		return nil
+	}
+	prior, err := writer.SetNameServers(ctx, zone, last.Prior)
+	if err != nil {
+		return err
+	}
+	change := &DelegationChange{Time: time.Now(), Account: last.Account, Zone: zone, Prior: prior, NameServers: last.Prior, Rollback: true}
+	if err := edge.delegationAudit.record(change); err != nil {
+		log.Errorf("Unable to record zone %s delegation rollback. Error: %s", zone, err.Error())
+	}
+	log.WithFields(delegationChangeFields(change)).Infof("Rolled back delegation of zone %s", zone)
+
+	return nil
+}
+
+func delegationChangeFields(c *DelegationChange) log.Fields {
+
+	return log.Fields{
+		"account":     c.Account,
+		"zone":        c.Zone,
+		"prior":       c.Prior,
+		"nameservers": c.NameServers,
+	}
+}
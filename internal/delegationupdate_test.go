@@ -0,0 +1,142 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDelegationAllowed(t *testing.T) {
+
+	handler := &EdgeDNSHandler{}
+	assert.True(t, handler.delegationAllowed("any.zone"))
+	handler.DelegationAllowList = []string{"example.com", "*.example.net."}
+	assert.True(t, handler.delegationAllowed("Example.com"))
+	assert.False(t, handler.delegationAllowed("sub.example.com"))
+	assert.True(t, handler.delegationAllowed("sub.example.net"))
+	assert.False(t, handler.delegationAllowed("example.net"))
+	assert.False(t, handler.delegationAllowed("badexample.net"))
+}
+
+func TestUpdateDelegations(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestUpdateDelegations"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.UpdateDelegation = true
+	config.DelegationAllowList = []string{"allowed.zone", "later.zone"}
+	config.StateDir = t.TempDir()
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+	writer := newRegistrarWriterStub(stubRegistrar)
+	stubEdgeDNS.FuncOutput["GetNameServers"] = []string{"a1-1.akam.net", "a2-2.akam.net"}
+	dnsStub := newDNSQueryStub()
+	dnsStub.Delegations["allowed.zone"] = []string{"ns1.registrar.net."}
+	handler.delegationclient = dnsStub
+
+	// only allowed zones are queued
+	assert.Nil(t, addSecondaryZones(ctx, handler, writer, []string{"allowed.zone", "other.zone"}, false))
+	queued := handler.delegationUpdates.queued(account.Name())
+	assert.Equal(t, 1, len(queued))
+	assert.Equal(t, "allowed.zone", queued[0].Zone)
+
+	// inactive zones are deferred
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "allowed.zone", ActivationState: "PENDING"}
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, false))
+	assert.Equal(t, 0, len(writer.nameservers))
+
+	// dry run makes no changes
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "allowed.zone", ActivationState: "ACTIVE"}
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, true))
+	assert.Equal(t, 0, len(writer.nameservers))
+
+	// failures stay queued
+	writer.FuncErrors["SetNameServers"] = "Registrar unavailable"
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, false))
+	assert.Equal(t, 1, handler.delegationUpdates.queued(account.Name())[0].Attempts)
+	assert.Equal(t, 0, len(handler.delegationAudit.changes))
+
+	// zones whose pending change cannot be recorded stay queued and are not updated
+	delete(writer.FuncErrors, "SetNameServers")
+	notDir := filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, ioutil.WriteFile(notDir, []byte{}, 0600))
+	handler.delegationAudit.stateDir = notDir
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, false))
+	assert.Equal(t, 1, len(handler.delegationUpdates.queued(account.Name())))
+	assert.Equal(t, 0, len(writer.nameservers))
+	assert.Equal(t, 0, len(handler.delegationAudit.changes))
+
+	handler.delegationAudit.stateDir = config.StateDir
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, false))
+	assert.Equal(t, []string{"a1-1.akam.net", "a2-2.akam.net"}, writer.nameservers["allowed.zone"])
+	assert.Equal(t, 0, len(handler.delegationUpdates.queued(account.Name())))
+
+	// the audit trail survives a restart
+	audit, err := newDelegationAuditTrail(config.StateDir)
+	assert.Nil(t, err)
+	change := audit.lastChange("allowed.zone")
+	assert.NotNil(t, change)
+	assert.Equal(t, []string{"ns1.registrar.net"}, change.Prior)
+	assert.Equal(t, account.Name(), change.Account)
+	assert.False(t, change.Pending)
+
+	// zones removed from the allow list are dropped
+	assert.Nil(t, handler.delegationUpdates.queue(account.Name(), "later.zone", change.Time))
+	handler.DelegationAllowList = []string{"allowed.zone"}
+	assert.Nil(t, updateDelegations(ctx, handler, account, writer, false))
+	assert.Equal(t, 0, len(handler.delegationUpdates.queued(account.Name())))
+	_, ok := writer.nameservers["later.zone"]
+	assert.False(t, ok)
+}
+
+func TestRollbackDelegation(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestRollbackDelegation"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	writer := newRegistrarWriterStub(stubRegistrar)
+	writer.nameservers["allowed.zone"] = []string{"a1-1.akam.net"}
+	assert.Nil(t, handler.delegationAudit.record(&DelegationChange{Account: "1-A", Zone: "allowed.zone", Prior: []string{"ns1.registrar.net"}, NameServers: []string{"a1-1.akam.net"}}))
+
+	cmderr := make(chan string, 1)
+	RollbackDelegation(ctx, cmderr, writer, handler, []string{"allowed.zone"}, true)
+	assert.Equal(t, "", <-cmderr)
+	assert.Equal(t, []string{"a1-1.akam.net"}, writer.nameservers["allowed.zone"])
+
+	RollbackDelegation(ctx, cmderr, writer, handler, []string{"allowed.zone"}, false)
+	assert.Equal(t, "", <-cmderr)
+	assert.Equal(t, []string{"ns1.registrar.net"}, writer.nameservers["allowed.zone"])
+	// rollbacks are recorded and roll back to the same name servers
+	assert.Equal(t, 2, len(handler.delegationAudit.changes))
+	assert.True(t, handler.delegationAudit.changes[1].Rollback)
+	assert.Equal(t, []string{"ns1.registrar.net"}, handler.delegationAudit.lastChange("allowed.zone").Prior)
+
+	// zones without recorded changes fail
+	RollbackDelegation(ctx, cmderr, writer, handler, []string{"other.zone"}, false)
+	assert.Contains(t, <-cmderr, "1 of 1 zones")
+
+	// registrars without RegistrarWriter fail
+	RollbackDelegation(ctx, cmderr, stubRegistrar, handler, []string{"allowed.zone"}, false)
+	assert.Contains(t, <-cmderr, "does not support")
+}
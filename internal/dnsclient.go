@@ -0,0 +1,356 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDNSQueryTimeout = time.Second * 5
+	DefaultDNSPort         = "53"
+	DefaultTsigFudge       = 300
+)
+
+// DNSQueryService is a proxy interface of the DNS protocol queries made by the coordinator that can be stubbed for testing.
+type DNSQueryService interface {
+	GetSOASerial(ctx context.Context, zone string, server string) (uint32, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	ProbeTransfer(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey, ixfr bool) error
+	GetDelegation(ctx context.Context, domain string) ([]string, error)
+	TransferZone(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey) ([]miekg.RR, error)
+}
+
+// DNSQueryClient issues DNS queries directly to name servers. Host lookups go through Resolver if set,
+// otherwise through the system resolver.
+type DNSQueryClient struct {
+	Resolver string
+	Timeout  time.Duration
+	// Port of name servers whose addresses are looked up
+	Port string
+}
+
+func NewDNSQueryClient(resolver string, timeout time.Duration) *DNSQueryClient {
+
+	if timeout <= 0 {
+		timeout = DefaultDNSQueryTimeout
+	}
+	if resolver != "" {
+		resolver = serverAddr(resolver)
+	}
+
+	return &DNSQueryClient{
+		Resolver: resolver,
+		Timeout:  timeout,
+		Port:     DefaultDNSPort,
+	}
+}
+
+// serverAddr appends the default DNS port to server if none given
+func serverAddr(server string) string {
+
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), DefaultDNSPort)
+}
+
+func (c *DNSQueryClient) exchange(ctx context.Context, msg *miekg.Msg, server string) (*miekg.Msg, error) {
+
+	client := &miekg.Client{Net: "udp", Timeout: c.Timeout}
+	resp, _, err := client.ExchangeContext(ctx, msg, serverAddr(server))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, serverAddr(server))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// GetSOASerial queries server non recursively for the zone SOA and returns its serial. The answer must be authoritative.
+func (c *DNSQueryClient) GetSOASerial(ctx context.Context, zone string, server string) (uint32, error) {
+
+	msg := new(miekg.Msg)
+	msg.SetQuestion(miekg.Fqdn(zone), miekg.TypeSOA)
+	msg.RecursionDesired = false
+
+	resp, err := c.exchange(ctx, msg, server)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Rcode != miekg.RcodeSuccess {
+		return 0, fmt.Errorf("SOA query for %s returned %s", zone, miekg.RcodeToString[resp.Rcode])
+	}
+	if !resp.Authoritative {
+		return 0, fmt.Errorf("SOA answer for %s is not authoritative", zone)
+	}
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*miekg.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+
+	return 0, fmt.Errorf("SOA query for %s returned no SOA record", zone)
+}
+
+// LookupHost returns the addresses of host. IP literals are returned as is.
+func (c *DNSQueryClient) LookupHost(ctx context.Context, host string) ([]string, error) {
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	if c.Resolver == "" {
+		resolver := &net.Resolver{}
+		lctx, cancel := context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+		return resolver.LookupHost(lctx, host)
+	}
+
+	addrs := []string{}
+	var lastErr error
+	for _, qtype := range []uint16{miekg.TypeA, miekg.TypeAAAA} {
+		msg := new(miekg.Msg)
+		msg.SetQuestion(miekg.Fqdn(host), qtype)
+		resp, err := c.exchange(ctx, msg, c.Resolver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch r := rr.(type) {
+			case *miekg.A:
+				addrs = append(addrs, r.A.String())
+			case *miekg.AAAA:
+				addrs = append(addrs, r.AAAA.String())
+			}
+		}
+	}
+	if len(addrs) < 1 {
+		if lastErr != nil {
+			return addrs, lastErr
+		}
+		return addrs, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	return addrs, nil
+}
+
+// tsigAlgorithm converts an Edge DNS TSIG algorithm name to its canonical form
+func tsigAlgorithm(algorithm string) string {
+
+	return miekg.Fqdn(strings.ToLower(algorithm))
+}
+
+// ProbeTransfer queries the zone SOA from server, then starts a zone transfer, signed with tsigKey if provided.
+// The transfer is abandoned after the first response. Returns nil if the server accepted the transfer.
+func (c *DNSQueryClient) ProbeTransfer(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey, ixfr bool) error {
+
+	serial, err := c.GetSOASerial(ctx, zone, server)
+	if err != nil {
+		return fmt.Errorf("SOA query failed. %s", err.Error())
+	}
+
+	msg := new(miekg.Msg)
+	if ixfr {
+		msg.SetIxfr(miekg.Fqdn(zone), serial, ".", ".")
+	} else {
+		msg.SetAxfr(miekg.Fqdn(zone))
+	}
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddr(server))
+	if err != nil {
+		return fmt.Errorf("transfer connection failed. %s", err.Error())
+	}
+	transfer := &miekg.Transfer{Conn: &miekg.Conn{Conn: conn}}
+	defer transfer.Close()
+	if tsigKey != nil && tsigKey.Name != "" {
+		keyName := miekg.Fqdn(strings.ToLower(tsigKey.Name))
+		transfer.TsigSecret = map[string]string{keyName: tsigKey.Secret}
+		msg.SetTsig(keyName, tsigAlgorithm(tsigKey.Algorithm), DefaultTsigFudge, time.Now().Unix())
+	}
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	if err := transfer.WriteMsg(msg); err != nil {
+		return fmt.Errorf("transfer request failed. %s", err.Error())
+	}
+	resp, err := transfer.ReadMsg()
+	if err != nil {
+		return fmt.Errorf("transfer response failed. %s", err.Error())
+	}
+	if resp.Rcode != miekg.RcodeSuccess {
+		return fmt.Errorf("transfer refused. %s", miekg.RcodeToString[resp.Rcode])
+	}
+	if len(resp.Answer) < 1 || resp.Answer[0].Header().Rrtype != miekg.TypeSOA {
+		return fmt.Errorf("transfer response does not start with SOA")
+	}
+	if transfer.TsigSecret != nil && resp.IsTsig() == nil {
+		return fmt.Errorf("transfer response not TSIG signed")
+	}
+
+	return nil
+}
+
+// TransferZone transfers zone from server by AXFR, signed with tsigKey if provided. Returns the records of the zone
+// with the SOA record first and without the closing SOA record.
+func (c *DNSQueryClient) TransferZone(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey) ([]miekg.RR, error) {
+
+	msg := new(miekg.Msg)
+	msg.SetAxfr(miekg.Fqdn(zone))
+	transfer := &miekg.Transfer{DialTimeout: c.Timeout, ReadTimeout: c.Timeout, WriteTimeout: c.Timeout}
+	if tsigKey != nil && tsigKey.Name != "" {
+		keyName := miekg.Fqdn(strings.ToLower(tsigKey.Name))
+		transfer.TsigSecret = map[string]string{keyName: tsigKey.Secret}
+		msg.SetTsig(keyName, tsigAlgorithm(tsigKey.Algorithm), DefaultTsigFudge, time.Now().Unix())
+	}
+	envelopes, err := transfer.In(msg, serverAddr(server))
+	if err != nil {
+		return nil, fmt.Errorf("transfer request failed. %s", err.Error())
+	}
+	records := []miekg.RR{}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, fmt.Errorf("transfer failed. %s", envelope.Error.Error())
+		}
+		records = append(records, envelope.RR...)
+	}
+	if len(records) < 2 || records[0].Header().Rrtype != miekg.TypeSOA || records[len(records)-1].Header().Rrtype != miekg.TypeSOA {
+		return nil, fmt.Errorf("transfer incomplete. Expected records between two SOA records")
+	}
+
+	return records[:len(records)-1], nil
+}
+
+// lookupNS returns the name servers of zone, or none if zone is not a zone apex. Lookups go through Resolver if set,
+// otherwise through the system resolver.
+func (c *DNSQueryClient) lookupNS(ctx context.Context, zone string) ([]string, error) {
+
+	servers := []string{}
+	if c.Resolver == "" {
+		resolver := &net.Resolver{}
+		lctx, cancel := context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+		records, err := resolver.LookupNS(lctx, zone)
+		if err != nil {
+			if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+				return servers, nil
+			}
+			return servers, err
+		}
+		for _, ns := range records {
+			servers = append(servers, ns.Host)
+		}
+		return servers, nil
+	}
+
+	msg := new(miekg.Msg)
+	msg.SetQuestion(miekg.Fqdn(zone), miekg.TypeNS)
+	resp, err := c.exchange(ctx, msg, c.Resolver)
+	if err != nil {
+		return servers, err
+	}
+	if resp.Rcode != miekg.RcodeSuccess && resp.Rcode != miekg.RcodeNameError {
+		return servers, fmt.Errorf("NS query for %s returned %s", zone, miekg.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*miekg.NS); ok && strings.EqualFold(ns.Hdr.Name, miekg.Fqdn(zone)) {
+			servers = append(servers, ns.Ns)
+		}
+	}
+
+	return servers, nil
+}
+
+// nameServerName normalizes a name server name for comparison
+func nameServerName(name string) string {
+
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// GetDelegation returns the name servers delegated to domain by its parent zone, sorted. The closest parent zone is
+// found through the resolver and its name servers are queried directly. No name servers are returned if the parent
+// does not delegate domain.
+func (c *DNSQueryClient) GetDelegation(ctx context.Context, domain string) ([]string, error) {
+
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	parent := ""
+	parentServers := []string{}
+	for i := 1; i < len(labels) && len(parentServers) < 1; i++ {
+		parent = strings.Join(labels[i:], ".")
+		servers, err := c.lookupNS(ctx, parent)
+		if err != nil {
+			return nil, fmt.Errorf("Parent zone lookup of %s failed. %s", domain, err.Error())
+		}
+		parentServers = servers
+	}
+	if len(parentServers) < 1 {
+		return nil, fmt.Errorf("No parent zone of %s found", domain)
+	}
+
+	msg := new(miekg.Msg)
+	msg.SetQuestion(miekg.Fqdn(domain), miekg.TypeNS)
+	msg.RecursionDesired = false
+	var lastErr error
+	for _, server := range parentServers {
+		addrs, err := c.LookupHost(ctx, strings.TrimSuffix(server, "."))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			resp, err := c.exchange(ctx, msg, net.JoinHostPort(addr, c.Port))
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if resp.Rcode == miekg.RcodeNameError {
+				return []string{}, nil
+			}
+			if resp.Rcode != miekg.RcodeSuccess {
+				lastErr = fmt.Errorf("NS query for %s returned %s", domain, miekg.RcodeToString[resp.Rcode])
+				continue
+			}
+			// a referral carries the delegation in the authority section
+			delegated := map[string]bool{}
+			for _, rr := range append(resp.Answer, resp.Ns...) {
+				if ns, ok := rr.(*miekg.NS); ok && strings.EqualFold(ns.Hdr.Name, miekg.Fqdn(domain)) {
+					delegated[nameServerName(ns.Ns)] = true
+				}
+			}
+			nameservers := make([]string, 0, len(delegated))
+			for ns := range delegated {
+				nameservers = append(nameservers, ns)
+			}
+			sort.Strings(nameservers)
+			return nameservers, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for name servers of %s", parent)
+	}
+
+	return nil, fmt.Errorf("Parent zone %s query failed. %s", parent, lastErr.Error())
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"net"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// startTestDNSServer runs an in process authoritative server on udp and tcp. Returns server address.
+func startTestDNSServer(t *testing.T, handler miekg.Handler, tsigSecret map[string]string) string {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err.Error())
+	}
+	for _, server := range []*miekg.Server{
+		{Listener: ln, Handler: handler, TsigSecret: tsigSecret},
+		{PacketConn: pc, Handler: handler, TsigSecret: tsigSecret},
+	} {
+		started := make(chan bool)
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		s := server
+		t.Cleanup(func() { s.Shutdown() })
+	}
+
+	return ln.Addr().String()
+}
+
+func testSOAHandler(zone string, serial uint32) miekg.HandlerFunc {
+
+	return func(w miekg.ResponseWriter, req *miekg.Msg) {
+		m := new(miekg.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		if q.Name != miekg.Fqdn(zone) {
+			m.SetRcode(req, miekg.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+		m.Authoritative = true
+		switch q.Qtype {
+		case miekg.TypeSOA:
+			m.Answer = append(m.Answer, &miekg.SOA{
+				Hdr:    miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeSOA, Class: miekg.ClassINET, Ttl: 300},
+				Ns:     "ns1." + q.Name,
+				Mbox:   "hostmaster." + q.Name,
+				Serial: serial,
+			})
+		case miekg.TypeA:
+			m.Answer = append(m.Answer, &miekg.A{
+				Hdr: miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeA, Class: miekg.ClassINET, Ttl: 300},
+				A:   net.ParseIP("127.0.0.1"),
+			})
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestDNSQueryClientGetSOASerial(t *testing.T) {
+
+	addr := startTestDNSServer(t, testSOAHandler("example.com", 2021050101), nil)
+	client := NewDNSQueryClient("", time.Second)
+
+	serial, err := client.GetSOASerial(context.TODO(), "example.com", addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2021050101), serial)
+
+	_, err = client.GetSOASerial(context.TODO(), "other.com", addr)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "REFUSED")
+}
+
+func TestDNSQueryClientLookupHost(t *testing.T) {
+
+	addr := startTestDNSServer(t, testSOAHandler("ns1.example.com", 1), nil)
+	client := NewDNSQueryClient(addr, time.Second)
+
+	addrs, err := client.LookupHost(context.TODO(), "ns1.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addrs)
+
+	addrs, err = client.LookupHost(context.TODO(), "192.0.2.1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.0.2.1"}, addrs)
+}
+
+func testDelegationHandler(parent string, delegations map[string][]string) miekg.HandlerFunc {
+
+	return func(w miekg.ResponseWriter, req *miekg.Msg) {
+		m := new(miekg.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		switch {
+		case q.Qtype == miekg.TypeNS && q.Name == miekg.Fqdn(parent):
+			m.Authoritative = true
+			m.Answer = append(m.Answer, &miekg.NS{
+				Hdr: miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeNS, Class: miekg.ClassINET, Ttl: 300},
+				Ns:  "ns1." + q.Name,
+			})
+		case q.Qtype == miekg.TypeA && q.Name == "ns1."+miekg.Fqdn(parent):
+			m.Authoritative = true
+			m.Answer = append(m.Answer, &miekg.A{
+				Hdr: miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeA, Class: miekg.ClassINET, Ttl: 300},
+				A:   net.ParseIP("127.0.0.1"),
+			})
+		case q.Qtype == miekg.TypeNS:
+			nameservers, ok := delegations[q.Name]
+			if !ok {
+				m.SetRcode(req, miekg.RcodeNameError)
+				break
+			}
+			// referral
+			for _, ns := range nameservers {
+				m.Ns = append(m.Ns, &miekg.NS{
+					Hdr: miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeNS, Class: miekg.ClassINET, Ttl: 300},
+					Ns:  ns,
+				})
+			}
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestDNSQueryClientGetDelegation(t *testing.T) {
+
+	addr := startTestDNSServer(t, testDelegationHandler("example.com", map[string][]string{
+		"sub.example.com.": {"A2-2.akam.net.", "a1-1.akam.net."},
+	}), nil)
+	client := NewDNSQueryClient(addr, time.Second)
+	_, client.Port, _ = net.SplitHostPort(addr)
+
+	nameservers, err := client.GetDelegation(context.TODO(), "sub.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a1-1.akam.net", "a2-2.akam.net"}, nameservers)
+
+	// the parent of a deeper domain is found walking up
+	nameservers, err = client.GetDelegation(context.TODO(), "a.b.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(nameservers))
+
+	_, err = client.GetDelegation(context.TODO(), "example.org")
+	assert.NotNil(t, err)
+}
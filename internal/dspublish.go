@@ -0,0 +1,110 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"time"
+)
+
+const (
+	// DS publication queue file in the state directory
+	dsPublicationStateFile = "ds-publications.json"
+)
+
+// queueDSPublication queues a sign and serve zone created in account for DS publication
+func queueDSPublication(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zone string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if !edge.PublishDS {
+		return
+	}
+	if err := edge.dsPublications.queue(account.Name(), zone, time.Now()); err != nil {
+		log.Errorf("Unable to queue zone %s for DS publication. Error: %s", zone, err.Error())
+	}
+}
+
+// publishDSRecords publishes the DS records of queued zones of an account through the registrar. Zones are published
+// once active and, if create confirmation is enabled, confirmed. Zones stay queued until published.
+func publishDSRecords(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, reg registrar.RegistrarProvider, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	queued := edge.dsPublications.queued(account.Name())
+	metrics.SetGauge("pending_ds_publications", "Sign and serve zones whose DS records are not yet published", map[string]string{"account": account.Name()}, float64(len(queued)))
+	if len(queued) < 1 {
+		return nil
+	}
+	writer, ok := reg.(registrar.RegistrarWriter)
+	if !ok {
+		log.Warnf("Registrar does not support DS record publication. Unpublished zones: %d. Account: %s", len(queued), account.Name())
+		return nil
+	}
+	ready := []string{}
+	byZone := map[string]*RegistrarWrite{}
+	for _, p := range activeWrites(ctx, edge, account, queued, "DS publication") {
+		ready = append(ready, p.Zone)
+		byZone[p.Zone] = p
+	}
+	if len(ready) < 1 {
+		return nil
+	}
+	statuses, err := account.client.GetDNSSECStatus(ctx, ready)
+	if err != nil {
+		return err
+	}
+	published := []string{}
+	for _, status := range statuses {
+		p, ok := byZone[status.Zone]
+		if !ok {
+			continue
+		}
+		ds, err := status.DSRecords()
+		if err != nil {
+			log.Errorf("Invalid DNSSEC records of zone %s. Error: %s", status.Zone, err.Error())
+			continue
+		}
+		if len(ds) < 1 {
+			log.Debugf("Zone %s has no DS records yet. Alerts: %v", status.Zone, status.Alerts)
+			continue
+		}
+		if dryrun {
+			log.Infof("Publish DS records of zone %s: %v. dry run. No changes made", status.Zone, registrar.DSRdata(ds))
+			continue
+		}
+		err = writer.SetDSRecords(ctx, status.Zone, ds)
+		if err == registrar.ErrNotSupported {
+			log.Warnf("Registrar does not support DS record publication. Unpublished zones: %d. Account: %s", len(queued), account.Name())
+			return nil
+		}
+		if err != nil {
+			p.Attempts++
+			p.Error = err.Error()
+			log.Errorf("Failed to publish DS records of zone %s. Attempts: %d. Error: %s", status.Zone, p.Attempts, err.Error())
+			if uerr := edge.dsPublications.update(p); uerr != nil {
+				log.Errorf("Unable to save zone %s DS publication. Error: %s", status.Zone, uerr.Error())
+			}
+			continue
+		}
+		log.Infof("Published DS records of zone %s: %v", status.Zone, registrar.DSRdata(ds))
+		published = append(published, status.Zone)
+	}
+	metrics.SetGauge("pending_ds_publications", "Sign and serve zones whose DS records are not yet published", map[string]string{"account": account.Name()}, float64(len(queued)-len(published)))
+
+	return edge.dsPublications.forget(account.Name(), published)
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testDSData = "55648 13 2 B4C8C1FE2E7477127B27115656AD6256F424625BF5C1E2770CE6D6E37DF61D17"
+
+// registrarWriterStub is a registrar stub supporting RegistrarWriter. Written values are indexed by domain
+type registrarWriterStub struct {
+	StubRegistrar
+	published   map[string][]string
+	nameservers map[string][]string
+}
+
+func newRegistrarWriterStub(stubRegistrar StubRegistrar) *registrarWriterStub {
+
+	return &registrarWriterStub{StubRegistrar: stubRegistrar, published: map[string][]string{}, nameservers: map[string][]string{}}
+}
+
+func (sr *registrarWriterStub) SetDSRecords(ctx context.Context, domain string, ds []registrar.DS) error {
+
+	if errmsg, ok := sr.FuncErrors["SetDSRecords"]; ok {
+		return fmt.Errorf(errmsg)
+	}
+	sr.published[domain] = registrar.DSRdata(ds)
+
+	return nil
+}
+
+func (sr *registrarWriterStub) SetNameServers(ctx context.Context, domain string, nameservers []string) ([]string, error) {
+
+	if errmsg, ok := sr.FuncErrors["SetNameServers"]; ok {
+		return nil, fmt.Errorf(errmsg)
+	}
+	prior, ok := sr.nameservers[domain]
+	if !ok {
+		prior = []string{"ns1.registrar.net"}
+	}
+	sr.nameservers[domain] = nameservers
+
+	return prior, nil
+}
+
+func TestPublishDSRecords(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestPublishDSRecords"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.DNSSEC = true
+	config.PublishDS = true
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+	writer := newRegistrarWriterStub(stubRegistrar)
+
+	// created sign and serve zones are queued
+	assert.Nil(t, addSecondaryZones(ctx, handler, writer, []string{"signed.zone"}, false))
+	assert.Equal(t, 1, len(handler.dsPublications.queued(account.Name())))
+
+	// inactive zones are deferred
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "signed.zone", ActivationState: "PENDING"}
+	assert.Nil(t, publishDSRecords(ctx, handler, account, writer, false))
+	assert.Equal(t, 0, len(writer.published))
+
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "signed.zone", ActivationState: "ACTIVE"}
+	stubEdgeDNS.FuncOutput["GetDNSSECStatus"] = []*registrar.EdgeDNSSECStatus{
+		{Zone: "signed.zone", CurrentRecords: &registrar.EdgeDNSSECRecords{DSRecord: "signed.zone. 86400 IN DS " + testDSData}},
+	}
+
+	// dry run makes no changes
+	assert.Nil(t, publishDSRecords(ctx, handler, account, writer, true))
+	assert.Equal(t, 0, len(writer.published))
+	assert.Equal(t, 1, len(handler.dsPublications.queued(account.Name())))
+
+	// failures stay queued
+	writer.FuncErrors["SetDSRecords"] = "Registrar unavailable"
+	assert.Nil(t, publishDSRecords(ctx, handler, account, writer, false))
+	queued := handler.dsPublications.queued(account.Name())
+	assert.Equal(t, 1, queued[0].Attempts)
+	assert.Equal(t, "Registrar unavailable", queued[0].Error)
+	pending, ok := metrics.Gauge("pending_ds_publications", map[string]string{"account": account.Name()})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), pending)
+
+	delete(writer.FuncErrors, "SetDSRecords")
+	assert.Nil(t, publishDSRecords(ctx, handler, account, writer, false))
+	assert.Equal(t, []string{testDSData}, writer.published["signed.zone"])
+	assert.Equal(t, 0, len(handler.dsPublications.queued(account.Name())))
+	pending, _ = metrics.Gauge("pending_ds_publications", map[string]string{"account": account.Name()})
+	assert.Equal(t, float64(0), pending)
+}
+
+func TestPublishDSRecordsNotSupported(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestPublishDSRecordsNotSupported"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.PublishDS = true
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+	assert.Nil(t, handler.dsPublications.queue(account.Name(), "signed.zone", time.Now()))
+
+	// zones stay queued for registrars not supporting publication
+	assert.Nil(t, publishDSRecords(ctx, handler, account, stubRegistrar, false))
+	assert.Equal(t, 1, len(handler.dsPublications.queued(account.Name())))
+}
@@ -16,34 +16,159 @@ package internal
 import (
 	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
 	edgegrid "github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
 
 	"context"
+	"fmt"
 	"github.com/apex/log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const ()
 
 var (
 	edgeDNSHandler *EdgeDNSHandler
+	_              AkamaiDNSService = (*registrar.EdgeDNSClient)(nil)
 )
 
 // edgeDNSClient is a proxy interface of the Akamai edgegrid configdns-v2 package that can be stubbed for testing.
+// registrar.EdgeDNSClient implements it with per instance credentials.
 type AkamaiDNSService interface {
 	GetZoneNames(ctx context.Context, queryArgs dns.ZoneListQueryArgs, stateFilter []string) ([]string, error)
+	GetZoneStates(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (map[string]string, error)
 	GetZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error)
 	GetZone(ctx context.Context, zone string) (*dns.ZoneResponse, error)
 	CreateZone(ctx context.Context, zone *dns.ZoneCreate, zonequerystring dns.ZoneQueryString) error
 	CreateBulkZones(ctx context.Context, bulkzones *dns.BulkZonesCreate, zonequerystring dns.ZoneQueryString) (*dns.BulkZonesResponse, error)
 	DeleteBulkZones(ctx context.Context, zoneslist *dns.ZoneNameListResponse) (*dns.BulkZonesResponse, error)
+	GetNameServers(ctx context.Context, contract string) ([]string, error)
+	GetGroups(ctx context.Context) ([]*registrar.EdgeDNSGroup, error)
+	GetContracts(ctx context.Context, groupID int) ([]*registrar.EdgeDNSContract, error)
+	GetZoneTransferStatus(ctx context.Context, zones []string) ([]*registrar.EdgeDNSZoneTransferStatus, error)
+	GetDNSSECStatus(ctx context.Context, zones []string) ([]*registrar.EdgeDNSSECStatus, error)
+	UpdateZoneKey(ctx context.Context, zone string, key *dns.TSIGKey) error
+	UpdateZone(ctx context.Context, zone *dns.ZoneCreate) error
+	ReplaceRecordsets(ctx context.Context, zone string, recordsets *dns.Recordsets) error
 	//DeleteZone(zone *dns.ZoneCreate, zonequerystring dns.ZoneQueryString) error
 }
 
+// EdgeDNSAccount is an Edge DNS contract whose secondary zones are managed, optionally under an account switch key
+type EdgeDNSAccount struct {
+	Contract   string
+	Group      int
+	AccountKey string
+	// Contract and group names resolved at initialization
+	ContractName string
+	GroupName    string
+	// Defines client. Allows for mocking.
+	client AkamaiDNSService
+	// Edge DNS API client signing requests with the account switch key
+	api *registrar.EdgeDNSClient
+}
+
+// Name identifies the account in logs and zone inventories
+func (a *EdgeDNSAccount) Name() string {
+
+	contract := a.Contract
+	if contract == "" {
+		contract = a.ContractName
+	}
+	if a.AccountKey == "" {
+		return contract
+	}
+
+	return a.AccountKey + ":" + contract
+}
+
+// ParseEdgeDNSAccount parses an account spec of comma separated key=value pairs, e.g. contract=1-ABCDE,group=12345,account-key=B-C-1ED34DY:1-2RBL.
+// contract-name and group-name may replace contract and group.
+func ParseEdgeDNSAccount(spec string) (*EdgeDNSAccount, error) {
+
+	account := &EdgeDNSAccount{}
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid edgedns account %q. Expected key=value", kv)
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "contract":
+			account.Contract = value
+		case "group":
+			group, err := strconv.Atoi(value)
+			if err != nil || group < 1 {
+				return nil, fmt.Errorf("Invalid edgedns account group %q", value)
+			}
+			account.Group = group
+		case "account-key":
+			account.AccountKey = value
+		case "contract-name":
+			account.ContractName = value
+		case "group-name":
+			account.GroupName = value
+		default:
+			return nil, fmt.Errorf("Invalid edgedns account key %q. Valid keys: contract, contract-name, group, group-name, account-key", parts[0])
+		}
+	}
+	if account.Contract == "" && account.ContractName == "" {
+		return nil, fmt.Errorf("edgedns account %q has no contract", spec)
+	}
+	if account.Contract != "" && account.ContractName != "" {
+		return nil, fmt.Errorf("edgedns account %q has both a contract and a contract name", spec)
+	}
+	if account.Group > 0 && account.GroupName != "" {
+		return nil, fmt.Errorf("edgedns account %q has both a group and a group name", spec)
+	}
+
+	return account, nil
+}
+
+// EdgeDNSAccounts returns the managed accounts. The --edgedns-contract account, if any, comes first followed by each
+// --edgedns-account. Accounts without a group or account switch key use --edgedns-group and --edgegrid-account-key.
+// New zones are created in the first account. Names are resolved by InitEdgeDNSHandler.
+func (cfg *Config) EdgeDNSAccounts() ([]*EdgeDNSAccount, error) {
+
+	accounts := []*EdgeDNSAccount{}
+	if cfg.EdgeDNSContract != "" || cfg.EdgeDNSContractName != "" {
+		accounts = append(accounts, &EdgeDNSAccount{Contract: cfg.EdgeDNSContract, ContractName: cfg.EdgeDNSContractName})
+	}
+	for _, spec := range cfg.EdgeDNSAccountSpecs {
+		account, err := ParseEdgeDNSAccount(spec)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	seen := map[string]bool{}
+	for _, account := range accounts {
+		if account.Group == 0 && account.GroupName == "" {
+			account.Group = cfg.EdgeDNSGroup
+			account.GroupName = cfg.EdgeDNSGroupName
+		}
+		if account.AccountKey == "" {
+			account.AccountKey = cfg.EdgegridAccountKey
+		}
+		if seen[account.Name()] {
+			return nil, fmt.Errorf("edgedns account %s is listed more than once", account.Name())
+		}
+		seen[account.Name()] = true
+	}
+
+	return accounts, nil
+}
+
 type EdgeDNSHandler struct {
+	// Contract and group new zones are created in. Same as the first account
 	Contract      string
 	Group         int
+	Accounts      []*EdgeDNSAccount
 	DNSSEC        bool
 	TSig          bool
 	Host          string
@@ -53,9 +178,82 @@ type EdgeDNSHandler struct {
 	EdgercPath    string
 	EdgercSection string
 	FailOnError   bool
-	config        edgegrid.Config
+	// Serial verification
+	Verify          bool
+	VerifyThreshold time.Duration
+	// Master address family policy
+	MasterAddressFamily string
+	// Master transfer probe
+	ProbeMasters      bool
+	ProbeTransferType string
+	// Edge DNS API HTTP client settings
+	ClientOptions registrar.EdgeDNSClientOptions
+	// Zone list page size
+	PageSize int
+	// Handling of zones in non active activation states keyed by state
+	ActivationPolicies map[string]ActivationPolicy
+	activations        *activationTracker
+	recreations        *zoneRecreations
+	// Confirmation of created zones
+	ConfirmZones        bool
+	ConfirmTimeout      time.Duration
+	ConfirmPollInterval time.Duration
+	ConfirmRetries      int
+	confirmations       *confirmationQueue
+	// Action on zones whose domains were removed from the registrar
+	RemovalAction         string
+	ConvertTransferSource string
+	// Zone transfer status monitoring
+	TransferStatusInterval  time.Duration
+	TransferStatusBatchSize int
+	TransferAlertThreshold  time.Duration
+	lastTransferStatus      time.Time
+	// DS record publication at the registrar
+	PublishDS      bool
+	dsPublications *registrarWriteQueue
+	// Parent delegation checks
+	DelegationCheckInterval time.Duration
+	lastDelegationCheck     time.Time
+	// Name server delegation updates at the registrar
+	UpdateDelegation    bool
+	DelegationAllowList []string
+	delegationUpdates   *registrarWriteQueue
+	delegationAudit     *delegationAuditTrail
+	// Per zone overrides of registrar values
+	overrides *zoneOverrides
+	// Registrar name, host and monitor interval ID available to zone comments
+	Registrar string
+	hostname  string
+	cycleID   string
+	// Zone comments
+	UpdateZoneComments bool
+	commentTemplate    *ZoneCommentTemplate
+	zoneComments       *zoneCommentTracker
+	// Maintenance windows and freezes of zone operations, and the changes they hold back
+	changePolicy *ChangePolicy
+	deferred     *deferredChanges
+	// Zones never deleted
+	protected *protectedZones
+	// Notification webhooks
+	notifier *notifier
+	// Local TSIG keys used where the registrar returns none
+	tsigKeyring *TsigKeyring
+	// Coordinator managed TSIG keys
+	TSigGenerate bool
+	tsigKeys     *tsigKeyStore
+	config       edgegrid.Config
+	// Edgegrid credentials. May be secret references
+	clientToken  *registrar.SecretValue
+	clientSecret *registrar.SecretValue
+	accessToken  *registrar.SecretValue
 	// Defines client. Allows for mocking.
 	client AkamaiDNSService
+	// Edge DNS API client. Signs requests with this handler's credentials
+	api *registrar.EdgeDNSClient
+	// DNS protocol client. Allows for mocking.
+	dnsclient DNSQueryService
+	// DNS protocol client of delegation checks. Allows for mocking.
+	delegationclient DNSQueryService
 }
 
 // NewAkamaiProvider initializes a new Akamai DNS based Provider.
@@ -64,37 +262,167 @@ func InitEdgeDNSHandler(ctx context.Context, config *Config, akaService AkamaiDN
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Initializing EdgeDNSHandler")
 	edgeDNSHandler = &EdgeDNSHandler{
-		Contract:      config.EdgeDNSContract,
-		Group:         config.EdgeDNSGroup,
-		DNSSEC:        config.DNSSEC,
-		TSig:          config.TSig,
-		Host:          config.EdgegridHost,
-		ClientToken:   config.EdgegridClientToken,
-		ClientSecret:  config.EdgegridClientSecret,
-		AccessToken:   config.EdgegridAccessToken,
-		EdgercPath:    config.EdgegridEdgercPath,
-		EdgercSection: config.EdgegridEdgercSection,
-		FailOnError:   config.FailOnError,
-	}
-
-	// Process creds
+		Contract:                config.EdgeDNSContract,
+		Group:                   config.EdgeDNSGroup,
+		DNSSEC:                  config.DNSSEC,
+		TSig:                    config.TSig,
+		Host:                    config.EdgegridHost,
+		ClientToken:             config.EdgegridClientToken,
+		ClientSecret:            config.EdgegridClientSecret,
+		AccessToken:             config.EdgegridAccessToken,
+		EdgercPath:              config.EdgegridEdgercPath,
+		EdgercSection:           config.EdgegridEdgercSection,
+		FailOnError:             config.FailOnError,
+		Verify:                  config.Verify,
+		VerifyThreshold:         config.VerifyThreshold,
+		MasterAddressFamily:     config.MasterAddressFamily,
+		ProbeMasters:            config.ProbeMasters,
+		ProbeTransferType:       config.ProbeTransferType,
+		ConfirmZones:            config.ConfirmZones,
+		ConfirmTimeout:          config.ConfirmTimeout,
+		ConfirmPollInterval:     config.ConfirmPollInterval,
+		ConfirmRetries:          config.ConfirmRetries,
+		TransferStatusInterval:  config.TransferStatusInterval,
+		TransferStatusBatchSize: config.TransferStatusBatchSize,
+		TransferAlertThreshold:  config.TransferAlertThreshold,
+		PublishDS:               config.PublishDS,
+		DelegationCheckInterval: config.DelegationCheckInterval,
+		UpdateDelegation:        config.UpdateDelegation,
+		DelegationAllowList:     config.DelegationAllowList,
+		TSigGenerate:            config.TSigGenerate,
+		Registrar:               config.Registrar,
+		UpdateZoneComments:      config.UpdateZoneComments,
+		RemovalAction:           config.RemovalAction,
+		ConvertTransferSource:   config.ConvertTransferSource,
+		PageSize:                config.EdgeDNSPageSize,
+		ClientOptions: registrar.EdgeDNSClientOptions{
+			Timeout:   config.EdgegridTimeout,
+			KeepAlive: config.EdgegridKeepAlive,
+			Proxy:     config.EdgegridProxy,
+		},
+		dnsclient:        NewDNSQueryClient(config.VerifyResolver, config.VerifyTimeout),
+		delegationclient: NewDNSQueryClient(config.DelegationResolver, config.VerifyTimeout),
+	}
+	if edgeDNSHandler.MasterAddressFamily == "" {
+		edgeDNSHandler.MasterAddressFamily = registrar.AddressFamilyBoth
+	}
+	if edgeDNSHandler.VerifyThreshold <= 0 {
+		edgeDNSHandler.VerifyThreshold = DefaultVerifyThreshold
+	}
+	if edgeDNSHandler.ConfirmTimeout <= 0 {
+		edgeDNSHandler.ConfirmTimeout = DefaultConfirmTimeout
+	}
+	if edgeDNSHandler.ConfirmPollInterval <= 0 {
+		edgeDNSHandler.ConfirmPollInterval = DefaultConfirmPollInterval
+	}
+	if edgeDNSHandler.TransferStatusBatchSize <= 0 {
+		edgeDNSHandler.TransferStatusBatchSize = DefaultTransferStatusBatchSize
+	}
+	if edgeDNSHandler.TransferAlertThreshold <= 0 {
+		edgeDNSHandler.TransferAlertThreshold = DefaultTransferAlertThreshold
+	}
+	accounts, err := config.EdgeDNSAccounts()
+	if err != nil {
+		return nil, err
+	}
+	edgeDNSHandler.Accounts = accounts
+	if edgeDNSHandler.ActivationPolicies, err = ParseActivationPolicies(config.ActivationPolicies, config.ActivationTimeout); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.activations, err = newActivationTracker(config.StateDir); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.recreations, err = newZoneRecreations(config.StateDir); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.confirmations, err = newConfirmationQueue(config.StateDir); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.dsPublications, err = newRegistrarWriteQueue(config.StateDir, dsPublicationStateFile); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.delegationUpdates, err = newRegistrarWriteQueue(config.StateDir, delegationUpdateStateFile); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.delegationAudit, err = newDelegationAuditTrail(config.StateDir); err != nil {
+		return nil, err
+	}
+	edgeDNSHandler.hostname, _ = os.Hostname()
+	if config.ZoneCommentTemplate != "" {
+		if edgeDNSHandler.commentTemplate, err = ParseZoneCommentTemplate(config.ZoneCommentTemplate, config.ZoneCommentMaxLength); err != nil {
+			return nil, err
+		}
+	}
+	if edgeDNSHandler.zoneComments, err = newZoneCommentTracker(config.StateDir); err != nil {
+		return nil, err
+	}
+	if config.ZoneOverridesPath != "" {
+		if edgeDNSHandler.overrides, err = newZoneOverrides(ctx, config.ZoneOverridesPath); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := NewChangePolicy(config.MaintenanceWindows, config.ChangeFreezes, config.FreezeFile, config.FreezeOperations, config.MaintenanceTimezone)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.empty() {
+		edgeDNSHandler.changePolicy = policy
+		if config.StateDir == "" {
+			log.Warn("No state directory. Deferred zone deletions are lost on restart")
+		}
+	}
+	if edgeDNSHandler.deferred, err = newDeferredChanges(config.StateDir); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.protected, err = newProtectedZones(config.ProtectedZones, config.ProtectedZonesPath); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.notifier, err = newNotifier(ctx, config.NotifyWebhooks, config.NotifyTimeout); err != nil {
+		return nil, err
+	}
+	if len(config.TSigKeyrings) > 0 {
+		if edgeDNSHandler.tsigKeyring, err = LoadTsigKeyring(config.TSigKeyrings, config.TSigKeyringZones); err != nil {
+			return nil, err
+		}
+		log.Debugf("TSIG keyring keys: %d", edgeDNSHandler.tsigKeyring.Len())
+	}
+	if edgeDNSHandler.TSigGenerate {
+		if edgeDNSHandler.tsigKeys, err = initTsigKeyStore(ctx, config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Process creds. Resolve secret references
+	if edgeDNSHandler.clientToken, err = registrar.NewSecretValue(ctx, config.EdgegridClientToken, config.SecretRefresh); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.clientSecret, err = registrar.NewSecretValue(ctx, config.EdgegridClientSecret, config.SecretRefresh); err != nil {
+		return nil, err
+	}
+	if edgeDNSHandler.accessToken, err = registrar.NewSecretValue(ctx, config.EdgegridAccessToken, config.SecretRefresh); err != nil {
+		return nil, err
+	}
+	edgeDNSHandler.ClientToken, _, _ = edgeDNSHandler.clientToken.Value(ctx)
+	edgeDNSHandler.ClientSecret, _, _ = edgeDNSHandler.clientSecret.Value(ctx)
+	edgeDNSHandler.AccessToken, _, _ = edgeDNSHandler.accessToken.Value(ctx)
 	var edgeGridConfig edgegrid.Config
 
 	log.Debugf("Host: %s", edgeDNSHandler.Host)
-	log.Debugf("ClientToken: %s", edgeDNSHandler.ClientToken)
-	log.Debugf("ClientSecret: %s", edgeDNSHandler.ClientSecret)
-	log.Debugf("AccessToken: %s", edgeDNSHandler.AccessToken)
+	log.Debugf("ClientToken: %s", edgeDNSHandler.clientToken)
+	log.Debugf("ClientSecret: %s", edgeDNSHandler.clientSecret)
+	log.Debugf("AccessToken: %s", edgeDNSHandler.accessToken)
 	log.Debugf("EdgePath: %s", edgeDNSHandler.EdgercPath)
 	log.Debugf("EdgeSection: %s", edgeDNSHandler.EdgercSection)
 
 	// environment overrides edgerc file but config needs to be complete
 	if edgeDNSHandler.Host == "" || edgeDNSHandler.ClientToken == "" || edgeDNSHandler.ClientSecret == "" || edgeDNSHandler.AccessToken == "" {
-		var err error
 		edgeGridConfig, err = edgegrid.Init(edgeDNSHandler.EdgercPath, edgeDNSHandler.EdgercSection) // use default .edgerc location and section
 		if err != nil {
 			log.Errorf("EdgeDNS Edgegrid Init Failed")
 			return nil, err
 		}
+		// credentials not used
+		edgeDNSHandler.clientToken, edgeDNSHandler.clientSecret, edgeDNSHandler.accessToken = nil, nil, nil
 	} else {
 		// Use external-dns config
 		edgeGridConfig = edgegrid.Config{
@@ -112,10 +440,6 @@ func InitEdgeDNSHandler(ctx context.Context, config *Config, akaService AkamaiDN
 				log.Debugf("Edgegrid maxbody set to %s", envval)
 			}
 		}
-		if envval, ok := os.LookupEnv("AKAMAI_ACCOUNT_KEY"); ok {
-			edgeGridConfig.AccountKey = envval
-			log.Debugf("Edgegrid applying account key %s", envval)
-		}
 		if envval, ok := os.LookupEnv("AKAMAI_DEBUG"); ok {
 			if dbgval, err := strconv.ParseBool(envval); err == nil {
 				edgeGridConfig.Debug = dbgval
@@ -125,66 +449,252 @@ func InitEdgeDNSHandler(ctx context.Context, config *Config, akaService AkamaiDN
 	}
 
 	edgeDNSHandler.config = edgeGridConfig
-
+	// credentials may come from the .edgerc file
+	registrar.RegisterSecret(edgeGridConfig.ClientToken)
+	registrar.RegisterSecret(edgeGridConfig.ClientSecret)
+	registrar.RegisterSecret(edgeGridConfig.AccessToken)
+
+	// Each account signs its requests with its own account switch key. Accounts without one use AKAMAI_ACCOUNT_KEY or
+	// the .edgerc account_key, if any
+	defaultAccountKey := edgeGridConfig.AccountKey
+	if envval, ok := os.LookupEnv("AKAMAI_ACCOUNT_KEY"); ok {
+		defaultAccountKey = envval
+	}
+	if config.EdgegridAccountKey != "" {
+		defaultAccountKey = config.EdgegridAccountKey
+	}
+	for _, account := range edgeDNSHandler.Accounts {
+		if account.AccountKey == "" {
+			account.AccountKey = defaultAccountKey
+		}
+		log.Debugf("Edge DNS account: %s, group: %d", account.Name(), account.Group)
+		if akaService != nil {
+			account.client = akaService
+			continue
+		}
+		accountConfig := edgeGridConfig
+		accountConfig.AccountKey = account.AccountKey
+		account.api, err = registrar.NewEdgeDNSClient(accountConfig, edgeDNSHandler.ClientOptions)
+		if err != nil {
+			log.Errorf("EdgeDNS client init failed. Account %s", account.Name())
+			return nil, err
+		}
+		account.client = account.api
+	}
 	if akaService != nil {
 		log.Debugf("EdgeDNS Handler using STUB")
 		edgeDNSHandler.client = akaService
 	} else {
+		if len(edgeDNSHandler.Accounts) > 0 {
+			edgeDNSHandler.api = edgeDNSHandler.Accounts[0].api
+			edgeDNSHandler.Accounts[0].client = edgeDNSHandler
+		} else {
+			// no managed accounts, e.g. listing groups
+			edgeGridConfig.AccountKey = defaultAccountKey
+			edgeDNSHandler.api, err = registrar.NewEdgeDNSClient(edgeGridConfig, edgeDNSHandler.ClientOptions)
+			if err != nil {
+				log.Errorf("EdgeDNS client init failed")
+				return nil, err
+			}
+		}
 		edgeDNSHandler.client = edgeDNSHandler
 	}
 
-	// Init library for direct endpoint calls
-	dns.Init(edgeGridConfig)
+	seen := map[string]bool{}
+	for _, account := range edgeDNSHandler.Accounts {
+		if err := resolveEdgeDNSAccount(ctx, account); err != nil {
+			log.Errorf("EdgeDNS account %s init failed", account.Name())
+			return nil, err
+		}
+		if seen[account.Name()] {
+			return nil, fmt.Errorf("edgedns account %s is listed more than once", account.Name())
+		}
+		seen[account.Name()] = true
+	}
+	if len(edgeDNSHandler.Accounts) > 0 {
+		edgeDNSHandler.Contract = edgeDNSHandler.Accounts[0].Contract
+		edgeDNSHandler.Group = edgeDNSHandler.Accounts[0].Group
+	}
 
 	return edgeDNSHandler, nil
 }
 
-func (e *EdgeDNSHandler) restorePkgEdgeConfig(conf edgegrid.Config) {
+// resolveEdgeDNSAccount resolves the group and contract names of an account to IDs with the account's credentials.
+// Group and contract lists are cached by the Edge DNS client.
+func resolveEdgeDNSAccount(ctx context.Context, account *EdgeDNSAccount) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if account.GroupName != "" && account.Group < 1 {
+		groups, err := account.client.GetGroups(ctx)
+		if err != nil {
+			return fmt.Errorf("Unable to list Edge DNS groups. %s", err.Error())
+		}
+		group, err := registrar.FindGroup(groups, account.GroupName)
+		if err != nil {
+			return err
+		}
+		log.Infof("Edge DNS group %q resolved to %d", account.GroupName, group.GroupID)
+		account.Group = group.GroupID
+	}
+	if account.ContractName != "" && account.Contract == "" {
+		contracts, err := account.client.GetContracts(ctx, account.Group)
+		if err != nil {
+			return fmt.Errorf("Unable to list Edge DNS contracts. %s", err.Error())
+		}
+		contract, err := registrar.FindContract(contracts, account.ContractName)
+		if err != nil {
+			return err
+		}
+		log.Infof("Edge DNS contract %q resolved to %s", account.ContractName, contract.ContractID)
+		account.Contract = contract.ContractID
+	}
+
+	return nil
+}
+
+// refreshCredentials re-reads referenced Edgegrid credentials and updates the Edgegrid config if any were rotated
+func (e *EdgeDNSHandler) refreshCredentials(ctx context.Context) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
 
-	e.config = conf
+	if e.clientToken == nil || e.clientSecret == nil || e.accessToken == nil {
+		return nil
+	}
+	rotated := false
+	for _, cred := range []struct {
+		secret *registrar.SecretValue
+		value  *string
+	}{
+		{e.clientToken, &e.config.ClientToken},
+		{e.clientSecret, &e.config.ClientSecret},
+		{e.accessToken, &e.config.AccessToken},
+	} {
+		val, changed, err := cred.secret.Value(ctx)
+		if err != nil {
+			log.Errorf("Failed to refresh Edgegrid credentials. Error: %s", err.Error())
+			return err
+		}
+		if changed {
+			*cred.value = val
+			rotated = true
+		}
+	}
+	if rotated {
+		e.ClientToken = e.config.ClientToken
+		e.ClientSecret = e.config.ClientSecret
+		e.AccessToken = e.config.AccessToken
+		for _, account := range e.Accounts {
+			if account.api != nil {
+				account.api.SetCredentials(e.config.ClientToken, e.config.ClientSecret, e.config.AccessToken)
+			}
+		}
+		log.Info("Edgegrid credentials refreshed")
+	}
 
-	return
+	return nil
+}
+
+// accountZoneNames returns the secondary zones of each account keyed by account name. Zones in any activation state
+// are returned; the time zones spend in non active states is tracked.
+func (e *EdgeDNSHandler) accountZoneNames(ctx context.Context) (map[string][]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	accountZones := map[string][]string{}
+	for _, account := range e.Accounts {
+		log.Debugf("Edge Account: %s", account.Name())
+		states, err := zoneStates(ctx, account, e.PageSize)
+		if err != nil {
+			return accountZones, fmt.Errorf("Account %s. %s", account.Name(), err.Error())
+		}
+		if err := e.activations.update(account.Name(), states, time.Now()); err != nil {
+			log.Errorf("Failed to save zone activation states. Error: %s", err.Error())
+		}
+		zones := make([]string, 0, len(states))
+		for zone := range states {
+			zones = append(zones, zone)
+		}
+		accountZones[account.Name()] = zones
+	}
+
+	return accountZones, nil
+}
+
+// createAccount returns the account a new zone is created in. Zones deleted for recreation are created again in their
+// account, other zones in the first account.
+func (e *EdgeDNSHandler) createAccount(zone string) *EdgeDNSAccount {
+
+	if name := e.recreations.account(zone); name != "" {
+		if account := e.account(name); account != nil {
+			return account
+		}
+	}
+
+	return e.Accounts[0]
+}
+
+// account returns the managed account named name, if any
+func (e *EdgeDNSHandler) account(name string) *EdgeDNSAccount {
+
+	for _, account := range e.Accounts {
+		if account.Name() == name {
+			return account
+		}
+	}
+
+	return nil
+}
+
+// zoneCreateString formats a zone create request for logging with the TSIG key secret masked
+func zoneCreateString(zone *dns.ZoneCreate) string {
+
+	z := *zone
+	z.TsigKey = nil
+	str := fmt.Sprintf("%+v", z)
+	if zone.TsigKey != nil {
+		str += fmt.Sprintf(" TsigKey:%+v", *registrar.RedactTsigKey(zone.TsigKey))
+	}
+
+	return str
 }
 
-//
 func (e *EdgeDNSHandler) GetZoneNames(ctx context.Context, queryArgs dns.ZoneListQueryArgs, stateFilter []string) ([]string, error) {
 
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler GetZoneNames")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
-
 	// type shud be set to SECONDARY!
-	zlResp, err := e.GetZones(ctx, queryArgs)
+	zones, err := e.api.GetZoneNames(ctx, queryArgs, stateFilter)
 	if err != nil {
 		return []string{}, err
 	}
-	filter := strings.Join(stateFilter, " ")
-	zones := make([]string, 0, len(zlResp.Zones))
-	for _, zone := range zlResp.Zones {
-		if strings.Contains(filter, zone.ActivationState) {
-			continue
-		}
-		zones = append(zones, zone.Zone)
-	}
 
 	log.Debugf("GetZoneNames result: %v", zones)
 	return zones, nil
 }
 
+func (e *EdgeDNSHandler) GetZoneStates(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (map[string]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetZoneStates")
+
+	states, err := e.api.GetZoneStates(ctx, queryArgs)
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	log.Debugf("GetZoneStates result: %v", states)
+	return states, nil
+}
+
 func (e *EdgeDNSHandler) GetZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
 
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler GetZones")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
-
 	log.Debugf("queryArgs: %v", queryArgs)
-	zoneresp, err := dns.ListZones(queryArgs)
+	zoneresp, err := e.api.GetZones(ctx, queryArgs)
 	if err == nil {
 		log.Debugf("GetZones result: %v", zoneresp)
 	}
@@ -197,11 +707,7 @@ func (e *EdgeDNSHandler) GetZone(ctx context.Context, zone string) (*dns.ZoneRes
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler GetZone")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
-
-	zoneresp, err := dns.GetZone(zone)
+	zoneresp, err := e.api.GetZone(ctx, zone)
 	if err == nil {
 		log.Debugf("GetZone result: %v", zoneresp)
 	}
@@ -213,12 +719,8 @@ func (e *EdgeDNSHandler) CreateZone(ctx context.Context, zone *dns.ZoneCreate, z
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler CreateZone")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
-
-	log.Debugf("Creating Zone: %v", zone)
-	return zone.Save(zonequerystring)
+	log.Debugf("Creating Zone: %s", zoneCreateString(zone))
+	return e.api.CreateZone(ctx, zone, zonequerystring)
 
 }
 
@@ -236,11 +738,7 @@ func (e *EdgeDNSHandler) CreateBulkZones(ctx context.Context, bulkzones *dns.Bul
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler CreateBulkZones")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
-
-	return dns.CreateBulkZones(bulkzones, zonequerystring)
+	return e.api.CreateBulkZones(ctx, bulkzones, zonequerystring)
 }
 
 func (e *EdgeDNSHandler) DeleteBulkZones(ctx context.Context, zoneslist *dns.ZoneNameListResponse) (*dns.BulkZonesResponse, error) {
@@ -248,9 +746,69 @@ func (e *EdgeDNSHandler) DeleteBulkZones(ctx context.Context, zoneslist *dns.Zon
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering EdgeDNS Handler DeleteBulkZones")
 
-	var edgegridConf = dns.Config
-	defer e.restorePkgEdgeConfig(edgegridConf)
-	dns.Config = e.config
+	return e.api.DeleteBulkZones(ctx, zoneslist)
+}
+
+func (e *EdgeDNSHandler) GetNameServers(ctx context.Context, contract string) ([]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetNameServers")
+
+	return e.api.GetNameServers(ctx, contract)
+}
+
+func (e *EdgeDNSHandler) GetGroups(ctx context.Context) ([]*registrar.EdgeDNSGroup, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetGroups")
+
+	return e.api.GetGroups(ctx)
+}
+
+func (e *EdgeDNSHandler) GetContracts(ctx context.Context, groupID int) ([]*registrar.EdgeDNSContract, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetContracts")
+
+	return e.api.GetContracts(ctx, groupID)
+}
+
+func (e *EdgeDNSHandler) GetZoneTransferStatus(ctx context.Context, zones []string) ([]*registrar.EdgeDNSZoneTransferStatus, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetZoneTransferStatus")
+
+	return e.api.GetZoneTransferStatus(ctx, zones)
+}
+
+func (e *EdgeDNSHandler) GetDNSSECStatus(ctx context.Context, zones []string) ([]*registrar.EdgeDNSSECStatus, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler GetDNSSECStatus")
+
+	return e.api.GetDNSSECStatus(ctx, zones)
+}
+
+func (e *EdgeDNSHandler) UpdateZone(ctx context.Context, zone *dns.ZoneCreate) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler UpdateZone")
+
+	return e.api.UpdateZone(ctx, zone)
+}
+
+func (e *EdgeDNSHandler) ReplaceRecordsets(ctx context.Context, zone string, recordsets *dns.Recordsets) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler ReplaceRecordsets")
+
+	return e.api.ReplaceRecordsets(ctx, zone, recordsets)
+}
+
+func (e *EdgeDNSHandler) UpdateZoneKey(ctx context.Context, zone string, key *dns.TSIGKey) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering EdgeDNS Handler UpdateZoneKey")
 
-	return dns.DeleteBulkZones(zoneslist)
+	return e.api.UpdateZoneKey(ctx, zone, key)
 }
@@ -0,0 +1,93 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+type groupListRow struct {
+	contractID   string
+	contractName string
+	groupID      int
+	groupName    string
+	createZones  bool
+}
+
+// ListGroups implements the list-groups sub command. Prints the contracts and groups visible to the Edgegrid
+// credentials and whether zones may be created in each. Configured contracts and groups are not resolved.
+func ListGroups(ctx context.Context, config *Config, akaService AkamaiDNSService, w io.Writer) error {
+
+	credConfig := *config
+	credConfig.EdgeDNSContract, credConfig.EdgeDNSContractName = "", ""
+	credConfig.EdgeDNSGroup, credConfig.EdgeDNSGroupName = 0, ""
+	credConfig.EdgeDNSAccountSpecs = nil
+	edge, err := InitEdgeDNSHandler(ctx, &credConfig, akaService)
+	if err != nil {
+		return err
+	}
+	groups, err := edge.client.GetGroups(ctx)
+	if err != nil {
+		return fmt.Errorf("Unable to list Edge DNS groups. %s", err.Error())
+	}
+
+	rows := []groupListRow{}
+	for _, group := range groups {
+		contracts, err := edge.client.GetContracts(ctx, group.GroupID)
+		if err != nil {
+			return fmt.Errorf("Unable to list Edge DNS contracts of group %d. %s", group.GroupID, err.Error())
+		}
+		byID := map[string]*registrar.EdgeDNSContract{}
+		for _, contract := range contracts {
+			byID[contract.ContractID] = contract
+		}
+		if len(group.ContractIDs) < 1 {
+			rows = append(rows, groupListRow{contractID: "-", contractName: "-", groupID: group.GroupID, groupName: group.GroupName})
+			continue
+		}
+		for _, id := range group.ContractIDs {
+			row := groupListRow{contractID: id, contractName: "-", groupID: group.GroupID, groupName: group.GroupName, createZones: group.CanCreateZones()}
+			if contract, ok := byID[id]; ok {
+				row.contractName = contract.ContractName
+				row.createZones = row.createZones && contract.CanCreateZones()
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].contractID != rows[j].contractID {
+			return rows[i].contractID < rows[j].contractID
+		}
+		return rows[i].groupID < rows[j].groupID
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTRACT ID\tCONTRACT NAME\tGROUP ID\tGROUP NAME\tCREATE ZONES")
+	for _, row := range rows {
+		create := "no"
+		if row.createZones {
+			create = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", row.contractID, row.contractName, strconv.Itoa(row.groupID), row.groupName, create)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestListGroups(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestListGroups"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	// configured names are not resolved
+	config.EdgeDNSGroupName = "No Such Group"
+	stubEdgeDNS.FuncOutput["GetGroups"] = []*registrar.EdgeDNSGroup{
+		{GroupID: 22, GroupName: "Partner", ContractIDs: []string{"1-DEF"}, Permissions: []string{"READ"}},
+		{GroupID: 11, GroupName: "Primary", ContractIDs: []string{"1-ABC", "1-DEF"}, Permissions: []string{"READ", "WRITE", "ADD", "DELETE"}},
+	}
+	stubEdgeDNS.FuncOutput["GetContracts"] = []*registrar.EdgeDNSContract{
+		{ContractID: "1-ABC", ContractName: "Example", Permissions: []string{"READ", "ADD"}},
+		{ContractID: "1-DEF", ContractName: "Partner Contract", Permissions: []string{"READ", "ADD"}},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, ListGroups(ctx, &config, stubEdgeDNS, &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, []string{"CONTRACT", "ID", "CONTRACT", "NAME", "GROUP", "ID", "GROUP", "NAME", "CREATE", "ZONES"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"1-ABC", "Example", "11", "Primary", "yes"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"1-DEF", "Partner", "Contract", "11", "Primary", "yes"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"1-DEF", "Partner", "Contract", "22", "Partner", "no"}, strings.Fields(lines[3]))
+
+	delete(stubEdgeDNS.FuncOutput, "GetGroups")
+	stubEdgeDNS.FuncErrors["GetGroups"] = "forbidden"
+	assert.NotNil(t, ListGroups(ctx, &config, stubEdgeDNS, &buf))
+}
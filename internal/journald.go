@@ -0,0 +1,146 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultJournaldSocket = "/run/systemd/journal/socket"
+)
+
+// JournaldHandler is an apex/log handler writing entries to the systemd journal using the native protocol.
+// Entry fields are sent as journal fields, e.g. the zone field as ZONE.
+type JournaldHandler struct {
+	mu         sync.Mutex
+	socket     string
+	identifier string
+	conn       net.Conn
+}
+
+// NewJournaldHandler connects to the journal socket
+func NewJournaldHandler(socket, identifier string) (*JournaldHandler, error) {
+
+	if socket == "" {
+		socket = DefaultJournaldSocket
+	}
+	if identifier == "" {
+		identifier = DefaultSyslogAppName
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to journald socket %s. %s", socket, err.Error())
+	}
+
+	return &JournaldHandler{
+		socket:     socket,
+		identifier: identifier,
+		conn:       conn,
+	}, nil
+}
+
+// HandleLog writes the entry. On failure, the connection is reestablished and the write retried once.
+func (h *JournaldHandler) HandleLog(e *log.Entry) error {
+
+	msg := h.format(e)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		if _, err := h.conn.Write(msg); err == nil {
+			return nil
+		}
+		h.conn.Close()
+		h.conn = nil
+	}
+	conn, err := net.Dial("unixgram", h.socket)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	_, err = h.conn.Write(msg)
+
+	return err
+}
+
+// Close closes the journal connection
+func (h *JournaldHandler) Close() error {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+
+	return err
+}
+
+func (h *JournaldHandler) format(e *log.Entry) []byte {
+
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", e.Message)
+	writeJournalField(&buf, "PRIORITY", fmt.Sprint(SyslogSeverity(e.Level)))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", h.identifier)
+	for _, name := range e.Fields.Names() {
+		writeJournalField(&buf, journalFieldName(name), fmt.Sprint(e.Fields.Get(name)))
+	}
+
+	return buf.Bytes()
+}
+
+// writeJournalField appends a field. Values with newlines use the length prefixed binary format.
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// journalFieldName converts an entry field name to a valid journal field name: upper case letters, digits and
+// underscores, not starting with an underscore or digit
+func journalFieldName(name string) string {
+
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	field := strings.TrimLeft(string(b), "_")
+	if field == "" || (field[0] >= '0' && field[0] <= '9') {
+		field = "F_" + field
+	}
+	switch field {
+	case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		field = "F_" + field
+	}
+
+	return field
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// parseJournalEntry decodes a native journal protocol datagram
+func parseJournalEntry(t *testing.T, data string) map[string]string {
+
+	fields := map[string]string{}
+	buf := bytes.NewBufferString(data)
+	for buf.Len() > 0 {
+		line, err := buf.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if idx := strings.Index(line, "="); idx >= 0 {
+			fields[line[:idx]] = line[idx+1:]
+			continue
+		}
+		var length uint64
+		assert.Nil(t, binary.Read(buf, binary.LittleEndian, &length))
+		value := buf.Next(int(length))
+		buf.Next(1) // newline
+		fields[line] = string(value)
+	}
+
+	return fields
+}
+
+func TestJournaldHandler(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	pc, err := net.ListenPacket("unixgram", path)
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	handler, err := NewJournaldHandler(path, "coordinator-test")
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	testLogger(handler).WithFields(log.Fields{
+		"zone":       "example.com",
+		"subcommand": "monitor",
+		"masters":    "1.2.3.4\n5.6.7.8",
+		"_private":   "x",
+		"message":    "field",
+	}).Error("zone create failed")
+
+	fields := parseJournalEntry(t, readDatagram(t, pc))
+	assert.Equal(t, "zone create failed", fields["MESSAGE"])
+	assert.Equal(t, "3", fields["PRIORITY"])
+	assert.Equal(t, "coordinator-test", fields["SYSLOG_IDENTIFIER"])
+	assert.Equal(t, "example.com", fields["ZONE"])
+	assert.Equal(t, "monitor", fields["SUBCOMMAND"])
+	assert.Equal(t, "1.2.3.4\n5.6.7.8", fields["MASTERS"])
+	assert.Equal(t, "x", fields["PRIVATE"])
+	assert.Equal(t, "field", fields["F_MESSAGE"])
+
+	_, err = NewJournaldHandler(filepath.Join(t.TempDir(), "missing.sock"), "")
+	assert.NotNil(t, err)
+}
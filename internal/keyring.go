@@ -0,0 +1,295 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	miekg "github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// TsigKeyringRule maps the zones matching a pattern to a keyring key
+type TsigKeyringRule struct {
+	// Zone name, wildcard, e.g. *.example.com, or * for all zones
+	Pattern string `yaml:"zone"`
+	Key     string `yaml:"key"`
+}
+
+// TsigKeyring holds TSIG keys read from local key files. Used for zones the registrar returns no key for.
+type TsigKeyring struct {
+	// Keys by lower case name without trailing dot
+	keys  map[string]*dns.TSIGKey
+	rules []TsigKeyringRule
+}
+
+// tsigKeyringFile is the YAML keyring format
+type tsigKeyringFile struct {
+	Keys []struct {
+		Name      string `yaml:"name"`
+		Algorithm string `yaml:"algorithm"`
+		Secret    string `yaml:"secret"`
+	} `yaml:"keys"`
+	Zones []TsigKeyringRule `yaml:"zones"`
+}
+
+// ParseTsigKeyringRule parses a zone to key mapping rule, PATTERN=KEY, e.g. *.example.com=example-key
+func ParseTsigKeyringRule(spec string) (TsigKeyringRule, error) {
+
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return TsigKeyringRule{}, fmt.Errorf("Invalid TSIG keyring zone rule %q. Expected PATTERN=KEY, e.g. *.example.com=example-key", spec)
+	}
+
+	return TsigKeyringRule{Pattern: strings.TrimSpace(parts[0]), Key: strings.TrimSpace(parts[1])}, nil
+}
+
+// LoadTsigKeyring reads keys from named.conf style key files and YAML keyrings, ending in .yaml or .yml. Zones are
+// mapped to keys by the first matching rule, command line rules before keyring file rules, and otherwise to the key
+// named after the zone. Keys are validated and their secrets registered for redaction.
+func LoadTsigKeyring(paths []string, specs []string) (*TsigKeyring, error) {
+
+	k := &TsigKeyring{keys: map[string]*dns.TSIGKey{}, rules: []TsigKeyringRule{}}
+	for _, spec := range specs {
+		rule, err := ParseTsigKeyringRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		k.rules = append(k.rules, rule)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read TSIG keyring %s. %s", path, err.Error())
+		}
+		var keys []*dns.TSIGKey
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			var rules []TsigKeyringRule
+			keys, rules, err = parseYAMLKeyring(data)
+			k.rules = append(k.rules, rules...)
+		default:
+			keys, err = parseNamedConfKeys(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TSIG keyring %s. %s", path, err.Error())
+		}
+		for _, key := range keys {
+			if err := validateTsigKey(key); err != nil {
+				return nil, fmt.Errorf("Invalid TSIG keyring %s. %s", path, err.Error())
+			}
+			name := keyringName(key.Name)
+			if prior, ok := k.keys[name]; ok && (prior.Secret != key.Secret || tsigAlgorithm(prior.Algorithm) != tsigAlgorithm(key.Algorithm)) {
+				return nil, fmt.Errorf("TSIG key %s is defined more than once with different values", key.Name)
+			}
+			registrar.RegisterSecret(key.Secret)
+			k.keys[name] = key
+		}
+	}
+	for _, rule := range k.rules {
+		if _, ok := k.keys[keyringName(rule.Key)]; !ok {
+			return nil, fmt.Errorf("TSIG keyring zone rule %s refers to unknown key %s", rule.Pattern, rule.Key)
+		}
+	}
+
+	return k, nil
+}
+
+// ZoneKey returns a copy of the key of zone, or nil if the keyring has no key for the zone
+func (k *TsigKeyring) ZoneKey(zone string) *dns.TSIGKey {
+
+	name := keyringName(zone)
+	for _, rule := range k.rules {
+		if zonePatternMatch(rule.Pattern, zone) {
+			name = keyringName(rule.Key)
+			break
+		}
+	}
+	key, ok := k.keys[name]
+	if !ok {
+		return nil
+	}
+	c := *key
+
+	return &c
+}
+
+// Len returns the number of keys
+func (k *TsigKeyring) Len() int {
+
+	return len(k.keys)
+}
+
+func keyringName(name string) string {
+
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// validateTsigKey checks the key has a name, a supported algorithm and a base64 secret. The secret is not included in
+// errors.
+func validateTsigKey(key *dns.TSIGKey) error {
+
+	if key.Name == "" {
+		return fmt.Errorf("TSIG key name is required")
+	}
+	switch tsigAlgorithm(key.Algorithm) {
+	case miekg.HmacSHA1, miekg.HmacSHA224, miekg.HmacSHA256, miekg.HmacSHA384, miekg.HmacSHA512:
+	default:
+		return fmt.Errorf("TSIG key %s has unsupported algorithm %q", key.Name, key.Algorithm)
+	}
+	if key.Secret == "" {
+		return fmt.Errorf("TSIG key %s has no secret", key.Name)
+	}
+	if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil {
+		return fmt.Errorf("TSIG key %s secret is not valid base64", key.Name)
+	}
+
+	return nil
+}
+
+func parseYAMLKeyring(data []byte) ([]*dns.TSIGKey, []TsigKeyringRule, error) {
+
+	file := tsigKeyringFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, nil, err
+	}
+	keys := []*dns.TSIGKey{}
+	for _, key := range file.Keys {
+		keys = append(keys, &dns.TSIGKey{Name: key.Name, Algorithm: key.Algorithm, Secret: key.Secret})
+	}
+	for _, rule := range file.Zones {
+		if rule.Pattern == "" || rule.Key == "" {
+			return nil, nil, fmt.Errorf("Zone rules require zone and key")
+		}
+	}
+
+	return keys, file.Zones, nil
+}
+
+// parseNamedConfKeys returns the key statements of a named.conf style file. Other statements are skipped.
+//
+//	key "example-key" {
+//		algorithm hmac-sha256;
+//		secret "c2VjcmV0";
+//	};
+func parseNamedConfKeys(data []byte) ([]*dns.TSIGKey, error) {
+
+	tokens, err := namedConfTokens(string(data))
+	if err != nil {
+		return nil, err
+	}
+	keys := []*dns.TSIGKey{}
+	for i := 0; i < len(tokens); {
+		if tokens[i] != "key" {
+			// skip to the end of the statement
+			if i, err = skipNamedConfStatement(tokens, i); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if i+2 >= len(tokens) || tokens[i+2] != "{" {
+			return nil, fmt.Errorf("Expected key name and {")
+		}
+		key := &dns.TSIGKey{Name: tokens[i+1]}
+		i += 3
+		for i < len(tokens) && tokens[i] != "}" {
+			if i+2 >= len(tokens) || tokens[i+2] != ";" {
+				return nil, fmt.Errorf("Key %s: expected clause value and ;", key.Name)
+			}
+			switch tokens[i] {
+			case "algorithm":
+				key.Algorithm = tokens[i+1]
+			case "secret":
+				key.Secret = tokens[i+1]
+			default:
+				return nil, fmt.Errorf("Key %s: unknown clause %s", key.Name, tokens[i])
+			}
+			i += 3
+		}
+		if i+1 >= len(tokens) || tokens[i+1] != ";" {
+			return nil, fmt.Errorf("Key %s: expected };", key.Name)
+		}
+		i += 2
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// skipNamedConfStatement returns the index of the token following the statement starting at i
+func skipNamedConfStatement(tokens []string, i int) (int, error) {
+
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		case ";":
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+
+	return i, fmt.Errorf("Unterminated statement")
+}
+
+// namedConfTokens splits named.conf text into words, quoted strings, braces and semicolons. Comments are removed.
+func namedConfTokens(text string) ([]string, error) {
+
+	tokens := []string{}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated comment")
+			}
+			i += end + 4
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated string")
+			}
+			tokens = append(tokens, text[i+1:i+1+end])
+			i += end + 2
+		default:
+			start := i
+			for i < len(text) && !strings.ContainsRune(" \t\r\n{};\"#", rune(text[i])) && !strings.HasPrefix(text[i:], "//") && !strings.HasPrefix(text[i:], "/*") {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+
+	return tokens, nil
+}
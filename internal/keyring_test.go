@@ -0,0 +1,144 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testNamedConf = `// keys from the primary operators
+options {
+	directory "/var/named";
+	allow-transfer { key "example.com."; };
+};
+key "example.com." {
+	algorithm hmac-sha256; # zone key
+	secret "c2VjcmV0LWV4YW1wbGU=";
+};
+/* shared key */
+key shared-key {
+	algorithm HMAC-SHA512;
+	secret "c2VjcmV0LXNoYXJlZA==";
+};
+`
+
+const testYAMLKeyring = `keys:
+  - name: yaml-key
+    algorithm: hmac-sha1
+    secret: c2VjcmV0LXlhbWw=
+zones:
+  - zone: "*.example.net"
+    key: yaml-key
+  - zone: "*"
+    key: shared-key
+`
+
+func writeKeyringFile(t *testing.T, name, content string) string {
+
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestParseNamedConfKeys(t *testing.T) {
+
+	keys, err := parseNamedConfKeys([]byte(testNamedConf))
+	assert.Nil(t, err)
+	assert.Equal(t, []*dns.TSIGKey{
+		{Name: "example.com.", Algorithm: "hmac-sha256", Secret: "c2VjcmV0LWV4YW1wbGU="},
+		{Name: "shared-key", Algorithm: "HMAC-SHA512", Secret: "c2VjcmV0LXNoYXJlZA=="},
+	}, keys)
+
+	_, err = parseNamedConfKeys([]byte(`key "k" { algorithm hmac-sha256; secret "abc"`))
+	assert.NotNil(t, err)
+	_, err = parseNamedConfKeys([]byte(`key "k" { algorithm hmac-sha256; secret "abc"; tag 1; };`))
+	assert.NotNil(t, err)
+	_, err = parseNamedConfKeys([]byte(`/* unterminated`))
+	assert.NotNil(t, err)
+}
+
+func TestLoadTsigKeyring(t *testing.T) {
+
+	named := writeKeyringFile(t, "keys.conf", testNamedConf)
+	yml := writeKeyringFile(t, "keys.yaml", testYAMLKeyring)
+	keyring, err := LoadTsigKeyring([]string{named, yml}, []string{"special.example.net=shared-key"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, keyring.Len())
+
+	// command line rules come first, then keyring rules, then the key named after the zone
+	assert.Equal(t, "shared-key", keyring.ZoneKey("special.example.net").Name)
+	assert.Equal(t, "yaml-key", keyring.ZoneKey("other.example.net").Name)
+	assert.Equal(t, "shared-key", keyring.ZoneKey("example.com").Name)
+
+	keyring, err = LoadTsigKeyring([]string{named}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com.", keyring.ZoneKey("Example.com").Name)
+	assert.Nil(t, keyring.ZoneKey("other.zone"))
+	// callers may not change keyring keys
+	keyring.ZoneKey("example.com").Secret = ""
+	assert.Equal(t, "c2VjcmV0LWV4YW1wbGU=", keyring.ZoneKey("example.com").Secret)
+}
+
+func TestLoadTsigKeyringInvalid(t *testing.T) {
+
+	for name, content := range map[string]string{
+		"algorithm.conf": `key "k" { algorithm hmac-md5; secret "c2VjcmV0"; };`,
+		"base64.conf":    `key "k" { algorithm hmac-sha256; secret "not base64!"; };`,
+		"nosecret.conf":  `key "k" { algorithm hmac-sha256; };`,
+		"unknown.yaml":   "zones:\n  - zone: example.com\n    key: missing\n",
+		"strict.yml":     "keys:\n  - name: k\n    algo: hmac-sha256\n",
+	} {
+		_, err := LoadTsigKeyring([]string{writeKeyringFile(t, name, content)}, nil)
+		assert.NotNil(t, err, name)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "not base64!", name)
+		}
+	}
+	named := writeKeyringFile(t, "keys.conf", testNamedConf)
+	conflict := writeKeyringFile(t, "conflict.conf", `key "shared-key" { algorithm hmac-sha512; secret "b3RoZXI="; };`)
+	_, err := LoadTsigKeyring([]string{named, conflict}, nil)
+	assert.NotNil(t, err)
+	_, err = LoadTsigKeyring([]string{named}, []string{"example.com"})
+	assert.NotNil(t, err)
+}
+
+func TestTsigKeyringFallback(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestTsigKeyringFallback"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.TSig = true
+	config.TSigKeyrings = []string{writeKeyringFile(t, "keys.conf", testNamedConf)}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+
+	// the registrar returns no key
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"example.com", "other.zone"}, false))
+	created := stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)
+	assert.Equal(t, "example.com.", created["example.com"].TsigKey.Name)
+	assert.Equal(t, "", created["other.zone"].TsigKey.Name)
+
+	// registrar keys take precedence
+	stubRegistrar.FuncOutput["GetTsigKey"] = &dns.TSIGKey{Name: "registrar-key", Algorithm: "hmac-sha256", Secret: "cmVnaXN0cmFy"}
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"example.com"}, false))
+	assert.Equal(t, "registrar-key", created["example.com"].TsigKey.Name)
+}
@@ -0,0 +1,308 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLogFileMode = os.FileMode(0600)
+	// rotated file suffix. Sorts in rotation order
+	logFileBackupTimeFormat = "20060102T150405.000"
+	logFileCompressSuffix   = ".gz"
+)
+
+// LogFileOptions controls log file rotation and retention. Zero values disable the option.
+type LogFileOptions struct {
+	MaxSize    int64         // rotate once the file would exceed MaxSize bytes
+	MaxAge     time.Duration // rotate once the file is older than MaxAge
+	MaxBackups int           // number of rotated files kept
+	Retention  time.Duration // rotated files older than Retention are removed
+	Compress   bool          // gzip rotated files
+	Mode       os.FileMode   // mode of the log file and rotated files
+}
+
+// logFileErrors receives errors of background compression and pruning, which cannot be logged to the log file itself
+var logFileErrors io.Writer = os.Stderr
+
+// LogFile is a log file writer with size and age based rotation. Reopen supports external rotation.
+type LogFile struct {
+	mu        sync.Mutex
+	path      string
+	opts      LogFileOptions
+	file      *os.File
+	size      int64
+	startedAt time.Time
+	// compression and pruning of rotated files run in the background, one at a time
+	cleanupMu sync.Mutex
+	cleanup   sync.WaitGroup
+}
+
+// OpenLogFile opens or creates the log file at path
+func OpenLogFile(path string, opts LogFileOptions) (*LogFile, error) {
+
+	if opts.Mode == 0 {
+		opts.Mode = DefaultLogFileMode
+	}
+	l := &LogFile{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the log file for append. The mode of an existing file is restricted to the configured mode.
+func (l *LogFile) open() error {
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, l.opts.Mode)
+	if err != nil {
+		return fmt.Errorf("Unable to open log file %s. %s", l.path, err.Error())
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Unable to open log file %s. %s", l.path, err.Error())
+	}
+	if info.Mode().Perm()&^l.opts.Mode.Perm() != 0 {
+		if err := f.Chmod(info.Mode().Perm() & l.opts.Mode.Perm()); err != nil {
+			f.Close()
+			return fmt.Errorf("Unable to restrict log file %s mode. %s", l.path, err.Error())
+		}
+	}
+	l.file = f
+	l.size = info.Size()
+	l.startedAt = l.started()
+
+	return nil
+}
+
+// started returns when the current log file was started, so that reopening or restarting keeps its age.
+// That is the rotation time of the newest rotated file. An empty file starts now and a file without rotated files
+// has an unknown age and is rotated on the next write.
+func (l *LogFile) started() time.Time {
+
+	now := time.Now()
+	if l.size == 0 {
+		return now
+	}
+	backups, err := l.backups()
+	if err != nil || len(backups) < 1 {
+		return time.Time{}
+	}
+	rotated, err := l.backupTime(backups[0])
+	if err != nil || rotated.After(now) {
+		return now
+	}
+
+	return rotated
+}
+
+// Write writes p to the log file, rotating first if p would exceed the size limit or the file is older than the age limit
+func (l *LogFile) Write(p []byte) (int, error) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	if l.size > 0 && ((l.opts.MaxSize > 0 && l.size+int64(len(p)) > l.opts.MaxSize) || (l.opts.MaxAge > 0 && time.Since(l.startedAt) >= l.opts.MaxAge)) {
+		if err := l.rotate(); err != nil {
+			if l.file == nil {
+				return 0, err
+			}
+			// keep the entry in the current file
+			reportLogFileError(err)
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+
+	return n, err
+}
+
+// Reopen closes and reopens the log file without rotating it, e.g. after an external logrotate moved it
+func (l *LogFile) Reopen() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	return l.open()
+}
+
+// Rotate rotates the log file
+func (l *LogFile) Rotate() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rotate()
+}
+
+// Close closes the log file and waits for the compression and pruning of rotated files
+func (l *LogFile) Close() error {
+
+	l.mu.Lock()
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+		l.file = nil
+	}
+	l.mu.Unlock()
+	l.cleanup.Wait()
+
+	return err
+}
+
+func (l *LogFile) rotate() error {
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	backup := l.path + "." + time.Now().Format(logFileBackupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil && !os.IsNotExist(err) {
+		// keep logging to the current file and retry once it is due again
+		if l.open() == nil {
+			l.startedAt = time.Now()
+		}
+		return fmt.Errorf("Unable to rotate log file %s. %s", l.path, err.Error())
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.cleanup.Add(1)
+	go l.cleanupBackups(backup)
+
+	return nil
+}
+
+// cleanupBackups compresses a rotated file and prunes rotated files. Errors are reported to logFileErrors.
+func (l *LogFile) cleanupBackups(backup string) {
+
+	defer l.cleanup.Done()
+	l.cleanupMu.Lock()
+	defer l.cleanupMu.Unlock()
+
+	if l.opts.Compress {
+		if err := compressLogFile(backup, l.opts.Mode); err != nil && !os.IsNotExist(err) {
+			reportLogFileError(fmt.Errorf("Unable to compress rotated log file %s. %s", backup, err.Error()))
+		}
+	}
+	if err := l.prune(); err != nil {
+		reportLogFileError(fmt.Errorf("Unable to prune rotated log files of %s. %s", l.path, err.Error()))
+	}
+}
+
+func reportLogFileError(err error) {
+
+	fmt.Fprintf(logFileErrors, "%s %s\n", time.Now().Format(time.RFC3339), err.Error())
+}
+
+// compressLogFile replaces path with a gzip compressed copy
+func compressLogFile(path string, mode os.FileMode) error {
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+logFileCompressSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// backups returns the rotated files of the log file, newest first
+func (l *LogFile) backups() ([]string, error) {
+
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := []string{}
+	for _, m := range matches {
+		if _, err := l.backupTime(m); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups, nil
+}
+
+// backupTime returns the rotation time of a rotated file
+func (l *LogFile) backupTime(backup string) (time.Time, error) {
+
+	suffix := strings.TrimSuffix(strings.TrimPrefix(backup, l.path+"."), logFileCompressSuffix)
+
+	return time.ParseInLocation(logFileBackupTimeFormat, suffix, time.Local)
+}
+
+// prune removes rotated files beyond the backup count or retention
+func (l *LogFile) prune() error {
+
+	if l.opts.MaxBackups <= 0 && l.opts.Retention <= 0 {
+		return nil
+	}
+	backups, err := l.backups()
+	if err != nil {
+		return err
+	}
+	for i, b := range backups {
+		rotated, _ := l.backupTime(b)
+		if (l.opts.MaxBackups > 0 && i >= l.opts.MaxBackups) || (l.opts.Retention > 0 && time.Since(rotated) > l.opts.Retention) {
+			if err := os.Remove(b); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLogFileSizeRotation(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "coordinator.log")
+	l, err := OpenLogFile(path, LogFileOptions{MaxSize: 20, MaxBackups: 2, Compress: true})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	for _, line := range []string{"first line 0123456\n", "second line 012345\n", "third line 0123456\n", "fourth line 012345\n"} {
+		_, err := l.Write([]byte(line))
+		assert.Nil(t, err)
+		// distinct rotation timestamps
+		time.Sleep(2 * time.Millisecond)
+	}
+	l.cleanup.Wait()
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "fourth line 012345\n", string(data))
+
+	backups, err := l.backups()
+	assert.Nil(t, err)
+	// first rotated file removed by retention
+	if assert.Equal(t, 2, len(backups)) {
+		assert.True(t, strings.HasSuffix(backups[0], logFileCompressSuffix))
+		f, err := os.Open(backups[0])
+		assert.Nil(t, err)
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		assert.Nil(t, err)
+		data, err = ioutil.ReadAll(zr)
+		assert.Nil(t, err)
+		assert.Equal(t, "third line 0123456\n", string(data))
+		info, err := os.Stat(backups[0])
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultLogFileMode, info.Mode().Perm())
+	}
+}
+
+func TestLogFileAgeRotation(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "coordinator.log")
+	l, err := OpenLogFile(path, LogFileOptions{MaxAge: time.Hour})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	l.Write([]byte("old\n"))
+	l.startedAt = time.Now().Add(-2 * time.Hour)
+	l.Write([]byte("new\n"))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "new\n", string(data))
+	backups, err := l.backups()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(backups))
+}
+
+func TestLogFileAgeAfterReopen(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "coordinator.log")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("existing\n"), 0600))
+	backup := path + "." + time.Now().Add(-30*time.Minute).Format(logFileBackupTimeFormat)
+	assert.Nil(t, ioutil.WriteFile(backup, []byte("rotated\n"), 0600))
+
+	l, err := OpenLogFile(path, LogFileOptions{MaxAge: time.Hour})
+	assert.Nil(t, err)
+	defer l.Close()
+	// age continues from the last rotation
+	assert.WithinDuration(t, time.Now().Add(-30*time.Minute), l.startedAt, time.Second)
+	assert.Nil(t, l.Reopen())
+	assert.WithinDuration(t, time.Now().Add(-30*time.Minute), l.startedAt, time.Second)
+	l.Write([]byte("appended\n"))
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "existing\nappended\n", string(data))
+
+	// older than MaxAge after a restart
+	l.Close()
+	assert.Nil(t, os.Rename(backup, path+"."+time.Now().Add(-2*time.Hour).Format(logFileBackupTimeFormat)))
+	l, err = OpenLogFile(path, LogFileOptions{MaxAge: time.Hour})
+	assert.Nil(t, err)
+	defer l.Close()
+	l.Write([]byte("new\n"))
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "new\n", string(data))
+}
+
+func TestLogFileCompressionError(t *testing.T) {
+
+	var errs strings.Builder
+	logFileErrors = &errs
+	defer func() { logFileErrors = os.Stderr }()
+
+	path := filepath.Join(t.TempDir(), "coordinator.log")
+	l, err := OpenLogFile(path, LogFileOptions{Compress: true})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	backup := path + "." + time.Now().Format(logFileBackupTimeFormat)
+	assert.Nil(t, ioutil.WriteFile(backup, []byte("rotated\n"), 0600))
+	// compressed file can't be created
+	assert.Nil(t, os.Mkdir(backup+logFileCompressSuffix, 0700))
+	l.cleanup.Add(1)
+	l.cleanupBackups(backup)
+
+	assert.Contains(t, errs.String(), "Unable to compress rotated log file "+backup)
+	data, err := ioutil.ReadFile(backup)
+	assert.Nil(t, err)
+	assert.Equal(t, "rotated\n", string(data))
+
+	// writes are not affected
+	n, err := l.Write([]byte("entry\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+}
+
+func TestLogFileRetention(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "coordinator.log")
+	expired := path + "." + time.Now().Add(-48*time.Hour).Format(logFileBackupTimeFormat)
+	assert.Nil(t, ioutil.WriteFile(expired, []byte("expired\n"), 0600))
+	unrelated := path + ".orig"
+	assert.Nil(t, ioutil.WriteFile(unrelated, []byte("keep\n"), 0600))
+
+	l, err := OpenLogFile(path, LogFileOptions{Retention: 24 * time.Hour})
+	assert.Nil(t, err)
+	defer l.Close()
+	l.Write([]byte("current\n"))
+	assert.Nil(t, l.Rotate())
+	l.cleanup.Wait()
+
+	_, err = os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(unrelated)
+	assert.Nil(t, err)
+	backups, err := l.backups()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(backups))
+}
+
+func TestLogFileModeAndReopen(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "coordinator.log")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("existing\n"), 0666))
+	assert.Nil(t, os.Chmod(path, 0666))
+
+	l, err := OpenLogFile(path, LogFileOptions{Mode: 0640})
+	assert.Nil(t, err)
+	defer l.Close()
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	// external rotation
+	assert.Nil(t, os.Rename(path, path+".1"))
+	l.Write([]byte("to moved file\n"))
+	assert.Nil(t, l.Reopen())
+	l.Write([]byte("to new file\n"))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "to new file\n", string(data))
+	data, err = ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, "existing\nto moved file\n", string(data))
+}
+
+func TestLogFileOpenError(t *testing.T) {
+
+	_, err := OpenLogFile(filepath.Join(t.TempDir(), "missing", "coordinator.log"), LogFileOptions{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/cli"
+	"github.com/apex/log/handlers/discard"
+	"github.com/apex/log/handlers/json"
+	"github.com/apex/log/handlers/level"
+	"github.com/apex/log/handlers/text"
+
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	// LogHandlers are the valid log handler names
+	LogHandlers = []string{"text", "json", "cli", "discard", "syslog", "journald"}
+)
+
+// LogSink is a log destination with its own handler, level and optional file
+type LogSink struct {
+	Handler string
+	Level   string
+	File    string
+}
+
+// ParseLogSink parses a sink spec of comma separated key=value pairs, e.g. handler=json,level=debug,file=/var/log/coordinator.json
+func ParseLogSink(spec string) (LogSink, error) {
+
+	sink := LogSink{Handler: DefaultConfig.LogHandler, Level: DefaultConfig.LogLevel}
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return sink, fmt.Errorf("Invalid log sink %q. Expected key=value", kv)
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "handler":
+			sink.Handler = value
+		case "level":
+			sink.Level = value
+		case "file":
+			sink.File = value
+		default:
+			return sink, fmt.Errorf("Invalid log sink key %q. Valid keys: handler, level, file", parts[0])
+		}
+	}
+	if !validLogHandler(sink.Handler) {
+		return sink, fmt.Errorf("Invalid log sink handler %q. Valid handlers: %s", sink.Handler, strings.Join(LogHandlers, ", "))
+	}
+	if _, err := log.ParseLevel(sink.Level); err != nil {
+		return sink, fmt.Errorf("Invalid log sink level %q", sink.Level)
+	}
+	if sink.File != "" && (sink.Handler == "discard" || sink.Handler == "syslog" || sink.Handler == "journald") {
+		return sink, fmt.Errorf("log sink handler %s does not write to a file", sink.Handler)
+	}
+
+	return sink, nil
+}
+
+func validLogHandler(name string) bool {
+
+	for _, h := range LogHandlers {
+		if h == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LogOutput fans log entries out to the configured sinks. Each sink filters on its own level.
+type LogOutput struct {
+	Handler log.Handler // redacting handler of all sinks
+	Level   log.Level   // lowest sink level
+	files   []*LogFile
+	closers []io.Closer
+}
+
+// NewLogOutput creates the primary sink from --log-handler, --log-level and --log-file-path plus any --log-sink.
+// Failure to open a sink is an error; there is no fallback to stderr.
+func NewLogOutput(cfg *Config) (*LogOutput, error) {
+
+	sinks := []LogSink{{Handler: cfg.LogHandler, Level: cfg.LogLevel, File: cfg.LogFilePath}}
+	for _, spec := range cfg.LogSinks {
+		sink, err := ParseLogSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	out := &LogOutput{Level: log.FatalLevel}
+	handlers := make(fanoutHandler, 0, len(sinks))
+	for _, sink := range sinks {
+		lvl, err := log.ParseLevel(sink.Level)
+		if err != nil {
+			out.Close()
+			return nil, fmt.Errorf("Invalid log level %q", sink.Level)
+		}
+		h, err := out.newHandler(cfg, sink)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		if lvl < out.Level {
+			out.Level = lvl
+		}
+		handlers = append(handlers, level.New(h, lvl))
+	}
+	// All handlers are wrapped to redact credentials
+	out.Handler = registrar.NewRedactHandler(handlers)
+
+	return out, nil
+}
+
+func (o *LogOutput) newHandler(cfg *Config, sink LogSink) (log.Handler, error) {
+
+	var w io.Writer = os.Stderr
+	if sink.File != "" {
+		f, err := OpenLogFile(sink.File, cfg.LogFileOptions())
+		if err != nil {
+			return nil, err
+		}
+		o.files = append(o.files, f)
+		o.closers = append(o.closers, f)
+		w = f
+	}
+	switch sink.Handler {
+	case "cli":
+		return cli.New(w), nil
+
+	case "text":
+		return text.New(w), nil
+
+	case "json":
+		return json.New(w), nil
+
+	case "discard":
+		return discard.Default, nil
+
+	case "syslog":
+		h, err := NewSyslogHandler(cfg.LogSyslogNetwork, cfg.LogSyslogAddress, cfg.LogSyslogFacility, cfg.LogSyslogAppName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to initialize syslog log handler. %s", err.Error())
+		}
+		o.closers = append(o.closers, h)
+		return h, nil
+
+	case "journald":
+		h, err := NewJournaldHandler(cfg.LogJournaldSocket, cfg.LogSyslogAppName)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to initialize journald log handler. %s", err.Error())
+		}
+		o.closers = append(o.closers, h)
+		return h, nil
+	}
+
+	return nil, fmt.Errorf("Invalid log handler %q", sink.Handler)
+}
+
+// Reopen reopens all log files, e.g. after an external logrotate moved them
+func (o *LogOutput) Reopen() error {
+
+	var firstErr error
+	for _, f := range o.files {
+		if err := f.Reopen(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Close closes all log files and connections
+func (o *LogOutput) Close() error {
+
+	var firstErr error
+	for _, c := range o.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// fanoutHandler passes each entry to all handlers. A failing handler does not keep the entry from the others.
+type fanoutHandler []log.Handler
+
+func (f fanoutHandler) HandleLog(e *log.Entry) error {
+
+	var firstErr error
+	for _, h := range f {
+		if err := h.HandleLog(e); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseLogSink(t *testing.T) {
+
+	sink, err := ParseLogSink("handler=json, level=debug,file=/var/log/coordinator.json")
+	assert.Nil(t, err)
+	assert.Equal(t, LogSink{Handler: "json", Level: "debug", File: "/var/log/coordinator.json"}, sink)
+
+	sink, err = ParseLogSink("handler=cli")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultConfig.LogLevel, sink.Level)
+
+	for _, spec := range []string{"handler=xml", "level=verbose", "format=json", "handler", "handler=syslog,file=/tmp/x.log"} {
+		_, err := ParseLogSink(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestLogOutputSinkLevels(t *testing.T) {
+
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "coordinator.log")
+	jsonPath := filepath.Join(dir, "coordinator.json")
+	cfg := &Config{
+		LogHandler:  "text",
+		LogLevel:    "warning",
+		LogFilePath: textPath,
+		LogFileMode: "0600",
+		LogSinks:    []string{"handler=json,level=debug,file=" + jsonPath},
+	}
+	out, err := NewLogOutput(cfg)
+	assert.Nil(t, err)
+	defer out.Close()
+	assert.Equal(t, log.DebugLevel, out.Level)
+
+	registrar.RegisterSecret("sink-secret")
+	logger := &log.Logger{Handler: out.Handler, Level: out.Level}
+	logger.Debug("debug entry")
+	logger.WithField("token", "sink-secret").Warn("warn entry")
+
+	data, err := ioutil.ReadFile(textPath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "debug entry")
+	assert.Contains(t, string(data), "warn entry")
+	assert.NotContains(t, string(data), "sink-secret")
+	data, err = ioutil.ReadFile(jsonPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"message":"debug entry"`)
+	assert.Contains(t, string(data), `"message":"warn entry"`)
+	assert.NotContains(t, string(data), "sink-secret")
+}
+
+func TestLogOutputOpenError(t *testing.T) {
+
+	// no silent fallback to stderr
+	cfg := &Config{LogHandler: "text", LogLevel: "info", LogFilePath: filepath.Join(t.TempDir(), "missing", "coordinator.log")}
+	_, err := NewLogOutput(cfg)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MetricsPath is the path metrics are served on
+	MetricsPath = "/metrics"
+	// metric name prefix
+	metricsNamespace = "edgedns_coordinator_"
+)
+
+var (
+	// coordinator metrics
+	metrics = NewMetrics()
+)
+
+// Metrics is a set of gauges exposed in the Prometheus text format
+type Metrics struct {
+	lock   sync.Mutex
+	gauges map[string]*gaugeVec
+}
+
+type gaugeVec struct {
+	help    string
+	samples map[string]*gaugeSample // keyed by formatted label set
+}
+
+type gaugeSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// NewMetrics returns an empty metric set
+func NewMetrics() *Metrics {
+
+	return &Metrics{gauges: map[string]*gaugeVec{}}
+}
+
+// SetGauge sets the gauge name with the labels to value
+func (m *Metrics) SetGauge(name, help string, labels map[string]string, value float64) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	g, ok := m.gauges[name]
+	if !ok {
+		g = &gaugeVec{help: help, samples: map[string]*gaugeSample{}}
+		m.gauges[name] = g
+	}
+	g.samples[formatLabels(labels)] = &gaugeSample{labels: labels, value: value}
+}
+
+// ResetGauge removes all label sets of the gauge name whose labels include match
+func (m *Metrics) ResetGauge(name string, match map[string]string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	g, ok := m.gauges[name]
+	if !ok {
+		return
+	}
+	for key, sample := range g.samples {
+		matched := true
+		for k, v := range match {
+			if sample.labels[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			delete(g.samples, key)
+		}
+	}
+}
+
+// Gauge returns the value of the gauge name with the labels
+func (m *Metrics) Gauge(name string, labels map[string]string) (float64, bool) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	g, ok := m.gauges[name]
+	if !ok {
+		return 0, false
+	}
+	sample, ok := g.samples[formatLabels(labels)]
+	if !ok {
+		return 0, false
+	}
+
+	return sample.value, true
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var written int64
+	names := make([]string, 0, len(m.gauges))
+	for name := range m.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		g := m.gauges[name]
+		n, err := fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s gauge\n", metricsNamespace, name, g.help, metricsNamespace, name)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		keys := make([]string, 0, len(g.samples))
+		for key := range g.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			n, err := fmt.Fprintf(w, "%s%s%s %s\n", metricsNamespace, name, key, strconv.FormatFloat(g.samples[key].value, 'g', -1, 64))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// ServeHTTP serves the metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// StartMetricsServer serves the coordinator metrics on address until ctx is done
+func StartMetricsServer(ctx context.Context, address string) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Unable to listen on metrics address %s. %s", address, err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, metrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics server failed. Error: %s", err.Error())
+		}
+	}()
+	log.Infof("Serving metrics on %s%s", listener.Addr().String(), MetricsPath)
+
+	return nil
+}
+
+// formatLabels formats a label set, e.g. {account="1-ABC",state="LOCKED"}. Labels are sorted by name.
+func formatLabels(labels map[string]string) string {
+
+	if len(labels) < 1 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+escapeLabelValue(labels[name])+"\"")
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
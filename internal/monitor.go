@@ -19,14 +19,19 @@ import (
 	"github.com/apex/log"
 
 	"context"
+	"fmt"
 	"strconv"
 	"time"
-	//"fmt"
+)
+
+const (
+	// Comment of created zones without a comment override
+	DefaultZoneComment = "Created by EdgeDNS Registrar Coordinator"
 )
 
 var (
-	// track last registrar domain list
-	lastRegistrarTally = map[string]map[string]bool{}
+	// track last registrar domain list. Sorted
+	lastRegistrarTally = map[string][]string{}
 )
 
 func Monitor(ctx context.Context, err chan string, regname string, reg registrar.RegistrarProvider, edge *EdgeDNSHandler, interval time.Duration, dryrun bool, once bool) {
@@ -50,17 +55,16 @@ func monitorProc(ctx context.Context, regname string, reg registrar.RegistrarPro
 	var errmsg string
 
 	log := ctx.Value("appLog").(*log.Entry)
-
-	queryArgs := dns.ZoneListQueryArgs{
-		ContractIds: edge.Contract,
-		ShowAll:     true,
-		SortBy:      "zone",
-		Types:       "SECONDARY",
-	}
-	log.Debugf("Edge Contract: %s", edge.Contract)
+	// each interval is identified in logs and zone comments
+	edge.cycleID = newCycleID()
+	log = log.WithField("cycle", edge.cycleID)
+	ctx = context.WithValue(ctx, "appLog", log)
 
 	nextLoop := time.Now().Add(interval)
-	edgeZones, edgeErr := edge.client.GetZoneNames(ctx, queryArgs, []string{"LOCKED"})
+	// rotated credentials are picked up at the start of an interval. On failure, the current credentials are kept.
+	edge.refreshCredentials(ctx)
+	refreshProtectedZones(ctx, edge)
+	accountZones, edgeErr := edge.accountZoneNames(ctx)
 	registrarDomains, regErr := reg.GetDomains(ctx) // Up to registrar to decide how to filter
 
 	if edgeErr != nil {
@@ -76,24 +80,137 @@ func monitorProc(ctx context.Context, regname string, reg registrar.RegistrarPro
 			return &errmsg
 		}
 	} else {
-		log.Debugf("Monitor. Retrieved Edge DNS zones: %v", edgeZones)
+		log.Debugf("Monitor. Retrieved Edge DNS zones: %v", accountZones)
 		log.Debugf("Monitor. Retrieved Registrar zones: %v", registrarDomains)
 		// process
-		newZones, removedZones := diffZoneLists(ctx, regname, edgeZones, registrarDomains)
-		aerr := addSecondaryZones(ctx, edge, reg, newZones, dryrun)
-		if aerr != nil {
-			log.Errorf("Monitor. Failed to add secondary zones. Error: %s", aerr.Error())
-			if edge.FailOnError {
-				errmsg = "Monitor. Failed to add Secondary zones."
-				return &errmsg
+		newZones, removedZones := diffAccountZoneLists(ctx, regname, accountZones, registrarDomains)
+		// operations held back by maintenance windows and freezes are deferred
+		windows := changeWindows(ctx, edge, time.Now())
+		if windows[ChangeOperationCreate] {
+			aerr := addSecondaryZones(ctx, edge, reg, newZones, dryrun)
+			if aerr != nil {
+				log.Errorf("Monitor. Failed to add secondary zones. Error: %s", aerr.Error())
+				if edge.FailOnError {
+					errmsg = "Monitor. Failed to add Secondary zones."
+					return &errmsg
+				}
 			}
+			// new zones are found again each interval until created
+			if !dryrun {
+				if cerr := edge.deferred.remove(ChangeOperationCreate, "", nil); cerr != nil {
+					log.Errorf("Monitor. Failed to save deferred changes. Error: %s", cerr.Error())
+				}
+			}
+		} else {
+			deferZoneCreates(ctx, edge, newZones, dryrun)
 		}
-		derr := removeSecondaryZones(ctx, edge, removedZones, dryrun)
-		if derr != nil {
-			log.Errorf("Monitor. Failed to remove secondary zones. Error: %s", derr.Error())
-			if edge.FailOnError {
-				errmsg = "Monitor. Failed to remove secondary zones."
-				return &errmsg
+		for _, account := range edge.Accounts {
+			removed := pendingZoneDeletions(ctx, edge, account, removedZones[account.Name()], accountZones[account.Name()], registrarDomains)
+			if !windows[ChangeOperationDelete] {
+				deferZoneChanges(ctx, edge, ChangeOperationDelete, account.Name(), removed, dryrun)
+				continue
+			}
+			done, derr := removeZones(ctx, edge, account, removed, dryrun)
+			if !dryrun {
+				// zones not handled are retried next interval
+				if cerr := edge.deferred.remove(ChangeOperationDelete, account.Name(), done); cerr != nil {
+					log.Errorf("Monitor. Failed to save deferred changes. Error: %s", cerr.Error())
+				}
+				if cerr := edge.deferred.add(ChangeOperationDelete, account.Name(), sortedDifference(removed, done), time.Now()); cerr != nil {
+					log.Errorf("Monitor. Failed to save deferred changes. Error: %s", cerr.Error())
+				}
+			}
+			if derr != nil {
+				log.Errorf("Monitor. Failed to remove secondary zones. Account: %s. Error: %s", account.Name(), derr.Error())
+				if edge.FailOnError {
+					errmsg = "Monitor. Failed to remove secondary zones."
+					return &errmsg
+				}
+			}
+		}
+		reportDeferredChanges(edge)
+		if rerr := edge.recreations.retain(registrarDomains); rerr != nil {
+			log.Errorf("Monitor. Failed to save zone recreations. Error: %s", rerr.Error())
+		}
+		for _, account := range edge.Accounts {
+			rerr := remediateStuckZones(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains), dryrun)
+			if rerr != nil {
+				log.Errorf("Monitor. Failed to remediate stuck zones. Account: %s. Error: %s", account.Name(), rerr.Error())
+				if edge.FailOnError {
+					errmsg = "Monitor. Failed to remediate stuck zones."
+					return &errmsg
+				}
+			}
+		}
+		if edge.UpdateZoneComments {
+			if cerr := edge.zoneComments.retain(registrarDomains); cerr != nil {
+				log.Errorf("Monitor. Failed to save zone comment sources. Error: %s", cerr.Error())
+			}
+			if windows[ChangeOperationUpdate] {
+				for _, account := range edge.Accounts {
+					updateZoneComments(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains), dryrun)
+				}
+			}
+		}
+		if edge.ConfirmZones {
+			if cerr := edge.confirmations.retain(registrarDomains); cerr != nil {
+				log.Errorf("Monitor. Failed to save zone confirmations. Error: %s", cerr.Error())
+			}
+			// a single run waits for created zones to be confirmed or fail. Otherwise, zones are checked once per
+			// interval so that the later steps of the interval are not held up
+			var until time.Time
+			if once {
+				until = time.Now().Add(edge.ConfirmTimeout + edge.ConfirmPollInterval)
+			}
+			confirmZones(ctx, edge, until, dryrun)
+		}
+		if edge.PublishDS {
+			if perr := edge.dsPublications.retain(registrarDomains); perr != nil {
+				log.Errorf("Monitor. Failed to save DS publications. Error: %s", perr.Error())
+			}
+			if windows[ChangeOperationUpdate] {
+				for _, account := range edge.Accounts {
+					if perr := publishDSRecords(ctx, edge, account, reg, dryrun); perr != nil {
+						log.Errorf("Monitor. Failed to publish DS records. Account: %s. Error: %s", account.Name(), perr.Error())
+					}
+				}
+			}
+		}
+		if edge.TSigGenerate {
+			if terr := edge.tsigKeys.retain(registrarDomains); terr != nil {
+				log.Errorf("Monitor. Failed to save TSIG keys. Error: %s", terr.Error())
+			}
+			if windows[ChangeOperationUpdate] {
+				if terr := rotateTsigKeys(ctx, edge, dryrun); terr != nil {
+					log.Errorf("Monitor. Failed to rotate TSIG keys. Error: %s", terr.Error())
+				}
+			}
+		}
+		if edge.transferStatusDue(time.Now()) {
+			for _, account := range edge.Accounts {
+				monitorTransferHealth(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains))
+			}
+		}
+		if edge.UpdateDelegation {
+			if uerr := edge.delegationUpdates.retain(registrarDomains); uerr != nil {
+				log.Errorf("Monitor. Failed to save delegation updates. Error: %s", uerr.Error())
+			}
+			if windows[ChangeOperationUpdate] {
+				for _, account := range edge.Accounts {
+					if uerr := updateDelegations(ctx, edge, account, reg, dryrun); uerr != nil {
+						log.Errorf("Monitor. Failed to update delegations. Account: %s. Error: %s", account.Name(), uerr.Error())
+					}
+				}
+			}
+		}
+		if edge.delegationCheckDue(time.Now()) {
+			for _, account := range edge.Accounts {
+				monitorDelegations(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains))
+			}
+		}
+		if edge.Verify {
+			for _, account := range edge.Accounts {
+				verifyManagedZones(ctx, edge, account, reg, managedZones(accountZones[account.Name()], registrarDomains))
 			}
 		}
 	}
@@ -109,53 +226,47 @@ func monitorProc(ctx context.Context, regname string, reg registrar.RegistrarPro
 	return nil
 }
 
-func diffZoneLists(ctx context.Context, regname string, edgeZones, registrarDomains []string) (newZones []string, removedZones []string) {
+// diffAccountZoneLists diffs the registrar domains against the zones of each Edge DNS account. New zones are registrar
+// domains without a zone in any account. Zones of domains removed from the registrar since the last tally are returned
+// per account name.
+func diffAccountZoneLists(ctx context.Context, regname string, accountZones map[string][]string, registrarDomains []string) (newZones []string, removedZones map[string][]string) {
 
 	log := ctx.Value("appLog").(*log.Entry)
-	log.Debug("Monitor. Diffing Edge DNS and Registrar domain lists")
+	log.Debug("Monitor. Diffing Edge DNS account and Registrar domain lists")
 
-	var lastTally map[string]bool
-	lastTally, ok := lastRegistrarTally[regname]
-	if !ok {
-		lastTally = make(map[string]bool)
-	}
-	// Assme the register list is smaller than edgedns list ...
-	edgehash := make(map[string]bool)
-	reghash := make(map[string]bool)
-
-	for _, e := range edgeZones {
-		log.Debugf("Processing Edge zone: %s", e)
-		edgehash[e] = true
-	}
-	for _, e := range registrarDomains {
-		log.Debugf("Processing Registrar domain: %s", e)
-		reghash[e] = true
-		if _, ok := edgehash[e]; !ok {
-			// not there. new
-			log.Debugf("New zone to create: %s", e)
-			newZones = append(newZones, e)
+	regSorted := sortedZoneList(registrarDomains)
+	removedDomains := sortedDifference(lastRegistrarTally[regname], regSorted)
+	allZones := []string{}
+	removedZones = map[string][]string{}
+	for name, zones := range accountZones {
+		edgeSorted := sortedZoneList(zones)
+		allZones = append(allZones, edgeSorted...)
+		removedZones[name] = sortedIntersection(removedDomains, edgeSorted)
+		for _, z := range removedZones[name] {
+			log.Debugf("Zone to delete: %s. Account: %s", z, name)
 		}
 	}
-	for d, _ := range lastTally {
-		log.Debugf("Processing last tally: %s", d)
-		if _, ok := reghash[d]; !ok {
-			if _, ok := edgehash[d]; ok {
-				log.Debugf("Zone to delete: %s", d)
-				removedZones = append(removedZones, d)
-			}
-		}
+	newZones = sortedDifference(regSorted, sortedZoneList(allZones))
+	for _, z := range newZones {
+		log.Debugf("New zone to create: %s", z)
 	}
 	// Save current for next round
-	lastRegistrarTally[regname] = reghash
+	lastRegistrarTally[regname] = regSorted
 
 	return
 
 }
 
+// addSecondaryZones creates a secondary zone for each new zone. Masters, sign and serve algorithm and TSIG key are read
+// from the registrar, then replaced by matching zone overrides. Masters of either source are filtered by address family.
 func addSecondaryZones(ctx context.Context, edge *EdgeDNSHandler, reg registrar.RegistrarProvider, newZones []string, dryrun bool) error {
 
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debugf("Monitor. addSecondaryZones: %v", newZones)
+	if edge.ProbeMasters {
+		pruneDeferredZones(newZones)
+		defer reportDeferredZones(ctx)
+	}
 	if len(newZones) < 1 {
 		return nil
 	}
@@ -164,64 +275,147 @@ func addSecondaryZones(ctx context.Context, edge *EdgeDNSHandler, reg registrar.
 		log.Errorf("Unable to retrieve master Ips. Error: %s", err.Error())
 		return err
 	}
+	refreshZoneOverrides(ctx, edge)
 	// Create **Seconday** Zones one at a time ...
 	for _, zname := range newZones {
-		zonequerystring := dns.ZoneQueryString{Contract: edge.Contract, Group: strconv.Itoa(edge.Group)}
-		zone := &dns.ZoneCreate{Zone: zname, Type: "Secondary", Comment: "Created by EdgeDNS Registrar Coordinator"}
-		zone.Masters = masters
+		account := edge.createAccount(zname)
+		zonequerystring := dns.ZoneQueryString{Contract: account.Contract, Group: strconv.Itoa(account.Group)}
+		// registrar values, replaced by zone overrides
+		domain := &registrar.Domain{Name: zname, Type: "Secondary", Masters: masters}
 		if edge.DNSSEC {
 			if algo, err := reg.GetServeAlgorithm(ctx, zname); err == nil && algo != "" {
-				zone.SignAndServe = true
-				zone.SignAndServeAlgorithm = algo
+				domain.SignAndServe = true
+				domain.SignAndServeAlgorithm = algo
 			} else {
 				log.Warn("Unable to retrieve Sign algorithm")
 			}
 		}
+		var generatedKey *dns.TSIGKey
+		if edge.TSigGenerate {
+			generatedKey = generatedTsigKey(ctx, edge, zname, dryrun)
+			domain.TsigKey = generatedKey
+		}
 		if edge.TSig {
 			tsigKey, err := reg.GetTsigKey(ctx, zname)
+			if (tsigKey == nil || tsigKey.Name == "" || err != nil) && edge.tsigKeyring != nil {
+				if key := edge.tsigKeyring.ZoneKey(zname); key != nil {
+					log.Debugf("Zone %s using keyring TSIG key %s", zname, key.Name)
+					tsigKey, err = key, nil
+				}
+			}
 			if tsigKey != nil && err == nil {
-				zone.TsigKey = tsigKey // tsig key
+				registrar.RegisterSecret(tsigKey.Secret)
+				domain.TsigKey = tsigKey // tsig key
 			} else {
 				log.Warn("Unable to retrieve TSig Key")
 			}
 		}
+		overrides, commentOverride := applyZoneOverrides(ctx, edge, reg, domain)
+		// registrar and override masters are validated and filtered by address family once overrides are applied
+		if domain.Masters, err = filterMasters(domain.Masters, edge.MasterAddressFamily); err != nil {
+			log.Errorf("Add secondary zone %s skipped. Invalid master Ips. Overrides: %v. Error: %s", zname, overrides, err.Error())
+			if edge.FailOnError {
+				return err
+			}
+			continue
+		}
+		comment, commentSource := zoneComment(ctx, edge, account.Name(), domain, commentOverride)
+		zone := &dns.ZoneCreate{Zone: zname, Type: domain.Type, Comment: comment}
+		zone.Masters = domain.Masters
+		zone.SignAndServe = domain.SignAndServe
+		zone.SignAndServeAlgorithm = domain.SignAndServeAlgorithm
+		zone.TsigKey = domain.TsigKey
+		if edge.ProbeMasters && !probeZoneMasters(ctx, edge, zone) {
+			log.Warnf("Add secondary zone %s deferred. Masters refused transfer", zname)
+			continue
+		}
 		if dryrun {
-			log.Infof("Add secondary zone %s. dry run. No changes made", zname)
-			log.Debugf("Secondary zone: %v", zone)
+			log.Infof("Add secondary zone %s. dry run. No changes made. Secondary zone: %s. Overrides: %v", zname, zoneCreateString(zone), overrides)
 			continue
 		}
-		err := edge.client.CreateZone(ctx, zone, zonequerystring)
+		err := account.client.CreateZone(ctx, zone, zonequerystring)
 		if err != nil {
-			log.Errorf("Create zone error. %s", err.Error())
+			log.Errorf("Create zone error. Account: %s. %s", account.Name(), err.Error())
 			if edge.FailOnError {
 				return err
 			}
+			continue
+		}
+		if rerr := edge.recreations.created(zname); rerr != nil {
+			log.Errorf("Unable to save zone %s recreation. Error: %s", zname, rerr.Error())
+		}
+		if edge.UpdateZoneComments {
+			if cerr := edge.zoneComments.set(zname, commentSource); cerr != nil {
+				log.Errorf("Unable to save zone %s comment source. Error: %s", zname, cerr.Error())
+			}
+		}
+		// overrides may replace the generated key
+		if generatedKey != nil && zone.TsigKey == generatedKey {
+			if terr := edge.tsigKeys.applied(account.Name(), zname, zone.TsigKey.Name); terr != nil {
+				log.Errorf("Unable to save zone %s TSIG key. Error: %s", zname, terr.Error())
+			}
+		}
+		confirmCreatedZone(ctx, edge, account, zname)
+		if zone.SignAndServe {
+			queueDSPublication(ctx, edge, account, zname)
 		}
+		queueDelegationUpdate(ctx, edge, account, zname)
 	}
 
 	return nil
 
 }
 
-func removeSecondaryZones(ctx context.Context, edge *EdgeDNSHandler, removedZones []string, dryrun bool) error {
+// verifyManagedZones checks SOA serials of zones present in both Edge DNS and the registrar at the start of the interval
+func verifyManagedZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, reg registrar.RegistrarProvider, zones []string) {
 
 	log := ctx.Value("appLog").(*log.Entry)
-	log.Debugf("removeSecondaryZones: %v", removedZones)
+
+	results, err := VerifyAccountZones(ctx, edge, account, reg, zones)
+	if err != nil {
+		log.Errorf("Monitor. Failed to verify zone serials. Error: %s", err.Error())
+		return
+	}
+	reportZoneSerialStatus(ctx, results)
+}
+
+// filterMasters parses master addresses and applies the address family policy
+func filterMasters(masters []string, family string) ([]string, error) {
+
+	ips, err := registrar.ParseMasterIPs(masters)
+	if err != nil {
+		return []string{}, err
+	}
+	ips = registrar.FilterAddressFamily(ips, family)
+	if len(ips) < 1 {
+		return []string{}, fmt.Errorf("No master IPs of address family %s in %v", family, masters)
+	}
+
+	return registrar.MasterIPStrings(ips), nil
+}
+
+// removeSecondaryZones deletes zones of an account. Protected zones are never deleted. Returns the zones deleted, or
+// that would be deleted in a dry run.
+func removeSecondaryZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, removedZones []string, dryrun bool) ([]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("removeSecondaryZones: %v. Account: %s", removedZones, account.Name())
+	removedZones = filterProtectedZones(ctx, edge, account, removedZones, dryrun)
 	if len(removedZones) < 1 {
-		return nil
+		return removedZones, nil
 	}
 	if dryrun {
 		log.Infof("Remove secondary zones: [%v]. dry run. No changes made", removedZones)
-		return nil
+		return removedZones, nil
 	}
 
 	zonelist := &dns.ZoneNameListResponse{Zones: removedZones}
-	_, err := edge.client.DeleteBulkZones(ctx, zonelist) // (*dns.BulkZonesResponse, error)
+	_, err := account.client.DeleteBulkZones(ctx, zonelist) // (*dns.BulkZonesResponse, error)
 	if err != nil {
 		log.Errorf("Delete zones error. %s", err.Error())
-		return err
+		return []string{}, err
 	}
 
-	return nil
+	return removedZones, nil
 
 }
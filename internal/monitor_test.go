@@ -16,10 +16,14 @@ package internal
 import (
 	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
 	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	akamai "github.com/akamai/edgedns-registrar-coordinator/registrar/akamai"
 	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
 
 	"context"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -30,6 +34,8 @@ import (
 type EdgednsStub struct {
 	FuncOutput map[string]interface{}
 	FuncErrors map[string]string
+	// Query arguments of zone list requests
+	ZoneListQueries []dns.ZoneListQueryArgs
 }
 
 func newEdgeDNSStub(ctx context.Context) *EdgednsStub {
@@ -123,7 +129,7 @@ func TestMonitorBasic(t *testing.T) {
 	appLog.Info("TestMonitorBasic")
 
 	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
-	lastRegistrarTally["test"] = map[string]bool{"testdelete.zone": true}
+	lastRegistrarTally["test"] = []string{"testdelete.zone"}
 	log.Debugf("lastRegistrarTally after: %v", lastRegistrarTally)
 	stubEdgeDNS.FuncOutput["GetZoneNames"] = append(stubEdgeDNS.FuncOutput["GetZoneNames"].([]string), "testdelete.zone")
 
@@ -413,7 +419,7 @@ func TestMonitorDeleteBulkFail(t *testing.T) {
 
 	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
 	log.Debugf("lastRegistrarTally before: %v", lastRegistrarTally)
-	lastRegistrarTally["test"] = map[string]bool{"testdelete.zone": true}
+	lastRegistrarTally["test"] = []string{"testdelete.zone"}
 	log.Debugf("lastRegistrarTally after: %v", lastRegistrarTally)
 	stubEdgeDNS.FuncOutput["GetZoneNames"] = append(stubEdgeDNS.FuncOutput["GetZoneNames"].([]string), "testdelete.zone")
 	delete(stubEdgeDNS.FuncOutput, "DeleteBulkZones")
@@ -431,6 +437,51 @@ func TestMonitorDeleteBulkFail(t *testing.T) {
 	assert.Equal(t, strings.Contains(result, "Failed"), true)
 }
 
+func TestFilterMasters(t *testing.T) {
+
+	masters, err := filterMasters([]string{"1.2.3.4", "2001:db8::1"}, registrar.AddressFamilyBoth)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, masters)
+
+	masters, err = filterMasters([]string{"1.2.3.4", "2001:DB8::1"}, registrar.AddressFamilyIPv6)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2001:db8::1"}, masters)
+
+	_, err = filterMasters([]string{"1.2.3.4"}, registrar.AddressFamilyIPv6)
+	assert.NotNil(t, err)
+
+	_, err = filterMasters([]string{"1.2.3.4", "master.example.com"}, registrar.AddressFamilyBoth)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "master.example.com")
+}
+
+func TestMonitorInvalidMasterIPs(t *testing.T) {
+
+	ctx := context.TODO()
+
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestMonitor",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+	appLog.Info("TestMonitorInvalidMasterIPs")
+
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	stubRegistrar.FuncOutput["GetMasterIPs"] = []string{"2001:db8::1", "not-an-ip"}
+	config.FailOnError = true
+
+	testInterval := 1 * time.Second
+	cmderr := make(chan string)
+	handler := initEdgeDNSStubHandler(ctx, stubEdgeDNS, config)
+	go Monitor(ctx, cmderr, "test", stubRegistrar, handler, testInterval, false, true)
+
+	result := <-cmderr
+	assert.Equal(t, strings.Contains(result, "Failed"), true)
+}
+
 // stub functions
 
 func (sr StubRegistrar) GetDomains(ctx context.Context) (domains []string, err error) {
@@ -556,6 +607,32 @@ func (es *EdgednsStub) GetZoneNames(ctx context.Context, queryArgs dns.ZoneListQ
 	return
 }
 
+// GetZoneStates returns the GetZoneStates output. Without one, the GetZoneNames output is returned as ACTIVE zones.
+func (es *EdgednsStub) GetZoneStates(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (states map[string]string, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetZoneStates")
+
+	es.ZoneListQueries = append(es.ZoneListQueries, queryArgs)
+
+	if statesr, ok := es.FuncOutput["GetZoneStates"]; ok {
+		return statesr.(map[string]string), nil
+	}
+	if errmsg, ok := es.FuncErrors["GetZoneStates"]; ok {
+		return nil, fmt.Errorf(errmsg)
+	}
+	zones, err := es.GetZoneNames(ctx, queryArgs, []string{})
+	if err != nil {
+		return nil, err
+	}
+	states = map[string]string{}
+	for _, zone := range zones {
+		states[zone] = ActivationStateActive
+	}
+
+	return
+}
+
 func (es *EdgednsStub) GetZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (zlr *dns.ZoneListResponse, err error) {
 
 	log := ctx.Value("appLog").(*log.Entry)
@@ -609,7 +686,21 @@ func (es *EdgednsStub) CreateZone(ctx context.Context, zone *dns.ZoneCreate, zon
 	if errmsg, ok := es.FuncErrors["CreateZone"]; ok {
 		err = fmt.Errorf(errmsg)
 		fmt.Println("CreateZone Error: ", errmsg)
+		return err
 	}
+	// created zones are recorded by name
+	created, ok := es.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)
+	if !ok {
+		created = map[string]*dns.ZoneCreate{}
+		es.FuncOutput["CreatedZones"] = created
+	}
+	created[zone.Zone] = zone
+	queries, ok := es.FuncOutput["CreatedZoneQueries"].(map[string]dns.ZoneQueryString)
+	if !ok {
+		queries = map[string]dns.ZoneQueryString{}
+		es.FuncOutput["CreatedZoneQueries"] = queries
+	}
+	queries[zone.Zone] = zonequerystring
 
 	return err
 }
@@ -642,6 +733,9 @@ func (es *EdgednsStub) DeleteBulkZones(ctx context.Context, zoneslist *dns.ZoneN
 	bzrr, ok := es.FuncOutput["DeleteBulkZones"]
 	if ok {
 		bzr = bzrr.(*dns.BulkZonesResponse) // check make sure type is right?
+		// deleted zones are recorded in order
+		deleted, _ := es.FuncOutput["DeletedZones"].([]string)
+		es.FuncOutput["DeletedZones"] = append(deleted, zoneslist.Zones...)
 	} else {
 		errmsg, ok := es.FuncErrors["DeleteBulkZones"]
 		if !ok {
@@ -654,3 +748,343 @@ func (es *EdgednsStub) DeleteBulkZones(ctx context.Context, zoneslist *dns.ZoneN
 
 	return
 }
+
+func (es *EdgednsStub) GetNameServers(ctx context.Context, contract string) (nameservers []string, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetNameServers")
+
+	nsr, ok := es.FuncOutput["GetNameServers"]
+	if ok {
+		nameservers = nsr.([]string)
+	} else {
+		errmsg, ok := es.FuncErrors["GetNameServers"]
+		if !ok {
+			err = fmt.Errorf("GetNameServers expected output. Got none")
+		} else {
+			err = fmt.Errorf(errmsg)
+		}
+	}
+
+	return
+}
+
+func (es *EdgednsStub) GetGroups(ctx context.Context) (groups []*registrar.EdgeDNSGroup, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetGroups")
+
+	gr, ok := es.FuncOutput["GetGroups"]
+	if ok {
+		groups = gr.([]*registrar.EdgeDNSGroup)
+	} else {
+		errmsg, ok := es.FuncErrors["GetGroups"]
+		if !ok {
+			err = fmt.Errorf("GetGroups expected output. Got none")
+		} else {
+			err = fmt.Errorf(errmsg)
+		}
+	}
+
+	return
+}
+
+func (es *EdgednsStub) GetContracts(ctx context.Context, groupID int) (contracts []*registrar.EdgeDNSContract, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetContracts")
+
+	cr, ok := es.FuncOutput["GetContracts"]
+	if ok {
+		contracts = cr.([]*registrar.EdgeDNSContract)
+	} else {
+		errmsg, ok := es.FuncErrors["GetContracts"]
+		if !ok {
+			err = fmt.Errorf("GetContracts expected output. Got none")
+		} else {
+			err = fmt.Errorf(errmsg)
+		}
+	}
+
+	return
+}
+
+func (es *EdgednsStub) GetZoneTransferStatus(ctx context.Context, zones []string) (statuses []*registrar.EdgeDNSZoneTransferStatus, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetZoneTransferStatus")
+
+	sr, ok := es.FuncOutput["GetZoneTransferStatus"]
+	if ok {
+		statuses = sr.([]*registrar.EdgeDNSZoneTransferStatus)
+	} else {
+		errmsg, ok := es.FuncErrors["GetZoneTransferStatus"]
+		if !ok {
+			err = fmt.Errorf("GetZoneTransferStatus expected output. Got none")
+		} else {
+			err = fmt.Errorf(errmsg)
+		}
+	}
+
+	return
+}
+
+func (es *EdgednsStub) ReplaceRecordsets(ctx context.Context, zone string, recordsets *dns.Recordsets) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS ReplaceRecordsets")
+
+	if errmsg, ok := es.FuncErrors["ReplaceRecordsets"]; ok {
+		return fmt.Errorf(errmsg)
+	}
+	// replaced recordsets are recorded by zone
+	replaced, ok := es.FuncOutput["ReplaceRecordsets"].(map[string]*dns.Recordsets)
+	if !ok {
+		replaced = map[string]*dns.Recordsets{}
+		es.FuncOutput["ReplaceRecordsets"] = replaced
+	}
+	replaced[zone] = recordsets
+
+	return nil
+}
+
+func (es *EdgednsStub) UpdateZone(ctx context.Context, zone *dns.ZoneCreate) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS UpdateZone")
+
+	if errmsg, ok := es.FuncErrors["UpdateZone"]; ok {
+		return fmt.Errorf(errmsg)
+	}
+	// updated zones are recorded by name
+	updated, ok := es.FuncOutput["UpdateZone"].(map[string]*dns.ZoneCreate)
+	if !ok {
+		updated = map[string]*dns.ZoneCreate{}
+		es.FuncOutput["UpdateZone"] = updated
+	}
+	updated[zone.Zone] = zone
+
+	return nil
+}
+
+func (es *EdgednsStub) UpdateZoneKey(ctx context.Context, zone string, key *dns.TSIGKey) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS UpdateZoneKey")
+
+	if errmsg, ok := es.FuncErrors["UpdateZoneKey"]; ok {
+		return fmt.Errorf(errmsg)
+	}
+	// updated keys are recorded by zone
+	updated, ok := es.FuncOutput["UpdateZoneKey"].(map[string]*dns.TSIGKey)
+	if !ok {
+		updated = map[string]*dns.TSIGKey{}
+		es.FuncOutput["UpdateZoneKey"] = updated
+	}
+	updated[zone] = key
+
+	return nil
+}
+
+func (es *EdgednsStub) GetDNSSECStatus(ctx context.Context, zones []string) (statuses []*registrar.EdgeDNSSECStatus, err error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering STUB EdgeDNS GetDNSSECStatus")
+
+	sr, ok := es.FuncOutput["GetDNSSECStatus"]
+	if ok {
+		statuses = sr.([]*registrar.EdgeDNSSECStatus)
+	} else {
+		errmsg, ok := es.FuncErrors["GetDNSSECStatus"]
+		if !ok {
+			err = fmt.Errorf("GetDNSSECStatus expected output. Got none")
+		} else {
+			err = fmt.Errorf(errmsg)
+		}
+	}
+
+	return
+}
+
+func TestEdgeDNSHandlerAccounts(t *testing.T) {
+
+	ctx := context.TODO()
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestEdgeDNSHandlerAccounts",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	_, _, config := initStubs(ctx)
+	config.EdgegridHost = "akab-test.luna.akamaiapis.net"
+	config.EdgegridAccountKey = "KEY-DEFAULT"
+	config.EdgeDNSAccountSpecs = []string{"contract=1-SUB,account-key=KEY-SUB", "contract=1-OTHER,group=42"}
+	handler, err := InitEdgeDNSHandler(ctx, &config, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(handler.Accounts))
+	assert.Equal(t, "123456", handler.Contract)
+	assert.Equal(t, 123456789, handler.Group)
+	// each account signs with its own account switch key
+	assert.Equal(t, "KEY-DEFAULT", handler.Accounts[0].api.Config().AccountKey)
+	assert.Equal(t, "KEY-SUB", handler.Accounts[1].api.Config().AccountKey)
+	assert.Equal(t, "KEY-DEFAULT", handler.Accounts[2].api.Config().AccountKey)
+	assert.Equal(t, 42, handler.Accounts[2].Group)
+	assert.Equal(t, handler.api, handler.Accounts[0].api)
+
+	// without --edgedns-contract, zones are created in the first account
+	config.EdgeDNSContract = ""
+	handler, err = InitEdgeDNSHandler(ctx, &config, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(handler.Accounts))
+	assert.Equal(t, "1-SUB", handler.Contract)
+
+	// credentials only, e.g. listing groups
+	config.EdgeDNSAccountSpecs = nil
+	handler, err = InitEdgeDNSHandler(ctx, &config, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(handler.Accounts))
+	assert.Equal(t, "KEY-DEFAULT", handler.api.Config().AccountKey)
+}
+
+func TestEdgeDNSHandlerPageSize(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestEdgeDNSHandlerPageSize"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.EdgeDNSPageSize = 42
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	assert.Equal(t, 42, handler.PageSize)
+
+	_, err = handler.accountZoneNames(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(stubEdgeDNS.ZoneListQueries))
+	assert.Equal(t, 42, stubEdgeDNS.ZoneListQueries[0].PageSize)
+}
+
+func TestEdgeDNSHandlerResolveNames(t *testing.T) {
+
+	ctx := context.TODO()
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestEdgeDNSHandlerResolveNames",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	_, stubEdgeDNS, config := initStubs(ctx)
+	stubEdgeDNS.FuncOutput["GetGroups"] = []*registrar.EdgeDNSGroup{
+		{GroupID: 11, GroupName: "Primary", ContractIDs: []string{"1-ABC"}, Permissions: []string{"READ", "ADD"}},
+		{GroupID: 12, GroupName: "Partner", ContractIDs: []string{"1-DEF"}},
+		{GroupID: 13, GroupName: "partner", ContractIDs: []string{"1-DEF"}},
+	}
+	stubEdgeDNS.FuncOutput["GetContracts"] = []*registrar.EdgeDNSContract{
+		{ContractID: "1-ABC", ContractName: "Example Contract"},
+	}
+	config.EdgeDNSContract = ""
+	config.EdgeDNSGroup = 0
+	config.EdgeDNSContractName = "example contract"
+	config.EdgeDNSGroupName = "Primary"
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	assert.Equal(t, "1-ABC", handler.Contract)
+	assert.Equal(t, 11, handler.Group)
+
+	// ambiguous group name
+	config.EdgeDNSGroupName = "PARTNER"
+	_, err = InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ambiguous")
+
+	config.EdgeDNSGroupName = "Primary"
+	config.EdgeDNSContractName = "No Such Contract"
+	_, err = InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.NotNil(t, err)
+}
+
+func TestEdgeDNSHandlerRefreshCredentials(t *testing.T) {
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestEdgeDNSHandlerRefreshCredentials",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	_, stubEdgeDNS, config := initStubs(ctx)
+	path := filepath.Join(t.TempDir(), "client_secret")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first-secret\n"), 0600))
+	config.EdgegridClientSecret = "file://" + path
+	config.SecretRefresh = time.Millisecond
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	assert.Equal(t, "first-secret", handler.ClientSecret)
+	assert.Equal(t, "first-secret", handler.config.ClientSecret)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second-secret\n"), 0600))
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, handler.refreshCredentials(ctx))
+	assert.Equal(t, "second-secret", handler.config.ClientSecret)
+
+	config.EdgegridClientSecret = "env://TEST_EDGEGRID_SECRET_UNSET"
+	_, err = InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.NotNil(t, err)
+}
+
+// TestLogRedaction verifies that no known secret reaches a log handler at debug level
+func TestLogRedaction(t *testing.T) {
+
+	ctx := context.TODO()
+	mem := memory.New()
+	logger := log.Log.(*log.Logger)
+	origHandler, origLevel := logger.Handler, logger.Level
+	defer func() {
+		logger.Handler, logger.Level = origHandler, origLevel
+	}()
+	log.SetHandler(registrar.NewRedactHandler(mem))
+	log.SetLevel(log.DebugLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestLogRedaction",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	secrets := []string{"edge-client-token", "edge-client-secret", "edge-access-token", "registrar-client-secret", "tsig-key-secret"}
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.EdgegridClientToken = secrets[0]
+	config.EdgegridClientSecret = secrets[1]
+	config.EdgegridAccessToken = secrets[2]
+	config.TSig = true
+	stubRegistrar.FuncOutput["GetTsigKey"] = &dns.TSIGKey{Name: "key", Algorithm: "hmac-sha256", Secret: secrets[4]}
+
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	_, err = akamai.NewAkamaiRegistrar(ctx, akamai.AkamaiConfig{
+		AkamaiContracts:    "1-ABC",
+		AkamaiHost:         "test host",
+		AkamaiClientToken:  "registrar-client-token",
+		AkamaiClientSecret: registrar.Secret(secrets[3]),
+		AkamaiAccessToken:  "registrar-access-token",
+	}, akamaiRegistrarStub{})
+	assert.Nil(t, err)
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"regtest.zone"}, true))
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"regtest.zone"}, false))
+	appLog.WithField("key", handler.config.ClientSecret).Debugf("Edgegrid config %v", handler.config)
+
+	assert.NotEmpty(t, mem.Entries)
+	for _, entry := range mem.Entries {
+		for _, secret := range secrets {
+			assert.NotContains(t, entry.Message, secret)
+			for k, v := range entry.Fields {
+				assert.NotContains(t, fmt.Sprint(v), secret, k)
+			}
+		}
+	}
+}
+
+// akamaiRegistrarStub stands in for the Akamai registrar Edge DNS service
+type akamaiRegistrarStub struct {
+	registrar.BaseRegistrarProvider
+}
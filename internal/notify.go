@@ -0,0 +1,137 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultNotifyTimeout = 10 * time.Second
+	// Notification events
+	NotifyEventProtectedZone = "protected_zone_deletion_blocked"
+)
+
+// Notification is the JSON body posted to notification webhooks
+type Notification struct {
+	Event   string    `json:"event"`
+	Zone    string    `json:"zone,omitempty"`
+	Account string    `json:"account,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host,omitempty"`
+	// ID of the monitor interval
+	Cycle string `json:"cycle,omitempty"`
+}
+
+// notifier posts notifications to webhooks
+type notifier struct {
+	urls   []string
+	client *http.Client
+}
+
+// newNotifier resolves the webhook URLs. URLs may be secret references and are redacted from logs.
+func newNotifier(ctx context.Context, urls []string, timeout time.Duration) (*notifier, error) {
+
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout
+	}
+	n := &notifier{client: &http.Client{Timeout: timeout}}
+	for _, u := range urls {
+		resolved, err := registrar.ResolveSecret(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateWebhookURL(resolved); err != nil {
+			return nil, err
+		}
+		registrar.RegisterSecret(resolved)
+		n.urls = append(n.urls, resolved)
+	}
+
+	return n, nil
+}
+
+func validateWebhookURL(u string) error {
+
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Invalid notification webhook URL. Expected an http or https URL")
+	}
+
+	return nil
+}
+
+// send posts the notification to each webhook. Returns the first error.
+func (n *notifier) send(ctx context.Context, note *Notification) error {
+
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	var first error
+	for i, u := range n.urls {
+		if err := n.post(ctx, u, body); err != nil && first == nil {
+			first = fmt.Errorf("Webhook %d. %s", i+1, err.Error())
+		}
+	}
+
+	return first
+}
+
+func (n *notifier) post(ctx context.Context, u string, body []byte) error {
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// the error includes the URL
+		return fmt.Errorf("Request failed. %s", registrar.Redact(err.Error()))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// notify sends a notification to the configured webhooks. Failures are logged.
+func notify(ctx context.Context, edge *EdgeDNSHandler, note *Notification) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if edge.notifier == nil || len(edge.notifier.urls) == 0 {
+		return
+	}
+	note.Time = time.Now().UTC()
+	note.Host = edge.hostname
+	note.Cycle = edge.cycleID
+	if err := edge.notifier.send(ctx, note); err != nil {
+		log.Errorf("Notification %s not sent. Error: %s", note.Event, err.Error())
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+	"gopkg.in/yaml.v2"
+
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ZoneOverride replaces registrar values of the zones matching its pattern when the zones are created
+type ZoneOverride struct {
+	Masters []string `yaml:"masters"`
+	// Create the zone without a TSIG key
+	TsigDisabled bool `yaml:"tsig_disabled"`
+	// TSIG key of the zone. The secret may be a secret reference
+	TsigKey               *dns.TSIGKey `yaml:"tsig_key"`
+	SignAndServe          *bool        `yaml:"sign_and_serve"`
+	SignAndServeAlgorithm string       `yaml:"sign_and_serve_algorithm"`
+	Comment               *string      `yaml:"comment"`
+}
+
+// zoneOverrides is the zone overrides file. The file is read again when it changes.
+type zoneOverrides struct {
+	lock    sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	// Overrides keyed by zone name or pattern
+	entries map[string]*ZoneOverride
+}
+
+// newZoneOverrides reads the overrides file at path
+func newZoneOverrides(ctx context.Context, path string) (*zoneOverrides, error) {
+
+	o := &zoneOverrides{path: path, entries: map[string]*ZoneOverride{}}
+	if _, err := o.reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// reload reads the overrides file again if it changed since last read. Returns true if it was read. On error, the
+// current overrides are kept.
+func (o *zoneOverrides) reload(ctx context.Context) (bool, error) {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read zone overrides file %s. %s", o.path, err.Error())
+	}
+	if info.ModTime().Equal(o.modTime) && info.Size() == o.size {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(o.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read zone overrides file %s. %s", o.path, err.Error())
+	}
+	entries, err := parseZoneOverrides(ctx, data)
+	if err != nil {
+		return false, fmt.Errorf("Invalid zone overrides file %s. %s", o.path, err.Error())
+	}
+	o.entries = entries
+	o.modTime = info.ModTime()
+	o.size = info.Size()
+
+	return true, nil
+}
+
+func parseZoneOverrides(ctx context.Context, data []byte) (map[string]*ZoneOverride, error) {
+
+	entries := map[string]*ZoneOverride{}
+	if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, err
+	}
+	for pattern, entry := range entries {
+		if entry == nil {
+			entries[pattern] = &ZoneOverride{}
+			continue
+		}
+		if strings.Contains(pattern, "*") && pattern != "*" && !strings.HasPrefix(pattern, "*.") {
+			return nil, fmt.Errorf("Zone %s: invalid pattern. Expected a zone name, *.domain or *", pattern)
+		}
+		for _, master := range entry.Masters {
+			if net.ParseIP(master) == nil {
+				return nil, fmt.Errorf("Zone %s: invalid master %q", pattern, master)
+			}
+		}
+		if entry.TsigDisabled && entry.TsigKey != nil {
+			return nil, fmt.Errorf("Zone %s: tsig_disabled and tsig_key are mutually exclusive", pattern)
+		}
+		if entry.TsigKey != nil {
+			secret, err := registrar.ResolveSecret(ctx, entry.TsigKey.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("Zone %s: %s", pattern, err.Error())
+			}
+			entry.TsigKey.Secret = secret
+			if err := validateTsigKey(entry.TsigKey); err != nil {
+				return nil, fmt.Errorf("Zone %s: %s", pattern, err.Error())
+			}
+			registrar.RegisterSecret(secret)
+		}
+		if entry.SignAndServeAlgorithm != "" && entry.SignAndServe != nil && !*entry.SignAndServe {
+			return nil, fmt.Errorf("Zone %s: sign_and_serve_algorithm requires sign_and_serve", pattern)
+		}
+	}
+
+	return entries, nil
+}
+
+// match returns the patterns matching zone, least specific first: *, then wildcards from shortest to longest, then
+// the zone name
+func (o *zoneOverrides) match(zone string) ([]string, []*ZoneOverride) {
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	patterns := []string{}
+	for pattern := range o.entries {
+		if zonePatternMatch(pattern, zone) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		iwild, jwild := strings.HasPrefix(patterns[i], "*"), strings.HasPrefix(patterns[j], "*")
+		if iwild != jwild {
+			return iwild
+		}
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) < len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	entries := make([]*ZoneOverride, 0, len(patterns))
+	for _, pattern := range patterns {
+		entries = append(entries, o.entries[pattern])
+	}
+
+	return patterns, entries
+}
+
+// apply merges the overrides matching the domain onto the domain. Values of more specific overrides win. Returns the
+// matching patterns and the comment override, if any.
+func (o *zoneOverrides) apply(domain *registrar.Domain) ([]string, *string) {
+
+	patterns, entries := o.match(domain.Name)
+	var comment *string
+	for _, entry := range entries {
+		if len(entry.Masters) > 0 {
+			domain.Masters = append([]string{}, entry.Masters...)
+		}
+		if entry.TsigDisabled {
+			domain.TsigKey = nil
+		}
+		if entry.TsigKey != nil {
+			key := *entry.TsigKey
+			domain.TsigKey = &key
+		}
+		if entry.SignAndServe != nil {
+			domain.SignAndServe = *entry.SignAndServe
+			if !domain.SignAndServe {
+				domain.SignAndServeAlgorithm = ""
+			}
+		}
+		if entry.SignAndServeAlgorithm != "" {
+			domain.SignAndServe = true
+			domain.SignAndServeAlgorithm = entry.SignAndServeAlgorithm
+		}
+		if entry.Comment != nil {
+			comment = entry.Comment
+		}
+	}
+
+	return patterns, comment
+}
+
+// refreshZoneOverrides reads the overrides file again if it changed. Errors are logged and the current overrides kept.
+func refreshZoneOverrides(ctx context.Context, edge *EdgeDNSHandler) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if edge.overrides == nil {
+		return
+	}
+	reloaded, err := edge.overrides.reload(ctx)
+	if err != nil {
+		log.Errorf("Zone overrides not reloaded. Error: %s", err.Error())
+		return
+	}
+	if reloaded {
+		log.Infof("Zone overrides reloaded from %s", edge.overrides.path)
+	}
+}
+
+// applyZoneOverrides merges the overrides of a zone to be created onto its registrar values. Zones signed through an
+// override without an algorithm use the registrar's serve algorithm.
+func applyZoneOverrides(ctx context.Context, edge *EdgeDNSHandler, reg registrar.RegistrarProvider, domain *registrar.Domain) ([]string, *string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if edge.overrides == nil {
+		return []string{}, nil
+	}
+	patterns, comment := edge.overrides.apply(domain)
+	if domain.SignAndServe && domain.SignAndServeAlgorithm == "" {
+		if algo, err := reg.GetServeAlgorithm(ctx, domain.Name); err == nil && algo != "" {
+			domain.SignAndServeAlgorithm = algo
+		} else {
+			log.Warnf("Zone %s not signed. Unable to retrieve Sign algorithm", domain.Name)
+			domain.SignAndServe = false
+		}
+	}
+	if len(patterns) > 0 {
+		log.Debugf("Zone %s overrides applied: %v", domain.Name, patterns)
+	}
+
+	return patterns, comment
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testZoneOverrides = `"*":
+  comment: All zones
+"*.example.com":
+  masters: [10.0.0.1]
+  tsig_disabled: true
+  sign_and_serve: false
+a.example.com:
+  tsig_key:
+    name: a-key
+    algorithm: hmac-sha256
+    secret: env://TEST_OVERRIDE_SECRET
+  sign_and_serve_algorithm: ECDSA_P256_SHA256
+`
+
+func writeZoneOverrides(t *testing.T, path, content string, modTime time.Time) {
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestZoneOverridesPrecedence(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestZoneOverridesPrecedence"))
+	os.Setenv("TEST_OVERRIDE_SECRET", "c2VjcmV0LW92ZXJyaWRl")
+	defer os.Unsetenv("TEST_OVERRIDE_SECRET")
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	writeZoneOverrides(t, path, testZoneOverrides, time.Now())
+	overrides, err := newZoneOverrides(ctx, path)
+	assert.Nil(t, err)
+
+	registrarKey := &dns.TSIGKey{Name: "registrar-key", Algorithm: "hmac-sha256", Secret: "cmVnaXN0cmFy"}
+	domain := &registrar.Domain{Name: "a.example.com", Masters: []string{"1.2.3.4"}, SignAndServe: true, SignAndServeAlgorithm: "RSASHA256", TsigKey: registrarKey}
+	patterns, comment := overrides.apply(domain)
+	assert.Equal(t, []string{"*", "*.example.com", "a.example.com"}, patterns)
+	assert.Equal(t, "All zones", *comment)
+	assert.Equal(t, []string{"10.0.0.1"}, domain.Masters)
+	assert.Equal(t, "a-key", domain.TsigKey.Name)
+	assert.Equal(t, "c2VjcmV0LW92ZXJyaWRl", domain.TsigKey.Secret)
+	assert.True(t, domain.SignAndServe)
+	assert.Equal(t, "ECDSA_P256_SHA256", domain.SignAndServeAlgorithm)
+
+	domain = &registrar.Domain{Name: "b.example.com", Masters: []string{"1.2.3.4"}, SignAndServe: true, SignAndServeAlgorithm: "RSASHA256", TsigKey: registrarKey}
+	overrides.apply(domain)
+	assert.Nil(t, domain.TsigKey)
+	assert.False(t, domain.SignAndServe)
+	assert.Equal(t, "", domain.SignAndServeAlgorithm)
+
+	domain = &registrar.Domain{Name: "other.zone", Masters: []string{"1.2.3.4"}, TsigKey: registrarKey}
+	patterns, _ = overrides.apply(domain)
+	assert.Equal(t, []string{"*"}, patterns)
+	assert.Equal(t, []string{"1.2.3.4"}, domain.Masters)
+	assert.Equal(t, registrarKey, domain.TsigKey)
+}
+
+func TestZoneOverridesInvalid(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestZoneOverridesInvalid"))
+	for _, content := range []string{
+		"example.com:\n  masters: [not-an-ip]\n",
+		"example.*:\n  comment: bad pattern\n",
+		"example.com:\n  tsig_disabled: true\n  tsig_key: {name: k, algorithm: hmac-sha256, secret: c2VjcmV0}\n",
+		"example.com:\n  tsig_key: {name: k, algorithm: hmac-md5, secret: c2VjcmV0}\n",
+		"example.com:\n  sign_and_serve: false\n  sign_and_serve_algorithm: RSASHA256\n",
+		"example.com:\n  unknown: value\n",
+	} {
+		_, err := parseZoneOverrides(ctx, []byte(content))
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestZoneOverridesReload(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestZoneOverridesReload"))
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	now := time.Now()
+	writeZoneOverrides(t, path, "example.com:\n  comment: first\n", now.Add(-time.Minute))
+	overrides, err := newZoneOverrides(ctx, path)
+	assert.Nil(t, err)
+
+	reloaded, err := overrides.reload(ctx)
+	assert.Nil(t, err)
+	assert.False(t, reloaded)
+
+	writeZoneOverrides(t, path, "example.com:\n  comment: second\n", now)
+	reloaded, err = overrides.reload(ctx)
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	_, comment := overrides.apply(&registrar.Domain{Name: "example.com"})
+	assert.Equal(t, "second", *comment)
+
+	// invalid changes keep the current overrides
+	writeZoneOverrides(t, path, "example.com:\n  masters: [bad]\n", now.Add(time.Minute))
+	_, err = overrides.reload(ctx)
+	assert.NotNil(t, err)
+	_, comment = overrides.apply(&registrar.Domain{Name: "example.com"})
+	assert.Equal(t, "second", *comment)
+}
+
+func TestZoneOverridesCreate(t *testing.T) {
+
+	mem := memory.New()
+	logger := &log.Logger{Handler: mem, Level: log.InfoLevel}
+	ctx := context.WithValue(context.TODO(), "appLog", logger.WithField("subcommand", "TestZoneOverridesCreate"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.ZoneOverridesPath = filepath.Join(t.TempDir(), "overrides.yaml")
+	writeZoneOverrides(t, config.ZoneOverridesPath, "override.zone:\n  masters: [10.0.0.1]\n  sign_and_serve: true\n  comment: Override\n", time.Now())
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+
+	// dry run reports the effective values
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"override.zone"}, true))
+	entry := mem.Entries[len(mem.Entries)-1]
+	assert.Contains(t, entry.Message, "dry run")
+	assert.Contains(t, entry.Message, "Masters:[10.0.0.1]")
+	assert.Contains(t, entry.Message, "Comment:Override")
+	assert.Contains(t, entry.Message, "Overrides: [override.zone]")
+
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"override.zone", "plain.zone"}, false))
+	created := stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)
+	assert.Equal(t, []string{"10.0.0.1"}, created["override.zone"].Masters)
+	assert.True(t, created["override.zone"].SignAndServe)
+	assert.Equal(t, "1234567890abcdefghijklmnop", created["override.zone"].SignAndServeAlgorithm)
+	assert.Equal(t, "Override", created["override.zone"].Comment)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, created["plain.zone"].Masters)
+	assert.Equal(t, DefaultZoneComment, created["plain.zone"].Comment)
+}
+
+func TestZoneOverridesMasterAddressFamily(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestZoneOverridesMasterAddressFamily"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.MasterAddressFamily = registrar.AddressFamilyIPv4
+	config.ZoneOverridesPath = filepath.Join(t.TempDir(), "overrides.yaml")
+	writeZoneOverrides(t, config.ZoneOverridesPath, "v6.zone:\n  masters: [2001:db8::1]\nmixed.zone:\n  masters: [2001:db8::1, 10.0.0.1]\n", time.Now())
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+
+	// zones left without masters of the family are not created
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"v6.zone", "mixed.zone"}, false))
+	created := stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)
+	_, ok := created["v6.zone"]
+	assert.False(t, ok)
+	assert.Equal(t, []string{"10.0.0.1"}, created["mixed.zone"].Masters)
+
+	// override masters replace registrar masters that fail the address family policy
+	stubRegistrar.FuncOutput["GetMasterIPs"] = []string{"2001:db8::2"}
+	delete(stubEdgeDNS.FuncOutput, "CreatedZones")
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"plain.zone", "mixed.zone"}, false))
+	created = stubEdgeDNS.FuncOutput["CreatedZones"].(map[string]*dns.ZoneCreate)
+	_, ok = created["plain.zone"]
+	assert.False(t, ok)
+	assert.Equal(t, []string{"10.0.0.1"}, created["mixed.zone"].Masters)
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ProbeTransferAXFR = "axfr"
+	ProbeTransferIXFR = "ixfr"
+)
+
+var (
+	// zones not created because no master accepted a transfer
+	probeDeferredZones = map[string]*DeferredZone{}
+	probeDeferredLock  = &sync.Mutex{}
+)
+
+// DeferredZone records why and since when a zone creation has been deferred
+type DeferredZone struct {
+	Zone     string
+	Since    time.Time
+	Failures map[string]string // indexed by master
+}
+
+// probeZoneMasters attempts a transfer of zone from each of its masters. Returns true if at least one master accepted.
+func probeZoneMasters(ctx context.Context, edge *EdgeDNSHandler, zone *dns.ZoneCreate) bool {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("Probing masters %v for zone %s", zone.Masters, zone.Zone)
+
+	failures := map[string]string{}
+	ixfr := edge.ProbeTransferType == ProbeTransferIXFR
+	accepted := 0
+	for _, master := range zone.Masters {
+		if err := edge.dnsclient.ProbeTransfer(ctx, zone.Zone, master, zone.TsigKey, ixfr); err != nil {
+			log.Debugf("Zone %s master %s transfer probe failed. %s", zone.Zone, master, err.Error())
+			failures[master] = err.Error()
+			continue
+		}
+		accepted++
+	}
+
+	probeDeferredLock.Lock()
+	defer probeDeferredLock.Unlock()
+
+	if accepted > 0 {
+		delete(probeDeferredZones, zone.Zone)
+		for master, reason := range failures {
+			log.Warnf("Zone %s master %s refused transfer. %s", zone.Zone, master, reason)
+		}
+		return true
+	}
+	deferred, ok := probeDeferredZones[zone.Zone]
+	if !ok {
+		deferred = &DeferredZone{Zone: zone.Zone, Since: time.Now()}
+		probeDeferredZones[zone.Zone] = deferred
+	}
+	deferred.Failures = failures
+
+	return false
+}
+
+// pruneDeferredZones forgets deferred zones that are no longer pending creation
+func pruneDeferredZones(newZones []string) {
+
+	probeDeferredLock.Lock()
+	defer probeDeferredLock.Unlock()
+
+	pending := make(map[string]bool)
+	for _, z := range newZones {
+		pending[z] = true
+	}
+	for z := range probeDeferredZones {
+		if !pending[z] {
+			delete(probeDeferredZones, z)
+		}
+	}
+}
+
+// reportDeferredZones logs the zones currently deferred by the transfer probe
+func reportDeferredZones(ctx context.Context) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	probeDeferredLock.Lock()
+	defer probeDeferredLock.Unlock()
+
+	zones := make([]string, 0, len(probeDeferredZones))
+	for z := range probeDeferredZones {
+		zones = append(zones, z)
+	}
+	sort.Strings(zones)
+	for _, z := range zones {
+		d := probeDeferredZones[z]
+		entry := log.WithField("zone", z).WithField("deferred_since", d.Since.Format(time.RFC3339)).WithField("failures", d.Failures)
+		entry.Warnf("Zone %s creation deferred. No master accepted transfer", z)
+	}
+}
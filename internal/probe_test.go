@@ -0,0 +1,131 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	testTsigName   = "xfr-key."
+	testTsigSecret = "c2VjcmV0LWtleS1mb3ItdGVzdGluZy0xMjM0NTY3OA=="
+)
+
+// testTransferHandler serves SOA queries and zone transfers of zone. Transfers require a valid TSIG signature if requireTsig.
+func testTransferHandler(zone string, serial uint32, requireTsig bool) miekg.HandlerFunc {
+
+	soaHandler := testSOAHandler(zone, serial)
+	return func(w miekg.ResponseWriter, req *miekg.Msg) {
+		q := req.Question[0]
+		if q.Qtype != miekg.TypeAXFR && q.Qtype != miekg.TypeIXFR {
+			soaHandler(w, req)
+			return
+		}
+		m := new(miekg.Msg)
+		m.SetReply(req)
+		tsig := req.IsTsig()
+		if tsig != nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		if q.Name != miekg.Fqdn(zone) || (requireTsig && (tsig == nil || w.TsigStatus() != nil)) {
+			m.Rcode = miekg.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+		m.Authoritative = true
+		soa := &miekg.SOA{
+			Hdr:    miekg.RR_Header{Name: q.Name, Rrtype: miekg.TypeSOA, Class: miekg.ClassINET, Ttl: 300},
+			Ns:     "ns1." + q.Name,
+			Mbox:   "hostmaster." + q.Name,
+			Serial: serial,
+		}
+		m.Answer = []miekg.RR{soa, soa}
+		w.WriteMsg(m)
+	}
+}
+
+func TestProbeTransfer(t *testing.T) {
+
+	addr := startTestDNSServer(t, testTransferHandler("example.com", 10, false), nil)
+	client := NewDNSQueryClient("", time.Second)
+
+	assert.Nil(t, client.ProbeTransfer(context.TODO(), "example.com", addr, nil, false))
+	assert.Nil(t, client.ProbeTransfer(context.TODO(), "example.com", addr, nil, true))
+	err := client.ProbeTransfer(context.TODO(), "other.com", addr, nil, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "SOA query failed")
+}
+
+func TestProbeTransferTsig(t *testing.T) {
+
+	addr := startTestDNSServer(t, testTransferHandler("example.com", 10, true), map[string]string{testTsigName: testTsigSecret})
+	client := NewDNSQueryClient("", time.Second)
+
+	key := &dns.TSIGKey{Name: "xfr-key", Algorithm: "hmac-sha256", Secret: testTsigSecret}
+	assert.Nil(t, client.ProbeTransfer(context.TODO(), "example.com", addr, key, false))
+
+	// unsigned transfer is refused
+	err := client.ProbeTransfer(context.TODO(), "example.com", addr, nil, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "REFUSED")
+
+	// wrong secret is refused
+	badKey := &dns.TSIGKey{Name: "xfr-key", Algorithm: "hmac-sha256", Secret: "d3Jvbmctc2VjcmV0"}
+	err = client.ProbeTransfer(context.TODO(), "example.com", addr, badKey, false)
+	assert.NotNil(t, err)
+}
+
+func TestMonitorProbeMastersDeferred(t *testing.T) {
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": "TestMonitorProbeMastersDeferred",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	// creating a zone fails the test
+	stubEdgeDNS.FuncErrors["CreateZone"] = "Create not expected"
+	config.FailOnError = true
+	config.ProbeMasters = true
+	handler := initEdgeDNSStubHandler(ctx, stubEdgeDNS, config)
+	dnsStub := newDNSQueryStub()
+	dnsStub.ProbeErrors["1.2.3.4"] = "transfer refused. REFUSED"
+	dnsStub.ProbeErrors["5.6.7.8"] = "transfer refused. NOTAUTH"
+	handler.dnsclient = dnsStub
+
+	err := addSecondaryZones(ctx, handler, stubRegistrar, []string{"regtest.zone"}, false)
+	assert.Nil(t, err)
+	assert.Contains(t, probeDeferredZones, "regtest.zone")
+	assert.Equal(t, 2, len(probeDeferredZones["regtest.zone"].Failures))
+
+	// one master accepting is enough to create the zone
+	delete(dnsStub.ProbeErrors, "5.6.7.8")
+	delete(stubEdgeDNS.FuncErrors, "CreateZone")
+	err = addSecondaryZones(ctx, handler, stubRegistrar, []string{"regtest.zone"}, false)
+	assert.Nil(t, err)
+	assert.NotContains(t, probeDeferredZones, "regtest.zone")
+}
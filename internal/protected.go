@@ -0,0 +1,205 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protectedZones are the zones the coordinator never deletes. Patterns come from the configuration and from an
+// optional file, which is read again when it changes.
+type protectedZones struct {
+	lock     sync.Mutex
+	patterns []string
+	path     string
+	modTime  time.Time
+	size     int64
+	// Patterns read from the file
+	filePatterns []string
+}
+
+// newProtectedZones validates the configured patterns and reads the protected zones file, if any
+func newProtectedZones(patterns []string, path string) (*protectedZones, error) {
+
+	p := &protectedZones{path: path}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if err := validateZonePattern(pattern); err != nil {
+			return nil, fmt.Errorf("Invalid protected zone. %s", err.Error())
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	if path != "" {
+		if _, err := p.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// validateZonePattern checks a pattern is a zone name, *.domain or *
+func validateZonePattern(pattern string) error {
+
+	if pattern == "" {
+		return fmt.Errorf("Empty zone pattern")
+	}
+	if strings.Contains(pattern, "*") && pattern != "*" && (!strings.HasPrefix(pattern, "*.") || strings.Contains(pattern[1:], "*")) {
+		return fmt.Errorf("Zone %s: invalid pattern. Expected a zone name, *.domain or *", pattern)
+	}
+
+	return nil
+}
+
+// parseProtectedZones parses a protected zones file of one zone name or pattern per line. Blank lines and lines
+// starting with # are ignored.
+func parseProtectedZones(data []byte) ([]string, error) {
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pattern := strings.ToLower(text)
+		if err := validateZonePattern(pattern); err != nil {
+			return nil, fmt.Errorf("Line %d. %s", line, err.Error())
+		}
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
+// reload reads the protected zones file again if it changed since last read. Returns true if it was read. On error,
+// the current file patterns are kept so protection is never lost to a bad edit.
+func (p *protectedZones) reload() (bool, error) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read protected zones file %s. %s", p.path, err.Error())
+	}
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return false, fmt.Errorf("Unable to read protected zones file %s. %s", p.path, err.Error())
+	}
+	patterns, err := parseProtectedZones(data)
+	if err != nil {
+		return false, fmt.Errorf("Invalid protected zones file %s. %s", p.path, err.Error())
+	}
+	p.filePatterns = patterns
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return true, nil
+}
+
+// match returns the pattern protecting zone, if any
+func (p *protectedZones) match(zone string) (string, bool) {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	for _, patterns := range [][]string{p.patterns, p.filePatterns} {
+		for _, pattern := range patterns {
+			if zonePatternMatch(pattern, zone) {
+				return pattern, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// len returns the number of protected zone patterns
+func (p *protectedZones) len() int {
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.patterns) + len(p.filePatterns)
+}
+
+// refreshProtectedZones reads the protected zones file again if it changed. Errors are logged and the current
+// protected zones kept.
+func refreshProtectedZones(ctx context.Context, edge *EdgeDNSHandler) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if edge.protected == nil || edge.protected.path == "" {
+		return
+	}
+	reloaded, err := edge.protected.reload()
+	if err != nil {
+		log.Errorf("Protected zones not reloaded. Error: %s", err.Error())
+		return
+	}
+	if reloaded {
+		log.Infof("Protected zones reloaded from %s. %d patterns", edge.protected.path, edge.protected.len())
+	}
+}
+
+// filterProtectedZones returns the zones that may be deleted. Deletions of protected zones are blocked, logged as
+// errors, counted in a metric and sent as notifications. Dry runs only log them.
+func filterProtectedZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, dryrun bool) []string {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if edge.protected == nil {
+		return zones
+	}
+	allowed := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		pattern, protected := edge.protected.match(zone)
+		if !protected {
+			allowed = append(allowed, zone)
+			continue
+		}
+		message := fmt.Sprintf("PROTECTED ZONE %s NOT DELETED. Zone matches protected zone %s. Account: %s. Remove the zone from the protected zone list to delete it", zone, pattern, account.Name())
+		if dryrun {
+			log.Errorf("%s. dry run", message)
+			continue
+		}
+		log.Error(message)
+		labels := map[string]string{"account": account.Name(), "zone": zone}
+		blocked, _ := metrics.Gauge("protected_zone_deletions_blocked", labels)
+		metrics.SetGauge("protected_zone_deletions_blocked", "Deletions of a protected zone blocked since start", labels, blocked+1)
+		notify(ctx, edge, &Notification{Event: NotifyEventProtectedZone, Zone: zone, Account: account.Name(), Message: message})
+	}
+
+	return allowed
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProtectedZones(t *testing.T) {
+
+	_, err := newProtectedZones([]string{"example.*"}, "")
+	assert.NotNil(t, err)
+	_, err = newProtectedZones([]string{"*.*.example.com"}, "")
+	assert.NotNil(t, err)
+	_, err = newProtectedZones(nil, filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err)
+
+	path := filepath.Join(t.TempDir(), "protected")
+	now := time.Now()
+	writeZoneOverrides(t, path, "# crown jewels\nBank.example\n\n*.payments.example\n", now.Add(-time.Minute))
+	protected, err := newProtectedZones([]string{"example.com"}, path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, protected.len())
+	for zone, pattern := range map[string]string{"example.com": "example.com", "bank.example.": "bank.example", "eu.payments.example": "*.payments.example"} {
+		matched, ok := protected.match(zone)
+		assert.True(t, ok, zone)
+		assert.Equal(t, pattern, matched)
+	}
+	_, ok := protected.match("payments.example")
+	assert.False(t, ok)
+
+	// invalid changes keep the current list
+	writeZoneOverrides(t, path, "bank.example\nbad.*\n", now)
+	_, err = protected.reload()
+	assert.NotNil(t, err)
+	_, ok = protected.match("eu.payments.example")
+	assert.True(t, ok)
+
+	writeZoneOverrides(t, path, "bank.example\n", now.Add(time.Minute))
+	reloaded, err := protected.reload()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	_, ok = protected.match("eu.payments.example")
+	assert.False(t, ok)
+}
+
+func TestRemoveProtectedZones(t *testing.T) {
+
+	notes := make(chan *Notification, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		note := &Notification{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(note))
+		notes <- note
+	}))
+	defer server.Close()
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestRemoveProtectedZones"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.ProtectedZones = []string{"*.protected.zone"}
+	config.NotifyWebhooks = []string{server.URL}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	handler.cycleID = "cycle1"
+	account := handler.Accounts[0]
+
+	// dry runs neither count nor notify
+	deleted, err := removeSecondaryZones(ctx, handler, account, []string{"a.protected.zone", "plain.zone"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"plain.zone"}, deleted)
+	assert.Len(t, notes, 0)
+
+	deleted, err = removeSecondaryZones(ctx, handler, account, []string{"a.protected.zone", "plain.zone"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"plain.zone"}, deleted)
+	assert.Equal(t, []string{"plain.zone"}, stubEdgeDNS.FuncOutput["DeletedZones"])
+	blocked, ok := metrics.Gauge("protected_zone_deletions_blocked", map[string]string{"account": account.Name(), "zone": "a.protected.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), blocked)
+	note := <-notes
+	assert.Equal(t, NotifyEventProtectedZone, note.Event)
+	assert.Equal(t, "a.protected.zone", note.Zone)
+	assert.Equal(t, account.Name(), note.Account)
+	assert.Equal(t, "cycle1", note.Cycle)
+
+	// only protected zones. Nothing deleted
+	deleted, err = removeSecondaryZones(ctx, handler, account, []string{"b.protected.zone"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, deleted, 0)
+	assert.Equal(t, []string{"plain.zone"}, stubEdgeDNS.FuncOutput["DeletedZones"])
+	<-notes
+}
+
+func TestNotifier(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestNotifier"))
+	_, err := newNotifier(ctx, []string{"ftp://example.com/hook"}, 0)
+	assert.NotNil(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	n, err := newNotifier(ctx, []string{server.URL}, time.Second)
+	assert.Nil(t, err)
+	err = n.send(ctx, &Notification{Event: "test", Message: "test"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "502")
+}
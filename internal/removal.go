@@ -0,0 +1,286 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Actions taken on zones whose domains were removed from the registrar
+	RemovalActionDelete  = "delete"
+	RemovalActionConvert = "convert"
+	RemovalActionReport  = "report"
+	// Transfer sources of zone conversions. Any other value is a name server address
+	ConvertSourceMasters = "masters"
+	ConvertSourceAkamai  = "akamai"
+	// Notification events
+	NotifyEventZoneLeft            = "removed_zone_left"
+	NotifyEventZoneConverted       = "removed_zone_converted"
+	NotifyEventZoneConversionError = "removed_zone_conversion_failed"
+)
+
+// convertSkipTypes are record types not imported into converted zones. Edge DNS generates them for signed zones.
+var convertSkipTypes = map[uint16]bool{
+	miekg.TypeRRSIG:      true,
+	miekg.TypeNSEC:       true,
+	miekg.TypeNSEC3:      true,
+	miekg.TypeNSEC3PARAM: true,
+	miekg.TypeDNSKEY:     true,
+}
+
+// validateConvertSource checks a transfer source is masters, akamai or a name server address
+func validateConvertSource(source string) error {
+
+	if source == ConvertSourceMasters || source == ConvertSourceAkamai {
+		return nil
+	}
+	if source == "" || strings.ContainsAny(source, " /,") {
+		return fmt.Errorf("Invalid conversion transfer source %q. Expected masters, akamai or a name server address", source)
+	}
+
+	return nil
+}
+
+// removeZones applies the removal action to zones of an account whose domains were removed from the registrar.
+// Protected zones are blocked before any action and count as handled. Returns the zones handled. Zones not handled
+// are retried next interval.
+func removeZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, dryrun bool) ([]string, error) {
+
+	if len(zones) < 1 {
+		return zones, nil
+	}
+	allowed := filterProtectedZones(ctx, edge, account, zones, dryrun)
+	blocked := sortedDifference(sortedZoneList(zones), sortedZoneList(allowed))
+	if len(allowed) < 1 {
+		return blocked, nil
+	}
+	switch edge.RemovalAction {
+	case RemovalActionReport:
+		reportRemovedZones(ctx, edge, account, allowed, dryrun)
+		return zones, nil
+	case RemovalActionConvert:
+		return sortedZoneList(append(blocked, convertRemovedZones(ctx, edge, account, allowed, dryrun)...)), nil
+	}
+	if _, err := removeSecondaryZones(ctx, edge, account, allowed, dryrun); err != nil {
+		return blocked, err
+	}
+
+	return zones, nil
+}
+
+// reportRemovedZones leaves zones in place. Each zone is logged, flagged in a metric and sent as a notification.
+func reportRemovedZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, dryrun bool) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	for _, zone := range zones {
+		message := fmt.Sprintf("Zone %s removed from registrar. Zone left in Edge DNS. Account: %s", zone, account.Name())
+		if dryrun {
+			log.Warnf("%s. dry run", message)
+			continue
+		}
+		log.Warn(message)
+		metrics.SetGauge("removed_zones_left", "Zones left in Edge DNS after their domains were removed from the registrar", map[string]string{"account": account.Name(), "zone": zone}, 1)
+		notify(ctx, edge, &Notification{Event: NotifyEventZoneLeft, Zone: zone, Account: account.Name(), Message: message})
+	}
+}
+
+// convertRemovedZones converts secondary zones to primary zones with their current records. Returns the zones
+// converted. Failures are logged and sent as notifications.
+func convertRemovedZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, dryrun bool) []string {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	converted := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		if err := convertZone(ctx, edge, account, zone, dryrun); err != nil {
+			message := fmt.Sprintf("Zone %s not converted to PRIMARY. Account: %s. Error: %s", zone, account.Name(), err.Error())
+			log.Error(message)
+			if !dryrun {
+				notify(ctx, edge, &Notification{Event: NotifyEventZoneConversionError, Zone: zone, Account: account.Name(), Message: message})
+			}
+			continue
+		}
+		converted = append(converted, zone)
+	}
+
+	return converted
+}
+
+// convertZone transfers the records of a secondary zone, changes the zone to PRIMARY and imports the records. Apex NS
+// records are replaced by the Akamai name servers. If the import fails, the zone is restored to its secondary
+// settings. Zones that are not secondary zones are left as they are.
+func convertZone(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zone string, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("convertZone: %s. Account: %s", zone, account.Name())
+
+	resp, err := account.client.GetZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(resp.Type, "SECONDARY") {
+		log.Infof("Zone %s is a %s zone. Not converted", zone, resp.Type)
+		return nil
+	}
+	nameservers, err := account.client.GetNameServers(ctx, account.Contract)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve Edge DNS name servers. %s", err.Error())
+	}
+	servers, err := convertTransferServers(edge, resp, nameservers)
+	if err != nil {
+		return err
+	}
+	var records []miekg.RR
+	source := ""
+	errs := []string{}
+	for _, server := range servers {
+		records, err = edge.dnsclient.TransferZone(ctx, zone, server, resp.TsigKey)
+		if err == nil {
+			source = server
+			break
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", server, err.Error()))
+	}
+	if source == "" {
+		return fmt.Errorf("Zone transfer failed. %s", strings.Join(errs, "; "))
+	}
+	recordsets, err := zoneRecordsets(zone, records, nameservers)
+	if err != nil {
+		return err
+	}
+	if dryrun {
+		log.Infof("Convert zone %s to PRIMARY with %d recordsets transferred from %s. dry run. No changes made", zone, len(recordsets.Recordsets), source)
+		return nil
+	}
+
+	update := &dns.ZoneCreate{
+		Zone:                  zone,
+		Type:                  "PRIMARY",
+		Comment:               resp.Comment,
+		SignAndServe:          resp.SignAndServe,
+		SignAndServeAlgorithm: resp.SignAndServeAlgorithm,
+		EndCustomerId:         resp.EndCustomerId,
+		ContractId:            resp.ContractId,
+	}
+	if err := account.client.UpdateZone(ctx, update); err != nil {
+		return err
+	}
+	if err := account.client.ReplaceRecordsets(ctx, zone, recordsets); err != nil {
+		restore := &dns.ZoneCreate{
+			Zone:                  zone,
+			Type:                  resp.Type,
+			Masters:               resp.Masters,
+			Comment:               resp.Comment,
+			SignAndServe:          resp.SignAndServe,
+			SignAndServeAlgorithm: resp.SignAndServeAlgorithm,
+			TsigKey:               resp.TsigKey,
+			Target:                resp.Target,
+			EndCustomerId:         resp.EndCustomerId,
+			ContractId:            resp.ContractId,
+		}
+		if rerr := account.client.UpdateZone(ctx, restore); rerr != nil {
+			// the zone is primary now and is not converted again
+			return fmt.Errorf("ZONE CONVERTED TO PRIMARY WITHOUT RECORDS. Restore the secondary zone or import the records manually. Import error: %s. Restore error: %s", err.Error(), rerr.Error())
+		}
+		return fmt.Errorf("Records not imported. Zone restored to SECONDARY. %s", err.Error())
+	}
+	message := fmt.Sprintf("Zone %s converted to PRIMARY with %d recordsets transferred from %s. Account: %s", zone, len(recordsets.Recordsets), source, account.Name())
+	log.Info(message)
+	notify(ctx, edge, &Notification{Event: NotifyEventZoneConverted, Zone: zone, Account: account.Name(), Message: message})
+
+	return nil
+}
+
+// convertTransferServers returns the name servers the records of a zone are transferred from. nameservers are the
+// Edge DNS name servers of the account.
+func convertTransferServers(edge *EdgeDNSHandler, resp *dns.ZoneResponse, nameservers []string) ([]string, error) {
+
+	switch edge.ConvertTransferSource {
+	case "", ConvertSourceMasters:
+		if len(resp.Masters) < 1 {
+			return nil, fmt.Errorf("Zone has no masters")
+		}
+		return resp.Masters, nil
+	case ConvertSourceAkamai:
+		if len(nameservers) < 1 {
+			return nil, fmt.Errorf("No Edge DNS name servers")
+		}
+		servers := make([]string, 0, len(nameservers))
+		for _, ns := range nameservers {
+			servers = append(servers, strings.TrimSuffix(ns, "."))
+		}
+		return servers, nil
+	}
+
+	return []string{edge.ConvertTransferSource}, nil
+}
+
+// zoneRecordsets groups transferred records into Edge DNS recordsets. Each recordset takes the lowest TTL of its
+// records. Records Edge DNS generates for signed zones are skipped. The apex NS records are replaced by nameservers so
+// that the zone keeps its Edge DNS delegation.
+func zoneRecordsets(zone string, records []miekg.RR, nameservers []string) (*dns.Recordsets, error) {
+
+	apex := strings.ToLower(strings.TrimSuffix(zone, "."))
+	recordsets := &dns.Recordsets{Recordsets: []dns.Recordset{}}
+	index := map[string]int{}
+	seen := map[string]bool{}
+	for _, rr := range records {
+		hdr := rr.Header()
+		if convertSkipTypes[hdr.Rrtype] {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSuffix(hdr.Name, "."))
+		rtype := miekg.TypeToString[hdr.Rrtype]
+		key := name + " " + rtype
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+		i, ok := index[key]
+		if !ok {
+			i = len(recordsets.Recordsets)
+			index[key] = i
+			recordsets.Recordsets = append(recordsets.Recordsets, dns.Recordset{Name: name, Type: rtype, TTL: int(hdr.Ttl)})
+		}
+		set := &recordsets.Recordsets[i]
+		if int(hdr.Ttl) < set.TTL {
+			set.TTL = int(hdr.Ttl)
+		}
+		if seen[key+" "+rdata] {
+			continue
+		}
+		seen[key+" "+rdata] = true
+		set.Rdata = append(set.Rdata, rdata)
+	}
+	for _, rtype := range []string{"SOA", "NS"} {
+		if _, ok := index[apex+" "+rtype]; !ok {
+			return nil, fmt.Errorf("Transferred zone has no %s record at the apex", rtype)
+		}
+	}
+	if len(nameservers) < 1 {
+		return nil, fmt.Errorf("No Edge DNS name servers")
+	}
+	apexNS := &recordsets.Recordsets[index[apex+" NS"]]
+	apexNS.Rdata = make([]string, 0, len(nameservers))
+	for _, ns := range nameservers {
+		apexNS.Rdata = append(apexNS.Rdata, miekg.Fqdn(strings.ToLower(ns)))
+	}
+
+	return recordsets, nil
+}
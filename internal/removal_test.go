@@ -0,0 +1,213 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// testZoneRecords are the records of the zone served by testZoneHandler, in transfer order
+var testZoneRecords = []string{
+	"old.zone. 300 IN SOA ns1.old.zone. hostmaster.old.zone. 7 3600 600 604800 300",
+	"old.zone. 3600 IN NS ns1.old.zone.",
+	"old.zone. 3600 IN NS ns2.old.zone.",
+	"old.zone. 3600 IN RRSIG NS 13 2 3600 20210401000000 20210301000000 12345 old.zone. c2lnbmF0dXJl",
+	"www.old.zone. 300 IN A 192.0.2.10",
+	"WWW.old.zone. 60 IN A 192.0.2.11",
+	"www.old.zone. 300 IN A 192.0.2.10",
+	"old.zone. 300 IN TXT \"v=spf1 -all\"",
+}
+
+// testZoneHandler serves zone transfers of old.zone signed with the test TSIG key
+func testZoneHandler(t *testing.T) miekg.HandlerFunc {
+
+	return func(w miekg.ResponseWriter, req *miekg.Msg) {
+		m := new(miekg.Msg)
+		m.SetReply(req)
+		tsig := req.IsTsig()
+		if tsig != nil {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+		}
+		if req.Question[0].Qtype != miekg.TypeAXFR || tsig == nil || w.TsigStatus() != nil {
+			m.Rcode = miekg.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+		for _, record := range append(testZoneRecords, testZoneRecords[0]) {
+			rr, err := miekg.NewRR(record)
+			assert.Nil(t, err)
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestZoneRecordsets(t *testing.T) {
+
+	records := []miekg.RR{}
+	for _, record := range testZoneRecords {
+		rr, err := miekg.NewRR(record)
+		assert.Nil(t, err)
+		records = append(records, rr)
+	}
+	nameservers := []string{"a1-1.akam.net", "A2-2.akam.net."}
+	recordsets, err := zoneRecordsets("old.zone", records, nameservers)
+	assert.Nil(t, err)
+	// apex NS records are replaced by the Edge DNS name servers
+	assert.Equal(t, []dns.Recordset{
+		{Name: "old.zone", Type: "SOA", TTL: 300, Rdata: []string{"ns1.old.zone. hostmaster.old.zone. 7 3600 600 604800 300"}},
+		{Name: "old.zone", Type: "NS", TTL: 3600, Rdata: []string{"a1-1.akam.net.", "a2-2.akam.net."}},
+		{Name: "www.old.zone", Type: "A", TTL: 60, Rdata: []string{"192.0.2.10", "192.0.2.11"}},
+		{Name: "old.zone", Type: "TXT", TTL: 300, Rdata: []string{"\"v=spf1 -all\""}},
+	}, recordsets.Recordsets)
+
+	// zones without apex NS records are not imported
+	_, err = zoneRecordsets("old.zone", []miekg.RR{records[0], records[4]}, nameservers)
+	assert.NotNil(t, err)
+	_, err = zoneRecordsets("old.zone", records, nil)
+	assert.NotNil(t, err)
+}
+
+func TestConvertRemovedZones(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestConvertRemovedZones"))
+	addr := startTestDNSServer(t, testZoneHandler(t), map[string]string{testTsigName: testTsigSecret})
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.RemovalAction = RemovalActionConvert
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	handler.dnsclient = NewDNSQueryClient("", time.Second)
+	account := handler.Accounts[0]
+	stubEdgeDNS.FuncOutput["GetNameServers"] = []string{"a1-1.akam.net"}
+	secondary := &dns.ZoneResponse{
+		Zone:         "old.zone",
+		Type:         "SECONDARY",
+		Masters:      []string{"127.0.0.1:1", addr},
+		Comment:      "coordinator managed",
+		SignAndServe: true,
+		TsigKey:      &dns.TSIGKey{Name: "xfr-key", Algorithm: "hmac-sha256", Secret: testTsigSecret},
+		ContractId:   "1-2AB34C",
+	}
+	stubEdgeDNS.FuncOutput["GetZone"] = secondary
+
+	// dry runs transfer the zone but change nothing
+	done, err := removeZones(ctx, handler, account, []string{"old.zone"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"old.zone"}, done)
+	_, ok := stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"old.zone"}, done)
+	_, ok = stubEdgeDNS.FuncOutput["DeletedZones"]
+	assert.False(t, ok)
+	update := stubEdgeDNS.FuncOutput["UpdateZone"].(map[string]*dns.ZoneCreate)["old.zone"]
+	assert.Equal(t, "PRIMARY", update.Type)
+	assert.Len(t, update.Masters, 0)
+	assert.Nil(t, update.TsigKey)
+	assert.Equal(t, "coordinator managed", update.Comment)
+	assert.True(t, update.SignAndServe)
+	recordsets := stubEdgeDNS.FuncOutput["ReplaceRecordsets"].(map[string]*dns.Recordsets)["old.zone"]
+	assert.Len(t, recordsets.Recordsets, 4)
+	assert.Equal(t, []string{"a1-1.akam.net."}, recordsets.Recordsets[1].Rdata)
+
+	// zones whose records are not imported are restored to their secondary settings and not converted
+	stubEdgeDNS.FuncErrors["ReplaceRecordsets"] = "Invalid recordset"
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, done, 0)
+	restored := stubEdgeDNS.FuncOutput["UpdateZone"].(map[string]*dns.ZoneCreate)["old.zone"]
+	assert.Equal(t, "SECONDARY", restored.Type)
+	assert.Equal(t, secondary.Masters, restored.Masters)
+	assert.Equal(t, secondary.TsigKey, restored.TsigKey)
+	delete(stubEdgeDNS.FuncErrors, "ReplaceRecordsets")
+
+	// the records may also come from the Edge DNS name servers
+	delete(stubEdgeDNS.FuncOutput, "ReplaceRecordsets")
+	handler.ConvertTransferSource = ConvertSourceAkamai
+	stubEdgeDNS.FuncOutput["GetNameServers"] = []string{addr}
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"old.zone"}, done)
+	assert.Len(t, stubEdgeDNS.FuncOutput["ReplaceRecordsets"].(map[string]*dns.Recordsets)["old.zone"].Recordsets, 4)
+
+	// failed transfers are not converted
+	handler.ConvertTransferSource = "127.0.0.1:1"
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, done, 0)
+
+	// protected zones are never converted
+	delete(stubEdgeDNS.FuncOutput, "UpdateZone")
+	handler.ConvertTransferSource = ConvertSourceMasters
+	handler.protected, err = newProtectedZones([]string{"old.zone"}, "")
+	assert.Nil(t, err)
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"old.zone"}, done)
+	_, ok = stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+	blocked, ok := metrics.Gauge("protected_zone_deletions_blocked", map[string]string{"account": account.Name(), "zone": "old.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), blocked)
+	handler.protected = nil
+
+	// primary zones are left as they are
+	delete(stubEdgeDNS.FuncOutput, "UpdateZone")
+	stubEdgeDNS.FuncOutput["GetZone"] = &dns.ZoneResponse{Zone: "old.zone", Type: "PRIMARY"}
+	done, err = removeZones(ctx, handler, account, []string{"old.zone"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"old.zone"}, done)
+	_, ok = stubEdgeDNS.FuncOutput["UpdateZone"]
+	assert.False(t, ok)
+}
+
+func TestMonitorRemovalActions(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestMonitorRemovalActions"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.RemovalAction = RemovalActionReport
+	stubEdgeDNS.FuncOutput["GetZoneNames"] = []string{"old.zone", "regtest.zone", "regtest2.zone"}
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+
+	// reported zones are left in place
+	lastRegistrarTally["removaltest"] = []string{"old.zone", "regtest.zone", "regtest2.zone"}
+	monitorProc(ctx, "removaltest", stubRegistrar, handler, time.Millisecond, false, true)
+	_, ok := stubEdgeDNS.FuncOutput["DeletedZones"]
+	assert.False(t, ok)
+	left, ok := metrics.Gauge("removed_zones_left", map[string]string{"account": account.Name(), "zone": "old.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), left)
+	assert.Len(t, handler.deferred.list(), 0)
+
+	// zones failing conversion are retried next interval
+	handler.RemovalAction = RemovalActionConvert
+	stubEdgeDNS.FuncErrors["GetZone"] = "zone not found"
+	lastRegistrarTally["removaltest"] = []string{"old.zone", "regtest.zone", "regtest2.zone"}
+	monitorProc(ctx, "removaltest", stubRegistrar, handler, time.Millisecond, false, true)
+	assert.Equal(t, []string{"old.zone"}, handler.deferred.zones(ChangeOperationDelete, account.Name()))
+	_, ok = stubEdgeDNS.FuncOutput["DeletedZones"]
+	assert.False(t, ok)
+}
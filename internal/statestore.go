@@ -0,0 +1,269 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+const (
+	DefaultStateFileMode = os.FileMode(0600)
+)
+
+// loadState reads the JSON state file name in dir into v. An empty dir or a missing file leaves v unchanged.
+func loadState(dir, name string, v interface{}) error {
+
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, name)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read state file %s. %s", path, err.Error())
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Invalid state file %s. %s", path, err.Error())
+	}
+
+	return nil
+}
+
+// saveState writes v to the JSON state file name in dir. The file is replaced atomically. An empty dir is a no-op.
+func saveState(dir, name string, v interface{}) error {
+
+	if dir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("Unable to create state directory %s. %s", dir, err.Error())
+	}
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to write state file %s. %s", name, err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(DefaultStateFileMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to write state file %s. %s", name, err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+// stateEntry is an entry of a keyedStateStore
+type stateEntry interface {
+	// stateKey identifies the entry in its store
+	stateKey() string
+	// stateZone is the zone the entry belongs to
+	stateZone() string
+}
+
+// keyedStateStore is a set of entries keyed by stateKey. The entries are kept in a state file in the state directory,
+// if any, as a list sorted by key so that they survive restarts. Zone trackers and queues are built on it.
+type keyedStateStore struct {
+	lock      sync.Mutex
+	stateDir  string
+	stateFile string
+	entries   map[string]stateEntry
+}
+
+// newKeyedStateStore reads the entries saved in stateFile. saved points to an empty slice of the entry type, e.g.
+// &[]*RegistrarWrite{}.
+func newKeyedStateStore(stateDir, stateFile string, saved interface{}) (*keyedStateStore, error) {
+
+	s := &keyedStateStore{stateDir: stateDir, stateFile: stateFile, entries: map[string]stateEntry{}}
+	if err := loadState(stateDir, stateFile, saved); err != nil {
+		return nil, err
+	}
+	list := reflect.ValueOf(saved).Elem()
+	for i := 0; i < list.Len(); i++ {
+		if list.Index(i).IsNil() {
+			continue
+		}
+		entry := list.Index(i).Interface().(stateEntry)
+		s.entries[entry.stateKey()] = entry
+	}
+
+	return s, nil
+}
+
+// get returns the entry of key
+func (s *keyedStateStore) get(key string) (stateEntry, bool) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[key]
+
+	return entry, ok
+}
+
+// add adds entry unless an entry of its key is stored
+func (s *keyedStateStore) add(entry stateEntry) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[entry.stateKey()]; ok {
+		return nil
+	}
+	s.entries[entry.stateKey()] = entry
+
+	return s.save()
+}
+
+// put adds or replaces entry
+func (s *keyedStateStore) put(entry stateEntry) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries[entry.stateKey()] = entry
+
+	return s.save()
+}
+
+// replace replaces the entry of the key of entry. Entries no longer stored are not added back.
+func (s *keyedStateStore) replace(entry stateEntry) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.entries[entry.stateKey()]; !ok {
+		return nil
+	}
+	s.entries[entry.stateKey()] = entry
+
+	return s.save()
+}
+
+// remove drops the entries of keys
+func (s *keyedStateStore) remove(keys ...string) error {
+
+	return s.removeIf(func(entry stateEntry) bool {
+		for _, key := range keys {
+			if entry.stateKey() == key {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// removeIf drops the entries drop returns true for
+func (s *keyedStateStore) removeIf(drop func(entry stateEntry) bool) error {
+
+	return s.change(func(entries map[string]stateEntry) bool {
+		changed := false
+		for key, entry := range entries {
+			if drop(entry) {
+				delete(entries, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// retainZones drops the entries of zones not in zones, e.g. of domains no longer in the registrar
+func (s *keyedStateStore) retainZones(zones []string) error {
+
+	keep := map[string]bool{}
+	for _, zone := range zones {
+		keep[zone] = true
+	}
+
+	return s.removeIf(func(entry stateEntry) bool { return !keep[entry.stateZone()] })
+}
+
+// list returns the entries match returns true for, or all entries if match is nil, sorted by key
+func (s *keyedStateStore) list(match func(entry stateEntry) bool) []stateEntry {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := []stateEntry{}
+	for _, entry := range s.sorted() {
+		if match == nil || match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// change runs fn on the entries while holding the store lock. The store is saved if fn returns true.
+func (s *keyedStateStore) change(fn func(entries map[string]stateEntry) bool) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !fn(s.entries) {
+		return nil
+	}
+
+	return s.save()
+}
+
+func (s *keyedStateStore) sorted() []stateEntry {
+
+	entries := make([]stateEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].stateKey() < entries[j].stateKey() })
+
+	return entries
+}
+
+func (s *keyedStateStore) save() error {
+
+	if s.stateDir == "" {
+		return nil
+	}
+
+	return saveState(s.stateDir, s.stateFile, s.sorted())
+}
+
+// zoneKeys returns the keys of zones of an account in stores keyed by account and zone
+func zoneKeys(account string, zones []string) []string {
+
+	keys := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		keys = append(keys, activationKey(account, zone))
+	}
+
+	return keys
+}
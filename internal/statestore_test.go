@@ -0,0 +1,52 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKeyedStateStore(t *testing.T) {
+
+	dir := t.TempDir()
+	since := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s, err := newKeyedStateStore(dir, "test-state.json", &[]*ZoneActivation{})
+	assert.Nil(t, err)
+	assert.Nil(t, s.put(&ZoneActivation{Account: "acct", Zone: "b.com", State: "NEW", Since: since}))
+	assert.Nil(t, s.add(&ZoneActivation{Account: "acct", Zone: "a.com", State: "NEW", Since: since}))
+	// add keeps stored entries and replace does not add entries
+	assert.Nil(t, s.add(&ZoneActivation{Account: "acct", Zone: "a.com", State: "PENDING", Since: since}))
+	assert.Nil(t, s.replace(&ZoneActivation{Account: "acct", Zone: "c.com", State: "PENDING", Since: since}))
+	_, ok := s.get(activationKey("acct", "c.com"))
+	assert.False(t, ok)
+
+	// entries are saved as a list sorted by key
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test-state.json"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"account":"acct","zone":"a.com","state":"NEW","since":"2021-06-01T00:00:00Z"},{"account":"acct","zone":"b.com","state":"NEW","since":"2021-06-01T00:00:00Z"}]`, string(data))
+
+	assert.Nil(t, s.retainZones([]string{"b.com"}))
+	s, err = newKeyedStateStore(dir, "test-state.json", &[]*ZoneActivation{})
+	assert.Nil(t, err)
+	entries := s.list(nil)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "b.com", entries[0].stateZone())
+	assert.Nil(t, s.remove(activationKey("acct", "b.com")))
+	assert.Equal(t, 0, len(s.list(nil)))
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSyslogNetwork  = "unix"
+	DefaultSyslogAddress  = "/dev/log"
+	DefaultSyslogFacility = "daemon"
+	DefaultSyslogAppName  = "edgedns-registrar-coordinator"
+	// Syslog transports
+	SyslogNetworkUnix = "unix"
+	SyslogNetworkUDP  = "udp"
+	SyslogNetworkTCP  = "tcp"
+	// RFC 5424 structured data ID of entry fields. 32473 is the enterprise number reserved for documentation.
+	syslogFieldsSDID  = "fields@32473"
+	syslogTimeFormat  = "2006-01-02T15:04:05.000000Z07:00"
+	syslogDialTimeout = 5 * time.Second
+)
+
+var (
+	syslogFacilities = map[string]int{
+		"kern":     0,
+		"user":     1,
+		"mail":     2,
+		"daemon":   3,
+		"auth":     4,
+		"syslog":   5,
+		"lpr":      6,
+		"news":     7,
+		"uucp":     8,
+		"cron":     9,
+		"authpriv": 10,
+		"ftp":      11,
+		"local0":   16,
+		"local1":   17,
+		"local2":   18,
+		"local3":   19,
+		"local4":   20,
+		"local5":   21,
+		"local6":   22,
+		"local7":   23,
+	}
+	// escape characters of RFC 5424 SD-PARAM values
+	sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+// SyslogSeverity maps an apex/log level to a syslog severity
+func SyslogSeverity(level log.Level) int {
+
+	switch level {
+	case log.DebugLevel:
+		return 7 // debug
+	case log.InfoLevel:
+		return 6 // informational
+	case log.WarnLevel:
+		return 4 // warning
+	case log.ErrorLevel:
+		return 3 // error
+	case log.FatalLevel:
+		return 2 // critical
+	}
+
+	return 5 // notice
+}
+
+// SyslogFacilities returns the valid syslog facility names
+func SyslogFacilities() []string {
+
+	names := make([]string, 0, len(syslogFacilities))
+	for name := range syslogFacilities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SyslogHandler is an apex/log handler writing RFC 5424 messages to a syslog server over a unix socket, UDP or TCP.
+// Stream transports use octet counting framing (RFC 6587).
+type SyslogHandler struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+	procID   string
+	conn     net.Conn
+	stream   bool
+}
+
+// NewSyslogHandler connects to the syslog server. A unix socket is tried as datagram, then stream socket.
+func NewSyslogHandler(network, address, facility, appName string) (*SyslogHandler, error) {
+
+	fac, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("Invalid syslog facility %q", facility)
+	}
+	switch network {
+	case SyslogNetworkUnix, SyslogNetworkUDP, SyslogNetworkTCP:
+	default:
+		return nil, fmt.Errorf("Invalid syslog network %q", network)
+	}
+	if address == "" {
+		if network != SyslogNetworkUnix {
+			return nil, fmt.Errorf("syslog address required for %s", network)
+		}
+		address = DefaultSyslogAddress
+	}
+	if appName == "" {
+		appName = DefaultSyslogAppName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	h := &SyslogHandler{
+		network:  network,
+		address:  address,
+		facility: fac,
+		appName:  sanitizeSyslogName(appName, 48),
+		hostname: sanitizeSyslogName(hostname, 255),
+		procID:   fmt.Sprint(os.Getpid()),
+	}
+	if err := h.connect(); err != nil {
+		return nil, fmt.Errorf("Unable to connect to syslog %s %s. %s", network, address, err.Error())
+	}
+
+	return h, nil
+}
+
+func (h *SyslogHandler) connect() error {
+
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+	var err error
+	switch h.network {
+	case SyslogNetworkUnix:
+		if h.conn, err = net.DialTimeout("unixgram", h.address, syslogDialTimeout); err == nil {
+			h.stream = false
+			return nil
+		}
+		h.conn, err = net.DialTimeout("unix", h.address, syslogDialTimeout)
+		h.stream = true
+	case SyslogNetworkUDP:
+		h.conn, err = net.DialTimeout("udp", h.address, syslogDialTimeout)
+		h.stream = false
+	default:
+		h.conn, err = net.DialTimeout("tcp", h.address, syslogDialTimeout)
+		h.stream = true
+	}
+
+	return err
+}
+
+// HandleLog writes the entry. On failure, the connection is reestablished and the write retried once.
+func (h *SyslogHandler) HandleLog(e *log.Entry) error {
+
+	msg := h.format(e)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		if err := h.write(msg); err == nil {
+			return nil
+		}
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+
+	return h.write(msg)
+}
+
+func (h *SyslogHandler) write(msg []byte) error {
+
+	if h.stream {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	_, err := h.conn.Write(msg)
+
+	return err
+}
+
+// Close closes the syslog connection
+func (h *SyslogHandler) Close() error {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+
+	return err
+}
+
+// format returns the RFC 5424 message of e. Entry fields are sent as structured data.
+func (h *SyslogHandler) format(e *log.Entry) []byte {
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s - ", h.facility*8+SyslogSeverity(e.Level), e.Timestamp.Format(syslogTimeFormat), h.hostname, h.appName, h.procID)
+	names := e.Fields.Names()
+	if len(names) < 1 {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[" + syslogFieldsSDID)
+		for _, name := range names {
+			fmt.Fprintf(&buf, ` %s="%s"`, sanitizeSyslogName(name, 32), sdValueEscaper.Replace(fmt.Sprint(e.Fields.Get(name))))
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString(" " + e.Message)
+
+	return buf.Bytes()
+}
+
+// sanitizeSyslogName returns name limited to printable ASCII without space, '=', ']' or '"', truncated to max
+func sanitizeSyslogName(name string, max int) string {
+
+	b := []byte(name)
+	for i, c := range b {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	if len(b) < 1 {
+		return "-"
+	}
+
+	return string(b)
+}
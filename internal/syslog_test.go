@@ -0,0 +1,159 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var (
+	// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+	rfc5424Regexp = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\d+) - (-|\[.*\]) (.*)$`)
+)
+
+func testLogger(handler log.Handler) *log.Entry {
+
+	logger := &log.Logger{Handler: handler, Level: log.DebugLevel}
+
+	return log.NewEntry(logger)
+}
+
+// readDatagram returns the next datagram received on pc
+func readDatagram(t *testing.T, pc net.PacketConn) string {
+
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read datagram: %s", err.Error())
+	}
+
+	return string(buf[:n])
+}
+
+func assertSyslogMessage(t *testing.T, msg string, pri int, sd string, text string) {
+
+	match := rfc5424Regexp.FindStringSubmatch(msg)
+	if !assert.NotNil(t, match, msg) {
+		return
+	}
+	assert.Equal(t, fmt.Sprint(pri), match[1])
+	_, err := time.Parse(time.RFC3339Nano, match[2])
+	assert.Nil(t, err)
+	assert.Equal(t, "coordinator-test", match[4])
+	assert.Equal(t, sd, match[6])
+	assert.Equal(t, text, match[7])
+}
+
+func TestSyslogHandlerUDP(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	handler, err := NewSyslogHandler(SyslogNetworkUDP, pc.LocalAddr().String(), "local3", "coordinator-test")
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	logger := testLogger(handler)
+	logger.WithField("zone", "example.com").Warn("zone deferred")
+	// local3 (19) * 8 + warning (4)
+	assertSyslogMessage(t, readDatagram(t, pc), 156, `[fields@32473 zone="example.com"]`, "zone deferred")
+
+	logger.Debug("no fields")
+	assertSyslogMessage(t, readDatagram(t, pc), 159, "-", "no fields")
+
+	logger.WithField("err", `bad "value"]`).Error("failed")
+	assertSyslogMessage(t, readDatagram(t, pc), 155, `[fields@32473 err="bad \"value\"\]"]`, "failed")
+}
+
+func TestSyslogHandlerTCP(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	handler, err := NewSyslogHandler(SyslogNetworkTCP, ln.Addr().String(), "daemon", "coordinator-test")
+	assert.Nil(t, err)
+	defer handler.Close()
+	conn, err := ln.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	logger := testLogger(handler)
+	logger.Info("first")
+	logger.Error("second")
+
+	// octet counted frames
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, expect := range []struct {
+		pri  int
+		text string
+	}{{30, "first"}, {27, "second"}} {
+		var length int
+		_, err := fmt.Fscanf(reader, "%d ", &length)
+		assert.Nil(t, err)
+		frame := make([]byte, length)
+		_, err = io.ReadFull(reader, frame)
+		assert.Nil(t, err)
+		assertSyslogMessage(t, string(frame), expect.pri, "-", expect.text)
+	}
+}
+
+func TestSyslogHandlerUnix(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "log.sock")
+	pc, err := net.ListenPacket("unixgram", path)
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	handler, err := NewSyslogHandler(SyslogNetworkUnix, path, "user", "coordinator-test")
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	testLogger(handler).Info("over unix socket")
+	assertSyslogMessage(t, readDatagram(t, pc), 14, "-", "over unix socket")
+}
+
+func TestSyslogHandlerInvalid(t *testing.T) {
+
+	_, err := NewSyslogHandler(SyslogNetworkUDP, "127.0.0.1:514", "nofacility", "")
+	assert.NotNil(t, err)
+	_, err = NewSyslogHandler(SyslogNetworkTCP, "", "daemon", "")
+	assert.NotNil(t, err)
+	// no silent fallback when the server is not reachable
+	_, err = NewSyslogHandler(SyslogNetworkUnix, filepath.Join(t.TempDir(), "missing.sock"), "daemon", "")
+	assert.NotNil(t, err)
+}
+
+func TestSyslogSeverity(t *testing.T) {
+
+	assert.Equal(t, 7, SyslogSeverity(log.DebugLevel))
+	assert.Equal(t, 6, SyslogSeverity(log.InfoLevel))
+	assert.Equal(t, 4, SyslogSeverity(log.WarnLevel))
+	assert.Equal(t, 3, SyslogSeverity(log.ErrorLevel))
+	assert.Equal(t, 2, SyslogSeverity(log.FatalLevel))
+}
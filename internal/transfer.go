@@ -0,0 +1,323 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	DefaultTransferStatusBatchSize = 100
+	DefaultTransferAlertThreshold  = 24 * time.Hour
+	// Zone transfer health states
+	TransferStateOK      = "OK"
+	TransferStateFailing = "FAILING"           // the last attempt failed. The last success is within the threshold
+	TransferStateOverdue = "OVERDUE"           // no successful transfer within the threshold
+	TransferStateNever   = "NEVER_TRANSFERRED" // no successful transfer yet
+	TransferStatePending = "PENDING"           // no successful transfer yet. The zone awaits create confirmation
+	TransferStateUnknown = "UNKNOWN"           // Edge DNS returned no status
+)
+
+// ZoneTransferHealth is the zone transfer health of a managed secondary zone
+type ZoneTransferHealth struct {
+	Account     string
+	Zone        string
+	State       string
+	Master      string
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Serial      uint32
+	Error       string
+	// Time since the last successful transfer
+	Age time.Duration
+}
+
+// Alert returns true if the zone has not transferred within the alert threshold
+func (h *ZoneTransferHealth) Alert() bool {
+
+	return h.State == TransferStateOverdue || h.State == TransferStateNever || h.State == TransferStateUnknown
+}
+
+// TransferStatus implements the transfer-status sub command. Prints the transfer health of all managed zones once.
+func TransferStatus(ctx context.Context, err chan string, regname string, reg registrar.RegistrarProvider, edge *EdgeDNSHandler, w io.Writer) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering TransferStatus")
+
+	var errmsg string
+
+	edge.refreshCredentials(ctx)
+	accountZones, edgeErr := edge.accountZoneNames(ctx)
+	if edgeErr != nil {
+		log.Errorf("TransferStatus. Failed to read EdgeDNS Secondary zones. Error: %s", edgeErr.Error())
+		err <- "TransferStatus. Failed to read EdgeDNS Secondary zones."
+		return
+	}
+	registrarDomains, regErr := reg.GetDomains(ctx)
+	if regErr != nil {
+		log.Errorf("TransferStatus. Failed to read registrar primary zones. Error: %s", regErr.Error())
+		err <- "TransferStatus. Failed to read registrar primary zones."
+		return
+	}
+
+	results := []*ZoneTransferHealth{}
+	accounts := []string{}
+	for _, account := range edge.Accounts {
+		accounts = append(accounts, account.Name())
+		accountResults, terr := TransferHealth(ctx, edge, account, managedZones(accountZones[account.Name()], registrarDomains), time.Now())
+		if terr != nil {
+			log.Errorf("TransferStatus. Failed to read zone transfer status. Account: %s. Error: %s", account.Name(), terr.Error())
+			err <- "TransferStatus. Failed to read zone transfer status."
+			return
+		}
+		results = append(results, accountResults...)
+	}
+	if werr := WriteTransferHealthReport(w, results); werr != nil {
+		log.Errorf("TransferStatus. Failed to write report. Error: %s", werr.Error())
+	}
+	if alerts := reportTransferHealth(ctx, edge, accounts, results); alerts > 0 {
+		errmsg = fmt.Sprintf("TransferStatus. %d of %d zones have not transferred within %s.", alerts, len(results), edge.TransferAlertThreshold)
+	}
+
+	err <- errmsg
+	return
+}
+
+// zoneTransferStatus retrieves the transfer status of zones of an account in batches of batchSize zones. Statuses are
+// keyed by zone.
+func zoneTransferStatus(ctx context.Context, account *EdgeDNSAccount, zones []string, batchSize int) (map[string]*registrar.EdgeDNSZoneTransferStatus, error) {
+
+	if batchSize < 1 {
+		batchSize = DefaultTransferStatusBatchSize
+	}
+	statuses := map[string]*registrar.EdgeDNSZoneTransferStatus{}
+	for start := 0; start < len(zones); start += batchSize {
+		end := start + batchSize
+		if end > len(zones) {
+			end = len(zones)
+		}
+		batch, err := account.client.GetZoneTransferStatus(ctx, zones[start:end])
+		if err != nil {
+			return statuses, err
+		}
+		for _, status := range batch {
+			statuses[status.Zone] = status
+		}
+	}
+
+	return statuses, nil
+}
+
+// TransferHealth returns the zone transfer health of zones of an account at now, sorted by zone
+func TransferHealth(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string, now time.Time) ([]*ZoneTransferHealth, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("Checking zone transfer status: %v. Account: %s", zones, account.Name())
+
+	statuses, err := zoneTransferStatus(ctx, account, zones, edge.TransferStatusBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	pending := map[string]bool{}
+	for _, zc := range edge.confirmations.checked(true) {
+		if zc.Account == account.Name() {
+			pending[zc.Zone] = true
+		}
+	}
+	results := make([]*ZoneTransferHealth, 0, len(zones))
+	for _, zone := range sortedZoneList(zones) {
+		h := &ZoneTransferHealth{Account: account.Name(), Zone: zone, State: TransferStateUnknown}
+		results = append(results, h)
+		status, ok := statuses[zone]
+		if !ok {
+			continue
+		}
+		h.Master = status.MasterServer
+		h.Serial = status.SerialNumber
+		h.Error = status.LastTransferError
+		h.LastAttempt, _ = status.LastAttempt()
+		h.LastSuccess, _ = status.LastTransfer()
+		switch {
+		case !status.Transferred() && pending[zone]:
+			h.State = TransferStatePending
+		case !status.Transferred():
+			h.State = TransferStateNever
+		default:
+			if !h.LastSuccess.IsZero() {
+				h.Age = now.Sub(h.LastSuccess)
+			}
+			if h.Age > edge.TransferAlertThreshold {
+				h.State = TransferStateOverdue
+			} else if h.Error != "" && h.LastAttempt.After(h.LastSuccess) {
+				h.State = TransferStateFailing
+			} else {
+				h.State = TransferStateOK
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// monitorTransferHealth reports the transfer health of the managed zones of an account
+func monitorTransferHealth(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, zones []string) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	results, err := TransferHealth(ctx, edge, account, zones, time.Now())
+	if err != nil {
+		log.Errorf("Monitor. Failed to read zone transfer status. Account: %s. Error: %s", account.Name(), err.Error())
+		return
+	}
+	reportTransferHealth(ctx, edge, []string{account.Name()}, results)
+}
+
+// transferStatusDue returns true if the transfer status cadence has elapsed since the last check. The check time is
+// recorded.
+func (e *EdgeDNSHandler) transferStatusDue(now time.Time) bool {
+
+	if e.TransferStatusInterval <= 0 || now.Before(e.lastTransferStatus.Add(e.TransferStatusInterval)) {
+		return false
+	}
+	e.lastTransferStatus = now
+
+	return true
+}
+
+// reportTransferHealth logs zone transfer health, exports it as metrics and returns the number of alerting zones.
+// The zone metrics of the checked accounts are replaced, so zones no longer managed drop out.
+func reportTransferHealth(ctx context.Context, edge *EdgeDNSHandler, accounts []string, results []*ZoneTransferHealth) int {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	alerts := 0
+	alertCounts := map[string]int{}
+	for _, account := range accounts {
+		alertCounts[account] = 0
+	}
+	for _, r := range results {
+		alertCounts[r.Account] = 0
+	}
+	for account := range alertCounts {
+		for _, name := range []string{"zone_transfer_last_attempt_timestamp_seconds", "zone_transfer_last_success_timestamp_seconds", "zone_transfer_serial", "zone_transfer_error", "zone_transfer_alert"} {
+			metrics.ResetGauge(name, map[string]string{"account": account})
+		}
+	}
+	for _, r := range results {
+		labels := map[string]string{"account": r.Account, "zone": r.Zone}
+		if !r.LastAttempt.IsZero() {
+			metrics.SetGauge("zone_transfer_last_attempt_timestamp_seconds", "Time of the last zone transfer attempt", labels, float64(r.LastAttempt.Unix()))
+		}
+		if !r.LastSuccess.IsZero() {
+			metrics.SetGauge("zone_transfer_last_success_timestamp_seconds", "Time of the last successful zone transfer", labels, float64(r.LastSuccess.Unix()))
+		}
+		if r.Serial > 0 {
+			metrics.SetGauge("zone_transfer_serial", "SOA serial of the last zone transfer", labels, float64(r.Serial))
+		}
+		// the error text is logged only. As a label it would create a series per distinct error
+		transferError := float64(0)
+		if r.Error != "" {
+			transferError = 1
+		}
+		metrics.SetGauge("zone_transfer_error", "1 if the last zone transfer reported an error, else 0", labels, transferError)
+		alert := float64(0)
+		entry := log.WithFields(transferFields(r))
+		switch {
+		case r.Alert():
+			alert = 1
+			alerts++
+			alertCounts[r.Account]++
+			entry.Errorf("Zone %s has not transferred within %s: %s", r.Zone, edge.TransferAlertThreshold, r.State)
+		case r.State == TransferStateFailing:
+			entry.Warnf("Zone %s transfer failing: %s", r.Zone, r.Error)
+		default:
+			entry.Debugf("Zone %s transfer status %s", r.Zone, r.State)
+		}
+		metrics.SetGauge("zone_transfer_alert", "Managed zone without a successful zone transfer within the alert threshold", labels, alert)
+	}
+	for account, count := range alertCounts {
+		metrics.SetGauge("zone_transfer_alerts", "Managed zones without a successful zone transfer within the alert threshold", map[string]string{"account": account}, float64(count))
+	}
+	log.Infof("Zone transfer status complete. %d zones checked, %d alerts", len(results), alerts)
+
+	return alerts
+}
+
+func transferFields(r *ZoneTransferHealth) log.Fields {
+
+	fields := log.Fields{
+		"account": r.Account,
+		"zone":    r.Zone,
+		"state":   r.State,
+	}
+	if r.Master != "" {
+		fields["master"] = r.Master
+	}
+	if !r.LastAttempt.IsZero() {
+		fields["last_attempt"] = r.LastAttempt.Format(time.RFC3339)
+	}
+	if !r.LastSuccess.IsZero() {
+		fields["last_success"] = r.LastSuccess.Format(time.RFC3339)
+	}
+	if r.Serial > 0 {
+		fields["serial"] = r.Serial
+	}
+	if r.Error != "" {
+		fields["error"] = r.Error
+	}
+
+	return fields
+}
+
+// WriteTransferHealthReport writes a zone transfer health table
+func WriteTransferHealthReport(w io.Writer, results []*ZoneTransferHealth) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tZONE\tSTATE\tMASTER\tLAST ATTEMPT\tLAST SUCCESS\tSERIAL\tERROR")
+	for _, r := range results {
+		serial := "-"
+		if r.Serial > 0 {
+			serial = strconv.FormatUint(uint64(r.Serial), 10)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Account, r.Zone, r.State, reportValue(r.Master), reportTime(r.LastAttempt), reportTime(r.LastSuccess), serial, reportValue(r.Error))
+	}
+
+	return tw.Flush()
+}
+
+func reportTime(t time.Time) string {
+
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func reportValue(s string) string {
+
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
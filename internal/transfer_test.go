@@ -0,0 +1,124 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// transferStatusStub returns the status of requested zones from statuses and records each batch
+type transferStatusStub struct {
+	*EdgednsStub
+	statuses map[string]*registrar.EdgeDNSZoneTransferStatus
+	batches  [][]string
+}
+
+func (ts *transferStatusStub) GetZoneTransferStatus(ctx context.Context, zones []string) ([]*registrar.EdgeDNSZoneTransferStatus, error) {
+
+	ts.batches = append(ts.batches, zones)
+	statuses := []*registrar.EdgeDNSZoneTransferStatus{}
+	for _, zone := range zones {
+		if status, ok := ts.statuses[zone]; ok {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses, nil
+}
+
+func TestTransferHealth(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestTransferHealth"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	now := time.Now()
+	stub := &transferStatusStub{EdgednsStub: stubEdgeDNS, statuses: map[string]*registrar.EdgeDNSZoneTransferStatus{
+		"ok.zone":      {Zone: "ok.zone", MasterServer: "10.0.0.1", LastTransferAttemptDate: now.Add(-time.Hour).Format(time.RFC3339), LastTransferDate: now.Add(-time.Hour).Format(time.RFC3339), SerialNumber: 7},
+		"failing.zone": {Zone: "failing.zone", LastTransferAttemptDate: now.Add(-time.Minute).Format(time.RFC3339), LastTransferDate: now.Add(-time.Hour).Format(time.RFC3339), LastTransferError: "REFUSED"},
+		"overdue.zone": {Zone: "overdue.zone", LastTransferDate: now.Add(-3 * time.Hour).Format(time.RFC3339)},
+		"never.zone":   {Zone: "never.zone", LastTransferError: "TIMEOUT"},
+		"new.zone":     {Zone: "new.zone"},
+	}}
+	config.TransferStatusBatchSize = 2
+	config.TransferAlertThreshold = 2 * time.Hour
+	handler, err := InitEdgeDNSHandler(ctx, &config, stub)
+	assert.Nil(t, err)
+	account := handler.Accounts[0]
+	assert.Nil(t, handler.confirmations.created(account.Name(), "new.zone", now, time.Hour))
+
+	zones := []string{"ok.zone", "failing.zone", "overdue.zone", "never.zone", "new.zone", "missing.zone"}
+	results, err := TransferHealth(ctx, handler, account, zones, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(stub.batches))
+	states := map[string]string{}
+	for _, r := range results {
+		states[r.Zone] = r.State
+	}
+	assert.Equal(t, map[string]string{
+		"ok.zone":      TransferStateOK,
+		"failing.zone": TransferStateFailing,
+		"overdue.zone": TransferStateOverdue,
+		"never.zone":   TransferStateNever,
+		"new.zone":     TransferStatePending,
+		"missing.zone": TransferStateUnknown,
+	}, states)
+	assert.Equal(t, 3, reportTransferHealth(ctx, handler, []string{account.Name()}, results))
+
+	alert, ok := metrics.Gauge("zone_transfer_alert", map[string]string{"account": account.Name(), "zone": "overdue.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), alert)
+	serial, ok := metrics.Gauge("zone_transfer_serial", map[string]string{"account": account.Name(), "zone": "ok.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(7), serial)
+	transferError, ok := metrics.Gauge("zone_transfer_error", map[string]string{"account": account.Name(), "zone": "failing.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), transferError)
+	transferError, ok = metrics.Gauge("zone_transfer_error", map[string]string{"account": account.Name(), "zone": "ok.zone"})
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), transferError)
+
+	// accounts without results are reset
+	assert.Equal(t, 0, reportTransferHealth(ctx, handler, []string{account.Name()}, nil))
+	alerts, ok := metrics.Gauge("zone_transfer_alerts", map[string]string{"account": account.Name()})
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), alerts)
+	_, ok = metrics.Gauge("zone_transfer_alert", map[string]string{"account": account.Name(), "zone": "overdue.zone"})
+	assert.False(t, ok)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteTransferHealthReport(&buf, results))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 7, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "ACCOUNT"))
+	assert.Contains(t, buf.String(), "REFUSED")
+}
+
+func TestTransferStatusDue(t *testing.T) {
+
+	handler := &EdgeDNSHandler{}
+	assert.False(t, handler.transferStatusDue(time.Now()))
+	handler.TransferStatusInterval = time.Hour
+	now := time.Now()
+	assert.True(t, handler.transferStatusDue(now))
+	assert.False(t, handler.transferStatusDue(now.Add(time.Minute)))
+	assert.True(t, handler.transferStatusDue(now.Add(time.Hour)))
+}
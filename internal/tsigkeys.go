@@ -0,0 +1,686 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+	"golang.org/x/crypto/scrypt"
+
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Encrypted TSIG key store in the state directory
+	tsigKeyStoreFile    = "tsig-keys.json"
+	tsigKeyStoreVersion = 1
+	// Generated TSIG key algorithms
+	TsigAlgorithmHmacSHA256 = "hmac-sha256"
+	TsigAlgorithmHmacSHA512 = "hmac-sha512"
+	// Time a rotated key is exported before zones are updated to it
+	DefaultTsigRotationGrace = 24 * time.Hour
+)
+
+// GeneratedTsigKey is a TSIG key generated by the coordinator
+type GeneratedTsigKey struct {
+	Name      string    `json:"name"`
+	Algorithm string    `json:"algorithm"`
+	Secret    string    `json:"secret"`
+	Created   time.Time `json:"created"`
+}
+
+// TSIGKey returns the key in Edge DNS zone format
+func (k *GeneratedTsigKey) TSIGKey() *dns.TSIGKey {
+
+	return &dns.TSIGKey{Name: k.Name, Algorithm: k.Algorithm, Secret: k.Secret}
+}
+
+// TsigKeySet holds the keys of a zone or of a key group
+type TsigKeySet struct {
+	// Zone name, or key group name if Group is set
+	Owner string `json:"owner"`
+	Group bool   `json:"group,omitempty"`
+	// Key of the owner's zones
+	Active *GeneratedTsigKey `json:"active"`
+	// Key generated by a rotation. Replaces the active key once the rotation grace period has passed
+	Pending *GeneratedTsigKey `json:"pending,omitempty"`
+	// Key replaced by the active key. Kept until all zones of the owner use the active key
+	Retired *GeneratedTsigKey `json:"retired,omitempty"`
+}
+
+// TsigKeyGroup shares a TSIG key between the zones matching a pattern
+type TsigKeyGroup struct {
+	Name string
+	// Zone name, wildcard, e.g. *.example.com, or * for all zones
+	Pattern string
+}
+
+// ParseTsigKeyGroup parses a key group spec, GROUP=PATTERN, e.g. corp=*.example.com
+func ParseTsigKeyGroup(spec string) (TsigKeyGroup, error) {
+
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return TsigKeyGroup{}, fmt.Errorf("Invalid TSIG key group %q. Expected GROUP=PATTERN, e.g. corp=*.example.com", spec)
+	}
+
+	return TsigKeyGroup{Name: strings.TrimSpace(parts[0]), Pattern: strings.TrimSpace(parts[1])}, nil
+}
+
+// ParseTsigKeyGroups parses key group specs. Zones are assigned to the first matching group.
+func ParseTsigKeyGroups(specs []string) ([]TsigKeyGroup, error) {
+
+	groups := []TsigKeyGroup{}
+	for _, spec := range specs {
+		group, err := ParseTsigKeyGroup(spec)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// zonePatternMatch returns true if zone matches pattern. Patterns are zone names, wildcards, e.g. *.example.com,
+// matching names below the wildcard domain, or * matching all zones.
+func zonePatternMatch(pattern, zone string) bool {
+
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if pattern == "*" || pattern == zone {
+		return true
+	}
+
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(zone, pattern[1:])
+}
+
+// TsigKeyOptions configures the generation and rotation of TSIG keys
+type TsigKeyOptions struct {
+	Algorithm string
+	Groups    []TsigKeyGroup
+	// Rotation interval. 0 disables rotation
+	RotationInterval time.Duration
+	RotationGrace    time.Duration
+	// named.conf key statements file rewritten on key changes. Optional
+	ExportFile string
+}
+
+// tsigKeyUpdate is a zone whose Edge DNS key differs from the active key of its owner
+type tsigKeyUpdate struct {
+	Account string
+	Zone    string
+	Key     *GeneratedTsigKey
+}
+
+type tsigKeyData struct {
+	// Key sets keyed by owner id
+	Sets map[string]*TsigKeySet `json:"sets"`
+	// Name of the key last set on each zone
+	Applied map[string]*appliedTsigKey `json:"applied"`
+}
+
+type appliedTsigKey struct {
+	Account string `json:"account"`
+	Key     string `json:"key"`
+}
+
+// tsigKeyEnvelope is the on disk format of the key store. Data is encrypted with AES-256-GCM using a key derived from
+// the key store secret with scrypt.
+type tsigKeyEnvelope struct {
+	Version int    `json:"version"`
+	Salt    string `json:"salt"`
+	Nonce   string `json:"nonce"`
+	Data    string `json:"data"`
+}
+
+// tsigKeyStore generates, rotates and persists the TSIG keys of secondary zones
+type tsigKeyStore struct {
+	lock     sync.Mutex
+	stateDir string
+	opts     TsigKeyOptions
+	salt     []byte
+	aead     cipher.AEAD
+	data     tsigKeyData
+}
+
+// initTsigKeyStore opens the key store of the configured state directory. The key store secret may be a secret reference.
+func initTsigKeyStore(ctx context.Context, config *Config) (*tsigKeyStore, error) {
+
+	secret, err := registrar.ResolveSecret(ctx, config.TSigKeystoreSecret)
+	if err != nil {
+		return nil, err
+	}
+	registrar.RegisterSecret(secret)
+	groups, err := ParseTsigKeyGroups(config.TSigKeyGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTsigKeyStore(config.StateDir, secret, TsigKeyOptions{
+		Algorithm:        config.TSigAlgorithm,
+		Groups:           groups,
+		RotationInterval: config.TSigRotationInterval,
+		RotationGrace:    config.TSigRotationGrace,
+		ExportFile:       config.TSigExportFile,
+	})
+}
+
+func newTsigKeyStore(stateDir, secret string, opts TsigKeyOptions) (*tsigKeyStore, error) {
+
+	if secret == "" {
+		return nil, fmt.Errorf("TSIG key store secret is required")
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = TsigAlgorithmHmacSHA256
+	}
+	if opts.RotationGrace <= 0 {
+		opts.RotationGrace = DefaultTsigRotationGrace
+	}
+	s := &tsigKeyStore{
+		stateDir: stateDir,
+		opts:     opts,
+		data:     tsigKeyData{Sets: map[string]*TsigKeySet{}, Applied: map[string]*appliedTsigKey{}},
+	}
+	envelope := tsigKeyEnvelope{}
+	if err := loadState(stateDir, tsigKeyStoreFile, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Data == "" {
+		s.salt = make([]byte, 16)
+		if _, err := rand.Read(s.salt); err != nil {
+			return nil, err
+		}
+		if err := s.initCipher(secret); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if envelope.Version != tsigKeyStoreVersion {
+		return nil, fmt.Errorf("Unsupported TSIG key store version %d", envelope.Version)
+	}
+	var err error
+	if s.salt, err = base64.StdEncoding.DecodeString(envelope.Salt); err != nil {
+		return nil, fmt.Errorf("Invalid TSIG key store salt. %s", err.Error())
+	}
+	if err := s.initCipher(secret); err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(envelope.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TSIG key store nonce. %s", err.Error())
+	}
+	sealed, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TSIG key store data. %s", err.Error())
+	}
+	if len(nonce) != s.aead.NonceSize() {
+		return nil, fmt.Errorf("Invalid TSIG key store nonce")
+	}
+	plain, err := s.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt TSIG key store. Check the key store secret")
+	}
+	if err := json.Unmarshal(plain, &s.data); err != nil {
+		return nil, fmt.Errorf("Invalid TSIG key store. %s", err.Error())
+	}
+	if s.data.Sets == nil {
+		s.data.Sets = map[string]*TsigKeySet{}
+	}
+	if s.data.Applied == nil {
+		s.data.Applied = map[string]*appliedTsigKey{}
+	}
+	for _, set := range s.data.Sets {
+		registerTsigKeySecrets(set)
+	}
+
+	return s, nil
+}
+
+func (s *tsigKeyStore) initCipher(secret string) error {
+
+	key, err := scrypt.Key([]byte(secret), s.salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	s.aead, err = cipher.NewGCM(block)
+
+	return err
+}
+
+// save encrypts and writes the key store and rewrites the export file. Called with the lock held.
+func (s *tsigKeyStore) save() error {
+
+	plain, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	envelope := tsigKeyEnvelope{
+		Version: tsigKeyStoreVersion,
+		Salt:    base64.StdEncoding.EncodeToString(s.salt),
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		Data:    base64.StdEncoding.EncodeToString(s.aead.Seal(nil, nonce, plain, nil)),
+	}
+	if err := saveState(s.stateDir, tsigKeyStoreFile, envelope); err != nil {
+		return err
+	}
+
+	return s.writeExportFile()
+}
+
+// owner returns the owner id and name of the keys of zone
+func (s *tsigKeyStore) owner(zone string) (id string, name string, group bool) {
+
+	for _, g := range s.opts.Groups {
+		if zonePatternMatch(g.Pattern, zone) {
+			return "group:" + g.Name, g.Name, true
+		}
+	}
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+
+	return "zone:" + zone, zone, false
+}
+
+// generate returns a new random key of the configured algorithm named after its owner and creation time
+func (s *tsigKeyStore) generate(owner string, now time.Time) (*GeneratedTsigKey, error) {
+
+	size := 32
+	if s.opts.Algorithm == TsigAlgorithmHmacSHA512 {
+		size = 64
+	}
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key := &GeneratedTsigKey{
+		Name:      fmt.Sprintf("%s-%s", owner, now.UTC().Format("20060102150405")),
+		Algorithm: s.opts.Algorithm,
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		Created:   now,
+	}
+	registrar.RegisterSecret(key.Secret)
+
+	return key, nil
+}
+
+// keySet returns the key set of zone. A missing set is generated and, unless dryrun, saved.
+func (s *tsigKeyStore) keySet(zone string, now time.Time, dryrun bool) (*TsigKeySet, error) {
+
+	id, name, group := s.owner(zone)
+	if set, ok := s.data.Sets[id]; ok {
+		return set, nil
+	}
+	key, err := s.generate(name, now)
+	if err != nil {
+		return nil, err
+	}
+	set := &TsigKeySet{Owner: name, Group: group, Active: key}
+	if dryrun {
+		return set, nil
+	}
+	s.data.Sets[id] = set
+
+	return set, s.save()
+}
+
+// zoneKey returns the active key of zone. Zones without keys get a new key.
+func (s *tsigKeyStore) zoneKey(zone string, now time.Time, dryrun bool) (*GeneratedTsigKey, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	set, err := s.keySet(zone, now, dryrun)
+	if err != nil {
+		return nil, err
+	}
+
+	return set.Active, nil
+}
+
+// applied records the key set on a zone
+func (s *tsigKeyStore) applied(account, zone, key string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Applied[zone] = &appliedTsigKey{Account: account, Key: key}
+	id, _, _ := s.owner(zone)
+	if set, ok := s.data.Sets[id]; ok && set.Retired != nil && s.activeApplied(id) {
+		set.Retired = nil
+	}
+
+	return s.save()
+}
+
+// activeApplied returns whether all zones of the key set with owner id have the active key applied. Called with the
+// lock held.
+func (s *tsigKeyStore) activeApplied(id string) bool {
+
+	set := s.data.Sets[id]
+	for zone, applied := range s.data.Applied {
+		if zid, _, _ := s.owner(zone); zid == id && applied.Key != set.Active.Name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rotate generates pending keys of key sets due for rotation, activates pending keys past the grace period and drops
+// retired keys no zone uses anymore. A key set is not rotated again while it has a retired key.
+// Returns the owners of rotated key sets.
+func (s *tsigKeyStore) rotate(now time.Time) ([]string, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	changed := []string{}
+	retired := false
+	for _, id := range s.setIds() {
+		set := s.data.Sets[id]
+		switch {
+		case set.Retired != nil:
+			if s.activeApplied(id) {
+				set.Retired = nil
+				retired = true
+			}
+			continue
+		case set.Pending != nil && !now.Before(set.Pending.Created.Add(s.opts.RotationGrace)):
+			set.Retired, set.Active, set.Pending = set.Active, set.Pending, nil
+		case set.Pending == nil && s.opts.RotationInterval > 0 && !now.Before(set.Active.Created.Add(s.opts.RotationInterval)):
+			key, err := s.generate(set.Owner, now)
+			if err != nil {
+				return changed, err
+			}
+			set.Pending = key
+		default:
+			continue
+		}
+		changed = append(changed, set.Owner)
+	}
+	if len(changed) < 1 && !retired {
+		return changed, nil
+	}
+
+	return changed, s.save()
+}
+
+// updates returns the zones whose applied key is not the active key of their owner
+func (s *tsigKeyStore) updates(now time.Time) ([]*tsigKeyUpdate, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	updates := []*tsigKeyUpdate{}
+	for _, zone := range s.appliedZones() {
+		applied := s.data.Applied[zone]
+		// key groups may have changed since the key was applied
+		set, err := s.keySet(zone, now, false)
+		if err != nil {
+			return updates, err
+		}
+		if applied.Key != set.Active.Name {
+			updates = append(updates, &tsigKeyUpdate{Account: applied.Account, Zone: zone, Key: set.Active})
+		}
+	}
+
+	return updates, nil
+}
+
+// retain drops the applied keys and the zone key sets of zones not in zones
+func (s *tsigKeyStore) retain(zones []string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keep := map[string]bool{}
+	for _, z := range zones {
+		keep[strings.ToLower(strings.TrimSuffix(z, "."))] = true
+	}
+	changed := false
+	for zone := range s.data.Applied {
+		if !keep[strings.ToLower(strings.TrimSuffix(zone, "."))] {
+			delete(s.data.Applied, zone)
+			changed = true
+		}
+	}
+	for id, set := range s.data.Sets {
+		if !set.Group && !keep[set.Owner] {
+			delete(s.data.Sets, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	return s.save()
+}
+
+// export writes the active, pending and retired keys as named.conf key statements
+func (s *tsigKeyStore) export(w io.Writer) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.writeKeys(w)
+}
+
+func (s *tsigKeyStore) writeKeys(w io.Writer) error {
+
+	for _, id := range s.setIds() {
+		set := s.data.Sets[id]
+		owner := "zone " + set.Owner
+		if set.Group {
+			owner = "key group " + set.Owner
+		}
+		if err := writeKeyStatement(w, set.Active, fmt.Sprintf("%s, active", owner)); err != nil {
+			return err
+		}
+		if set.Pending != nil {
+			if err := writeKeyStatement(w, set.Pending, fmt.Sprintf("%s, pending. Active from %s", owner, set.Pending.Created.Add(s.opts.RotationGrace).UTC().Format(time.RFC3339))); err != nil {
+				return err
+			}
+		}
+		if set.Retired != nil {
+			if err := writeKeyStatement(w, set.Retired, fmt.Sprintf("%s, retired. Kept until all zones use the active key", owner)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeExportFile replaces the export file, if any, with the current keys. Called with the lock held.
+func (s *tsigKeyStore) writeExportFile() error {
+
+	if s.opts.ExportFile == "" {
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	if err := s.writeKeys(buf); err != nil {
+		return err
+	}
+	dir, name := filepath.Split(s.opts.ExportFile)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return fmt.Errorf("Unable to write TSIG key export file %s. %s", s.opts.ExportFile, err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(DefaultStateFileMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to write TSIG key export file %s. %s", s.opts.ExportFile, err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.opts.ExportFile)
+}
+
+func (s *tsigKeyStore) setIds() []string {
+
+	ids := []string{}
+	for id := range s.data.Sets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (s *tsigKeyStore) appliedZones() []string {
+
+	zones := []string{}
+	for zone := range s.data.Applied {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	return zones
+}
+
+func writeKeyStatement(w io.Writer, key *GeneratedTsigKey, comment string) error {
+
+	_, err := fmt.Fprintf(w, "// %s\nkey \"%s.\" {\n\talgorithm %s;\n\tsecret \"%s\";\n};\n", comment, key.Name, key.Algorithm, key.Secret)
+
+	return err
+}
+
+func registerTsigKeySecrets(set *TsigKeySet) {
+
+	if set.Active != nil {
+		registrar.RegisterSecret(set.Active.Secret)
+	}
+	if set.Pending != nil {
+		registrar.RegisterSecret(set.Pending.Secret)
+	}
+	if set.Retired != nil {
+		registrar.RegisterSecret(set.Retired.Secret)
+	}
+}
+
+// generatedTsigKey returns the coordinator managed key of a zone to be created, or nil on error
+func generatedTsigKey(ctx context.Context, edge *EdgeDNSHandler, zone string, dryrun bool) *dns.TSIGKey {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	key, err := edge.tsigKeys.zoneKey(zone, time.Now(), dryrun)
+	if err != nil {
+		log.Errorf("Unable to generate TSIG key of zone %s. Error: %s", zone, err.Error())
+		return nil
+	}
+
+	return key.TSIGKey()
+}
+
+// rotateTsigKeys rotates coordinator managed keys due for rotation and updates the Edge DNS keys of zones whose key
+// differs from the active key of their owner. Failed updates are retried the next interval.
+func rotateTsigKeys(ctx context.Context, edge *EdgeDNSHandler, dryrun bool) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if dryrun {
+		log.Debug("TSIG key rotation skipped. dry run")
+		return nil
+	}
+	rotated, err := edge.tsigKeys.rotate(time.Now())
+	for _, owner := range rotated {
+		log.Infof("Rotated TSIG key of %s", owner)
+	}
+	if err != nil {
+		return err
+	}
+	updates, err := edge.tsigKeys.updates(time.Now())
+	if err != nil {
+		return err
+	}
+	pending := map[string]int{}
+	for _, account := range edge.Accounts {
+		pending[account.Name()] = 0
+	}
+	for _, u := range updates {
+		account := edge.account(u.Account)
+		if account == nil {
+			log.Warnf("Zone %s TSIG key not updated. Unknown account: %s", u.Zone, u.Account)
+			continue
+		}
+		if uerr := account.client.UpdateZoneKey(ctx, u.Zone, u.Key.TSIGKey()); uerr != nil {
+			pending[u.Account]++
+			log.Errorf("Failed to update TSIG key of zone %s. Error: %s", u.Zone, uerr.Error())
+			continue
+		}
+		if aerr := edge.tsigKeys.applied(u.Account, u.Zone, u.Key.Name); aerr != nil {
+			log.Errorf("Unable to save zone %s TSIG key. Error: %s", u.Zone, aerr.Error())
+		}
+		log.Infof("Updated TSIG key of zone %s to %s", u.Zone, u.Key.Name)
+	}
+	for account, count := range pending {
+		metrics.SetGauge("pending_tsig_key_updates", "Zones whose Edge DNS TSIG key is not yet updated to the active key", map[string]string{"account": account}, float64(count))
+	}
+
+	return nil
+}
+
+// ExportTsigKeys implements the export-tsig-keys sub command. Writes the coordinator managed keys as named.conf key
+// statements.
+func ExportTsigKeys(ctx context.Context, err chan string, edge *EdgeDNSHandler, w io.Writer) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering ExportTsigKeys")
+
+	if edge.tsigKeys == nil {
+		err <- "ExportTsigKeys. TSIG key generation is not enabled."
+		return
+	}
+	if werr := edge.tsigKeys.export(w); werr != nil {
+		log.Errorf("ExportTsigKeys. Failed to write keys. Error: %s", werr.Error())
+		err <- "ExportTsigKeys. Failed to write keys."
+		return
+	}
+
+	err <- ""
+	return
+}
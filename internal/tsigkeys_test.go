@@ -0,0 +1,188 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTsigKeyGroups(t *testing.T) {
+
+	groups, err := ParseTsigKeyGroups([]string{"corp=*.example.com", " all = * "})
+	assert.Nil(t, err)
+	assert.Equal(t, []TsigKeyGroup{{Name: "corp", Pattern: "*.example.com"}, {Name: "all", Pattern: "*"}}, groups)
+
+	_, err = ParseTsigKeyGroups([]string{"corp"})
+	assert.NotNil(t, err)
+	_, err = ParseTsigKeyGroups([]string{"=*.example.com"})
+	assert.NotNil(t, err)
+
+	assert.True(t, zonePatternMatch("*", "any.zone"))
+	assert.True(t, zonePatternMatch("*.Example.com.", "sub.example.com"))
+	assert.False(t, zonePatternMatch("*.example.com", "example.com"))
+	assert.True(t, zonePatternMatch("example.com", "EXAMPLE.com."))
+}
+
+func TestTsigKeyStore(t *testing.T) {
+
+	dir := t.TempDir()
+	opts := TsigKeyOptions{Algorithm: TsigAlgorithmHmacSHA512, Groups: []TsigKeyGroup{{Name: "corp", Pattern: "*.example.com"}}}
+	store, err := newTsigKeyStore(dir, "passphrase", opts)
+	assert.Nil(t, err)
+	now := time.Now()
+
+	key, err := store.zoneKey("test.zone", now, false)
+	assert.Nil(t, err)
+	assert.Equal(t, TsigAlgorithmHmacSHA512, key.Algorithm)
+	assert.True(t, strings.HasPrefix(key.Name, "test.zone-"))
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, len(secret))
+	// zones of a group share a key
+	a, err := store.zoneKey("a.example.com", now, false)
+	assert.Nil(t, err)
+	b, err := store.zoneKey("b.example.com", now, false)
+	assert.Nil(t, err)
+	assert.Equal(t, a, b)
+	assert.True(t, strings.HasPrefix(a.Name, "corp-"))
+	// dry run keys are not saved
+	_, err = store.zoneKey("dryrun.zone", now, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(store.data.Sets))
+
+	// keys are encrypted at rest
+	data, err := ioutil.ReadFile(filepath.Join(dir, tsigKeyStoreFile))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), key.Secret)
+	assert.NotContains(t, string(data), "test.zone")
+
+	reopened, err := newTsigKeyStore(dir, "passphrase", opts)
+	assert.Nil(t, err)
+	same, err := reopened.zoneKey("test.zone", now, false)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Secret, same.Secret)
+	_, err = newTsigKeyStore(dir, "wrong", opts)
+	assert.NotNil(t, err)
+
+	// removed zones lose their keys, groups keep theirs
+	assert.Nil(t, store.retain([]string{"a.example.com"}))
+	assert.Equal(t, 1, len(store.data.Sets))
+}
+
+func TestTsigKeyRotation(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestTsigKeyRotation"))
+	_, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.TSigGenerate = true
+	config.TSigKeystoreSecret = "passphrase"
+	config.TSigRotationInterval = time.Hour
+	config.TSigExportFile = filepath.Join(t.TempDir(), "tsig.conf")
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+	store := handler.tsigKeys
+	account := handler.Accounts[0]
+
+	created := time.Now().Add(-2 * time.Hour)
+	key, err := store.zoneKey("test.zone", created, false)
+	assert.Nil(t, err)
+	assert.Nil(t, store.applied(account.Name(), "test.zone", key.Name))
+
+	// a rotation generates a pending key which is exported before it is applied
+	rotated, err := store.rotate(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test.zone"}, rotated)
+	pending := store.data.Sets["zone:test.zone"].Pending
+	assert.NotNil(t, pending)
+	updates, err := store.updates(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(updates))
+	exported, err := ioutil.ReadFile(config.TSigExportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(exported), pending.Secret)
+	assert.Contains(t, string(exported), key.Secret)
+
+	// the pending key becomes active after the grace period
+	rotated, err = store.rotate(time.Now().Add(DefaultTsigRotationGrace))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test.zone"}, rotated)
+	assert.Equal(t, pending, store.data.Sets["zone:test.zone"].Active)
+	assert.Equal(t, key, store.data.Sets["zone:test.zone"].Retired)
+
+	// failed updates are retried and the retired key stays exported
+	stubEdgeDNS.FuncErrors["UpdateZoneKey"] = "Edge DNS unavailable"
+	assert.Nil(t, rotateTsigKeys(ctx, handler, false))
+	assert.Equal(t, key.Name, store.data.Applied["test.zone"].Key)
+	count, ok := metrics.Gauge("pending_tsig_key_updates", map[string]string{"account": account.Name()})
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), count)
+	assert.Equal(t, key, store.data.Sets["zone:test.zone"].Retired)
+	exported, err = ioutil.ReadFile(config.TSigExportFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(exported), key.Secret)
+	assert.Contains(t, string(exported), pending.Secret)
+	// no new rotation while the retired key is in use
+	rotated, err = store.rotate(time.Now().Add(2 * time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rotated))
+	assert.Nil(t, store.data.Sets["zone:test.zone"].Pending)
+
+	delete(stubEdgeDNS.FuncErrors, "UpdateZoneKey")
+	assert.Nil(t, rotateTsigKeys(ctx, handler, false))
+	assert.Equal(t, pending.Name, store.data.Applied["test.zone"].Key)
+	assert.Equal(t, pending.Secret, stubEdgeDNS.FuncOutput["UpdateZoneKey"].(map[string]*dns.TSIGKey)["test.zone"].Secret)
+	// the retired key is dropped once all zones use the active key
+	assert.Nil(t, store.data.Sets["zone:test.zone"].Retired)
+	exported, err = ioutil.ReadFile(config.TSigExportFile)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(exported), key.Secret)
+	assert.Contains(t, string(exported), pending.Secret)
+}
+
+func TestGeneratedTsigKeyCreate(t *testing.T) {
+
+	ctx := context.WithValue(context.TODO(), "appLog", log.WithField("subcommand", "TestGeneratedTsigKeyCreate"))
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	config.StateDir = t.TempDir()
+	config.TSigGenerate = true
+	config.TSigKeystoreSecret = "passphrase"
+	handler, err := InitEdgeDNSHandler(ctx, &config, stubEdgeDNS)
+	assert.Nil(t, err)
+
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"new.zone"}, true))
+	assert.Equal(t, 0, len(handler.tsigKeys.data.Applied))
+
+	assert.Nil(t, addSecondaryZones(ctx, handler, stubRegistrar, []string{"new.zone"}, false))
+	key, err := handler.tsigKeys.zoneKey("new.zone", time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Name, handler.tsigKeys.data.Applied["new.zone"].Key)
+
+	buf := &bytes.Buffer{}
+	cmderr := make(chan string, 1)
+	ExportTsigKeys(ctx, cmderr, handler, buf)
+	assert.Equal(t, "", <-cmderr)
+	assert.Contains(t, buf.String(), "key \""+key.Name+".\" {\n\talgorithm hmac-sha256;\n\tsecret \""+key.Secret+"\";\n};\n")
+}
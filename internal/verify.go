@@ -0,0 +1,358 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultVerifyThreshold = time.Hour
+	// Zone serial verification states
+	SerialStateOK                = "OK"
+	SerialStateMismatch          = "MISMATCH"
+	SerialStateStale             = "STALE"
+	SerialStateMasterUnreachable = "MASTER_UNREACHABLE"
+	SerialStateNotServed         = "NOT_SERVED"
+	SerialStateNoMasters         = "NO_MASTERS"
+)
+
+var (
+	// track when a serial mismatch was first seen for a zone, keyed by account and zone
+	serialMismatchSince = map[string]map[string]time.Time{}
+	serialMismatchLock  = &sync.Mutex{}
+)
+
+// ZoneSerialStatus is the result of comparing a zone's SOA serial on its masters and on the Edge DNS name servers
+type ZoneSerialStatus struct {
+	Zone                   string
+	State                  string
+	MasterSerials          map[string]uint32
+	EdgeSerials            map[string]uint32
+	UnreachableMasters     []string
+	UnreachableNameServers []string
+	MismatchSince          time.Time
+	// Why the zone's masters are unknown
+	Error string
+}
+
+// Problem returns true if the status should be reported as a failure
+func (s *ZoneSerialStatus) Problem() bool {
+
+	return s.State != SerialStateOK && s.State != SerialStateMismatch
+}
+
+// Verify implements the verify sub command. Compares SOA serials for all managed zones once.
+func Verify(ctx context.Context, err chan string, regname string, reg registrar.RegistrarProvider, edge *EdgeDNSHandler) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering Verify")
+
+	var errmsg string
+
+	edge.refreshCredentials(ctx)
+	accountZones, edgeErr := edge.accountZoneNames(ctx)
+	if edgeErr != nil {
+		log.Errorf("Verify. Failed to read EdgeDNS Secondary zones. Error: %s", edgeErr.Error())
+		err <- "Verify. Failed to read EdgeDNS Secondary zones."
+		return
+	}
+	registrarDomains, regErr := reg.GetDomains(ctx)
+	if regErr != nil {
+		log.Errorf("Verify. Failed to read registrar primary zones. Error: %s", regErr.Error())
+		err <- "Verify. Failed to read registrar primary zones."
+		return
+	}
+
+	results := []*ZoneSerialStatus{}
+	for _, account := range edge.Accounts {
+		accountResults, verr := VerifyAccountZones(ctx, edge, account, reg, managedZones(accountZones[account.Name()], registrarDomains))
+		if verr != nil {
+			log.Errorf("Verify. Failed to verify zone serials. Account: %s. Error: %s", account.Name(), verr.Error())
+			err <- "Verify. Failed to verify zone serials."
+			return
+		}
+		results = append(results, accountResults...)
+	}
+	if failed := reportZoneSerialStatus(ctx, results); failed > 0 {
+		errmsg = fmt.Sprintf("Verify. %d of %d zones failed serial verification.", failed, len(results))
+	}
+
+	err <- errmsg
+	return
+}
+
+// managedZones returns the Edge DNS secondary zones that are also present in the registrar domain list
+func managedZones(edgeZones, registrarDomains []string) []string {
+
+	return sortedIntersection(sortedZoneList(edgeZones), sortedZoneList(registrarDomains))
+}
+
+// VerifyZones compares the SOA serial of each zone on its masters with the serial served by the Edge DNS name servers
+// of the first account.
+func VerifyZones(ctx context.Context, edge *EdgeDNSHandler, reg registrar.RegistrarProvider, zones []string) ([]*ZoneSerialStatus, error) {
+
+	return VerifyAccountZones(ctx, edge, edge.Accounts[0], reg, zones)
+}
+
+// VerifyAccountZones compares the SOA serial of each zone of an account on its masters with the serial served by the
+// account's Edge DNS name servers.
+func VerifyAccountZones(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, reg registrar.RegistrarProvider, zones []string) ([]*ZoneSerialStatus, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debugf("Verifying zones: %v", zones)
+
+	results := []*ZoneSerialStatus{}
+	if len(zones) < 1 {
+		return results, nil
+	}
+	nameservers, err := account.client.GetNameServers(ctx, account.Contract)
+	if err != nil {
+		log.Errorf("Unable to retrieve Edge DNS name servers. Error: %s", err.Error())
+		return results, err
+	}
+	if len(nameservers) < 1 {
+		return results, fmt.Errorf("No Edge DNS name servers found for contract %s", account.Contract)
+	}
+
+	pruneSerialMismatches(account.Name(), zones)
+	var registrarMasters []string
+	var registrarMastersErr error
+	for _, zone := range zones {
+		var masters []string
+		if dom, err := reg.GetDomain(ctx, zone); err == nil && dom != nil && len(dom.Masters) > 0 {
+			masters = dom.Masters
+		} else {
+			if registrarMasters == nil && registrarMastersErr == nil {
+				registrarMasters, registrarMastersErr = reg.GetMasterIPs(ctx)
+				if registrarMastersErr != nil {
+					log.Errorf("Unable to retrieve master Ips. Error: %s", registrarMastersErr.Error())
+				}
+			}
+			if registrarMastersErr != nil {
+				results = append(results, &ZoneSerialStatus{Zone: zone, State: SerialStateNoMasters, Error: registrarMastersErr.Error()})
+				continue
+			}
+			masters = registrarMasters
+		}
+		masters, err = filterMasters(masters, edge.MasterAddressFamily)
+		if err != nil {
+			log.Errorf("Zone %s has invalid master Ips. Error: %s", zone, err.Error())
+			results = append(results, &ZoneSerialStatus{Zone: zone, State: SerialStateNoMasters, Error: err.Error()})
+			continue
+		}
+		results = append(results, verifyZone(ctx, edge, account.Name(), zone, masters, nameservers))
+	}
+
+	return results, nil
+}
+
+// pruneSerialMismatches drops the mismatches of an account's zones not in zones, e.g. of zones no longer managed
+func pruneSerialMismatches(account string, zones []string) {
+
+	serialMismatchLock.Lock()
+	defer serialMismatchLock.Unlock()
+
+	keep := map[string]bool{}
+	for _, zone := range zones {
+		keep[zone] = true
+	}
+	for zone := range serialMismatchSince[account] {
+		if !keep[zone] {
+			delete(serialMismatchSince[account], zone)
+		}
+	}
+}
+
+// serialNewer returns true if serial s1 is newer than s2 in RFC 1982 serial number arithmetic, so that a serial that
+// wrapped around 2^32 is newer than the serials before the wrap
+func serialNewer(s1, s2 uint32) bool {
+
+	return s1 != s2 && int32(s1-s2) > 0
+}
+
+// querySerial queries each address of server until one answers
+func querySerial(ctx context.Context, client DNSQueryService, zone string, server string) (uint32, error) {
+
+	addrs, err := client.LookupHost(ctx, server)
+	if err != nil {
+		return 0, err
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		serial, err := client.GetSOASerial(ctx, zone, addr)
+		if err == nil {
+			return serial, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for %s", server)
+	}
+
+	return 0, lastErr
+}
+
+func verifyZone(ctx context.Context, edge *EdgeDNSHandler, account string, zone string, masters []string, nameservers []string) *ZoneSerialStatus {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	status := &ZoneSerialStatus{
+		Zone:          zone,
+		MasterSerials: map[string]uint32{},
+		EdgeSerials:   map[string]uint32{},
+	}
+	for _, m := range masters {
+		serial, err := querySerial(ctx, edge.dnsclient, zone, m)
+		if err != nil {
+			log.Debugf("Zone %s master %s SOA query failed. %s", zone, m, err.Error())
+			status.UnreachableMasters = append(status.UnreachableMasters, m)
+			continue
+		}
+		status.MasterSerials[m] = serial
+	}
+	for _, ns := range nameservers {
+		serial, err := querySerial(ctx, edge.dnsclient, zone, ns)
+		if err != nil {
+			log.Debugf("Zone %s name server %s SOA query failed. %s", zone, ns, err.Error())
+			status.UnreachableNameServers = append(status.UnreachableNameServers, ns)
+			continue
+		}
+		status.EdgeSerials[ns] = serial
+	}
+
+	serialMismatchLock.Lock()
+	defer serialMismatchLock.Unlock()
+
+	switch {
+	case len(status.MasterSerials) < 1:
+		status.State = SerialStateMasterUnreachable
+		return status
+	case len(status.EdgeSerials) < 1:
+		status.State = SerialStateNotServed
+		return status
+	}
+	// the newest master serial
+	first := true
+	var masterSerial uint32
+	for _, s := range status.MasterSerials {
+		if first || serialNewer(s, masterSerial) {
+			masterSerial = s
+			first = false
+		}
+	}
+	mismatch := false
+	for _, s := range status.EdgeSerials {
+		if s != masterSerial {
+			mismatch = true
+		}
+	}
+	if !mismatch {
+		delete(serialMismatchSince[account], zone)
+		status.State = SerialStateOK
+		return status
+	}
+	since, ok := serialMismatchSince[account][zone]
+	if !ok {
+		since = time.Now()
+		if serialMismatchSince[account] == nil {
+			serialMismatchSince[account] = map[string]time.Time{}
+		}
+		serialMismatchSince[account][zone] = since
+	}
+	status.MismatchSince = since
+	if time.Since(since) >= edge.VerifyThreshold {
+		status.State = SerialStateStale
+	} else {
+		status.State = SerialStateMismatch
+	}
+
+	return status
+}
+
+// reportZoneSerialStatus logs the verification results. Returns the number of zones with problems.
+func reportZoneSerialStatus(ctx context.Context, results []*ZoneSerialStatus) int {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	failed := 0
+	for _, r := range results {
+		entry := log.WithFields(mapFields(r))
+		switch {
+		case r.Problem():
+			failed++
+			entry.Warnf("Zone %s failed serial verification: %s", r.Zone, r.State)
+		case r.State == SerialStateMismatch:
+			entry.Infof("Zone %s serial mismatch within threshold", r.Zone)
+		default:
+			entry.Debugf("Zone %s serial verified", r.Zone)
+		}
+	}
+	log.Infof("Serial verification complete. %d zones verified, %d failed", len(results), failed)
+
+	return failed
+}
+
+func mapFields(r *ZoneSerialStatus) log.Fields {
+
+	fields := log.Fields{
+		"zone":  r.Zone,
+		"state": r.State,
+	}
+	if len(r.MasterSerials) > 0 {
+		fields["master_serials"] = serialsString(r.MasterSerials)
+	}
+	if len(r.EdgeSerials) > 0 {
+		fields["edge_serials"] = serialsString(r.EdgeSerials)
+	}
+	if len(r.UnreachableMasters) > 0 {
+		fields["unreachable_masters"] = r.UnreachableMasters
+	}
+	if len(r.UnreachableNameServers) > 0 {
+		fields["unreachable_nameservers"] = r.UnreachableNameServers
+	}
+	if !r.MismatchSince.IsZero() {
+		fields["mismatch_since"] = r.MismatchSince.Format(time.RFC3339)
+	}
+	if r.Error != "" {
+		fields["error"] = r.Error
+	}
+
+	return fields
+}
+
+func serialsString(serials map[string]uint32) string {
+
+	servers := make([]string, 0, len(serials))
+	for s := range serials {
+		servers = append(servers, s)
+	}
+	sort.Strings(servers)
+	str := ""
+	for i, s := range servers {
+		if i > 0 {
+			str += " "
+		}
+		str += fmt.Sprintf("%s=%d", s, serials[s])
+	}
+
+	return str
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/akamai/edgedns-registrar-coordinator/registrar"
+	"github.com/apex/log"
+	miekg "github.com/miekg/dns"
+
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// DNS query stub. Serials and errors are indexed by server, delegations by domain
+type DNSQueryStub struct {
+	Serials     map[string]uint32
+	Errors      map[string]string
+	ProbeErrors map[string]string
+	Delegations map[string][]string
+}
+
+func newDNSQueryStub() *DNSQueryStub {
+
+	return &DNSQueryStub{
+		Serials:     map[string]uint32{},
+		Errors:      map[string]string{},
+		ProbeErrors: map[string]string{},
+		Delegations: map[string][]string{},
+	}
+}
+
+func (ds *DNSQueryStub) GetSOASerial(ctx context.Context, zone string, server string) (uint32, error) {
+
+	if errmsg, ok := ds.Errors[server]; ok {
+		return 0, fmt.Errorf(errmsg)
+	}
+	serial, ok := ds.Serials[server]
+	if !ok {
+		return 0, fmt.Errorf("GetSOASerial expected output. Got none")
+	}
+
+	return serial, nil
+}
+
+func (ds *DNSQueryStub) LookupHost(ctx context.Context, host string) ([]string, error) {
+
+	return []string{host}, nil
+}
+
+func (ds *DNSQueryStub) ProbeTransfer(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey, ixfr bool) error {
+
+	if errmsg, ok := ds.ProbeErrors[server]; ok {
+		return fmt.Errorf(errmsg)
+	}
+
+	return nil
+}
+
+func (ds *DNSQueryStub) TransferZone(ctx context.Context, zone string, server string, tsigKey *dns.TSIGKey) ([]miekg.RR, error) {
+
+	return nil, fmt.Errorf("TransferZone expected output. Got none")
+}
+
+func (ds *DNSQueryStub) GetDelegation(ctx context.Context, domain string) ([]string, error) {
+
+	nameservers, ok := ds.Delegations[domain]
+	if !ok {
+		return nil, fmt.Errorf("GetDelegation expected output. Got none")
+	}
+
+	return nameservers, nil
+}
+
+func initVerifyTest(t *testing.T, name string) (context.Context, StubRegistrar, *EdgeDNSHandler, *DNSQueryStub) {
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test",
+		"subcommand": name,
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	stubRegistrar, stubEdgeDNS, config := initStubs(ctx)
+	stubEdgeDNS.FuncOutput["GetNameServers"] = []string{"a1-1.akam.net", "a2-2.akam.net"}
+	handler := initEdgeDNSStubHandler(ctx, stubEdgeDNS, config)
+	dnsStub := newDNSQueryStub()
+	handler.dnsclient = dnsStub
+	serialMismatchSince = map[string]map[string]time.Time{}
+
+	return ctx, stubRegistrar, handler, dnsStub
+}
+
+func TestVerifyZonesOK(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesOK")
+	for _, s := range []string{"1.2.3.4", "5.6.7.8", "a1-1.akam.net", "a2-2.akam.net"} {
+		dnsStub.Serials[s] = 2021050101
+	}
+
+	results, err := VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, SerialStateOK, results[0].State)
+	assert.Equal(t, 0, reportZoneSerialStatus(ctx, results))
+}
+
+func TestVerifyZonesMismatch(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesMismatch")
+	dnsStub.Serials["1.2.3.4"] = 2021050102
+	dnsStub.Serials["5.6.7.8"] = 2021050102
+	dnsStub.Serials["a1-1.akam.net"] = 2021050102
+	dnsStub.Serials["a2-2.akam.net"] = 2021050101
+
+	results, err := VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateMismatch, results[0].State)
+	assert.False(t, results[0].Problem())
+
+	// mismatch older than threshold is stale
+	handler.VerifyThreshold = time.Nanosecond
+	results, err = VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateStale, results[0].State)
+	assert.Equal(t, 1, reportZoneSerialStatus(ctx, results))
+}
+
+func TestVerifyZonesUnreachable(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesUnreachable")
+	dnsStub.Errors["1.2.3.4"] = "timeout"
+	dnsStub.Errors["5.6.7.8"] = "timeout"
+	dnsStub.Serials["a1-1.akam.net"] = 1
+	dnsStub.Serials["a2-2.akam.net"] = 1
+
+	results, err := VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateMasterUnreachable, results[0].State)
+	assert.Equal(t, []string{"1.2.3.4", "5.6.7.8"}, results[0].UnreachableMasters)
+
+	dnsStub.Errors = map[string]string{"a1-1.akam.net": "REFUSED", "a2-2.akam.net": "REFUSED"}
+	dnsStub.Serials["1.2.3.4"] = 1
+	dnsStub.Serials["5.6.7.8"] = 1
+	results, err = VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateNotServed, results[0].State)
+}
+
+func TestVerifyZonesSerialWrap(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesSerialWrap")
+	// the master that wrapped around is newer than the one that did not
+	dnsStub.Serials["1.2.3.4"] = 4294967295
+	dnsStub.Serials["5.6.7.8"] = 1
+	dnsStub.Serials["a1-1.akam.net"] = 1
+	dnsStub.Serials["a2-2.akam.net"] = 1
+
+	results, err := VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateOK, results[0].State)
+	assert.True(t, serialNewer(1, 4294967295))
+	assert.False(t, serialNewer(4294967295, 1))
+	assert.True(t, serialNewer(2021050102, 2021050101))
+}
+
+func TestVerifyZonesInvalidMasters(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesInvalidMasters")
+	for _, s := range []string{"1.2.3.4", "5.6.7.8", "a1-1.akam.net", "a2-2.akam.net"} {
+		dnsStub.Serials[s] = 2021050101
+	}
+	stubRegistrar.FuncOutput["GetDomain"] = &registrar.Domain{Name: "v6.zone", Masters: []string{"2001:db8::1"}}
+	handler.MasterAddressFamily = registrar.AddressFamilyIPv4
+
+	// zones without masters of the address family are problems instead of failing the verification
+	results, err := VerifyZones(ctx, handler, stubRegistrar, []string{"v6.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, SerialStateNoMasters, results[0].State)
+	assert.True(t, results[0].Problem())
+	assert.NotEqual(t, "", results[0].Error)
+
+	delete(stubRegistrar.FuncOutput, "GetDomain")
+	delete(stubRegistrar.FuncOutput, "GetMasterIPs")
+	stubRegistrar.FuncErrors["GetMasterIPs"] = "registrar unavailable"
+	results, err = VerifyZones(ctx, handler, stubRegistrar, []string{"a.zone", "b.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, SerialStateNoMasters, results[1].State)
+	assert.Equal(t, 2, reportZoneSerialStatus(ctx, results))
+}
+
+func TestVerifyZonesPruneMismatches(t *testing.T) {
+
+	ctx, stubRegistrar, handler, dnsStub := initVerifyTest(t, "TestVerifyZonesPruneMismatches")
+	dnsStub.Serials["1.2.3.4"] = 2
+	dnsStub.Serials["5.6.7.8"] = 2
+	dnsStub.Serials["a1-1.akam.net"] = 1
+	dnsStub.Serials["a2-2.akam.net"] = 1
+
+	_, err := VerifyZones(ctx, handler, stubRegistrar, []string{"a.zone", "b.zone"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(serialMismatchSince[handler.Accounts[0].Name()]))
+	// mismatches of zones no longer managed are dropped
+	_, err = VerifyZones(ctx, handler, stubRegistrar, []string{"b.zone"})
+	assert.Nil(t, err)
+	_, ok := serialMismatchSince[handler.Accounts[0].Name()]["a.zone"]
+	assert.False(t, ok)
+}
+
+func TestVerifyZonesNameServersFail(t *testing.T) {
+
+	ctx, stubRegistrar, handler, _ := initVerifyTest(t, "TestVerifyZonesNameServersFail")
+	stubEdgeDNS := handler.client.(*EdgednsStub)
+	delete(stubEdgeDNS.FuncOutput, "GetNameServers")
+	stubEdgeDNS.FuncErrors["GetNameServers"] = "GET failed"
+
+	_, err := VerifyZones(ctx, handler, stubRegistrar, []string{"regtest.zone"})
+	assert.NotNil(t, err)
+}
+
+func TestManagedZones(t *testing.T) {
+
+	zones := managedZones([]string{"a.zone", "b.zone", "c.zone"}, []string{"b.zone", "c.zone", "d.zone"})
+	assert.Equal(t, []string{"b.zone", "c.zone"}, zones)
+}
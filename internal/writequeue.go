@@ -0,0 +1,120 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+
+	"context"
+	"time"
+)
+
+// RegistrarWrite is a zone awaiting a change through the registrar, e.g. the publication of its DS records
+type RegistrarWrite struct {
+	Account  string    `json:"account"`
+	Zone     string    `json:"zone"`
+	Queued   time.Time `json:"queued"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (p *RegistrarWrite) stateKey() string {
+
+	return activationKey(p.Account, p.Zone)
+}
+
+func (p *RegistrarWrite) stateZone() string {
+
+	return p.Zone
+}
+
+// registrarWriteQueue tracks zones until a registrar change is made. The queue is kept in stateFile.
+type registrarWriteQueue struct {
+	store *keyedStateStore
+}
+
+func newRegistrarWriteQueue(stateDir, stateFile string) (*registrarWriteQueue, error) {
+
+	store, err := newKeyedStateStore(stateDir, stateFile, &[]*RegistrarWrite{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &registrarWriteQueue{store: store}, nil
+}
+
+// queue adds a zone of an account. Queued zones are kept as is.
+func (q *registrarWriteQueue) queue(account, zone string, now time.Time) error {
+
+	return q.store.add(&RegistrarWrite{Account: account, Zone: zone, Queued: now})
+}
+
+// queued returns copies of the queued zones of an account sorted by zone
+func (q *registrarWriteQueue) queued(account string) []*RegistrarWrite {
+
+	zones := []*RegistrarWrite{}
+	for _, entry := range q.store.list(func(entry stateEntry) bool { return entry.(*RegistrarWrite).Account == account }) {
+		c := *entry.(*RegistrarWrite)
+		zones = append(zones, &c)
+	}
+
+	return zones
+}
+
+// update replaces a queued zone. Zones no longer queued are not added back.
+func (q *registrarWriteQueue) update(p *RegistrarWrite) error {
+
+	c := *p
+
+	return q.store.replace(&c)
+}
+
+// forget drops zones of an account, e.g. once changed
+func (q *registrarWriteQueue) forget(account string, zones []string) error {
+
+	return q.store.remove(zoneKeys(account, zones)...)
+}
+
+// retain drops zones of domains no longer in the registrar
+func (q *registrarWriteQueue) retain(registrarDomains []string) error {
+
+	return q.store.retainZones(registrarDomains)
+}
+
+// activeWrites returns the queued zones of an account that are active and, if create confirmation is enabled,
+// confirmed. Other zones are deferred.
+func activeWrites(ctx context.Context, edge *EdgeDNSHandler, account *EdgeDNSAccount, queued []*RegistrarWrite, purpose string) []*RegistrarWrite {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	active := []*RegistrarWrite{}
+	for _, p := range queued {
+		if edge.ConfirmZones && edge.confirmations.pending(account.Name(), p.Zone) {
+			log.Debugf("Zone %s awaiting confirmation. %s deferred", p.Zone, purpose)
+			continue
+		}
+		zone, err := account.client.GetZone(ctx, p.Zone)
+		if err != nil {
+			log.Debugf("Unable to retrieve zone %s. Error: %s", p.Zone, err.Error())
+			continue
+		}
+		if zone.ActivationState != ActivationStateActive {
+			log.Debugf("Zone %s in state %s. %s deferred", p.Zone, zone.ActivationState, purpose)
+			continue
+		}
+		active = append(active, p)
+	}
+
+	return active
+}
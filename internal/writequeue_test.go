@@ -0,0 +1,51 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegistrarWriteQueuePersistence(t *testing.T) {
+
+	dir := t.TempDir()
+	q, err := newRegistrarWriteQueue(dir, dsPublicationStateFile)
+	assert.Nil(t, err)
+	now := time.Now()
+	assert.Nil(t, q.queue("1-A", "b.com", now))
+	assert.Nil(t, q.queue("1-A", "a.com", now))
+	p := q.queued("1-A")[0]
+	assert.Equal(t, "a.com", p.Zone)
+	p.Attempts = 2
+	assert.Nil(t, q.update(p))
+
+	// the queue survives a restart
+	q, err = newRegistrarWriteQueue(dir, dsPublicationStateFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(q.queued("1-A")))
+	assert.Equal(t, 2, q.queued("1-A")[0].Attempts)
+	// queued zones are kept as is
+	assert.Nil(t, q.queue("1-A", "a.com", now))
+	assert.Equal(t, 2, q.queued("1-A")[0].Attempts)
+
+	assert.Nil(t, q.retain([]string{"b.com"}))
+	q, err = newRegistrarWriteQueue(dir, dsPublicationStateFile)
+	assert.Nil(t, err)
+	zones := q.queued("1-A")
+	assert.Equal(t, 1, len(zones))
+	assert.Equal(t, "b.com", zones[0].Zone)
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"sort"
+)
+
+// sortedZoneList returns zones sorted without duplicates. Sorted input without duplicates is returned as is.
+func sortedZoneList(zones []string) []string {
+
+	if sort.StringsAreSorted(zones) {
+		unique := true
+		for i := 1; i < len(zones) && unique; i++ {
+			unique = zones[i] != zones[i-1]
+		}
+		if unique {
+			return zones
+		}
+	}
+	sorted := make([]string, len(zones))
+	copy(sorted, zones)
+	sort.Strings(sorted)
+	n := 0
+	for i, z := range sorted {
+		if i > 0 && z == sorted[n-1] {
+			continue
+		}
+		sorted[n] = z
+		n++
+	}
+
+	return sorted[:n]
+}
+
+// sortedDifference returns the zones of sorted list a not in sorted list b
+func sortedDifference(a, b []string) []string {
+
+	diff := []string{}
+	j := 0
+	for _, z := range a {
+		for j < len(b) && b[j] < z {
+			j++
+		}
+		if j < len(b) && b[j] == z {
+			continue
+		}
+		diff = append(diff, z)
+	}
+
+	return diff
+}
+
+// sortedIntersection returns the zones in both sorted lists a and b
+func sortedIntersection(a, b []string) []string {
+
+	both := []string{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			both = append(both, a[i])
+			i++
+			j++
+		}
+	}
+
+	return both
+}
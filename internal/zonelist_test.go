@@ -0,0 +1,104 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSortedZoneLists(t *testing.T) {
+
+	assert.Equal(t, []string{"a.com", "b.com", "c.com"}, sortedZoneList([]string{"c.com", "a.com", "b.com", "a.com"}))
+	assert.Equal(t, []string{}, sortedZoneList([]string{}))
+	assert.Equal(t, []string{"b.com", "d.com"}, sortedDifference([]string{"a.com", "b.com", "c.com", "d.com"}, []string{"a.com", "c.com", "e.com"}))
+	assert.Equal(t, []string{"a.com", "c.com"}, sortedIntersection([]string{"a.com", "b.com", "c.com", "d.com"}, []string{"a.com", "c.com", "e.com"}))
+	assert.Equal(t, []string{}, sortedIntersection(nil, []string{"a.com"}))
+}
+
+func TestDiffZoneLists(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), "appLog", log.WithField("test", "diff"))
+	delete(lastRegistrarTally, "diff")
+
+	// first tally. Nothing removed
+	newZones, removedZones := diffAccountZoneLists(ctx, "diff", map[string][]string{"1-A": {"a.com", "b.com"}}, []string{"c.com", "b.com", "d.com", "c.com"})
+	assert.Equal(t, []string{"c.com", "d.com"}, newZones)
+	assert.Equal(t, []string{}, removedZones["1-A"])
+
+	// b.com and d.com removed from the registrar. d.com never created
+	newZones, removedZones = diffAccountZoneLists(ctx, "diff", map[string][]string{"1-A": {"a.com", "b.com", "c.com"}}, []string{"c.com", "e.com"})
+	assert.Equal(t, []string{"e.com"}, newZones)
+	assert.Equal(t, []string{"b.com"}, removedZones["1-A"])
+	assert.Equal(t, []string{"c.com", "e.com"}, lastRegistrarTally["diff"])
+}
+
+func TestDiffAccountZoneLists(t *testing.T) {
+
+	ctx := context.WithValue(context.Background(), "appLog", log.WithField("test", "diff"))
+	delete(lastRegistrarTally, "accounts")
+
+	accountZones := map[string][]string{
+		"1-A":       {"a.com", "b.com"},
+		"KEY-B:1-B": {"c.com"},
+	}
+	// zones in any account are not created again
+	newZones, _ := diffAccountZoneLists(ctx, "accounts", accountZones, []string{"a.com", "b.com", "c.com", "d.com"})
+	assert.Equal(t, []string{"d.com"}, newZones)
+
+	// removed zones are deleted from the account holding them
+	accountZones["1-A"] = append(accountZones["1-A"], "d.com")
+	newZones, removedZones := diffAccountZoneLists(ctx, "accounts", accountZones, []string{"a.com", "d.com"})
+	assert.Equal(t, []string{}, newZones)
+	assert.Equal(t, []string{"b.com"}, removedZones["1-A"])
+	assert.Equal(t, []string{"c.com"}, removedZones["KEY-B:1-B"])
+}
+
+// syntheticZones returns n zone names in random order
+func syntheticZones(n int, offset int) []string {
+
+	zones := make([]string, n)
+	for i := range zones {
+		zones[i] = fmt.Sprintf("zone%07d.example.com", i+offset)
+	}
+	rand.New(rand.NewSource(int64(n))).Shuffle(n, func(i, j int) { zones[i], zones[j] = zones[j], zones[i] })
+
+	return zones
+}
+
+// BenchmarkDiffZoneLists diffs synthetic Edge DNS and registrar zone sets. The sets differ by 1%.
+func BenchmarkDiffZoneLists(b *testing.B) {
+
+	logger := &log.Logger{Handler: discard.Default, Level: log.InfoLevel}
+	ctx := context.WithValue(context.Background(), "appLog", log.NewEntry(logger))
+	for _, n := range []int{1000, 10000, 150000} {
+		edgeZones := sortedZoneList(syntheticZones(n, 0))
+		registrarDomains := syntheticZones(n, n/100)
+		b.Run(fmt.Sprintf("zones-%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lastRegistrarTally["bench"] = edgeZones
+				diffAccountZoneLists(ctx, "bench", map[string][]string{"bench": edgeZones}, registrarDomains)
+			}
+		})
+	}
+	delete(lastRegistrarTally, "bench")
+}
@@ -20,10 +20,6 @@ import (
 	markmonitorsftp "github.com/akamai/edgedns-registrar-coordinator/registrar/markmonitorsftp"
 	plugin "github.com/akamai/edgedns-registrar-coordinator/registrar/plugin"
 	log "github.com/apex/log"
-	"github.com/apex/log/handlers/cli"
-	"github.com/apex/log/handlers/discard"
-	"github.com/apex/log/handlers/json"
-	"github.com/apex/log/handlers/text"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	"context"
@@ -42,6 +38,21 @@ var (
 	app            *kingpin.Application
 	// monitor sub command
 	monitor *kingpin.CmdClause
+	// verify sub command
+	verify *kingpin.CmdClause
+	// transfer status sub command
+	transferStatus *kingpin.CmdClause
+	// check delegation sub command
+	checkDelegation *kingpin.CmdClause
+	// rollback delegation sub command
+	rollbackDelegation *kingpin.CmdClause
+	rollbackZones      *[]string
+	// export TSIG keys sub command
+	exportTsigKeys *kingpin.CmdClause
+	// config dump sub command
+	configDump *kingpin.CmdClause
+	// list groups sub command
+	listGroups *kingpin.CmdClause
 )
 
 func main() {
@@ -77,6 +88,14 @@ func main() {
 	cfg := internal.NewConfig()
 	app = internal.NewApp()
 	monitor = app.Command("monitor", "Monitor registrar for domain adds and deletes.")
+	verify = app.Command("verify", "Verify SOA serials of Edge DNS secondaries against registrar masters.")
+	transferStatus = app.Command("transfer-status", "Report the zone transfer health of Edge DNS secondaries.")
+	checkDelegation = app.Command("check-delegation", "Report the parent delegation of Edge DNS secondaries against the Akamai name servers.")
+	rollbackDelegation = app.Command("rollback-delegation", "Restore the name servers replaced by the latest delegation update of zones, as recorded in the audit trail.")
+	rollbackZones = rollbackDelegation.Arg("zone", "Zone whose delegation is rolled back. Repeatable").Required().Strings()
+	exportTsigKeys = app.Command("export-tsig-keys", "Print the coordinator generated TSIG keys as named.conf key statements.")
+	configDump = app.Command("config", "Coordinator configuration commands.").Command("dump", "Print the effective configuration with secrets masked.")
+	listGroups = app.Command("list-groups", "Print the Edge DNS contracts and groups visible to the Edgegrid credentials and their zone create permissions.")
 	if len(os.Args) < 2 {
 		app.FatalUsage("/nError: sub command is required/n")
 		os.Exit(1)
@@ -87,7 +106,17 @@ func main() {
 		app.FatalUsage("command line parsing error: %v", err.Error())
 		os.Exit(1)
 	}
-	err = cfg.Validate()
+	if cmd == configDump.FullCommand() {
+		if err := cfg.Dump(app, os.Stdout); err != nil {
+			app.Fatalf("Failed to dump configuration. Error: %s", err.Error())
+		}
+		os.Exit(0)
+	}
+	if cmd == listGroups.FullCommand() {
+		err = cfg.ValidateEdgegrid()
+	} else {
+		err = cfg.Validate()
+	}
 	if err != nil {
 		fmt.Println("validation error: ", err.Error())
 		app.FatalUsage("command line validation error: %v", err.Error())
@@ -95,47 +124,25 @@ func main() {
 	}
 
 	// Setup logging
-	logLevel, err := log.ParseLevel(cfg.LogLevel)
+	logOutput, err := internal.NewLogOutput(cfg)
 	if err != nil {
-		log.Warnf("Log level invalid. Using default, %s", internal.DefaultConfig.LogLevel)
-		logLevel, err = log.ParseLevel(internal.DefaultConfig.LogLevel)
-	}
-	log.SetLevel(logLevel)
-	out := os.Stderr
-	if cfg.LogFilePath != "" {
-		f, err := os.OpenFile(cfg.LogFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			fmt.Println("Could Not Open Log File : " + err.Error())
-		}
-		out = f
+		app.Fatalf("Failed to initialize logging. Error: %s", err.Error())
 	}
-	switch cfg.LogHandler {
-	case "cli":
-		log.SetHandler(cli.Default)
-
-	case "text":
-		log.SetHandler(text.New(out))
-
-	case "json":
-		log.SetHandler(json.New(out))
-
-	case "discard":
-		log.SetHandler(discard.Default)
-
-	/*
-			case "syslog":
-		                levelMap := map[string]string{
-		                        "debug": ,
-					"info": ,
-					"warning": ,
-					"error": ,
-					"fatal": ,
-		                }
-	*/
-
-	default:
-		log.Warn("Log handler invalid. Using default text handler")
-		log.SetHandler(text.Default)
+	defer logOutput.Close()
+	log.SetLevel(logOutput.Level)
+	log.SetHandler(logOutput.Handler)
+	if len(internal.LogReopenSignals) > 0 {
+		reopenChan := make(chan os.Signal, 1)
+		signal.Notify(reopenChan, internal.LogReopenSignals...)
+		go func() {
+			for range reopenChan {
+				if err := logOutput.Reopen(); err != nil {
+					log.Errorf("Failed to reopen log files. Error: %s", err.Error())
+				} else {
+					log.Info("Log files reopened")
+				}
+			}
+		}()
 	}
 
 	monitor = app.Command("monitor", "Monitor registrar for domain adds and deletes.")
@@ -151,6 +158,13 @@ func main() {
 	})
 
 	ctx = context.WithValue(ctx, "appLog", appLog)
+	if cmd == listGroups.FullCommand() {
+		if err := internal.ListGroups(ctx, cfg, nil, os.Stdout); err != nil {
+			appLog.Errorf("Failed to list Edge DNS groups. Error: %s", err.Error())
+			app.Fatalf("Failed to list Edge DNS groups. Error: %s", err.Error())
+		}
+		os.Exit(0)
+	}
 	// Initialize registrar provider
 	var r registrar.RegistrarProvider
 	switch cfg.Registrar {
@@ -159,6 +173,7 @@ func main() {
 			ctx,
 			akamai.AkamaiConfig{
 				AkamaiConfigPath: cfg.RegistrarConfigPath,
+				AkamaiConfigData: cfg.RegistrarConfigData(),
 			},
 			nil,
 		)
@@ -171,6 +186,7 @@ func main() {
 			registrar.PluginConfig{
 				PluginLibPath:    cfg.PluginLibPath,
 				PluginConfigPath: cfg.RegistrarConfigPath,
+				PluginConfigData: cfg.RegistrarConfigData(),
 				LogEntry:         appLog,
 			},
 		)
@@ -180,6 +196,7 @@ func main() {
 			ctx,
 			markmonitorsftp.MarkMonitorSFTPConfig{
 				MarkMonitorSFTPConfigPath: cfg.RegistrarConfigPath,
+				MarkMonitorSFTPConfigData: cfg.RegistrarConfigData(),
 			},
 			nil,
 		)
@@ -204,11 +221,38 @@ func main() {
 		os.Exit(1)
 	}
 
+	if cfg.MetricsAddress != "" {
+		if err := internal.StartMetricsServer(ctx, cfg.MetricsAddress); err != nil {
+			appLog.Errorf("Failed to start metrics server. Error: %s", err.Error())
+			app.Fatalf("Failed to start metrics server. Error: %s", err.Error())
+		}
+	}
+
 	switch cmd {
 	case monitor.FullCommand():
 		appLog.Info("Processing monitor command")
 		go internal.Monitor(ctx, cmderr, cfg.Registrar, r, edgeDNSHandler, cfg.Interval, cfg.DryRun, cfg.Once)
 
+	case verify.FullCommand():
+		appLog.Info("Processing verify command")
+		go internal.Verify(ctx, cmderr, cfg.Registrar, r, edgeDNSHandler)
+
+	case transferStatus.FullCommand():
+		appLog.Info("Processing transfer-status command")
+		go internal.TransferStatus(ctx, cmderr, cfg.Registrar, r, edgeDNSHandler, os.Stdout)
+
+	case checkDelegation.FullCommand():
+		appLog.Info("Processing check-delegation command")
+		go internal.CheckDelegation(ctx, cmderr, cfg.Registrar, r, edgeDNSHandler, cfg.DelegationFormat, os.Stdout)
+
+	case rollbackDelegation.FullCommand():
+		appLog.Info("Processing rollback-delegation command")
+		go internal.RollbackDelegation(ctx, cmderr, r, edgeDNSHandler, *rollbackZones, cfg.DryRun)
+
+	case exportTsigKeys.FullCommand():
+		appLog.Info("Processing export-tsig-keys command")
+		go internal.ExportTsigKeys(ctx, cmderr, edgeDNSHandler, os.Stdout)
+
 	default:
 		appLog.Errorf("Invalid commandline [%s]", strings.Join(os.Args, " "))
 		app.FatalUsage("Invalid commandline [%s]", strings.Join(os.Args, " "))
@@ -18,14 +18,17 @@ import (
 
 	"github.com/akamai/edgedns-registrar-coordinator/registrar"
 
+	"context"
 	"fmt"
 	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
 	edgegrid "github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const ()
@@ -50,22 +53,38 @@ type AkamaiDNSService interface {
 type AkamaiLibRegistrar struct {
 	//registrar.BaseRegistrarProvider
 	akaConfig *AkamaiConfig
-	config    *edgegrid.Config
+	// Edge DNS API client. Signs requests with the plugin's own credentials
+	edgeClient *registrar.EdgeDNSClient
+	// Edgegrid credentials. May be secret references
+	clientToken  *registrar.SecretValue
+	clientSecret *registrar.SecretValue
+	accessToken  *registrar.SecretValue
 	// Defines client. Allows for mocking.
 	client AkamaiDNSService
 }
 
 type AkamaiConfig struct {
-	AkamaiContracts     string `yaml:"akamai_contracts"`
-	AkamaiNameFilter    string `yaml:"akamai_name_filter"`
-	AkamaiEdgercPath    string `yaml:"akamai_edgerc_path"`
-	AkamaiEdgercSection string `yaml:"akamai_edgerc_section"`
-	AkamaiHost          string `yaml:"akamai_host"`
-	AkamaiAccessToken   string `yaml:"akamai_access_token"`
-	AkamaiClientToken   string `yaml:"akamai_client_token"`
-	AkamaiClientSecret  string `yaml:"akamai_client_secret"`
-	MaxBody             int    `yaml:"akamai_client_maxbody"`
-	AccountKey          string `yaml:"akamai_client_account_key"`
+	AkamaiContracts     string           `yaml:"akamai_contracts"`
+	AkamaiNameFilter    string           `yaml:"akamai_name_filter"`
+	AkamaiEdgercPath    string           `yaml:"akamai_edgerc_path"`
+	AkamaiEdgercSection string           `yaml:"akamai_edgerc_section"`
+	AkamaiHost          string           `yaml:"akamai_host"`
+	AkamaiAccessToken   registrar.Secret `yaml:"akamai_access_token"`
+	AkamaiClientToken   registrar.Secret `yaml:"akamai_client_token"`
+	AkamaiClientSecret  registrar.Secret `yaml:"akamai_client_secret"`
+	MaxBody             int              `yaml:"akamai_client_maxbody"`
+	AccountKey          string           `yaml:"akamai_client_account_key"`
+	MasterAddressFamily string           `yaml:"akamai_master_address_family"`
+	// Edge DNS API HTTP client
+	ClientTimeout   time.Duration `yaml:"akamai_client_timeout"`
+	ClientKeepAlive time.Duration `yaml:"akamai_client_keepalive"`
+	ClientProxy     string        `yaml:"akamai_client_proxy"`
+	// Zone list page size
+	PageSize int `yaml:"akamai_page_size"`
+	// Activation states of zones left out of the domain list. Default lists zones in all states
+	ExcludeStates []string `yaml:"akamai_exclude_states"`
+	// Re-read interval of secret references. Default if not set
+	SecretRefresh *time.Duration `yaml:"akamai_secret_refresh"`
 }
 
 // NewAkamaiProvider initializes a new Akamai DNS based Provider.
@@ -77,11 +96,15 @@ func NewPluginLibRegistrar() {
 	pluginConfig := LibPluginArgs.PluginArg.(registrar.PluginConfig)
 	libLog = pluginConfig.LogEntry
 	// Get file config and parse
-	if pluginConfig.PluginConfigPath == "" {
+	if pluginConfig.PluginConfigPath == "" && len(pluginConfig.PluginConfigData) == 0 {
 		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library requires a configurtion file")
 		return
 	}
-	akaConfig, err = loadConfig(pluginConfig.PluginConfigPath)
+	if pluginConfig.PluginConfigPath != "" {
+		akaConfig, err = loadConfig(pluginConfig.PluginConfigPath)
+	} else {
+		akaConfig, err = loadConfigContent(pluginConfig.PluginConfigData)
+	}
 	if err != nil {
 		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration file load failed. Error: %s", err.Error())
 		return
@@ -96,13 +119,45 @@ func NewPluginLibRegistrar() {
 		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration requires valid set of auth keys.")
 		return
 	}
-	// Process creds. Could be on cmd line, config file
+	if akaConfig.MasterAddressFamily == "" {
+		akaConfig.MasterAddressFamily = registrar.AddressFamilyIPv4
+	}
+	if err := registrar.ValidateAddressFamily(akaConfig.MasterAddressFamily); err != nil {
+		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration invalid. %s", err.Error())
+		return
+	}
+	secretRefresh := registrar.SecretRefreshInterval(akaConfig.SecretRefresh)
+	if secretRefresh < 0 {
+		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration invalid. Secret refresh must not be negative")
+		return
+	}
+	// Process creds. Could be on cmd line, config file. Resolve secret references
+	ctx := context.Background()
+	var clientToken, clientSecret, accessToken *registrar.SecretValue
+	if clientToken, err = registrar.NewSecretValue(ctx, string(akaConfig.AkamaiClientToken), secretRefresh); err != nil {
+		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration invalid. %s", err.Error())
+		return
+	}
+	if clientSecret, err = registrar.NewSecretValue(ctx, string(akaConfig.AkamaiClientSecret), secretRefresh); err != nil {
+		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration invalid. %s", err.Error())
+		return
+	}
+	if accessToken, err = registrar.NewSecretValue(ctx, string(akaConfig.AkamaiAccessToken), secretRefresh); err != nil {
+		LibPluginResult.PluginError = fmt.Errorf("Akamai Plugin Library configuration invalid. %s", err.Error())
+		return
+	}
+	clientTokenValue, _, _ := clientToken.Value(ctx)
+	akaConfig.AkamaiClientToken = registrar.Secret(clientTokenValue)
+	clientSecretValue, _, _ := clientSecret.Value(ctx)
+	akaConfig.AkamaiClientSecret = registrar.Secret(clientSecretValue)
+	accessTokenValue, _, _ := accessToken.Value(ctx)
+	akaConfig.AkamaiAccessToken = registrar.Secret(accessTokenValue)
 	var edgeGridConfig edgegrid.Config
 
 	libLog.Debugf("Host: %s", akaConfig.AkamaiHost)
-	libLog.Debugf("ClientToken: %s", akaConfig.AkamaiClientToken)
-	libLog.Debugf("ClientSecret: %s", akaConfig.AkamaiClientSecret)
-	libLog.Debugf("AccessToken: %s", akaConfig.AkamaiAccessToken)
+	libLog.Debugf("ClientToken: %s", clientToken)
+	libLog.Debugf("ClientSecret: %s", clientSecret)
+	libLog.Debugf("AccessToken: %s", accessToken)
 	libLog.Debugf("EdgePath: %s", akaConfig.AkamaiEdgercPath)
 	libLog.Debugf("EdgeSection: %s", akaConfig.AkamaiEdgercSection)
 	libLog.Debugf("AkamaiContracts: %v", akaConfig.AkamaiContracts)
@@ -116,13 +171,15 @@ func NewPluginLibRegistrar() {
 			LibPluginResult.PluginError = err
 			return // return empty provider for backward compatibility
 		}
+		// credentials not used
+		clientToken, clientSecret, accessToken = nil, nil, nil
 	} else {
 		// Use external-dns config
 		edgeGridConfig = edgegrid.Config{
 			Host:         akaConfig.AkamaiHost,
-			ClientToken:  akaConfig.AkamaiClientToken,
-			ClientSecret: akaConfig.AkamaiClientSecret,
-			AccessToken:  akaConfig.AkamaiAccessToken,
+			ClientToken:  string(akaConfig.AkamaiClientToken),
+			ClientSecret: string(akaConfig.AkamaiClientSecret),
+			AccessToken:  string(akaConfig.AkamaiAccessToken),
 			MaxBody:      131072, // same default val as used by Edgegrid
 			Debug:        false,
 		}
@@ -145,9 +202,22 @@ func NewPluginLibRegistrar() {
 		}
 	}
 
+	edgeClient, err := registrar.NewEdgeDNSClient(edgeGridConfig, registrar.EdgeDNSClientOptions{
+		Timeout:   akaConfig.ClientTimeout,
+		KeepAlive: akaConfig.ClientKeepAlive,
+		Proxy:     akaConfig.ClientProxy,
+	})
+	if err != nil {
+		libLog.Errorf("Edge DNS client init failed")
+		LibPluginResult.PluginError = err
+		return
+	}
 	akamaiLibRegistrar = AkamaiLibRegistrar{
-		config:    &edgeGridConfig,
-		akaConfig: akaConfig,
+		edgeClient:   edgeClient,
+		akaConfig:    akaConfig,
+		clientToken:  clientToken,
+		clientSecret: clientSecret,
+		accessToken:  accessToken,
 	}
 	/*
 		if akaService != nil {
@@ -158,26 +228,53 @@ func NewPluginLibRegistrar() {
 		}
 	*/
 
-	// Init library for direct endpoint calls
-	dns.Init(edgeGridConfig)
+	// Redact credentials from coordinator log entries. Credentials may come from the .edgerc file
+	registrar.RegisterSecret(edgeGridConfig.ClientToken)
+	registrar.RegisterSecret(edgeGridConfig.ClientSecret)
+	registrar.RegisterSecret(edgeGridConfig.AccessToken)
 
 	return
 }
 
-func resetDNSConfig(orig edgegrid.Config) {
+// refreshCredentials re-reads referenced Edgegrid credentials and updates the Edgegrid config if any were rotated
+func refreshCredentials(ctx context.Context) error {
 
-	dns.Config = orig
+	if akamaiLibRegistrar.clientToken == nil || akamaiLibRegistrar.clientSecret == nil || akamaiLibRegistrar.accessToken == nil {
+		return nil
+	}
+	config := akamaiLibRegistrar.edgeClient.Config()
+	rotated := false
+	for _, cred := range []struct {
+		secret *registrar.SecretValue
+		value  *string
+	}{
+		{akamaiLibRegistrar.clientToken, &config.ClientToken},
+		{akamaiLibRegistrar.clientSecret, &config.ClientSecret},
+		{akamaiLibRegistrar.accessToken, &config.AccessToken},
+	} {
+		val, changed, err := cred.secret.Value(ctx)
+		if err != nil {
+			libLog.Errorf("Akamai Plugin Library failed to refresh Edgegrid credentials. Error: %s", err.Error())
+			return err
+		}
+		if changed {
+			*cred.value = val
+			rotated = true
+		}
+	}
+	if rotated {
+		akamaiLibRegistrar.edgeClient.SetCredentials(config.ClientToken, config.ClientSecret, config.AccessToken)
+		libLog.Info("Akamai Plugin Library Edgegrid credentials refreshed")
+	}
 
+	return nil
 }
 
 func GetDomains() {
 
 	libLog.Debug("Entering Plugin Lib Akamai registrar GetDomains")
-
-	// both edgedns and this plugin using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *akamaiLibRegistrar.config
+	// rotated credentials are picked up once per domain list. On failure, the current credentials are kept.
+	refreshCredentials(context.Background())
 
 	LibPluginResult.PluginResult = []string{}
 
@@ -186,22 +283,15 @@ func GetDomains() {
 		SortBy:      "zone",
 		ContractIds: akamaiLibRegistrar.akaConfig.AkamaiContracts,
 		Search:      akamaiLibRegistrar.akaConfig.AkamaiNameFilter,
+		PageSize:    akamaiLibRegistrar.akaConfig.PageSize,
 	}
 	libLog.Debugf("ListZones Query Args: %v", queryArgs)
-	zlResp, err := dns.ListZones(queryArgs)
+	domains, err := akamaiLibRegistrar.edgeClient.GetZoneNames(context.Background(), queryArgs, akamaiLibRegistrar.akaConfig.ExcludeStates)
 	if err != nil {
 		libLog.Debugf("Plugin Lib Registrar GetDomains failed. Error: %s", err.Error())
 		LibPluginResult.PluginError = err
 		return
 	}
-	filter := "LOCKED"
-	domains := make([]string, 0, len(zlResp.Zones))
-	for _, zone := range zlResp.Zones {
-		if strings.Contains(filter, zone.ActivationState) {
-			continue
-		}
-		domains = append(domains, zone.Zone)
-	}
 
 	libLog.Debugf("Plugin Akamai Registrar GetDomains result: %v", domains)
 
@@ -212,13 +302,8 @@ func GetDomains() {
 func GetDomain() {
 	libLog.Debug("Entering Akamai Plugin Lib registrar GetDomain")
 
-	// both edgedns and this plugin using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *akamaiLibRegistrar.config
-
 	domain := LibPluginArgs.PluginArg.(string)
-	zone, err := dns.GetZone(domain)
+	zone, err := akamaiLibRegistrar.edgeClient.GetZone(context.Background(), domain)
 	if err != nil {
 		LibPluginResult.PluginResult = registrar.Domain{}
 		LibPluginResult.PluginError = err
@@ -242,13 +327,8 @@ func GetTsigKey() {
 
 	libLog.Debug("Entering Akamai Plugin Lib registrar GetTsigKey")
 
-	// both edgedns and this plugin using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *akamaiLibRegistrar.config
-
 	domain := LibPluginArgs.PluginArg.(string)
-	resp, err := dns.GetZoneKey(domain)
+	resp, err := akamaiLibRegistrar.edgeClient.GetZoneKey(context.Background(), domain)
 	if err != nil {
 		LibPluginResult.PluginResult = dns.TSIGKey{}
 		LibPluginResult.PluginError = err
@@ -265,13 +345,8 @@ func GetServeAlgorithm() {
 
 	libLog.Debug("Entering Akamai Plugin Lib registrar GetServeAlgorithm")
 
-	// both edgedns and this plugin using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *akamaiLibRegistrar.config
-
 	domain := LibPluginArgs.PluginArg.(string)
-	zone, err := dns.GetZone(domain)
+	zone, err := akamaiLibRegistrar.edgeClient.GetZone(context.Background(), domain)
 	if err != nil {
 		LibPluginResult.PluginResult = ""
 		LibPluginResult.PluginError = err
@@ -287,11 +362,6 @@ func GetMasterIPs() {
 
 	libLog.Debug("Entering Akamai Plugin Lib registrar GetMasterIPs")
 
-	// both edgedns and this plugin using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *akamaiLibRegistrar.config
-
 	LibPluginResult.PluginResult = []string{}
 	if len(akamaiLibRegistrar.akaConfig.AkamaiContracts) < 1 {
 		libLog.Debug("Registrar GetMasterIPs failed. No contracts")
@@ -299,12 +369,26 @@ func GetMasterIPs() {
 		return
 	}
 	contractId := strings.Split(akamaiLibRegistrar.akaConfig.AkamaiContracts, ",")[0]
-	masters, err := dns.GetNameServerRecordList(contractId)
+	ns, err := akamaiLibRegistrar.edgeClient.GetNameServers(context.Background(), contractId)
 	if err != nil {
 		libLog.Debugf("Registrar GetMasterIPs failed. Error: %s", err.Error())
 		LibPluginResult.PluginError = err
 		return
 	}
+	masters := []string{}
+	// Lookup first address of each allowed family for each name server
+	for _, entry := range ns {
+		addrs, err := net.LookupHost(entry)
+		if err != nil {
+			libLog.Warnf("Master Hostname %s lookup failed. %s", entry, err.Error())
+			continue
+		}
+		ips, err := registrar.ParseMasterIPs(addrs)
+		if err != nil {
+			libLog.Warnf("Master Hostname %s lookup returned invalid address. %s", entry, err.Error())
+		}
+		masters = append(masters, registrar.MasterIPStrings(registrar.FirstAddressPerFamily(ips, akamaiLibRegistrar.akaConfig.MasterAddressFamily))...)
+	}
 
 	libLog.Debugf("Akamai Plugin Registrar GetMasterIPs result: %v", masters)
 	LibPluginResult.PluginResult = masters
@@ -312,6 +396,48 @@ func GetMasterIPs() {
 	return
 }
 
+// SetDSRecords replaces the DS records of a domain in its parent zone. The parent zone must be a primary zone
+// accessible with the plugin's credentials.
+func SetDSRecords() {
+
+	libLog.Debug("Entering Akamai Plugin Lib registrar SetDSRecords")
+
+	arg := LibPluginArgs.PluginArg.(registrar.PluginDSRecordsArg)
+	prior, err := akamaiLibRegistrar.edgeClient.SetParentRecords(context.Background(), arg.Domain, "DS", registrar.DefaultDSTTL, registrar.DSRdata(arg.DS))
+	if err != nil {
+		libLog.Debugf("Plugin Lib Registrar SetDSRecords failed. Error: %s", err.Error())
+		LibPluginResult.PluginError = err
+		return
+	}
+	if prior != nil {
+		libLog.Debugf("Akamai Plugin Lib Registrar SetDSRecords replaced: %v", prior.Rdata)
+	}
+
+	return
+}
+
+// SetNameServers replaces the NS delegation of a domain in its parent zone. The parent zone must be a primary zone
+// accessible with the plugin's credentials. The replaced name servers are the result.
+func SetNameServers() {
+
+	libLog.Debug("Entering Akamai Plugin Lib registrar SetNameServers")
+
+	arg := LibPluginArgs.PluginArg.(registrar.PluginNameServersArg)
+	prior, err := akamaiLibRegistrar.edgeClient.SetParentRecords(context.Background(), arg.Domain, "NS", registrar.DefaultNSTTL, arg.NameServers)
+	if err != nil {
+		libLog.Debugf("Plugin Lib Registrar SetNameServers failed. Error: %s", err.Error())
+		LibPluginResult.PluginError = err
+		return
+	}
+	replaced := []string{}
+	if prior != nil {
+		replaced = prior.Rdata
+	}
+	LibPluginResult.PluginResult = replaced
+
+	return
+}
+
 func loadConfig(configFile string) (*AkamaiConfig, error) {
 
 	libLog.Debug("Entering Plugin Lib Akamai registrar loadConfig")
@@ -27,6 +27,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const ()
@@ -47,16 +48,22 @@ type OpenEdgegridDNSService interface {
 	GetZone(domain string) (*dns.ZoneResponse, error)
 	GetZoneKey(domain string) (*dns.TSIGKeyResponse, error)
 	GetNameServerRecordList(contractId string) ([]string, error)
+	SetParentRecords(domain, rrtype string, ttl int, rdata []string) (*registrar.EdgeDNSRecordSet, error)
 }
 
+// OpenDNSConfig calls the Edge DNS API with the registrar's own credentials
 type OpenDNSConfig struct {
-	config *edgegrid.Config
+	client *registrar.EdgeDNSClient
 }
 
 // AkamaiProvider implements the DNS provider for Akamai.
 type AkamaiRegistrar struct {
 	registrar.BaseRegistrarProvider
 	akamaiConfig *AkamaiConfig
+	// Edgegrid credentials. May be secret references
+	clientToken  *registrar.SecretValue
+	clientSecret *registrar.SecretValue
+	accessToken  *registrar.SecretValue
 	// Defines client. Allows for mocking.
 	client    AkamaiDNSService
 	dnsclient OpenEdgegridDNSService
@@ -64,16 +71,28 @@ type AkamaiRegistrar struct {
 
 type AkamaiConfig struct {
 	AkamaiConfigPath    string
-	AkamaiContracts     string `yaml:"akamai_contracts"`
-	AkamaiNameFilter    string `yaml:"akamai_name_filter"`
-	AkamaiEdgercPath    string `yaml:"akamai_edgerc_path"`
-	AkamaiEdgercSection string `yaml:"akamai_edgerc_section"`
-	AkamaiHost          string `yaml:"akamai_host"`
-	AkamaiAccessToken   string `yaml:"akamai_access_token"`
-	AkamaiClientToken   string `yaml:"akamai_client_token"`
-	AkamaiClientSecret  string `yaml:"akamai_client_secret"`
-	MaxBody             int    `yaml:"akamai_max_body"`
-	AccountKey          string `yaml:"akamai_account_key"`
+	AkamaiConfigData    []byte           `yaml:"-"` // config content embedded in the coordinator config file
+	AkamaiContracts     string           `yaml:"akamai_contracts"`
+	AkamaiNameFilter    string           `yaml:"akamai_name_filter"`
+	AkamaiEdgercPath    string           `yaml:"akamai_edgerc_path"`
+	AkamaiEdgercSection string           `yaml:"akamai_edgerc_section"`
+	AkamaiHost          string           `yaml:"akamai_host"`
+	AkamaiAccessToken   registrar.Secret `yaml:"akamai_access_token"`
+	AkamaiClientToken   registrar.Secret `yaml:"akamai_client_token"`
+	AkamaiClientSecret  registrar.Secret `yaml:"akamai_client_secret"`
+	MaxBody             int              `yaml:"akamai_max_body"`
+	AccountKey          string           `yaml:"akamai_account_key"`
+	MasterAddressFamily string           `yaml:"akamai_master_address_family"`
+	// Edge DNS API HTTP client
+	ClientTimeout   time.Duration `yaml:"akamai_client_timeout"`
+	ClientKeepAlive time.Duration `yaml:"akamai_client_keepalive"`
+	ClientProxy     string        `yaml:"akamai_client_proxy"`
+	// Zone list page size
+	PageSize int `yaml:"akamai_page_size"`
+	// Activation states of zones left out of the domain list. Default lists zones in all states
+	ExcludeStates []string `yaml:"akamai_exclude_states"`
+	// Re-read interval of secret references. Default if not set
+	SecretRefresh *time.Duration `yaml:"akamai_secret_refresh"`
 }
 
 // NewAkamaiProvider initializes a new Akamai DNS based Provider.
@@ -86,21 +105,51 @@ func NewAkamaiRegistrar(ctx context.Context, akaConfig AkamaiConfig, akaService
 	// if mock, skip
 	if akaService == nil {
 		// Get file config and parse
-		if akaConfig.AkamaiConfigPath == "" {
+		if akaConfig.AkamaiConfigPath == "" && len(akaConfig.AkamaiConfigData) == 0 {
 			return nil, fmt.Errorf("Akamai Registrar requires a configuration file")
 		}
-		akamaiConfig, err = loadConfig(log, akaConfig.AkamaiConfigPath)
+		if akaConfig.AkamaiConfigPath != "" {
+			akamaiConfig, err = loadConfig(log, akaConfig.AkamaiConfigPath)
+		} else {
+			akamaiConfig, err = loadConfigContent(log, akaConfig.AkamaiConfigData)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("Akamai Registrar. Invalid configuration file")
 		}
 	}
-	// Process creds. Could be on cmd line, config file
+	if akamaiConfig.MasterAddressFamily == "" {
+		akamaiConfig.MasterAddressFamily = registrar.AddressFamilyIPv4
+	}
+	if err := registrar.ValidateAddressFamily(akamaiConfig.MasterAddressFamily); err != nil {
+		return nil, fmt.Errorf("Akamai Registrar. %s", err.Error())
+	}
+	secretRefresh := registrar.SecretRefreshInterval(akamaiConfig.SecretRefresh)
+	if secretRefresh < 0 {
+		return nil, fmt.Errorf("Akamai Registrar. Secret refresh must not be negative")
+	}
+	// Process creds. Could be on cmd line, config file. Resolve secret references
+	var clientToken, clientSecret, accessToken *registrar.SecretValue
+	if clientToken, err = registrar.NewSecretValue(ctx, string(akamaiConfig.AkamaiClientToken), secretRefresh); err != nil {
+		return nil, fmt.Errorf("Akamai Registrar. %s", err.Error())
+	}
+	if clientSecret, err = registrar.NewSecretValue(ctx, string(akamaiConfig.AkamaiClientSecret), secretRefresh); err != nil {
+		return nil, fmt.Errorf("Akamai Registrar. %s", err.Error())
+	}
+	if accessToken, err = registrar.NewSecretValue(ctx, string(akamaiConfig.AkamaiAccessToken), secretRefresh); err != nil {
+		return nil, fmt.Errorf("Akamai Registrar. %s", err.Error())
+	}
+	clientTokenValue, _, _ := clientToken.Value(ctx)
+	akamaiConfig.AkamaiClientToken = registrar.Secret(clientTokenValue)
+	clientSecretValue, _, _ := clientSecret.Value(ctx)
+	akamaiConfig.AkamaiClientSecret = registrar.Secret(clientSecretValue)
+	accessTokenValue, _, _ := accessToken.Value(ctx)
+	akamaiConfig.AkamaiAccessToken = registrar.Secret(accessTokenValue)
 	var edgeGridConfig edgegrid.Config
 
 	log.Debugf("Host: %s", akamaiConfig.AkamaiHost)
-	log.Debugf("ClientToken: %s", akamaiConfig.AkamaiClientToken)
-	log.Debugf("ClientSecret: %s", akamaiConfig.AkamaiClientSecret)
-	log.Debugf("AccessToken: %s", akamaiConfig.AkamaiAccessToken)
+	log.Debugf("ClientToken: %s", clientToken)
+	log.Debugf("ClientSecret: %s", clientSecret)
+	log.Debugf("AccessToken: %s", accessToken)
 	log.Debugf("EdgePath: %s", akamaiConfig.AkamaiEdgercPath)
 	log.Debugf("EdgeSection: %s", akamaiConfig.AkamaiEdgercSection)
 	log.Debugf("AkamaiContracts: %v", akamaiConfig.AkamaiContracts)
@@ -113,13 +162,15 @@ func NewAkamaiRegistrar(ctx context.Context, akaConfig AkamaiConfig, akaService
 			log.Errorf("Edgegrid Init Failed")
 			return nil, err // return empty provider for backward compatibility
 		}
+		// credentials not used
+		clientToken, clientSecret, accessToken = nil, nil, nil
 	} else {
 		// Use external-dns config
 		edgeGridConfig = edgegrid.Config{
 			Host:         akamaiConfig.AkamaiHost,
-			ClientToken:  akamaiConfig.AkamaiClientToken,
-			ClientSecret: akamaiConfig.AkamaiClientSecret,
-			AccessToken:  akamaiConfig.AkamaiAccessToken,
+			ClientToken:  string(akamaiConfig.AkamaiClientToken),
+			ClientSecret: string(akamaiConfig.AkamaiClientSecret),
+			AccessToken:  string(akamaiConfig.AkamaiAccessToken),
 			MaxBody:      131072, // same default val as used by Edgegrid
 			Debug:        false,
 		}
@@ -148,9 +199,25 @@ func NewAkamaiRegistrar(ctx context.Context, akaConfig AkamaiConfig, akaService
 		}
 	}
 
+	// credentials may come from the .edgerc file
+	registrar.RegisterSecret(edgeGridConfig.ClientToken)
+	registrar.RegisterSecret(edgeGridConfig.ClientSecret)
+	registrar.RegisterSecret(edgeGridConfig.AccessToken)
+
+	edgeClient, err := registrar.NewEdgeDNSClient(edgeGridConfig, registrar.EdgeDNSClientOptions{
+		Timeout:   akamaiConfig.ClientTimeout,
+		KeepAlive: akamaiConfig.ClientKeepAlive,
+		Proxy:     akamaiConfig.ClientProxy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Akamai Registrar. %s", err.Error())
+	}
 	provider := &AkamaiRegistrar{
-		dnsclient:    &OpenDNSConfig{config: &edgeGridConfig},
+		dnsclient:    &OpenDNSConfig{client: edgeClient},
 		akamaiConfig: akamaiConfig,
+		clientToken:  clientToken,
+		clientSecret: clientSecret,
+		accessToken:  accessToken,
 	}
 	if akaService != nil {
 		log.Debugf("Using STUB")
@@ -159,43 +226,70 @@ func NewAkamaiRegistrar(ctx context.Context, akaConfig AkamaiConfig, akaService
 		provider.client = provider
 	}
 
-	// Init library for direct endpoint calls
-	dns.Init(edgeGridConfig)
-
 	return provider, nil
 }
 
-func resetDNSConfig(orig edgegrid.Config) {
+// refreshCredentials re-reads referenced Edgegrid credentials and updates the Edgegrid config if any were rotated
+func (a *AkamaiRegistrar) refreshCredentials(ctx context.Context) error {
 
-	dns.Config = orig
+	log := ctx.Value("appLog").(*log.Entry)
 
+	dnsConfig, ok := a.dnsclient.(*OpenDNSConfig)
+	if !ok || a.clientToken == nil || a.clientSecret == nil || a.accessToken == nil {
+		return nil
+	}
+	config := dnsConfig.client.Config()
+	rotated := false
+	for _, cred := range []struct {
+		secret *registrar.SecretValue
+		value  *string
+	}{
+		{a.clientToken, &config.ClientToken},
+		{a.clientSecret, &config.ClientSecret},
+		{a.accessToken, &config.AccessToken},
+	} {
+		val, changed, err := cred.secret.Value(ctx)
+		if err != nil {
+			log.Errorf("Akamai registrar failed to refresh Edgegrid credentials. Error: %s", err.Error())
+			return err
+		}
+		if changed {
+			*cred.value = val
+			rotated = true
+		}
+	}
+	if rotated {
+		dnsConfig.client.SetCredentials(config.ClientToken, config.ClientSecret, config.AccessToken)
+		log.Info("Akamai registrar Edgegrid credentials refreshed")
+	}
+
+	return nil
 }
 
 func (a *AkamaiRegistrar) GetDomains(ctx context.Context) ([]string, error) {
 
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering Akamai registrar GetDomains")
+	// rotated credentials are picked up once per domain list. On failure, the current credentials are kept.
+	a.refreshCredentials(ctx)
 
 	queryArgs := dns.ZoneListQueryArgs{
 		Types:       "PRIMARY",
 		SortBy:      "zone",
 		ContractIds: a.akamaiConfig.AkamaiContracts,
 		Search:      a.akamaiConfig.AkamaiNameFilter,
+		PageSize:    a.akamaiConfig.PageSize,
 	}
 	log.Debugf("ListZones Query Args: %v", queryArgs)
-	zlResp, err := a.dnsclient.ListZones(queryArgs)
+	// zones are listed one page at a time
+	listPage := func(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
+		return a.dnsclient.ListZones(queryArgs)
+	}
+	domains, err := registrar.ZoneNames(registrar.NewZoneIterator(ctx, listPage, queryArgs), a.akamaiConfig.ExcludeStates)
 	if err != nil {
 		log.Debugf("Registrar GetDomains failed. Error: %s", err.Error())
 		return []string{}, err
 	}
-	filter := "LOCKED"
-	domains := make([]string, 0, len(zlResp.Zones))
-	for _, zone := range zlResp.Zones {
-		if filter == zone.ActivationState {
-			continue
-		}
-		domains = append(domains, zone.Zone)
-	}
 
 	log.Debugf("Registrar GetDomains result: %v", domains)
 	return domains, nil
@@ -266,24 +360,63 @@ func (a *AkamaiRegistrar) GetMasterIPs(ctx context.Context) ([]string, error) {
 	}
 	log.Debugf("Retrieved IPs: %v", ns)
 	masters := []string{}
-	// Lookup IP4 address for each name server
+	// Lookup first address of each allowed family for each name server
 	for _, entry := range ns {
-		ips, err := net.LookupHost(entry)
+		addrs, err := net.LookupHost(entry)
 		if err != nil {
 			log.Warnf("Master Hostname %s lookup failed. %s", entry, err.Error())
 			continue
 		}
-		for _, ip := range ips {
-			if strings.Contains(ip, ".") {
-				masters = append(masters, ip)
-				break
-			}
+		ips, err := registrar.ParseMasterIPs(addrs)
+		if err != nil {
+			log.Warnf("Master Hostname %s lookup returned invalid address. %s", entry, err.Error())
 		}
+		masters = append(masters, registrar.MasterIPStrings(registrar.FirstAddressPerFamily(ips, a.akamaiConfig.MasterAddressFamily))...)
 	}
 	log.Debugf("Registrar GetMasterIPs result: %v", masters)
 	return masters, nil
 }
 
+// SetDSRecords replaces the DS records of domain in its parent zone. The parent zone must be a primary zone
+// accessible with the registrar's credentials.
+func (a *AkamaiRegistrar) SetDSRecords(ctx context.Context, domain string, ds []registrar.DS) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering Akamai registrar SetDSRecords")
+
+	prior, err := a.dnsclient.SetParentRecords(domain, "DS", registrar.DefaultDSTTL, registrar.DSRdata(ds))
+	if err != nil {
+		log.Debugf("Registrar SetDSRecords failed. Error: %s", err.Error())
+		return err
+	}
+	if prior != nil {
+		log.Debugf("Registrar SetDSRecords replaced: %v", prior.Rdata)
+	}
+
+	return nil
+}
+
+// SetNameServers replaces the NS delegation of domain in its parent zone. The parent zone must be a primary zone
+// accessible with the registrar's credentials. Returns the replaced name servers.
+func (a *AkamaiRegistrar) SetNameServers(ctx context.Context, domain string, nameservers []string) ([]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering Akamai registrar SetNameServers")
+
+	prior, err := a.dnsclient.SetParentRecords(domain, "NS", registrar.DefaultNSTTL, nameservers)
+	if err != nil {
+		log.Debugf("Registrar SetNameServers failed. Error: %s", err.Error())
+		return nil, err
+	}
+	replaced := []string{}
+	if prior != nil {
+		replaced = prior.Rdata
+	}
+	log.Debugf("Registrar SetNameServers replaced: %v", replaced)
+
+	return replaced, nil
+}
+
 //
 // Config file processing
 //
@@ -329,40 +462,25 @@ func fileExists(filename string) bool {
 //
 func (o OpenDNSConfig) ListZones(queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
 
-	// both edgedns and this registrar using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *o.config
-
-	return dns.ListZones(queryArgs)
+	return o.client.ListZones(context.Background(), queryArgs)
 }
 
 func (o OpenDNSConfig) GetZone(domain string) (*dns.ZoneResponse, error) {
 
-	// both edgedns and this registrar using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *o.config
-
-	return dns.GetZone(domain)
+	return o.client.GetZone(context.Background(), domain)
 }
 
 func (o OpenDNSConfig) GetZoneKey(domain string) (*dns.TSIGKeyResponse, error) {
 
-	// both edgedns and this registrar using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *o.config
-
-	return dns.GetZoneKey(domain)
+	return o.client.GetZoneKey(context.Background(), domain)
 }
 
 func (o OpenDNSConfig) GetNameServerRecordList(contractId string) ([]string, error) {
 
-	// both edgedns and this registrar using dns. need to temp swap config...
-	existConfig := dns.Config
-	defer resetDNSConfig(existConfig)
-	dns.Config = *o.config
+	return o.client.GetNameServers(context.Background(), contractId)
+}
+
+func (o OpenDNSConfig) SetParentRecords(domain, rrtype string, ttl int, rdata []string) (*registrar.EdgeDNSRecordSet, error) {
 
-	return dns.GetNameServerRecordList(contractId)
+	return o.client.SetParentRecords(context.Background(), domain, rrtype, ttl, rdata)
 }
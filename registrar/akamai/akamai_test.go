@@ -19,6 +19,9 @@ import (
 
 	"context"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
 
 	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
 
@@ -330,6 +333,91 @@ func TestRegistrarGetMasterIPsFail(t *testing.T) {
         assert.Contains(t, err.Error(), "Fail")
 }
 
+func TestRegistrarGetMasterIPsFamily(t *testing.T) {
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Akamai",
+		"subcommand": "GetMasterIPsFamily",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	stubRegistrar, config := initRegistrarStub(ctx)
+	config.MasterAddressFamily = registrar.AddressFamilyBoth
+	testRegistrar, err := NewAkamaiRegistrar(ctx, config, stubRegistrar)
+	assert.Nil(t, err)
+	testRegistrar.dnsclient = newStubOpenDNSConfig(ctx)
+	testRegistrar.dnsclient.(StubOpenDNSConfig).FuncOutput["GetNameServerRecordList"] = []string{"1.2.3.4", "2001:db8::1"}
+	mips, err := testRegistrar.GetMasterIPs(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, mips)
+
+	testRegistrar.akamaiConfig.MasterAddressFamily = registrar.AddressFamilyIPv6
+	mips, err = testRegistrar.GetMasterIPs(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2001:db8::1"}, mips)
+
+	config.MasterAddressFamily = "v5"
+	_, err = NewAkamaiRegistrar(ctx, config, stubRegistrar)
+	assert.NotNil(t, err)
+}
+
+func TestRegistrarSetDSRecords(t *testing.T) {
+
+	ctx := context.TODO()
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Akamai",
+		"subcommand": "SetDSRecords",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	stubRegistrar, config := initRegistrarStub(ctx)
+	testRegistrar, err := NewAkamaiRegistrar(ctx, config, stubRegistrar)
+	assert.Nil(t, err)
+	stub := newStubOpenDNSConfig(ctx)
+	testRegistrar.dnsclient = stub
+	ds := []registrar.DS{{KeyTag: 55648, Algorithm: 13, DigestType: 2, Digest: "b4c8"}}
+	assert.Nil(t, testRegistrar.SetDSRecords(ctx, "sub.example.com", ds))
+	assert.Equal(t, &registrar.EdgeDNSRecordSet{Name: "sub.example.com", Type: "DS", TTL: registrar.DefaultDSTTL, Rdata: []string{"55648 13 2 B4C8"}}, stub.FuncOutput["SetParentRecords"])
+
+	stub.FuncErrors["SetParentRecords"] = "No parent zone"
+	assert.NotNil(t, testRegistrar.SetDSRecords(ctx, "sub.example.com", ds))
+}
+
+func TestRegistrarSetNameServers(t *testing.T) {
+
+	ctx := context.TODO()
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Akamai",
+		"subcommand": "SetNameServers",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	stubRegistrar, config := initRegistrarStub(ctx)
+	testRegistrar, err := NewAkamaiRegistrar(ctx, config, stubRegistrar)
+	assert.Nil(t, err)
+	stub := newStubOpenDNSConfig(ctx)
+	testRegistrar.dnsclient = stub
+	nameservers := []string{"a1-1.akam.net", "a2-2.akam.net"}
+	prior, err := testRegistrar.SetNameServers(ctx, "sub.example.com", nameservers)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(prior))
+	assert.Equal(t, &registrar.EdgeDNSRecordSet{Name: "sub.example.com", Type: "NS", TTL: registrar.DefaultNSTTL, Rdata: nameservers}, stub.FuncOutput["SetParentRecords"])
+
+	// replaced name servers are returned
+	stub.FuncOutput["SetParentRecordsPrior"] = &registrar.EdgeDNSRecordSet{Name: "sub.example.com", Type: "NS", Rdata: []string{"ns1.other.net"}}
+	prior, err = testRegistrar.SetNameServers(ctx, "sub.example.com", nameservers)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ns1.other.net"}, prior)
+
+	stub.FuncErrors["SetParentRecords"] = "No parent zone"
+	_, err = testRegistrar.SetNameServers(ctx, "sub.example.com", nameservers)
+	assert.NotNil(t, err)
+}
+
 //
 // Open DNS stubbable functions
 //
@@ -409,3 +497,44 @@ func (o StubOpenDNSConfig) GetNameServerRecordList(contractId string) ([]string,
         return []string{}, err
 }
 
+func (o StubOpenDNSConfig) SetParentRecords(domain, rrtype string, ttl int, rdata []string) (*registrar.EdgeDNSRecordSet, error) {
+
+	var err error
+	if errmsg, ok := o.FuncErrors["SetParentRecords"]; ok {
+		err = fmt.Errorf(errmsg)
+		log.Debugf("error: %s", err.Error())
+		return nil, err
+	}
+	o.FuncOutput["SetParentRecords"] = &registrar.EdgeDNSRecordSet{Name: domain, Type: rrtype, TTL: ttl, Rdata: rdata}
+	prior, ok := o.FuncOutput["SetParentRecordsPrior"]
+	if !ok {
+		return nil, nil
+	}
+	return prior.(*registrar.EdgeDNSRecordSet), nil
+}
+
+func TestRegistrarSecretRefresh(t *testing.T) {
+
+	ctx := context.TODO()
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Akamai",
+		"subcommand": "SecretRefresh",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first"), 0600))
+	stubRegistrar, config := initRegistrarStub(ctx)
+	config.AkamaiClientSecret = registrar.Secret("file://" + path)
+	// an explicit zero resolves references once
+	loaded, err := loadConfigContent(appLog, []byte("akamai_secret_refresh: 0s\n"))
+	assert.Nil(t, err)
+	assert.NotNil(t, loaded.SecretRefresh)
+	assert.Equal(t, time.Duration(0), registrar.SecretRefreshInterval(loaded.SecretRefresh))
+	config.SecretRefresh = loaded.SecretRefresh
+	testRegistrar, err := NewAkamaiRegistrar(ctx, config, stubRegistrar)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	assert.Nil(t, testRegistrar.refreshCredentials(ctx))
+	assert.Equal(t, "first", testRegistrar.dnsclient.(*OpenDNSConfig).client.Config().ClientSecret)
+}
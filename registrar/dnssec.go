@@ -0,0 +1,158 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"github.com/miekg/dns"
+
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultDSTTL is the TTL of DS records published at the parent
+	DefaultDSTTL = 86400
+)
+
+// DS is a delegation signer record published at the parent of a signed domain
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     string
+}
+
+// String returns the DS record data, e.g. 12345 13 2 3A1EADA7...
+func (d DS) String() string {
+
+	return fmt.Sprintf("%d %d %d %s", d.KeyTag, d.Algorithm, d.DigestType, strings.ToUpper(d.Digest))
+}
+
+// ParseDS parses a DS resource record, e.g. example.com. 86400 IN DS 12345 13 2 3A1EADA7..., or its record data
+func ParseDS(record string) (DS, error) {
+
+	record = strings.TrimSpace(record)
+	if fields := strings.Fields(record); len(fields) > 0 && !strings.HasSuffix(fields[0], ".") {
+		record = ". IN DS " + record
+	}
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		return DS{}, fmt.Errorf("Invalid DS record %q. %s", record, err.Error())
+	}
+	ds, ok := rr.(*dns.DS)
+	if !ok {
+		return DS{}, fmt.Errorf("Invalid DS record %q", record)
+	}
+
+	return DS{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: strings.ToUpper(ds.Digest)}, nil
+}
+
+// DSFromDNSKEY returns the SHA-256 DS of a DNSKEY resource record
+func DSFromDNSKEY(record string) (DS, error) {
+
+	rr, err := dns.NewRR(strings.TrimSpace(record))
+	if err != nil {
+		return DS{}, fmt.Errorf("Invalid DNSKEY record %q. %s", record, err.Error())
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return DS{}, fmt.Errorf("Invalid DNSKEY record %q", record)
+	}
+	ds := key.ToDS(dns.SHA256)
+	if ds == nil {
+		return DS{}, fmt.Errorf("Unable to compute DS of DNSKEY record %q", record)
+	}
+
+	return DS{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: strings.ToUpper(ds.Digest)}, nil
+}
+
+// EdgeDNSSECRecords are the DNSKEY and DS records of a signed zone. Multiple records are newline separated.
+type EdgeDNSSECRecords struct {
+	DNSKEYRecord     string `json:"dnskeyRecord,omitempty"`
+	DSRecord         string `json:"dsRecord,omitempty"`
+	ExpectedTTL      int    `json:"expectedTtl,omitempty"`
+	LastModifiedDate string `json:"lastModifiedDate,omitempty"`
+}
+
+// EdgeDNSSECStatus is the DNSSEC status of a sign and serve zone
+type EdgeDNSSECStatus struct {
+	Zone           string             `json:"zone"`
+	Alerts         []string           `json:"alerts,omitempty"`
+	CurrentRecords *EdgeDNSSECRecords `json:"currentRecords,omitempty"`
+	NewRecords     *EdgeDNSSECRecords `json:"newRecords,omitempty"`
+}
+
+// DSRecords returns the DS records of the current signing keys. DS records are computed from key signing DNSKEY
+// records if Edge DNS returned none.
+func (s *EdgeDNSSECStatus) DSRecords() ([]DS, error) {
+
+	records := []DS{}
+	if s.CurrentRecords == nil {
+		return records, nil
+	}
+	for _, line := range strings.Split(s.CurrentRecords.DSRecord, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		ds, err := ParseDS(line)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, ds)
+	}
+	if len(records) > 0 {
+		return records, nil
+	}
+	for _, line := range strings.Split(s.CurrentRecords.DNSKEYRecord, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rr, err := dns.NewRR(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DNSKEY record %q. %s", line, err.Error())
+		}
+		// zone signing keys have no DS
+		if key, ok := rr.(*dns.DNSKEY); !ok || key.Flags&dns.SEP == 0 {
+			continue
+		}
+		ds, err := DSFromDNSKEY(line)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, ds)
+	}
+
+	return records, nil
+}
+
+// GetDNSSECStatus retrieves the DNSSEC status of sign and serve zones
+func (c *EdgeDNSClient) GetDNSSECStatus(ctx context.Context, zones []string) ([]*EdgeDNSSECStatus, error) {
+
+	if len(zones) < 1 {
+		return []*EdgeDNSSECStatus{}, nil
+	}
+	req := struct {
+		Zones []string `json:"zones"`
+	}{Zones: zones}
+	resp := struct {
+		DNSSECStatuses []*EdgeDNSSECStatus `json:"dnsSecStatuses"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "/config-dns/v2/zones/dns-sec-status", req, &resp, ""); err != nil {
+		return nil, err
+	}
+
+	return resp.DNSSECStatuses, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	// RFC 6605 example key and its SHA-256 DS
+	testKSK    = "example.net. 3600 IN DNSKEY 257 3 13 GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edbkrSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA=="
+	testZSK    = "example.net. 3600 IN DNSKEY 256 3 13 GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edbkrSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA=="
+	testDSData = "55648 13 2 B4C8C1FE2E7477127B27115656AD6256F424625BF5C1E2770CE6D6E37DF61D17"
+)
+
+func TestParseDS(t *testing.T) {
+
+	ds, err := ParseDS("example.net. 86400 IN DS 55648 13 2 b4c8c1fe2e7477127b27115656ad6256f424625bf5c1e2770ce6d6e37df61d17")
+	assert.Nil(t, err)
+	assert.Equal(t, testDSData, ds.String())
+	ds, err = ParseDS(testDSData)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(55648), ds.KeyTag)
+	_, err = ParseDS("example.net. 86400 IN NS a1-1.akam.net.")
+	assert.NotNil(t, err)
+
+	ds, err = DSFromDNSKEY(testKSK)
+	assert.Nil(t, err)
+	assert.Equal(t, testDSData, ds.String())
+}
+
+func TestEdgeDNSSECStatusDSRecords(t *testing.T) {
+
+	status := &EdgeDNSSECStatus{Zone: "example.net", CurrentRecords: &EdgeDNSSECRecords{DSRecord: "example.net. 86400 IN DS " + testDSData + "\n"}}
+	records, err := status.DSRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{testDSData}, DSRdata(records))
+
+	// computed from the key signing key
+	status.CurrentRecords = &EdgeDNSSECRecords{DNSKEYRecord: testZSK + "\n" + testKSK}
+	records, err = status.DSRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{testDSData}, DSRdata(records))
+
+	status.CurrentRecords = nil
+	records, err = status.DSRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestEdgeDNSClientDNSSECStatus(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/config-dns/v2/zones/dns-sec-status", r.URL.Path)
+		fmt.Fprintf(w, `{"dnsSecStatuses":[{"zone":"example.net","alerts":[],"currentRecords":{"dsRecord":"example.net. 86400 IN DS %s","expectedTtl":86400}}]}`, testDSData)
+	}))
+	defer srv.Close()
+
+	c := newTestEdgeDNSClient(t, srv, "token-a")
+	statuses, err := c.GetDNSSECStatus(context.Background(), []string{"example.net"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(statuses))
+	records, err := statuses[0].DSRecords()
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(13), records[0].Algorithm)
+}
@@ -0,0 +1,401 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	client "github.com/akamai/AkamaiOPEN-edgegrid-golang/client-v1"
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	edgegrid "github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
+
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultEdgeDNSTimeout         = 60 * time.Second
+	DefaultEdgeDNSKeepAlive       = 30 * time.Second
+	DefaultEdgeDNSIdleConnTimeout = 90 * time.Second
+	DefaultEdgeDNSMaxIdleConns    = 10
+)
+
+var (
+	// edgegrid lazily initializes its signing logger. Initialize once before concurrent signing.
+	edgegridLogOnce sync.Once
+)
+
+// EdgeDNSClientOptions are the HTTP transport settings of an EdgeDNSClient. Zero values use the defaults.
+type EdgeDNSClientOptions struct {
+	Timeout         time.Duration // request timeout, including reading the response body
+	KeepAlive       time.Duration // TCP keep-alive period. Negative disables keep-alive
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	Proxy           string // proxy URL. Empty uses the HTTPS_PROXY and NO_PROXY env vars
+}
+
+// EdgeDNSNotFoundError is returned when a zone, zone key or contract does not exist
+type EdgeDNSNotFoundError struct {
+	Name string
+}
+
+func (e *EdgeDNSNotFoundError) Error() string {
+
+	return fmt.Sprintf("Zone \"%s\" not found.", e.Name)
+}
+
+// NotFound is always true. Matches the configdns-v2 ConfigDNSError method.
+func (e *EdgeDNSNotFoundError) NotFound() bool {
+
+	return true
+}
+
+// EdgeDNSClient is an Edge DNS API client. Unlike the configdns-v2 package functions, it signs requests with its own
+// Edgegrid config and sends them with its own http.Client, so clients with different credentials can be used
+// concurrently.
+type EdgeDNSClient struct {
+	lock       sync.RWMutex
+	config     edgegrid.Config
+	httpClient *http.Client
+	// cached group and contract lists
+	directory edgeDNSDirectory
+}
+
+// NewEdgeDNSClient creates an Edge DNS API client for the Edgegrid config
+func NewEdgeDNSClient(config edgegrid.Config, opts EdgeDNSClientOptions) (*EdgeDNSClient, error) {
+
+	if config.Host == "" {
+		return nil, fmt.Errorf("Edgegrid host is required")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultEdgeDNSTimeout
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultEdgeDNSKeepAlive
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultEdgeDNSIdleConnTimeout
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultEdgeDNSMaxIdleConns
+	}
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("Invalid Edge DNS proxy URL %q", opts.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: opts.KeepAlive,
+		}).DialContext,
+		DisableKeepAlives:     opts.KeepAlive < 0,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConns,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	edgegridLogOnce.Do(edgegrid.SetupLogging)
+	c := &EdgeDNSClient{config: config}
+	c.httpClient = &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// redirects are signed with the config of the original request
+			edgegrid.AddRequestHeader(c.Config(), req)
+			return nil
+		},
+	}
+
+	return c, nil
+}
+
+// Config returns a copy of the client Edgegrid config
+func (c *EdgeDNSClient) Config() edgegrid.Config {
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.config
+}
+
+// SetCredentials replaces the client credentials, e.g. after rotation. Requests in flight keep the prior credentials.
+func (c *EdgeDNSClient) SetCredentials(clientToken, clientSecret, accessToken string) {
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.config.ClientToken = clientToken
+	c.config.ClientSecret = clientSecret
+	c.config.AccessToken = accessToken
+}
+
+// CloseIdleConnections closes idle keep-alive connections
+func (c *EdgeDNSClient) CloseIdleConnections() {
+
+	c.httpClient.CloseIdleConnections()
+}
+
+// do signs and sends a request. On success, the JSON response body is unmarshalled into result.
+func (c *EdgeDNSClient) do(ctx context.Context, method, path string, body interface{}, result interface{}, notFound string) error {
+
+	config := c.Config()
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = client.NewJSONRequest(config, method, path, body)
+	} else {
+		req, err = client.NewRequest(config, method, path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	req = edgegrid.AddRequestHeader(config, req.WithContext(ctx))
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound && notFound != "" {
+		return &EdgeDNSNotFoundError{Name: notFound}
+	}
+	if client.IsError(res) {
+		return client.NewAPIError(res)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return client.BodyJSON(res, result)
+}
+
+// GetZones lists zones. The query arguments match the configdns-v2 ListZones function.
+func (c *EdgeDNSClient) GetZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
+
+	q := url.Values{}
+	if queryArgs.Page > 0 {
+		q.Add("page", strconv.Itoa(queryArgs.Page))
+	}
+	if queryArgs.PageSize > 0 {
+		q.Add("pageSize", strconv.Itoa(queryArgs.PageSize))
+	}
+	if queryArgs.Search != "" {
+		q.Add("search", queryArgs.Search)
+	}
+	q.Add("showAll", strconv.FormatBool(queryArgs.ShowAll))
+	if queryArgs.SortBy != "" {
+		q.Add("sortBy", queryArgs.SortBy)
+	}
+	if queryArgs.Types != "" {
+		q.Add("types", queryArgs.Types)
+	}
+	if queryArgs.ContractIds != "" {
+		q.Add("contractIds", queryArgs.ContractIds)
+	}
+	zoneList := &dns.ZoneListResponse{}
+	if err := c.do(ctx, http.MethodGet, "/config-dns/v2/zones?"+q.Encode(), nil, zoneList, ""); err != nil {
+		return nil, err
+	}
+
+	return zoneList, nil
+}
+
+// ListZones is GetZones
+func (c *EdgeDNSClient) ListZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
+
+	return c.GetZones(ctx, queryArgs)
+}
+
+// GetZoneNames lists the names of zones not in one of the stateFilter activation states. The list is requested one
+// page at a time unless ShowAll is set.
+func (c *EdgeDNSClient) GetZoneNames(ctx context.Context, queryArgs dns.ZoneListQueryArgs, stateFilter []string) ([]string, error) {
+
+	if !queryArgs.ShowAll {
+		return ZoneNames(c.IterateZones(ctx, queryArgs), stateFilter)
+	}
+	zlResp, err := c.GetZones(ctx, queryArgs)
+	if err != nil {
+		return []string{}, err
+	}
+	zones := make([]string, 0, len(zlResp.Zones))
+	for _, zone := range zlResp.Zones {
+		if containsString(stateFilter, zone.ActivationState) {
+			continue
+		}
+		zones = append(zones, zone.Zone)
+	}
+
+	return zones, nil
+}
+
+// GetZoneStates lists the activation states of zones keyed by zone name. The list is requested one page at a time.
+func (c *EdgeDNSClient) GetZoneStates(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (map[string]string, error) {
+
+	return ZoneStates(c.IterateZones(ctx, queryArgs))
+}
+
+// GetZone retrieves a zone
+func (c *EdgeDNSClient) GetZone(ctx context.Context, zone string) (*dns.ZoneResponse, error) {
+
+	zoneResp := &dns.ZoneResponse{}
+	if err := c.do(ctx, http.MethodGet, "/config-dns/v2/zones/"+zone, nil, zoneResp, zone); err != nil {
+		return nil, err
+	}
+
+	return zoneResp, nil
+}
+
+// GetZoneKey retrieves the TSIG key of a zone
+func (c *EdgeDNSClient) GetZoneKey(ctx context.Context, zone string) (*dns.TSIGKeyResponse, error) {
+
+	keyResp := &dns.TSIGKeyResponse{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/config-dns/v2/zones/%s/key", zone), nil, keyResp, zone); err != nil {
+		return nil, err
+	}
+
+	return keyResp, nil
+}
+
+// UpdateZoneKey replaces the TSIG key of a zone
+func (c *EdgeDNSClient) UpdateZoneKey(ctx context.Context, zone string, key *dns.TSIGKey) error {
+
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/config-dns/v2/zones/%s/key", zone), key, nil, zone); err != nil {
+		return fmt.Errorf("Zone \"%s\" key update failed: %s", zone, err.Error())
+	}
+
+	return nil
+}
+
+// CreateZone creates a zone
+func (c *EdgeDNSClient) CreateZone(ctx context.Context, zone *dns.ZoneCreate, zonequerystring dns.ZoneQueryString) error {
+
+	path := "/config-dns/v2/zones/?contractId=" + url.QueryEscape(zonequerystring.Contract)
+	if zonequerystring.Group != "" {
+		path += "&gid=" + url.QueryEscape(zonequerystring.Group)
+	}
+	if err := c.do(ctx, http.MethodPost, path, zoneCreateBody(zone), nil, ""); err != nil {
+		return fmt.Errorf("Zone \"%s\" create failed: %s", zone.Zone, err.Error())
+	}
+
+	return nil
+}
+
+// UpdateZone replaces the settings of a zone, e.g. its comment
+func (c *EdgeDNSClient) UpdateZone(ctx context.Context, zone *dns.ZoneCreate) error {
+
+	if err := c.do(ctx, http.MethodPut, "/config-dns/v2/zones/"+zone.Zone, zoneCreateBody(zone), nil, zone.Zone); err != nil {
+		return fmt.Errorf("Zone \"%s\" update failed: %s", zone.Zone, err.Error())
+	}
+
+	return nil
+}
+
+// ReplaceRecordsets replaces all recordsets of a primary zone
+func (c *EdgeDNSClient) ReplaceRecordsets(ctx context.Context, zone string, recordsets *dns.Recordsets) error {
+
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/config-dns/v2/zones/%s/recordsets", zone), recordsets, nil, zone); err != nil {
+		return fmt.Errorf("Zone \"%s\" recordsets update failed: %s", zone, err.Error())
+	}
+
+	return nil
+}
+
+// zoneCreateBody returns the zone create request body. Fields not applicable to the zone type are omitted.
+func zoneCreateBody(zone *dns.ZoneCreate) map[string]interface{} {
+
+	zoneType := strings.ToUpper(zone.Type)
+	body := map[string]interface{}{
+		"zone":          zone.Zone,
+		"type":          zone.Type,
+		"comment":       zone.Comment,
+		"endCustomerId": zone.EndCustomerId,
+		"contractId":    zone.ContractId,
+	}
+	switch zoneType {
+	case "SECONDARY":
+		body["masters"] = zone.Masters
+		body["tsigKey"] = zone.TsigKey
+	case "ALIAS":
+		body["target"] = zone.Target
+	}
+	if zoneType != "ALIAS" {
+		body["signAndServe"] = zone.SignAndServe
+		body["signAndServeAlgorithm"] = zone.SignAndServeAlgorithm
+	}
+
+	return body
+}
+
+// CreateBulkZones submits a bulk zone create request
+func (c *EdgeDNSClient) CreateBulkZones(ctx context.Context, bulkzones *dns.BulkZonesCreate, zonequerystring dns.ZoneQueryString) (*dns.BulkZonesResponse, error) {
+
+	path := "/config-dns/v2/zones/create-requests?contractId=" + url.QueryEscape(zonequerystring.Contract)
+	if zonequerystring.Group != "" {
+		path += "&gid=" + url.QueryEscape(zonequerystring.Group)
+	}
+	bulkResp := &dns.BulkZonesResponse{}
+	if err := c.do(ctx, http.MethodPost, path, bulkzones, bulkResp, ""); err != nil {
+		return nil, err
+	}
+
+	return bulkResp, nil
+}
+
+// DeleteBulkZones submits a bulk zone delete request
+func (c *EdgeDNSClient) DeleteBulkZones(ctx context.Context, zoneslist *dns.ZoneNameListResponse) (*dns.BulkZonesResponse, error) {
+
+	bulkResp := &dns.BulkZonesResponse{}
+	if err := c.do(ctx, http.MethodPost, "/config-dns/v2/zones/delete-requests", zoneslist, bulkResp, ""); err != nil {
+		return nil, err
+	}
+
+	return bulkResp, nil
+}
+
+// GetNameServers returns the Edge DNS name servers of the contract
+func (c *EdgeDNSClient) GetNameServers(ctx context.Context, contract string) ([]string, error) {
+
+	authorities := &dns.AuthorityResponse{}
+	if err := c.do(ctx, http.MethodGet, "/config-dns/v2/data/authorities?contractIds="+url.QueryEscape(contract), nil, authorities, contract); err != nil {
+		return nil, err
+	}
+	ns := []string{}
+	for _, c := range authorities.Contracts {
+		ns = append(ns, c.Authorities...)
+	}
+
+	return ns, nil
+}
+
+func containsString(list []string, s string) bool {
+
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	edgegrid "github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newTestEdgeDNSClient returns a client for the TLS test server signing with clientToken
+func newTestEdgeDNSClient(t *testing.T, srv *httptest.Server, clientToken string) *EdgeDNSClient {
+
+	c, err := NewEdgeDNSClient(edgegrid.Config{
+		Host:         strings.TrimPrefix(srv.URL, "https://"),
+		ClientToken:  clientToken,
+		ClientSecret: "secret-" + clientToken,
+		AccessToken:  "access-" + clientToken,
+		MaxBody:      131072,
+	}, EdgeDNSClientOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err.Error())
+	}
+	c.httpClient.Transport.(*http.Transport).TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	return c
+}
+
+// authClientToken returns the client token of an Edgegrid Authorization header
+func authClientToken(r *http.Request) string {
+
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "EG1-HMAC-SHA256 "), ";") {
+		if strings.HasPrefix(part, "client_token=") {
+			return strings.TrimPrefix(part, "client_token=")
+		}
+	}
+
+	return ""
+}
+
+func TestEdgeDNSClientConcurrentCredentials(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// zone name is <client token>.example.com
+		zone := strings.TrimPrefix(r.URL.Path, "/config-dns/v2/zones/")
+		json.NewEncoder(w).Encode(dns.ZoneResponse{Zone: zone, Comment: authClientToken(r)})
+	}))
+	defer srv.Close()
+
+	clients := []*EdgeDNSClient{newTestEdgeDNSClient(t, srv, "token-a"), newTestEdgeDNSClient(t, srv, "token-b")}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(c *EdgeDNSClient, token string) {
+			defer wg.Done()
+			zone, err := c.GetZone(context.Background(), token+".example.com")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if zone.Comment != token {
+				errs <- fmt.Errorf("zone %s signed with %s", zone.Zone, zone.Comment)
+			}
+		}(clients[i%2], []string{"token-a", "token-b"}[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	clients[0].SetCredentials("token-c", "secret-c", "access-c")
+	zone, err := clients[0].GetZone(context.Background(), "rotated.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "token-c", zone.Comment)
+}
+
+func TestEdgeDNSClientRequests(t *testing.T) {
+
+	var created, updated map[string]interface{}
+	var key dns.TSIGKey
+	var recordsets dns.Recordsets
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/config-dns/v2/zones/secondary.com/key":
+			json.NewDecoder(r.Body).Decode(&key)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut && r.URL.Path == "/config-dns/v2/zones/secondary.com/recordsets":
+			json.NewDecoder(r.Body).Decode(&recordsets)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut && r.URL.Path == "/config-dns/v2/zones/secondary.com":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &updated)
+			w.Write(body)
+		case r.Method == http.MethodGet && r.URL.Path == "/config-dns/v2/zones":
+			assert.Equal(t, "SECONDARY", r.URL.Query().Get("types"))
+			json.NewEncoder(w).Encode(dns.ZoneListResponse{Zones: []*dns.ZoneResponse{
+				{Zone: "active.com", ActivationState: "ACTIVE"},
+				{Zone: "locked.com", ActivationState: "LOCKED"},
+			}})
+		case r.Method == http.MethodPost && r.URL.Path == "/config-dns/v2/zones/":
+			assert.Equal(t, "ctr_1", r.URL.Query().Get("contractId"))
+			assert.Equal(t, "123", r.URL.Query().Get("gid"))
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &created)
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == "/config-dns/v2/data/authorities":
+			w.Write([]byte(`{"contracts":[{"contractId":"ctr_1","authorities":["a1-1.akam.net.","a2-2.akam.net."]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"title":"Not Found","status":404}`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := newTestEdgeDNSClient(t, srv, "token")
+
+	zones, err := c.GetZoneNames(ctx, dns.ZoneListQueryArgs{Types: "SECONDARY"}, []string{"LOCKED"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"active.com"}, zones)
+
+	err = c.CreateZone(ctx, &dns.ZoneCreate{Zone: "primary.com", Type: "PRIMARY", Masters: []string{"1.2.3.4"}}, dns.ZoneQueryString{Contract: "ctr_1", Group: "123"})
+	assert.Nil(t, err)
+	assert.Equal(t, "primary.com", created["zone"])
+	_, ok := created["masters"]
+	assert.False(t, ok)
+
+	err = c.UpdateZoneKey(ctx, "secondary.com", &dns.TSIGKey{Name: "secondary.com-20210101000000", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hmac-sha256", key.Algorithm)
+	assert.NotNil(t, c.UpdateZoneKey(ctx, "missing.com", &dns.TSIGKey{}))
+
+	err = c.UpdateZone(ctx, &dns.ZoneCreate{Zone: "secondary.com", Type: "SECONDARY", Masters: []string{"1.2.3.4"}, Comment: "updated"})
+	assert.Nil(t, err)
+	assert.Equal(t, "updated", updated["comment"])
+	assert.Equal(t, []interface{}{"1.2.3.4"}, updated["masters"])
+	assert.NotNil(t, c.UpdateZone(ctx, &dns.ZoneCreate{Zone: "missing.com", Type: "SECONDARY"}))
+
+	err = c.ReplaceRecordsets(ctx, "secondary.com", &dns.Recordsets{Recordsets: []dns.Recordset{{Name: "www.secondary.com", Type: "A", TTL: 300, Rdata: []string{"10.0.0.1"}}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "www.secondary.com", recordsets.Recordsets[0].Name)
+	assert.NotNil(t, c.ReplaceRecordsets(ctx, "missing.com", &dns.Recordsets{}))
+
+	ns, err := c.GetNameServers(ctx, "ctr_1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a1-1.akam.net.", "a2-2.akam.net."}, ns)
+
+	_, err = c.GetZone(ctx, "missing.com")
+	if assert.NotNil(t, err) {
+		notFound, ok := err.(*EdgeDNSNotFoundError)
+		assert.True(t, ok)
+		assert.True(t, ok && notFound.NotFound())
+	}
+	_, err = c.DeleteBulkZones(ctx, &dns.ZoneNameListResponse{Zones: []string{"a.com"}})
+	assert.NotNil(t, err)
+}
+
+func TestNewEdgeDNSClientInvalid(t *testing.T) {
+
+	_, err := NewEdgeDNSClient(edgegrid.Config{}, EdgeDNSClientOptions{})
+	assert.NotNil(t, err)
+	_, err = NewEdgeDNSClient(edgegrid.Config{Host: "akab.example.net"}, EdgeDNSClientOptions{Proxy: "not a url"})
+	assert.NotNil(t, err)
+	c, err := NewEdgeDNSClient(edgegrid.Config{Host: "akab.example.net"}, EdgeDNSClientOptions{Proxy: "http://proxy.example.net:3128"})
+	assert.Nil(t, err)
+	req, _ := http.NewRequest(http.MethodGet, "https://akab.example.net/config-dns/v2/zones", nil)
+	proxyURL, err := c.httpClient.Transport.(*http.Transport).Proxy(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "proxy.example.net:3128", proxyURL.Host)
+}
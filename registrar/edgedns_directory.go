@@ -0,0 +1,184 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEdgeDNSDirectoryTTL is how long group and contract lists are cached
+	DefaultEdgeDNSDirectoryTTL = time.Hour
+	// EdgeDNSPermissionAdd is the group and contract permission to create zones
+	EdgeDNSPermissionAdd = "ADD"
+)
+
+// EdgeDNSGroup is a group visible to the Edge DNS credentials
+type EdgeDNSGroup struct {
+	GroupID     int      `json:"groupId"`
+	GroupName   string   `json:"groupName"`
+	ContractIDs []string `json:"contractIds"`
+	Permissions []string `json:"permissions"`
+}
+
+// CanCreateZones returns true if the credentials may create zones in the group
+func (g *EdgeDNSGroup) CanCreateZones() bool {
+
+	return containsString(g.Permissions, EdgeDNSPermissionAdd)
+}
+
+// EdgeDNSContract is a contract visible to the Edge DNS credentials
+type EdgeDNSContract struct {
+	ContractID       string   `json:"contractId"`
+	ContractName     string   `json:"contractName"`
+	ContractTypeName string   `json:"contractTypeName"`
+	Features         []string `json:"features"`
+	Permissions      []string `json:"permissions"`
+	ZoneCount        int64    `json:"zoneCount"`
+	MaximumZones     int64    `json:"maximumZones"`
+}
+
+// CanCreateZones returns true if the credentials may create zones under the contract
+func (c *EdgeDNSContract) CanCreateZones() bool {
+
+	return containsString(c.Permissions, EdgeDNSPermissionAdd)
+}
+
+// edgeDNSDirectory caches the group and contract lists of a client
+type edgeDNSDirectory struct {
+	lock            sync.Mutex
+	groups          []*EdgeDNSGroup
+	groupsFetched   time.Time
+	contracts       map[int][]*EdgeDNSContract
+	contractFetched map[int]time.Time
+}
+
+// GetGroups lists the groups visible to the client credentials. The list is cached for DefaultEdgeDNSDirectoryTTL.
+func (c *EdgeDNSClient) GetGroups(ctx context.Context) ([]*EdgeDNSGroup, error) {
+
+	c.directory.lock.Lock()
+	defer c.directory.lock.Unlock()
+
+	if c.directory.groups != nil && time.Since(c.directory.groupsFetched) < DefaultEdgeDNSDirectoryTTL {
+		return c.directory.groups, nil
+	}
+	groups := &struct {
+		Groups []*EdgeDNSGroup `json:"groups"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, "/config-dns/v2/data/groups/", nil, groups, ""); err != nil {
+		return nil, err
+	}
+	c.directory.groups = groups.Groups
+	c.directory.groupsFetched = time.Now()
+
+	return groups.Groups, nil
+}
+
+// GetContracts lists the contracts visible to the client credentials. A groupID greater than zero lists the
+// contracts of the group. Lists are cached for DefaultEdgeDNSDirectoryTTL.
+func (c *EdgeDNSClient) GetContracts(ctx context.Context, groupID int) ([]*EdgeDNSContract, error) {
+
+	c.directory.lock.Lock()
+	defer c.directory.lock.Unlock()
+
+	if list, ok := c.directory.contracts[groupID]; ok && time.Since(c.directory.contractFetched[groupID]) < DefaultEdgeDNSDirectoryTTL {
+		return list, nil
+	}
+	path := "/config-dns/v2/data/contracts/"
+	if groupID > 0 {
+		path += "?gid=" + strconv.Itoa(groupID)
+	}
+	contracts := &struct {
+		Contracts []*EdgeDNSContract `json:"contracts"`
+	}{}
+	if err := c.do(ctx, http.MethodGet, path, nil, contracts, ""); err != nil {
+		return nil, err
+	}
+	if c.directory.contracts == nil {
+		c.directory.contracts = map[int][]*EdgeDNSContract{}
+		c.directory.contractFetched = map[int]time.Time{}
+	}
+	c.directory.contracts[groupID] = contracts.Contracts
+	c.directory.contractFetched[groupID] = time.Now()
+
+	return contracts.Contracts, nil
+}
+
+// FindGroup returns the group with the name or numeric ID. Names are matched case insensitively. A name matching
+// more than one group is an error.
+func FindGroup(groups []*EdgeDNSGroup, name string) (*EdgeDNSGroup, error) {
+
+	name = strings.TrimSpace(name)
+	if id, err := strconv.Atoi(name); err == nil {
+		for _, g := range groups {
+			if g.GroupID == id {
+				return g, nil
+			}
+		}
+	}
+	matches := []*EdgeDNSGroup{}
+	for _, g := range groups {
+		if strings.EqualFold(g.GroupName, name) {
+			matches = append(matches, g)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("Edge DNS group %q not found", name)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, 0, len(matches))
+	for _, g := range matches {
+		ids = append(ids, strconv.Itoa(g.GroupID))
+	}
+
+	return nil, fmt.Errorf("Edge DNS group name %q is ambiguous. Matching group ids: %s", name, strings.Join(ids, ", "))
+}
+
+// FindContract returns the contract with the name or ID. Names are matched case insensitively. A name matching
+// more than one contract is an error.
+func FindContract(contracts []*EdgeDNSContract, name string) (*EdgeDNSContract, error) {
+
+	name = strings.TrimSpace(name)
+	for _, c := range contracts {
+		if strings.EqualFold(c.ContractID, name) {
+			return c, nil
+		}
+	}
+	matches := []*EdgeDNSContract{}
+	for _, c := range contracts {
+		if strings.EqualFold(c.ContractName, name) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("Edge DNS contract %q not found", name)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, 0, len(matches))
+	for _, c := range matches {
+		ids = append(ids, c.ContractID)
+	}
+
+	return nil, fmt.Errorf("Edge DNS contract name %q is ambiguous. Matching contract ids: %s", name, strings.Join(ids, ", "))
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEdgeDNSClientDirectory(t *testing.T) {
+
+	var requests int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/config-dns/v2/data/groups/":
+			fmt.Fprint(w, `{"groups":[{"groupId":11,"groupName":"Primary","contractIds":["1-ABC"],"permissions":["READ","ADD"]}]}`)
+		case "/config-dns/v2/data/contracts/":
+			assert.Equal(t, "11", r.URL.Query().Get("gid"))
+			fmt.Fprint(w, `{"contracts":[{"contractId":"1-ABC","contractName":"Example","permissions":["READ"]}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestEdgeDNSClient(t, srv, "token-a")
+	groups, err := c.GetGroups(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, 11, groups[0].GroupID)
+	assert.True(t, groups[0].CanCreateZones())
+	contracts, err := c.GetContracts(context.Background(), 11)
+	assert.Nil(t, err)
+	assert.Equal(t, "Example", contracts[0].ContractName)
+	assert.False(t, contracts[0].CanCreateZones())
+
+	// cached
+	_, err = c.GetGroups(context.Background())
+	assert.Nil(t, err)
+	_, err = c.GetContracts(context.Background(), 11)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestFindGroupAndContract(t *testing.T) {
+
+	groups := []*EdgeDNSGroup{
+		{GroupID: 11, GroupName: "Primary"},
+		{GroupID: 12, GroupName: "Partner"},
+		{GroupID: 13, GroupName: "PARTNER"},
+	}
+	g, err := FindGroup(groups, "primary")
+	assert.Nil(t, err)
+	assert.Equal(t, 11, g.GroupID)
+	g, err = FindGroup(groups, "13")
+	assert.Nil(t, err)
+	assert.Equal(t, "PARTNER", g.GroupName)
+	_, err = FindGroup(groups, "Partner")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "12, 13")
+	_, err = FindGroup(groups, "missing")
+	assert.NotNil(t, err)
+
+	contracts := []*EdgeDNSContract{
+		{ContractID: "1-ABC", ContractName: "Example"},
+		{ContractID: "1-DEF", ContractName: "Shared"},
+		{ContractID: "1-GHI", ContractName: "shared"},
+	}
+	c, err := FindContract(contracts, "example")
+	assert.Nil(t, err)
+	assert.Equal(t, "1-ABC", c.ContractID)
+	c, err = FindContract(contracts, "1-def")
+	assert.Nil(t, err)
+	assert.Equal(t, "Shared", c.ContractName)
+	_, err = FindContract(contracts, "Shared")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "ambiguous")
+}
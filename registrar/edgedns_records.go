@@ -0,0 +1,117 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultNSTTL is the TTL of NS records delegating a domain in its parent zone
+	DefaultNSTTL = 86400
+)
+
+// EdgeDNSRecordSet is a record set of a zone
+type EdgeDNSRecordSet struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	TTL   int      `json:"ttl"`
+	Rdata []string `json:"rdata"`
+}
+
+func recordSetPath(zone, name, rrtype string) string {
+
+	return fmt.Sprintf("/config-dns/v2/zones/%s/names/%s/types/%s", zone, name, strings.ToUpper(rrtype))
+}
+
+// GetRecordSet retrieves a record set. A missing record set returns an EdgeDNSNotFoundError.
+func (c *EdgeDNSClient) GetRecordSet(ctx context.Context, zone, name, rrtype string) (*EdgeDNSRecordSet, error) {
+
+	rs := &EdgeDNSRecordSet{}
+	if err := c.do(ctx, http.MethodGet, recordSetPath(zone, name, rrtype), nil, rs, name+" "+strings.ToUpper(rrtype)); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// SetRecordSet creates or replaces a record set of a zone. The replaced record set, if any, is returned.
+func (c *EdgeDNSClient) SetRecordSet(ctx context.Context, zone string, rs *EdgeDNSRecordSet) (*EdgeDNSRecordSet, error) {
+
+	prior, err := c.GetRecordSet(ctx, zone, rs.Name, rs.Type)
+	if err != nil {
+		if _, ok := err.(*EdgeDNSNotFoundError); !ok {
+			return nil, err
+		}
+		prior = nil
+	}
+	method := http.MethodPost
+	if prior != nil {
+		method = http.MethodPut
+	}
+	if err := c.do(ctx, method, recordSetPath(zone, rs.Name, rs.Type), rs, nil, ""); err != nil {
+		return nil, fmt.Errorf("Record set %s %s update in zone %s failed: %s", rs.Name, rs.Type, zone, err.Error())
+	}
+
+	return prior, nil
+}
+
+// FindParentZone returns the closest zone above domain, e.g. example.com for sub.example.com. The parent must be a
+// primary zone so its records can be changed.
+func (c *EdgeDNSClient) FindParentZone(ctx context.Context, domain string) (string, error) {
+
+	labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+	for i := 1; i < len(labels); i++ {
+		parent := strings.Join(labels[i:], ".")
+		zone, err := c.GetZone(ctx, parent)
+		if err != nil {
+			if _, ok := err.(*EdgeDNSNotFoundError); ok {
+				continue
+			}
+			return "", err
+		}
+		if !strings.EqualFold(zone.Type, "PRIMARY") {
+			return "", fmt.Errorf("Parent zone %s of %s is a %s zone", parent, domain, zone.Type)
+		}
+		return parent, nil
+	}
+
+	return "", fmt.Errorf("No parent zone of %s found", domain)
+}
+
+// SetParentRecords replaces the record set of type rrtype at domain in its parent zone, e.g. the DS or NS delegation
+// records. The replaced record set, if any, is returned.
+func (c *EdgeDNSClient) SetParentRecords(ctx context.Context, domain, rrtype string, ttl int, rdata []string) (*EdgeDNSRecordSet, error) {
+
+	parent, err := c.FindParentZone(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SetRecordSet(ctx, parent, &EdgeDNSRecordSet{Name: domain, Type: strings.ToUpper(rrtype), TTL: ttl, Rdata: rdata})
+}
+
+// DSRdata returns the record data of DS records
+func DSRdata(ds []DS) []string {
+
+	rdata := make([]string, 0, len(ds))
+	for _, d := range ds {
+		rdata = append(rdata, d.String())
+	}
+
+	return rdata
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEdgeDNSClientSetParentRecords(t *testing.T) {
+
+	requests := []string{}
+	var written *EdgeDNSRecordSet
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/config-dns/v2/zones/example.com":
+			fmt.Fprint(w, `{"zone":"example.com","type":"PRIMARY"}`)
+		case "/config-dns/v2/zones/example.com/names/sub.example.com/types/DS":
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, `{"name":"sub.example.com","type":"DS","ttl":3600,"rdata":["1 8 2 AA"]}`)
+				return
+			}
+			written = &EdgeDNSRecordSet{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(written))
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestEdgeDNSClient(t, srv, "token-a")
+	prior, err := c.SetParentRecords(context.Background(), "sub.example.com", "ds", DefaultDSTTL, []string{"2 13 2 BB"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1 8 2 AA"}, prior.Rdata)
+	assert.Equal(t, &EdgeDNSRecordSet{Name: "sub.example.com", Type: "DS", TTL: DefaultDSTTL, Rdata: []string{"2 13 2 BB"}}, written)
+	assert.Equal(t, "PUT /config-dns/v2/zones/example.com/names/sub.example.com/types/DS", requests[len(requests)-1])
+
+	_, err = c.SetParentRecords(context.Background(), "example.org", "ds", DefaultDSTTL, []string{"2 13 2 BB"})
+	assert.NotNil(t, err)
+}
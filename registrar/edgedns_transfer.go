@@ -0,0 +1,82 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// EdgeDNSZoneTransferStatus is the zone transfer status of a secondary zone. Dates are RFC 3339 and empty if the
+// zone has no such transfer.
+type EdgeDNSZoneTransferStatus struct {
+	Zone                    string `json:"zone"`
+	MasterServer            string `json:"masterServer,omitempty"`
+	LastTransferAttemptDate string `json:"lastTransferAttemptDate,omitempty"`
+	LastTransferDate        string `json:"lastTransferDate,omitempty"` // last successful transfer
+	LastTransferResult      string `json:"lastTransferResult,omitempty"`
+	LastTransferError       string `json:"lastTransferError,omitempty"`
+	SerialNumber            uint32 `json:"serialNumber,omitempty"`
+}
+
+// Transferred returns whether the zone has ever transferred successfully
+func (s *EdgeDNSZoneTransferStatus) Transferred() bool {
+
+	return s.LastTransferDate != ""
+}
+
+// LastTransfer returns the time of the last successful transfer
+func (s *EdgeDNSZoneTransferStatus) LastTransfer() (time.Time, bool) {
+
+	return parseTransferDate(s.LastTransferDate)
+}
+
+// LastAttempt returns the time of the last transfer attempt
+func (s *EdgeDNSZoneTransferStatus) LastAttempt() (time.Time, bool) {
+
+	return parseTransferDate(s.LastTransferAttemptDate)
+}
+
+func parseTransferDate(date string) (time.Time, bool) {
+
+	if date == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// GetZoneTransferStatus retrieves the zone transfer status of secondary zones
+func (c *EdgeDNSClient) GetZoneTransferStatus(ctx context.Context, zones []string) ([]*EdgeDNSZoneTransferStatus, error) {
+
+	if len(zones) < 1 {
+		return []*EdgeDNSZoneTransferStatus{}, nil
+	}
+	req := struct {
+		Zones []string `json:"zones"`
+	}{Zones: zones}
+	resp := struct {
+		Zones []*EdgeDNSZoneTransferStatus `json:"zones"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "/config-dns/v2/zones/zone-transfer-status", req, &resp, ""); err != nil {
+		return nil, err
+	}
+
+	return resp.Zones, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEdgeDNSClientZoneTransferStatus(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/config-dns/v2/zones/zone-transfer-status", r.URL.Path)
+		req := struct {
+			Zones []string `json:"zones"`
+		}{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []string{"a.com", "b.com"}, req.Zones)
+		fmt.Fprint(w, `{"zones":[{"zone":"a.com","masterServer":"10.0.0.1","lastTransferAttemptDate":"2021-03-01T10:00:00Z","lastTransferDate":"2021-03-01T10:00:00Z","lastTransferResult":"SUCCESS","serialNumber":2021030101},`+
+			`{"zone":"b.com","lastTransferAttemptDate":"2021-03-01T10:05:00Z","lastTransferResult":"FAILURE","lastTransferError":"REFUSED"}]}`)
+	}))
+	defer srv.Close()
+
+	c := newTestEdgeDNSClient(t, srv, "token-a")
+	statuses, err := c.GetZoneTransferStatus(context.Background(), []string{"a.com", "b.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(statuses))
+	assert.True(t, statuses[0].Transferred())
+	assert.Equal(t, uint32(2021030101), statuses[0].SerialNumber)
+	last, ok := statuses[0].LastTransfer()
+	assert.True(t, ok)
+	assert.Equal(t, 10, last.Hour())
+	assert.False(t, statuses[1].Transferred())
+	assert.Equal(t, "REFUSED", statuses[1].LastTransferError)
+	_, ok = statuses[1].LastTransfer()
+	assert.False(t, ok)
+	_, ok = statuses[1].LastAttempt()
+	assert.True(t, ok)
+
+	// no request without zones
+	statuses, err = c.GetZoneTransferStatus(context.Background(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(statuses))
+}
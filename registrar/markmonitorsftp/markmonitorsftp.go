@@ -74,24 +74,28 @@ type SFTPDNSConfig struct {
 type MarkMonitorSFTPRegistrar struct {
 	registrar.BaseRegistrarProvider
 	markmonitorConfig *MarkMonitorSFTPConfig
-	closeSFTPSession  func(interface{})
+	// ssh password. May be a secret reference
+	sshPassword      *registrar.SecretValue
+	closeSFTPSession func(interface{})
 	// Defines client. Allows for mocking.
 	sftpService SFTPDNSService
 }
 
 type MarkMonitorSFTPConfig struct {
 	MarkMonitorSFTPConfigPath       string
-	MarkMonitorSshUser              string   `yaml:"markmonitor_ssh_user"`
-	MarkMonitorSshPassword          string   `yaml:"markmonitor_ssh_password"`
-	MarkMonitorSshHost              string   `yaml:"markmonitor_ssh_host"`
-	MarkMonitorSshPort              int      `yaml:"markmonitor_ssh_port"`
-	MarkMonitorSslCertAlgorithm     string   `yaml:"markmonitor_ssl_cert_algorithm"`
-	MarkMonitorSslSignature         string   `yaml:"markmonitor_ssl_signature"`
-	MarkMonitorSftpPktSize          int      `yaml:"markmonitor_sftp_pkt_size"`
-	MarkMonitorMasterIPs            []string `yaml:"markmonitor_master_ips"`
-	MarkMonitorDomainConfigFilePath string   `yaml:"markmonitor_registrar_domain_filepath"`
-	MarkMonitorTempDomainFileFolder string   `yaml:"markmonitor_temp_file_folder"`
-	MarkMonitorDomFileTTL           string   `yaml:"markmonitor_domain_file_ttle"` // in seconds
+	MarkMonitorSFTPConfigData       []byte           `yaml:"-"` // config content embedded in the coordinator config file
+	MarkMonitorSshUser              string           `yaml:"markmonitor_ssh_user"`
+	MarkMonitorSshPassword          registrar.Secret `yaml:"markmonitor_ssh_password"`
+	MarkMonitorSshHost              string           `yaml:"markmonitor_ssh_host"`
+	MarkMonitorSshPort              int              `yaml:"markmonitor_ssh_port"`
+	MarkMonitorSslCertAlgorithm     string           `yaml:"markmonitor_ssl_cert_algorithm"`
+	MarkMonitorSslSignature         string           `yaml:"markmonitor_ssl_signature"`
+	MarkMonitorSftpPktSize          int              `yaml:"markmonitor_sftp_pkt_size"`
+	MarkMonitorMasterIPs            []string         `yaml:"markmonitor_master_ips"`
+	MarkMonitorDomainConfigFilePath string           `yaml:"markmonitor_registrar_domain_filepath"`
+	MarkMonitorTempDomainFileFolder string           `yaml:"markmonitor_temp_file_folder"`
+	MarkMonitorDomFileTTL           string           `yaml:"markmonitor_domain_file_ttle"` // in seconds
+	MarkMonitorSecretRefresh        *time.Duration   `yaml:"markmonitor_secret_refresh"`   // re-read interval of secret references. Default if not set
 }
 
 // Create and return ssl Connection
@@ -106,7 +110,7 @@ func initSSHClient(log *log.Entry, markmonitorConfig *MarkMonitorSFTPConfig) (*s
 	config := &ssh.ClientConfig{
 		User: markmonitorConfig.MarkMonitorSshUser,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(markmonitorConfig.MarkMonitorSshPassword),
+			ssh.Password(string(markmonitorConfig.MarkMonitorSshPassword)),
 		},
 		HostKeyCallback: hostKeyCallback,
 	}
@@ -171,14 +175,28 @@ func NewMarkMonitorSFTPRegistrar(ctx context.Context, mmConfig MarkMonitorSFTPCo
 	// if mock, skip
 	if sftpService == nil {
 		// Get file config and parse
-		if mmConfig.MarkMonitorSFTPConfigPath == "" {
+		if mmConfig.MarkMonitorSFTPConfigPath == "" && len(mmConfig.MarkMonitorSFTPConfigData) == 0 {
 			return nil, fmt.Errorf("MarkMonitor Registrar requires a configuration file")
 		}
-		markmonitorConfig, err = loadConfig(log, mmConfig.MarkMonitorSFTPConfigPath)
+		if mmConfig.MarkMonitorSFTPConfigPath != "" {
+			markmonitorConfig, err = loadConfig(log, mmConfig.MarkMonitorSFTPConfigPath)
+		} else {
+			markmonitorConfig, err = loadConfigContent(log, mmConfig.MarkMonitorSFTPConfigData)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("MarkMonitor Registrar. Invalid configuration file")
 		}
 	}
+	secretRefresh := registrar.SecretRefreshInterval(markmonitorConfig.MarkMonitorSecretRefresh)
+	if secretRefresh < 0 {
+		return nil, fmt.Errorf("MarkMonitor Registrar. Secret refresh must not be negative")
+	}
+	sshPassword, err := registrar.NewSecretValue(ctx, string(markmonitorConfig.MarkMonitorSshPassword), secretRefresh)
+	if err != nil {
+		return nil, fmt.Errorf("MarkMonitor Registrar. %s", err.Error())
+	}
+	password, _, _ := sshPassword.Value(ctx)
+	markmonitorConfig.MarkMonitorSshPassword = registrar.Secret(password)
 	// Set up ssl and sftp clients/session
 	if markmonitorConfig.MarkMonitorSshHost == "" || markmonitorConfig.MarkMonitorSshUser == "" || markmonitorConfig.MarkMonitorSshPassword == "" {
 		return nil, fmt.Errorf("MarkMonitor Registrar. Invalid configuration file. One or more required credentials missing.")
@@ -186,6 +204,11 @@ func NewMarkMonitorSFTPRegistrar(ctx context.Context, mmConfig MarkMonitorSFTPCo
 	if len(markmonitorConfig.MarkMonitorMasterIPs) < 1 {
 		return nil, fmt.Errorf("MarkMonitor Registrar. One or more Master IPs required.")
 	}
+	masterIPs, err := registrar.ParseMasterIPs(markmonitorConfig.MarkMonitorMasterIPs)
+	if err != nil {
+		return nil, fmt.Errorf("MarkMonitor Registrar. Invalid configuration file. %s", err.Error())
+	}
+	markmonitorConfig.MarkMonitorMasterIPs = registrar.MasterIPStrings(masterIPs)
 	if markmonitorConfig.MarkMonitorDomainConfigFilePath == "" {
 		return nil, fmt.Errorf("MarkMonitor Registrar. Invalid configuration file. Remote domain file path missing.")
 	}
@@ -204,6 +227,7 @@ func NewMarkMonitorSFTPRegistrar(ctx context.Context, mmConfig MarkMonitorSFTPCo
 
 	provider := &MarkMonitorSFTPRegistrar{
 		markmonitorConfig: markmonitorConfig,
+		sshPassword:       sshPassword,
 		sftpService:       &SFTPDNSConfig{},
 		closeSFTPSession:  closeSFTPSession,
 	}
@@ -254,10 +278,33 @@ func parseDomainsConfigFile(localDomsFile *os.File) ([]string, error) {
 Service Entry Points
 */
 
+// refreshCredentials re-reads a referenced ssh password. The password is used by the next SFTP session.
+func (mm *MarkMonitorSFTPRegistrar) refreshCredentials(ctx context.Context) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+
+	if mm.sshPassword == nil {
+		return nil
+	}
+	password, changed, err := mm.sshPassword.Value(ctx)
+	if err != nil {
+		log.Errorf("MarkMonitor failed to refresh ssh password. Error: %s", err.Error())
+		return err
+	}
+	if changed {
+		mm.markmonitorConfig.MarkMonitorSshPassword = registrar.Secret(password)
+		log.Info("MarkMonitor ssh password refreshed")
+	}
+
+	return nil
+}
+
 func (mm *MarkMonitorSFTPRegistrar) GetDomains(ctx context.Context) ([]string, error) {
 
 	log := ctx.Value("appLog").(*log.Entry)
 	log.Debug("Entering MarkMonitor registrar GetDomains")
+	// rotated credentials are picked up once per domain list. On failure, the current credentials are kept.
+	mm.refreshCredentials(ctx)
 
 	defer mm.closeSFTPSession(mm.sftpService)
 	err := mm.sftpService.EstablishSFTPSession(log, mm.markmonitorConfig)
@@ -405,7 +452,7 @@ func (s *SFTPDNSConfig) EstablishSFTPSession(log *log.Entry, markmonitorConfig *
 			return fmt.Errorf("MarkMonitor Registrar. Failed to initialize SSH Client.")
 		}
 		s.sshClient = sshClient
-		log.Debugf("SSH Session created: [%v]", *s.sshClient)
+		log.Debugf("SSH Session created: [%s]", s.sshClient.RemoteAddr())
 	}
 	if s.sftpClient == nil {
 		sftpClient, err := initSFTPClient(log, markmonitorConfig, s.sshClient)
@@ -414,7 +461,7 @@ func (s *SFTPDNSConfig) EstablishSFTPSession(log *log.Entry, markmonitorConfig *
 			return fmt.Errorf("MarkMonitor Registrar. Failed to initialize SFTP Client.")
 		}
 		s.sftpClient = sftpClient
-		log.Debugf("SSH Session created: [%v]", *s.sftpClient)
+		log.Debug("SFTP Session created")
 	}
 
 	return nil
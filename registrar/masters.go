@@ -0,0 +1,115 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// Master address family policies
+	AddressFamilyIPv4 = "v4"
+	AddressFamilyIPv6 = "v6"
+	AddressFamilyBoth = "both"
+)
+
+// ValidateAddressFamily returns an error if family is not a known address family policy
+func ValidateAddressFamily(family string) error {
+
+	switch family {
+	case AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyBoth:
+		return nil
+	}
+
+	return fmt.Errorf("Invalid master address family %q. Must be one of %s, %s or %s", family, AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyBoth)
+}
+
+// ParseMasterIPs parses a list of master addresses. All invalid entries are reported in the returned error.
+func ParseMasterIPs(masters []string) ([]net.IP, error) {
+
+	ips := make([]net.IP, 0, len(masters))
+	invalid := []string{}
+	for _, m := range masters {
+		ip := net.ParseIP(strings.TrimSpace(m))
+		if ip == nil {
+			invalid = append(invalid, fmt.Sprintf("%q", m))
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	if len(invalid) > 0 {
+		return ips, fmt.Errorf("Invalid master IP address(es): %s", strings.Join(invalid, ", "))
+	}
+
+	return ips, nil
+}
+
+// IsAddressFamily returns true if ip is allowed by the address family policy
+func IsAddressFamily(ip net.IP, family string) bool {
+
+	isV4 := ip.To4() != nil
+	switch family {
+	case AddressFamilyIPv4:
+		return isV4
+	case AddressFamilyIPv6:
+		return !isV4
+	}
+
+	return true
+}
+
+// FilterAddressFamily returns the addresses allowed by the address family policy
+func FilterAddressFamily(ips []net.IP, family string) []net.IP {
+
+	filtered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if IsAddressFamily(ip, family) {
+			filtered = append(filtered, ip)
+		}
+	}
+
+	return filtered
+}
+
+// FirstAddressPerFamily returns the first address of each family allowed by the address family policy, e.g. the
+// master addresses of a name server with several addresses
+func FirstAddressPerFamily(ips []net.IP, family string) []net.IP {
+
+	first := make([]net.IP, 0, 2)
+	var haveV4, haveV6 bool
+	for _, ip := range FilterAddressFamily(ips, family) {
+		isV4 := ip.To4() != nil
+		if (isV4 && haveV4) || (!isV4 && haveV6) {
+			continue
+		}
+		haveV4 = haveV4 || isV4
+		haveV6 = haveV6 || !isV4
+		first = append(first, ip)
+	}
+
+	return first
+}
+
+// MasterIPStrings returns the canonical text form of each address
+func MasterIPStrings(ips []net.IP) []string {
+
+	masters := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		masters = append(masters, ip.String())
+	}
+
+	return masters
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseMasterIPs(t *testing.T) {
+
+	ips, err := ParseMasterIPs([]string{"1.2.3.4", " 2001:DB8::1 ", "::ffff:5.6.7.8"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1", "5.6.7.8"}, MasterIPStrings(ips))
+
+	_, err = ParseMasterIPs([]string{"1.2.3.4", "ns1.example.com", "1.2.3"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `"ns1.example.com", "1.2.3"`)
+}
+
+func TestFilterAddressFamily(t *testing.T) {
+
+	ips, _ := ParseMasterIPs([]string{"1.2.3.4", "2001:db8::1"})
+	assert.Equal(t, []string{"1.2.3.4"}, MasterIPStrings(FilterAddressFamily(ips, AddressFamilyIPv4)))
+	assert.Equal(t, []string{"2001:db8::1"}, MasterIPStrings(FilterAddressFamily(ips, AddressFamilyIPv6)))
+	assert.Equal(t, 2, len(FilterAddressFamily(ips, AddressFamilyBoth)))
+	assert.NotNil(t, ValidateAddressFamily("ipv4"))
+	assert.Nil(t, ValidateAddressFamily(AddressFamilyBoth))
+}
+
+func TestFirstAddressPerFamily(t *testing.T) {
+
+	ips, _ := ParseMasterIPs([]string{"1.2.3.4", "2001:db8::1", "5.6.7.8", "2001:db8::2"})
+	assert.Equal(t, []string{"1.2.3.4", "2001:db8::1"}, MasterIPStrings(FirstAddressPerFamily(ips, AddressFamilyBoth)))
+	assert.Equal(t, []string{"1.2.3.4"}, MasterIPStrings(FirstAddressPerFamily(ips, AddressFamilyIPv4)))
+	assert.Equal(t, []string{"2001:db8::1"}, MasterIPStrings(FirstAddressPerFamily(ips, AddressFamilyIPv6)))
+}
@@ -42,7 +42,10 @@ type PluginRegistrar struct {
 	pluginGetMasterIPs      func()
 	pluginGetTsigKey        func()
 	pluginGetServeAlgorithm func()
-	pluginTest              bool // flag for testing.
+	// Optional RegistrarWriter functions. Nil if the library does not export them
+	pluginSetDSRecords   func()
+	pluginSetNameServers func()
+	pluginTest           bool // flag for testing.
 }
 
 func lookupSymbols(plug *plugin.Plugin, reg *PluginRegistrar) error {
@@ -95,6 +98,16 @@ func lookupSymbols(plug *plugin.Plugin, reg *PluginRegistrar) error {
 		return err
 	}
 	reg.pluginGetMasterIPs = sym.(func())
+	if sym, err = plug.Lookup("SetDSRecords"); err == nil {
+		reg.pluginSetDSRecords = sym.(func())
+	} else {
+		log.Debugf("Plugin library does not support SetDSRecords")
+	}
+	if sym, err = plug.Lookup("SetNameServers"); err == nil {
+		reg.pluginSetNameServers = sym.(func())
+	} else {
+		log.Debugf("Plugin library does not support SetNameServers")
+	}
 
 	return nil
 
@@ -312,3 +325,72 @@ func (r *PluginRegistrar) GetMasterIPs(ctx context.Context) ([]string, error) {
 	masters = append(masters, mlist...)
 	return masters, nil
 }
+
+// SetDSRecords publishes the DS records of domain through the plugin library. Libraries without SetDSRecords return
+// registrar.ErrNotSupported.
+func (r *PluginRegistrar) SetDSRecords(ctx context.Context, domain string, ds []registrar.DS) error {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering Plugin registrar SetDSRecords")
+	if r.pluginSetDSRecords == nil {
+		return registrar.ErrNotSupported
+	}
+	// Synchronize library calls
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+
+	if !r.pluginTest {
+		// clear ResultObj
+		r.pluginResult.PluginError = nil
+		r.pluginResult.PluginResult = nil
+		r.pluginArgs.PluginArg = registrar.PluginDSRecordsArg{Domain: domain, DS: ds}
+	}
+
+	log.Debugf("Invoking %s library SetDSRecords", r.pluginConfig.PluginName)
+	r.pluginSetDSRecords()
+	if r.pluginResult.PluginError != nil {
+		return r.pluginResult.PluginError
+	}
+
+	log.Debugf("Plugin SetDSRecords complete")
+	return nil
+}
+
+// SetNameServers replaces the name servers of domain through the plugin library. Libraries without SetNameServers
+// return registrar.ErrNotSupported.
+func (r *PluginRegistrar) SetNameServers(ctx context.Context, domain string, nameservers []string) ([]string, error) {
+
+	log := ctx.Value("appLog").(*log.Entry)
+	log.Debug("Entering Plugin registrar SetNameServers")
+	if r.pluginSetNameServers == nil {
+		return nil, registrar.ErrNotSupported
+	}
+	// Synchronize library calls
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+
+	if !r.pluginTest {
+		// clear ResultObj
+		r.pluginResult.PluginError = nil
+		r.pluginResult.PluginResult = nil
+		r.pluginArgs.PluginArg = registrar.PluginNameServersArg{Domain: domain, NameServers: nameservers}
+	}
+
+	log.Debugf("Invoking %s library SetNameServers", r.pluginConfig.PluginName)
+	r.pluginSetNameServers()
+	if r.pluginResult.PluginError != nil {
+		return nil, r.pluginResult.PluginError
+	}
+	prior := []string{}
+	if r.pluginResult.PluginResult != nil {
+		var ok bool
+		prior, ok = r.pluginResult.PluginResult.([]string)
+		if !ok {
+			log.Debugf("Unexpected Plugin library SetNameServers return value: %v", r.pluginResult.PluginResult)
+			return nil, fmt.Errorf("Unexpected Plugin library SetNameServers return value type")
+		}
+	}
+
+	log.Debugf("Plugin SetNameServers result: %v", prior)
+	return prior, nil
+}
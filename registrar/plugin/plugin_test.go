@@ -378,3 +378,74 @@ func TestRegistrarGetMasterIPsFail(t *testing.T) {
 	assert.NotNil(t, testRegistrar.pluginResult.PluginError)
 	assert.Contains(t, testRegistrar.pluginResult.PluginError.Error(), "Fail")
 }
+
+func TestRegistrarSetDSRecords(t *testing.T) {
+
+	pluginTestMutex.Lock()
+	defer pluginTestMutex.Unlock()
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Plugin",
+		"subcommand": "SetDSRecords",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	config := initRegistrarStub(appLog)
+	testRegistrar, err := NewPluginRegistrar(ctx, config)
+	assert.Nil(t, err)
+
+	ds := []registrar.DS{{KeyTag: 55648, Algorithm: 13, DigestType: 2, Digest: "B4C8"}}
+	testRegistrar.pluginArgs.PluginArg = map[string]interface{}{"FuncErrors": "Parent not found"}
+	testSetup(testRegistrar)
+	err = testRegistrar.SetDSRecords(ctx, "test1.com", ds)
+	assert.NotNil(t, err)
+
+	// libraries without SetDSRecords
+	testRegistrar.pluginSetDSRecords = nil
+	err = testRegistrar.SetDSRecords(ctx, "test1.com", ds)
+	assert.Equal(t, registrar.ErrNotSupported, err)
+
+}
+
+func TestRegistrarSetNameServers(t *testing.T) {
+
+	pluginTestMutex.Lock()
+	defer pluginTestMutex.Unlock()
+
+	ctx := context.TODO()
+	logLevel, _ := log.ParseLevel("info")
+	log.SetLevel(logLevel)
+
+	appLog := log.WithFields(log.Fields{
+		"registrar":  "Test Plugin",
+		"subcommand": "SetNameServers",
+	})
+	ctx = context.WithValue(ctx, "appLog", appLog)
+
+	config := initRegistrarStub(appLog)
+	testRegistrar, err := NewPluginRegistrar(ctx, config)
+	assert.Nil(t, err)
+
+	nameservers := []string{"a1-1.akam.net", "a2-2.akam.net"}
+	testRegistrar.pluginArgs.PluginArg = map[string]interface{}{"FuncOutput": []string{"ns1.other.net"}}
+	testSetup(testRegistrar)
+	prior, err := testRegistrar.SetNameServers(ctx, "test1.com", nameservers)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ns1.other.net"}, prior)
+
+	// unexpected library return values
+	testRegistrar.pluginArgs.PluginArg = map[string]interface{}{"FuncOutput": "ns1.other.net"}
+	_, err = testRegistrar.SetNameServers(ctx, "test1.com", nameservers)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unexpected Plugin library SetNameServers return value type")
+
+	// libraries without SetNameServers
+	testRegistrar.pluginSetNameServers = nil
+	_, err = testRegistrar.SetNameServers(ctx, "test1.com", nameservers)
+	assert.Equal(t, registrar.ErrNotSupported, err)
+
+}
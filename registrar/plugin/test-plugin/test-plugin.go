@@ -120,6 +120,36 @@ func GetMasterIPs() {
 	return
 }
 
+func SetDSRecords() {
+
+	libLog.Debug("Entering Test Plugin Lib registrar SetDSRecords")
+	pluginObj := LibPluginArgs.PluginArg.(map[string]interface{})
+	if output, ok := pluginObj["FuncOutput"]; ok {
+		LibPluginResult.PluginResult = output
+	}
+	if errmsg, ok := pluginObj["FuncErrors"]; ok {
+		err := fmt.Errorf("SetDSRecords Failed. %s", errmsg.(string))
+		LibPluginResult.PluginError = err
+	}
+
+	return
+}
+
+func SetNameServers() {
+
+	libLog.Debug("Entering Test Plugin Lib registrar SetNameServers")
+	pluginObj := LibPluginArgs.PluginArg.(map[string]interface{})
+	if output, ok := pluginObj["FuncOutput"]; ok {
+		LibPluginResult.PluginResult = output
+	}
+	if errmsg, ok := pluginObj["FuncErrors"]; ok {
+		err := fmt.Errorf("SetNameServers Failed. %s", errmsg.(string))
+		LibPluginResult.PluginError = err
+	}
+
+	return
+}
+
 func main() {
 
 	fmt.Println("Test Plugin Library Registrar")
@@ -0,0 +1,153 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	log "github.com/apex/log"
+
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	MaskedSecret = "********"
+	// shorter secrets are not redacted from log text to avoid masking common substrings
+	minRedactLength = 4
+)
+
+var (
+	// resolved secret values to redact from log entries, longest first so that secrets containing other secrets are
+	// masked as a whole
+	knownSecrets     = []string{}
+	knownSecretsLock = &sync.RWMutex{}
+)
+
+// Secret is a credential string that masks itself when formatted or marshaled. Use string(s) for the value.
+type Secret string
+
+func (s Secret) String() string {
+
+	if s == "" {
+		return ""
+	}
+
+	return MaskedSecret
+}
+
+func (s Secret) GoString() string {
+
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+
+	return json.Marshal(s.String())
+}
+
+// RegisterSecret adds a secret value to be redacted from all log entries passed through a RedactHandler
+func RegisterSecret(secret string) {
+
+	if len(secret) < minRedactLength {
+		return
+	}
+	knownSecretsLock.Lock()
+	defer knownSecretsLock.Unlock()
+
+	i := sort.Search(len(knownSecrets), func(i int) bool {
+		return len(knownSecrets[i]) < len(secret) || (len(knownSecrets[i]) == len(secret) && knownSecrets[i] >= secret)
+	})
+	if i < len(knownSecrets) && knownSecrets[i] == secret {
+		return
+	}
+	knownSecrets = append(knownSecrets, "")
+	copy(knownSecrets[i+1:], knownSecrets[i:])
+	knownSecrets[i] = secret
+}
+
+// Redact replaces each registered secret in str, longest first
+func Redact(str string) string {
+
+	knownSecretsLock.RLock()
+	defer knownSecretsLock.RUnlock()
+
+	for _, secret := range knownSecrets {
+		if strings.Contains(str, secret) {
+			str = strings.Replace(str, secret, MaskedSecret, -1)
+		}
+	}
+
+	return str
+}
+
+// RedactTsigKey returns a copy of key with the secret masked
+func RedactTsigKey(key *dns.TSIGKey) *dns.TSIGKey {
+
+	if key == nil {
+		return nil
+	}
+	redacted := *key
+	redacted.Secret = Secret(key.Secret).String()
+
+	return &redacted
+}
+
+// RedactHandler is an apex/log handler that removes registered secrets from entries before passing them to the wrapped handler
+type RedactHandler struct {
+	handler log.Handler
+}
+
+// NewRedactHandler wraps handler
+func NewRedactHandler(handler log.Handler) *RedactHandler {
+
+	return &RedactHandler{handler: handler}
+}
+
+// HandleLog redacts the entry message and fields
+func (h *RedactHandler) HandleLog(e *log.Entry) error {
+
+	entry := *e
+	entry.Message = Redact(e.Message)
+	entry.Fields = make(log.Fields, len(e.Fields))
+	for k, v := range e.Fields {
+		entry.Fields[k] = redactField(v)
+	}
+
+	return h.handler.HandleLog(&entry)
+}
+
+// redactField returns a field value with registered secrets removed. Values are only converted to text if redacted.
+func redactField(val interface{}) interface{} {
+
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case Secret:
+		return v.String()
+	case string:
+		return Redact(v)
+	case error:
+		return Redact(v.Error())
+	}
+	str := fmt.Sprint(val)
+	if redacted := Redact(str); redacted != str {
+		return redacted
+	}
+
+	return val
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecretFormat(t *testing.T) {
+
+	secret := Secret("formatted-secret")
+	conf := struct {
+		User     string
+		Password Secret
+	}{"user", secret}
+
+	for _, str := range []string{
+		fmt.Sprintf("%s", secret),
+		fmt.Sprintf("%v", secret),
+		fmt.Sprintf("%q", secret),
+		fmt.Sprintf("%#v", secret),
+		fmt.Sprintf("%v", conf),
+		fmt.Sprintf("%+v", conf),
+		fmt.Sprintf("%#v", conf),
+	} {
+		assert.NotContains(t, str, "formatted-secret")
+		assert.Contains(t, str, MaskedSecret)
+	}
+	data, err := json.Marshal(conf)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "formatted-secret")
+	assert.Equal(t, "", Secret("").String())
+	assert.Equal(t, "formatted-secret", string(secret))
+
+	key := &dns.TSIGKey{Name: "key", Algorithm: "hmac-sha256", Secret: "tsig-secret"}
+	assert.Equal(t, MaskedSecret, RedactTsigKey(key).Secret)
+	assert.Equal(t, "tsig-secret", key.Secret)
+}
+
+func TestRedactOverlappingSecrets(t *testing.T) {
+
+	// registration order doesn't matter
+	RegisterSecret("overlap-secret")
+	RegisterSecret("overlap-secret-extended")
+	RegisterSecret("overlap")
+	RegisterSecret("overlap-secret")
+
+	assert.Equal(t, "key="+MaskedSecret+" ", Redact("key=overlap-secret-extended "))
+	assert.Equal(t, MaskedSecret+"-other", Redact("overlap-secret-other"))
+	assert.Equal(t, MaskedSecret+"-other", Redact("overlap-other"))
+}
+
+func TestRedactHandler(t *testing.T) {
+
+	mem := memory.New()
+	logger := &log.Logger{Handler: NewRedactHandler(mem), Level: log.DebugLevel}
+	RegisterSecret("handler-secret")
+
+	logger.WithFields(log.Fields{
+		"secret": "handler-secret",
+		"err":    errors.New("auth failed for handler-secret"),
+		"list":   []string{"a", "handler-secret"},
+		"count":  3,
+		"typed":  Secret("typed-secret"),
+	}).Debugf("using secret %s", "handler-secret")
+
+	assert.Equal(t, 1, len(mem.Entries))
+	entry := mem.Entries[0]
+	assert.Equal(t, "using secret "+MaskedSecret, entry.Message)
+	for k, v := range entry.Fields {
+		assert.NotContains(t, fmt.Sprint(v), "handler-secret", k)
+		assert.NotContains(t, fmt.Sprint(v), "typed-secret", k)
+	}
+	// unredacted values keep their type
+	assert.Equal(t, 3, entry.Fields["count"])
+}
@@ -15,6 +15,7 @@ package registrar
 
 import (
 	"context"
+	"errors"
 	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
 	log "github.com/apex/log"
 	"plugin"
@@ -22,7 +23,10 @@ import (
 
 const ()
 
-var ()
+var (
+	// ErrNotSupported is returned by registrars for RegistrarWriter operations they do not support
+	ErrNotSupported = errors.New("Operation not supported by registrar")
+)
 
 type Domain struct {
 	Name                  string
@@ -46,6 +50,14 @@ type RegistrarProvider interface {
 	//GetZoneTransferStatus
 }
 
+// RegistrarWriter is implemented by registrars that can update their domains. Registrars without support for an
+// operation return ErrNotSupported.
+type RegistrarWriter interface {
+	SetDSRecords(ctx context.Context, domain string, ds []DS) error
+	// SetNameServers replaces the name servers of domain. Returns the replaced name servers.
+	SetNameServers(ctx context.Context, domain string, nameservers []string) ([]string, error)
+}
+
 type BaseRegistrarProvider struct {
 }
 
@@ -86,12 +98,16 @@ type PluginRegistrarProvider interface {
 	//GetTsigKeys() []dnsTSIGKey
 	//GetDnsSecStatus
 	//GetZoneTransferStatus
+	// Optional RegistrarWriter functions. Arguments are Plugin<Func>Arg
+	//SetDSRecords()
+	//SetNameServers() // result is the replaced name servers, []string
 }
 
 type PluginConfig struct {
 	PluginLibPath    string
 	PluginName       string
 	PluginConfigPath string
+	PluginConfigData []byte // config content embedded in the coordinator config file
 	LogEntry         *log.Entry
 	Registrar        *plugin.Plugin
 }
@@ -100,6 +116,18 @@ type PluginFuncArgs struct {
 	PluginArg interface{}
 }
 
+// PluginDSRecordsArg is the plugin argument of SetDSRecords
+type PluginDSRecordsArg struct {
+	Domain string
+	DS     []DS
+}
+
+// PluginNameServersArg is the plugin argument of SetNameServers
+type PluginNameServersArg struct {
+	Domain      string
+	NameServers []string
+}
+
 type PluginFuncResult struct {
 	PluginResult interface{}
 	PluginError  error
@@ -0,0 +1,205 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSecretRefresh = time.Minute * 5
+	// Secret reference schemes
+	SecretSchemeFile = "file"
+	SecretSchemeEnv  = "env"
+)
+
+var (
+	secretProviders = map[string]SecretProvider{
+		SecretSchemeFile: FileSecretProvider{},
+		SecretSchemeEnv:  EnvSecretProvider{},
+	}
+	secretProvidersLock = &sync.RWMutex{}
+)
+
+// SecretProvider resolves secret references of the form <scheme>://<ref>
+type SecretProvider interface {
+	Scheme() string
+	// GetSecret returns the secret identified by ref. ref excludes the scheme prefix.
+	GetSecret(ctx context.Context, ref string) (string, error)
+}
+
+// FileSecretProvider reads secrets from files, e.g. file:///run/secrets/edgegrid_client_secret
+type FileSecretProvider struct{}
+
+func (FileSecretProvider) Scheme() string {
+
+	return SecretSchemeFile
+}
+
+// GetSecret returns the file content. Trailing line endings are removed.
+func (FileSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EnvSecretProvider reads secrets from environment variables, e.g. env://EDGEGRID_CLIENT_SECRET
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Scheme() string {
+
+	return SecretSchemeEnv
+}
+
+func (EnvSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s not set", ref)
+	}
+
+	return val, nil
+}
+
+// RegisterSecretProvider adds or replaces the provider of a reference scheme
+func RegisterSecretProvider(provider SecretProvider) {
+
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+
+	secretProviders[provider.Scheme()] = provider
+}
+
+// parseSecretRef returns the provider and reference of value. ok is false if value is not a reference.
+func parseSecretRef(value string) (provider SecretProvider, ref string, ok bool) {
+
+	idx := strings.Index(value, "://")
+	if idx < 1 {
+		return nil, "", false
+	}
+	secretProvidersLock.RLock()
+	defer secretProvidersLock.RUnlock()
+	provider, ok = secretProviders[value[:idx]]
+
+	return provider, value[idx+3:], ok
+}
+
+// IsSecretRef returns true if value references a secret of a registered provider
+func IsSecretRef(value string) bool {
+
+	_, _, ok := parseSecretRef(value)
+
+	return ok
+}
+
+// ResolveSecret returns the secret referenced by value. Values that are not references are returned as is.
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+
+	provider, ref, ok := parseSecretRef(value)
+	if !ok {
+		return value, nil
+	}
+	secret, err := provider.GetSecret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("Unable to resolve secret %s. %s", value, err.Error())
+	}
+
+	return secret, nil
+}
+
+// SecretRefreshInterval returns the configured re-read interval of secret references, or DefaultSecretRefresh if
+// none is configured. A configured interval of zero resolves references once.
+func SecretRefreshInterval(refresh *time.Duration) time.Duration {
+
+	if refresh == nil {
+		return DefaultSecretRefresh
+	}
+
+	return *refresh
+}
+
+// SecretValue is a configured credential that may be a secret reference. The resolved value is re-read once older
+// than the refresh interval so that rotated credentials are picked up. A refresh of zero resolves the reference once.
+type SecretValue struct {
+	ref      string
+	refresh  time.Duration
+	lock     sync.Mutex
+	value    string
+	resolved time.Time
+}
+
+// NewSecretValue resolves value and returns the SecretValue
+func NewSecretValue(ctx context.Context, value string, refresh time.Duration) (*SecretValue, error) {
+
+	secret, err := ResolveSecret(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+	RegisterSecret(secret)
+
+	return &SecretValue{
+		ref:      value,
+		refresh:  refresh,
+		value:    secret,
+		resolved: time.Now(),
+	}, nil
+}
+
+// String returns the reference, or the masked secret if the value is not a reference
+func (s *SecretValue) String() string {
+
+	if IsSecretRef(s.ref) {
+		return s.ref
+	}
+
+	return Secret(s.ref).String()
+}
+
+// IsRef returns true if the value is a secret reference
+func (s *SecretValue) IsRef() bool {
+
+	return IsSecretRef(s.ref)
+}
+
+// Value returns the resolved secret, re-reading the reference if the refresh interval has elapsed. changed is true
+// if the secret differs from the previously resolved value. On failure, the previous value is returned with the error.
+func (s *SecretValue) Value(ctx context.Context) (value string, changed bool, err error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !IsSecretRef(s.ref) || s.refresh <= 0 || time.Since(s.resolved) < s.refresh {
+		return s.value, false, nil
+	}
+	secret, err := ResolveSecret(ctx, s.ref)
+	if err != nil {
+		return s.value, false, err
+	}
+	RegisterSecret(secret)
+	s.resolved = time.Now()
+	changed = secret != s.value
+	s.value = secret
+
+	return s.value, changed, nil
+}
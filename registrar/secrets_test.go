@@ -0,0 +1,125 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testSecretProvider struct {
+	secrets map[string]string
+}
+
+func (p *testSecretProvider) Scheme() string {
+
+	return "test"
+}
+
+func (p *testSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+
+	if s, ok := p.secrets[ref]; ok {
+		return s, nil
+	}
+
+	return "", fmt.Errorf("secret %s not found", ref)
+}
+
+func TestResolveSecret(t *testing.T) {
+
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("file-secret\n"), 0600))
+	os.Setenv("TEST_RESOLVE_SECRET", "env-secret")
+	defer os.Unsetenv("TEST_RESOLVE_SECRET")
+
+	val, err := ResolveSecret(ctx, "file://"+path)
+	assert.Nil(t, err)
+	assert.Equal(t, "file-secret", val)
+
+	val, err = ResolveSecret(ctx, "env://TEST_RESOLVE_SECRET")
+	assert.Nil(t, err)
+	assert.Equal(t, "env-secret", val)
+
+	// literals are returned as is
+	val, err = ResolveSecret(ctx, "plain=secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain=secret", val)
+	assert.False(t, IsSecretRef("https://example.com"))
+
+	_, err = ResolveSecret(ctx, "env://TEST_RESOLVE_SECRET_UNSET")
+	assert.NotNil(t, err)
+	_, err = ResolveSecret(ctx, "file://"+path+".missing")
+	assert.NotNil(t, err)
+
+	RegisterSecretProvider(&testSecretProvider{secrets: map[string]string{"edgegrid/secret": "vault-secret"}})
+	val, err = ResolveSecret(ctx, "test://edgegrid/secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "vault-secret", val)
+}
+
+func TestSecretValueRefresh(t *testing.T) {
+
+	ctx := context.TODO()
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first"), 0600))
+
+	secret, err := NewSecretValue(ctx, "file://"+path, time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, secret.IsRef())
+	val, changed, err := secret.Value(ctx)
+	assert.Equal(t, "first", val)
+
+	// rotated secret is picked up after refresh interval
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	time.Sleep(2 * time.Millisecond)
+	val, changed, err = secret.Value(ctx)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "second", val)
+
+	// failed refresh keeps previous value
+	os.Remove(path)
+	time.Sleep(2 * time.Millisecond)
+	val, changed, err = secret.Value(ctx)
+	assert.NotNil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "second", val)
+
+	// zero refresh resolves once
+	assert.Nil(t, ioutil.WriteFile(path, []byte("first"), 0600))
+	secret, err = NewSecretValue(ctx, "file://"+path, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	val, changed, err = secret.Value(ctx)
+	assert.False(t, changed)
+	assert.Equal(t, "first", val)
+}
+
+func TestSecretRefreshInterval(t *testing.T) {
+
+	assert.Equal(t, DefaultSecretRefresh, SecretRefreshInterval(nil))
+	// an explicit zero is kept
+	zero := time.Duration(0)
+	assert.Equal(t, time.Duration(0), SecretRefreshInterval(&zero))
+	minute := time.Minute
+	assert.Equal(t, time.Minute, SecretRefreshInterval(&minute))
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+
+	"context"
+)
+
+const (
+	DefaultZonePageSize = 500
+)
+
+// ZoneIterator streams a zone list. Next advances to the next zone and returns false when the list is exhausted or
+// a page request failed; Err returns the failure.
+//
+//	it := client.IterateZones(ctx, queryArgs)
+//	for it.Next() {
+//		zone := it.Zone()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type ZoneIterator interface {
+	Next() bool
+	Zone() *dns.ZoneResponse
+	Err() error
+}
+
+// ZonePageFunc returns one page of a zone list, e.g. EdgeDNSClient.ListZones
+type ZonePageFunc func(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error)
+
+type pagedZoneIterator struct {
+	ctx       context.Context
+	list      ZonePageFunc
+	queryArgs dns.ZoneListQueryArgs
+	zones     []*dns.ZoneResponse
+	index     int
+	fetched   int // zones fetched so far
+	last      bool
+	err       error
+}
+
+// NewZoneIterator returns an iterator requesting one page of queryArgs.PageSize zones at a time, starting at
+// queryArgs.Page. ShowAll is ignored.
+func NewZoneIterator(ctx context.Context, list ZonePageFunc, queryArgs dns.ZoneListQueryArgs) ZoneIterator {
+
+	queryArgs.ShowAll = false
+	if queryArgs.PageSize < 1 {
+		queryArgs.PageSize = DefaultZonePageSize
+	}
+	if queryArgs.Page < 1 {
+		queryArgs.Page = 1
+	}
+
+	return &pagedZoneIterator{ctx: ctx, list: list, queryArgs: queryArgs}
+}
+
+func (it *pagedZoneIterator) Next() bool {
+
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.zones) {
+		if it.last {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		resp, err := it.list(it.ctx, it.queryArgs)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.zones = resp.Zones
+		it.index = 0
+		it.fetched += len(resp.Zones)
+		// last page if short or the total is reached
+		it.last = len(resp.Zones) < it.queryArgs.PageSize || (resp.Metadata != nil && resp.Metadata.TotalElements > 0 && it.fetched >= resp.Metadata.TotalElements)
+		it.queryArgs.Page++
+	}
+
+	return true
+}
+
+func (it *pagedZoneIterator) Zone() *dns.ZoneResponse {
+
+	if it.index < 0 || it.index >= len(it.zones) {
+		return nil
+	}
+
+	return it.zones[it.index]
+}
+
+func (it *pagedZoneIterator) Err() error {
+
+	return it.err
+}
+
+// IterateZones streams the zone list one page at a time
+func (c *EdgeDNSClient) IterateZones(ctx context.Context, queryArgs dns.ZoneListQueryArgs) ZoneIterator {
+
+	return NewZoneIterator(ctx, c.ListZones, queryArgs)
+}
+
+// ZoneNames collects the names of the iterated zones not in one of the stateFilter activation states
+func ZoneNames(it ZoneIterator, stateFilter []string) ([]string, error) {
+
+	zones := []string{}
+	for it.Next() {
+		zone := it.Zone()
+		if containsString(stateFilter, zone.ActivationState) {
+			continue
+		}
+		zones = append(zones, zone.Zone)
+	}
+	if err := it.Err(); err != nil {
+		return []string{}, err
+	}
+
+	return zones, nil
+}
+
+// ZoneStates collects the activation states of the iterated zones keyed by zone name
+func ZoneStates(it ZoneIterator) (map[string]string, error) {
+
+	states := map[string]string{}
+	for it.Next() {
+		zone := it.Zone()
+		states[zone.Zone] = zone.ActivationState
+	}
+	if err := it.Err(); err != nil {
+		return map[string]string{}, err
+	}
+
+	return states, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Akamai Technologies, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registrar
+
+import (
+	dns "github.com/akamai/AkamaiOPEN-edgegrid-golang/configdns-v2"
+
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// pagedZones returns a ZonePageFunc serving total zones and recording requested pages
+func pagedZones(total int, pages *[]int, withMetadata bool) ZonePageFunc {
+
+	return func(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
+
+		*pages = append(*pages, queryArgs.Page)
+		resp := &dns.ZoneListResponse{}
+		if withMetadata {
+			resp.Metadata = &dns.ListMetadata{Page: queryArgs.Page, PageSize: queryArgs.PageSize, TotalElements: total}
+		}
+		for i := (queryArgs.Page - 1) * queryArgs.PageSize; i < queryArgs.Page*queryArgs.PageSize && i < total; i++ {
+			state := "ACTIVE"
+			if i%5 == 4 {
+				state = "LOCKED"
+			}
+			resp.Zones = append(resp.Zones, &dns.ZoneResponse{Zone: fmt.Sprintf("zone%03d.com", i), ActivationState: state})
+		}
+
+		return resp, nil
+	}
+}
+
+func TestZoneIteratorPages(t *testing.T) {
+
+	for _, withMetadata := range []bool{true, false} {
+		pages := []int{}
+		it := NewZoneIterator(context.Background(), pagedZones(25, &pages, withMetadata), dns.ZoneListQueryArgs{PageSize: 10, ShowAll: true})
+		names, err := ZoneNames(it, []string{"LOCKED"})
+		assert.Nil(t, err)
+		assert.Equal(t, 20, len(names))
+		assert.Equal(t, "zone000.com", names[0])
+		assert.Equal(t, "zone023.com", names[19])
+		assert.Equal(t, []int{1, 2, 3}, pages)
+	}
+
+	// exact multiple of the page size
+	pages := []int{}
+	names, err := ZoneNames(NewZoneIterator(context.Background(), pagedZones(20, &pages, true), dns.ZoneListQueryArgs{PageSize: 10}), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, len(names))
+	assert.Equal(t, []int{1, 2}, pages)
+}
+
+func TestZoneIteratorError(t *testing.T) {
+
+	calls := 0
+	list := func(ctx context.Context, queryArgs dns.ZoneListQueryArgs) (*dns.ZoneListResponse, error) {
+		calls++
+		if queryArgs.Page > 1 {
+			return nil, errors.New("page failed")
+		}
+		return &dns.ZoneListResponse{Zones: []*dns.ZoneResponse{{Zone: "a.com"}, {Zone: "b.com"}}}, nil
+	}
+	it := NewZoneIterator(context.Background(), list, dns.ZoneListQueryArgs{PageSize: 2})
+	assert.True(t, it.Next())
+	assert.Equal(t, "a.com", it.Zone().Zone)
+	assert.True(t, it.Next())
+	assert.False(t, it.Next())
+	assert.NotNil(t, it.Err())
+	assert.False(t, it.Next())
+	assert.Equal(t, 2, calls)
+
+	names, err := ZoneNames(NewZoneIterator(context.Background(), list, dns.ZoneListQueryArgs{PageSize: 2}), nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{}, names)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it = NewZoneIterator(ctx, list, dns.ZoneListQueryArgs{})
+	assert.False(t, it.Next())
+	assert.Equal(t, context.Canceled, it.Err())
+}